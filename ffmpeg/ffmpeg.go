@@ -2,6 +2,7 @@ package ffmpeg
 
 import (
 	"fmt"
+	"io"
 	"os/exec"
 	"sync"
 	"time"
@@ -32,6 +33,7 @@ type ProcessConfig struct {
 	Reconnect      bool
 	ReconnectDelay time.Duration
 	StaleTimeout   time.Duration
+	StopTimeout    time.Duration
 	LimitCPU       float64
 	LimitMemory    uint64
 	LimitDuration  time.Duration
@@ -41,6 +43,10 @@ type ProcessConfig struct {
 	OnExit         func()
 	OnStart        func()
 	OnStateChange  func(from, to string)
+	OnStale        func()
+	OnArgs         func([]string) []string
+	Stdin          io.Reader
+	Stdout         io.Writer
 }
 
 // Config is the configuration for ffmpeg that is part of the configuration
@@ -120,6 +126,7 @@ func (f *ffmpeg) New(config ProcessConfig) (process.Process, error) {
 		Reconnect:      config.Reconnect,
 		ReconnectDelay: config.ReconnectDelay,
 		StaleTimeout:   config.StaleTimeout,
+		StopTimeout:    config.StopTimeout,
 		LimitCPU:       config.LimitCPU,
 		LimitMemory:    config.LimitMemory,
 		LimitDuration:  config.LimitDuration,
@@ -127,6 +134,10 @@ func (f *ffmpeg) New(config ProcessConfig) (process.Process, error) {
 		Logger:         config.Logger,
 		OnStart:        config.OnStart,
 		OnExit:         config.OnExit,
+		OnStale:        config.OnStale,
+		OnArgs:         config.OnArgs,
+		Stdin:          config.Stdin,
+		Stdout:         config.Stdout,
 		OnStateChange: func(from, to string) {
 			f.statesLock.Lock()
 			switch to {
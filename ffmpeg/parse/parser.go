@@ -36,6 +36,9 @@ type Parser interface {
 
 	// TransferReportHistory transfers the report history to another parser
 	TransferReportHistory(Parser) error
+
+	// Reset removes the current log lines and the report history. The prelude is kept
+	Reset()
 }
 
 // Config is the config for the Parser implementation
@@ -106,6 +109,7 @@ type parser struct {
 		progress sync.RWMutex
 		prelude  sync.RWMutex
 		log      sync.RWMutex
+		history  sync.RWMutex
 	}
 }
 
@@ -152,9 +156,11 @@ func New(config Config) Parser {
 	p.lock.log.Lock()
 	p.log = ring.New(config.LogLines)
 
+	p.lock.history.Lock()
 	if p.logHistoryLength > 0 {
 		p.logHistory = ring.New(p.logHistoryLength)
 	}
+	p.lock.history.Unlock()
 
 	if p.collector == nil {
 		p.collector = session.NewNullCollector()
@@ -723,6 +729,23 @@ func (p *parser) ResetLog() {
 	p.lock.log.Unlock()
 }
 
+// Reset removes the current log lines and the report history. The prelude of the current
+// run is kept because FFmpeg will not write it again.
+func (p *parser) Reset() {
+	p.lock.log.Lock()
+	defer p.lock.log.Unlock()
+
+	p.log = ring.New(p.logLines)
+	p.logStart = time.Now()
+
+	p.lock.history.Lock()
+	defer p.lock.history.Unlock()
+
+	if p.logHistoryLength > 0 {
+		p.logHistory = ring.New(p.logHistoryLength)
+	}
+}
+
 // Report represents a log report, including the prelude and the last log lines
 // of the process.
 type Report struct {
@@ -732,12 +755,15 @@ type Report struct {
 }
 
 func (p *parser) storeLogHistory() {
+	h := p.Report()
+
+	p.lock.history.Lock()
+	defer p.lock.history.Unlock()
+
 	if p.logHistory == nil {
 		return
 	}
 
-	h := p.Report()
-
 	if len(h.Prelude) != 0 {
 		p.logHistory.Value = h
 		p.logHistory = p.logHistory.Next()
@@ -760,6 +786,9 @@ func (p *parser) Report() Report {
 func (p *parser) ReportHistory() []Report {
 	var history = []Report{}
 
+	p.lock.history.RLock()
+	defer p.lock.history.RUnlock()
+
 	p.logHistory.Do(func(l interface{}) {
 		if l == nil {
 			return
@@ -777,6 +806,20 @@ func (p *parser) TransferReportHistory(dst Parser) error {
 		return fmt.Errorf("the target parser is not of the required type")
 	}
 
+	if pp == p {
+		return nil
+	}
+
+	p.lock.history.RLock()
+	defer p.lock.history.RUnlock()
+
+	pp.lock.history.Lock()
+	defer pp.lock.history.Unlock()
+
+	if pp.logHistory == nil {
+		return nil
+	}
+
 	p.logHistory.Do(func(l interface{}) {
 		if l == nil {
 			return
@@ -154,6 +154,30 @@ func TestParserReset(t *testing.T) {
 	require.Equal(t, 0, len(prelude))
 }
 
+func TestParserClearLog(t *testing.T) {
+	parser := New(Config{
+		LogLines:   20,
+		LogHistory: 5,
+	})
+
+	parser.Parse("prelude")
+	parser.ResetLog()
+	parser.Parse("prelude")
+
+	require.Equal(t, 1, len(parser.ReportHistory()))
+	require.Equal(t, 1, len(parser.Log()))
+
+	parser.Reset()
+
+	require.Equal(t, 0, len(parser.ReportHistory()))
+	require.Equal(t, 0, len(parser.Log()))
+	require.Equal(t, 1, len(parser.Prelude()))
+
+	parser.Parse("bla")
+
+	require.Equal(t, 1, len(parser.Log()))
+}
+
 func TestParserDefault(t *testing.T) {
 	parser := New(Config{
 		LogLines: 20,
@@ -103,11 +103,14 @@ func (h *PlayoutHandler) Keyframe(c echo.Context) error {
 	inputid := util.PathParam(c, "inputid")
 	name := util.PathWildcardParam(c)
 
-	addr, err := h.restream.GetPlayout(id, inputid)
+	// A viewer of the keyframes is a consumer of a process on demand
+	addr, release, err := h.restream.DemandPlayout(id, inputid)
 	if err != nil {
 		return api.Err(http.StatusNotFound, "Unknown process or input", "%s", err)
 	}
 
+	defer release()
+
 	path := "/v1/keyframe/last."
 
 	if strings.HasSuffix(name, ".png") {
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 )
 
@@ -69,6 +70,13 @@ Output #0, hls, to './data/testsrc.m3u8':
 		os.Exit(2)
 	}
 
+	for _, arg := range os.Args[1:] {
+		if strings.Contains(arg, "unreachable") {
+			fmt.Fprintf(os.Stderr, "%s: Connection refused\n", arg)
+			os.Exit(1)
+		}
+	}
+
 	fmt.Fprintf(os.Stderr, "%s\n", prelude)
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -39,6 +39,10 @@ type Process interface {
 	// automatically if it is defined to do so.
 	Kill(wait bool) error
 
+	// ForceKill kills the running process immediately with
+	// SIGKILL, e.g. if it doesn't react to Stop.
+	ForceKill() error
+
 	// IsRunning returns whether the process is currently
 	// running or not.
 	IsRunning() bool
@@ -46,18 +50,23 @@ type Process interface {
 
 // Config is the configuration of a process
 type Config struct {
-	Binary         string                // Path to the ffmpeg binary
-	Args           []string              // List of arguments for the binary
-	Reconnect      bool                  // Whether to restart the process if it exited
-	ReconnectDelay time.Duration         // Duration to wait before restarting the process
-	StaleTimeout   time.Duration         // Kill the process after this duration if it doesn't produce any output
-	LimitCPU       float64               // Kill the process if the CPU usage in percent is above this value
-	LimitMemory    uint64                // Kill the process if the memory consumption in bytes is above this value
-	LimitDuration  time.Duration         // Kill the process if the limits are exceeded for this duration
-	Parser         Parser                // A parser for the output of the process
-	OnStart        func()                // A callback which is called after the process started
-	OnExit         func()                // A callback which is called after the process exited
-	OnStateChange  func(from, to string) // A callback which is called after a state changed
+	Binary         string                  // Path to the ffmpeg binary
+	Args           []string                // List of arguments for the binary
+	Reconnect      bool                    // Whether to restart the process if it exited
+	ReconnectDelay time.Duration           // Duration to wait before restarting the process
+	StaleTimeout   time.Duration           // Kill the process after this duration if it doesn't produce any output
+	StopTimeout    time.Duration           // Kill the process with SIGKILL if it didn't exit this duration after it has been stopped, defaults to 5 seconds
+	LimitCPU       float64                 // Kill the process if the CPU usage in percent is above this value
+	LimitMemory    uint64                  // Kill the process if the memory consumption in bytes is above this value
+	LimitDuration  time.Duration           // Kill the process if the limits are exceeded for this duration
+	Parser         Parser                  // A parser for the output of the process
+	OnStart        func()                  // A callback which is called after the process started
+	OnExit         func()                  // A callback which is called after the process exited
+	OnStateChange  func(from, to string)   // A callback which is called after a state changed
+	OnStale        func()                  // A callback which is called if the process is stale, right before it will be stopped
+	OnArgs         func([]string) []string // A callback which is called right before the process starts, the returned arguments will be used instead
+	Stdin          io.Reader               // The stdin of the process, if not set it reads from the null device
+	Stdout         io.Writer               // The stdout of the process, if not set it writes to the null device
 	Logger         log.Logger
 }
 
@@ -68,7 +77,11 @@ type Status struct {
 	Order    string        // Order is the wanted condition of process, either "start" or "stop"
 	Duration time.Duration // Duration is the time since the last change of the state
 	Time     time.Time     // Time is the time of the last change of the state
-	CPU      struct {
+	Exit     struct {
+		Code   int    // Exit code of the last run, -1 if the process is running, never ran, or has been terminated by a signal
+		Signal string // Description of the signal that terminated the last run, e.g. "killed", empty if there was no signal
+	}
+	CPU struct {
 		Current float64 // Used CPU in percent
 		Limit   float64 // Limit in percent
 	}
@@ -154,7 +167,11 @@ type process struct {
 	pid      int32
 	stdout   io.ReadCloser
 	lastLine string
-	state    struct {
+	pipe     struct {
+		stdin  io.Reader
+		stdout io.Writer
+	}
+	state struct {
 		state  stateType
 		time   time.Time
 		states States
@@ -177,14 +194,26 @@ type process struct {
 		timer  *time.Timer
 		lock   sync.Mutex
 	}
+	exit struct {
+		code   int
+		signal string
+		lock   sync.Mutex
+	}
+	running struct {
+		process *os.Process // OS process of the current run, it can be killed without the order lock
+		lock    sync.Mutex
+	}
 	killTimer     *time.Timer
 	killTimerLock sync.Mutex
+	stopTimeout   time.Duration
 	logger        log.Logger
 	debuglogger   log.Logger
 	callbacks     struct {
 		onStart       func()
 		onExit        func()
 		onStateChange func(from, to string)
+		onStale       func()
+		onArgs        func([]string) []string
 		lock          sync.Mutex
 	}
 	limits Limiter
@@ -225,6 +254,7 @@ func New(config Config) (Process, error) {
 	p.order.order = "stop"
 
 	p.initState(stateFinished)
+	p.setExit(-1, "")
 
 	p.reconn.enable = config.Reconnect
 	p.reconn.delay = config.ReconnectDelay
@@ -232,9 +262,19 @@ func New(config Config) (Process, error) {
 	p.stale.last = time.Now()
 	p.stale.timeout = config.StaleTimeout
 
+	p.stopTimeout = config.StopTimeout
+	if p.stopTimeout <= 0 {
+		p.stopTimeout = 5 * time.Second
+	}
+
 	p.callbacks.onStart = config.OnStart
 	p.callbacks.onExit = config.OnExit
 	p.callbacks.onStateChange = config.OnStateChange
+	p.callbacks.onStale = config.OnStale
+	p.callbacks.onArgs = config.OnArgs
+
+	p.pipe.stdin = config.Stdin
+	p.pipe.stdout = config.Stdout
 
 	p.limits = NewLimiter(LimiterConfig{
 		CPU:     config.LimitCPU,
@@ -359,6 +399,15 @@ func (p *process) setState(state stateType) error {
 	return nil
 }
 
+// setExit records the exit code and the signal of the last run.
+func (p *process) setExit(code int, signal string) {
+	p.exit.lock.Lock()
+	defer p.exit.lock.Unlock()
+
+	p.exit.code = code
+	p.exit.signal = signal
+}
+
 func (p *process) getState() stateType {
 	p.state.lock.Lock()
 	defer p.state.lock.Unlock()
@@ -403,6 +452,11 @@ func (p *process) Status() Status {
 		Time:     stateTime,
 	}
 
+	p.exit.lock.Lock()
+	s.Exit.Code = p.exit.code
+	s.Exit.Signal = p.exit.signal
+	p.exit.lock.Unlock()
+
 	s.CPU.Current = cpu
 	s.CPU.Limit = cpuLimit
 
@@ -462,9 +516,25 @@ func (p *process) start() error {
 	p.unreconnect()
 
 	p.setState(stateStarting)
+	p.setExit(-1, "")
 
-	p.cmd = exec.Command(p.binary, p.args...)
+	args := p.args
+
+	p.callbacks.lock.Lock()
+	onArgs := p.callbacks.onArgs
+	p.callbacks.lock.Unlock()
+
+	if onArgs != nil {
+		a := make([]string, len(p.args))
+		copy(a, p.args)
+
+		args = onArgs(a)
+	}
+
+	p.cmd = exec.Command(p.binary, args...)
 	p.cmd.Env = []string{}
+	p.cmd.Stdin = p.pipe.stdin
+	p.cmd.Stdout = p.pipe.stdout
 
 	p.stdout, err = p.cmd.StderrPipe()
 	if err != nil {
@@ -486,6 +556,10 @@ func (p *process) start() error {
 		return err
 	}
 
+	p.running.lock.Lock()
+	p.running.process = p.cmd.Process
+	p.running.lock.Unlock()
+
 	p.pid = int32(p.cmd.Process.Pid)
 
 	if proc, err := psutil.NewProcess(p.pid); err == nil {
@@ -502,10 +576,11 @@ func (p *process) start() error {
 	}
 
 	// Start the reader
-	go p.reader()
+	done := make(chan struct{})
+	go p.reader(p.stdout, done)
 
 	// Wait for the process to finish
-	go p.waiter()
+	go p.waiter(done)
 
 	// Start the stale timeout if enabled
 	if p.stale.timeout != 0 {
@@ -561,6 +636,28 @@ func (p *process) Kill(wait bool) error {
 	return err
 }
 
+// ForceKill will kill a running process with SIGKILL without waiting for it
+// to exit. It doesn't change the order.
+func (p *process) ForceKill() error {
+	if !p.isRunning() {
+		return nil
+	}
+
+	// The command is replaced by start while holding the order lock, which might
+	// be held by a blocking stop.
+	p.running.lock.Lock()
+	proc := p.running.process
+	p.running.lock.Unlock()
+
+	if proc == nil {
+		return nil
+	}
+
+	p.logger.Warn().Log("Killing")
+
+	return proc.Kill()
+}
+
 // stop will stop a process considering the current order and state.
 func (p *process) stop(wait bool) error {
 	// If the process is currently not running, stop the restart timer
@@ -592,7 +689,9 @@ func (p *process) stop(wait bool) error {
 			p.callbacks.onExit = func() {
 				wg.Done()
 
+				p.callbacks.lock.Lock()
 				p.callbacks.onExit = nil
+				p.callbacks.lock.Unlock()
 			}
 		} else {
 			cb := p.callbacks.onExit
@@ -600,7 +699,9 @@ func (p *process) stop(wait bool) error {
 				cb()
 				wg.Done()
 
+				p.callbacks.lock.Lock()
 				p.callbacks.onExit = cb
+				p.callbacks.lock.Unlock()
 			}
 		}
 		p.callbacks.lock.Unlock()
@@ -621,9 +722,13 @@ func (p *process) stop(wait bool) error {
 		} else {
 			// Set up a timer to kill the process with SIGKILL in case SIGINT didn't have
 			// an effect.
+			// The process is captured because the command will be replaced by a restart
+			proc := p.cmd.Process
+
 			p.killTimerLock.Lock()
-			p.killTimer = time.AfterFunc(5*time.Second, func() {
-				p.cmd.Process.Kill()
+			p.killTimer = time.AfterFunc(p.stopTimeout, func() {
+				p.logger.WithField("timeout", p.stopTimeout).Warn().Log("Killing because it didn't stop in time")
+				proc.Kill()
 			})
 			p.killTimerLock.Unlock()
 		}
@@ -710,7 +815,19 @@ func (p *process) staler(ctx context.Context) {
 			d := t.Sub(last)
 			if d.Seconds() > timeout.Seconds() {
 				p.logger.Info().Log("Stale timeout after %s (%.2f).", timeout, d.Seconds())
+
+				p.callbacks.lock.Lock()
+				onStale := p.callbacks.onStale
+				p.callbacks.lock.Unlock()
+
+				if onStale != nil {
+					onStale()
+				}
+
+				p.order.lock.Lock()
 				p.stop(false)
+				p.order.lock.Unlock()
+
 				return
 			}
 		}
@@ -721,9 +838,11 @@ func (p *process) staler(ctx context.Context) {
 // each line to the parser. The parser returns a postive number to
 // indicate progress. If the returned number is zero, then the time
 // of the last progress will not be updated thus the stale timeout
-// may kick in.
-func (p *process) reader() {
-	scanner := bufio.NewScanner(p.stdout)
+// may kick in. The done channel will be closed after all output has been read.
+func (p *process) reader(stdout io.Reader, done chan<- struct{}) {
+	defer close(done)
+
+	scanner := bufio.NewScanner(stdout)
 	scanner.Split(scanLine)
 
 	// Reset the parser statistics
@@ -753,12 +872,15 @@ func (p *process) reader() {
 }
 
 // waiter waits for the process to finish. If enabled, the process will
-// be scheduled for a restart.
-func (p *process) waiter() {
+// be scheduled for a restart. The reader has to be done before the process
+// can be waited for.
+func (p *process) waiter(done <-chan struct{}) {
 	if p.getState() == stateFinishing {
 		p.stop(false)
 	}
 
+	<-done
+
 	if err := p.cmd.Wait(); err != nil {
 		// The process exited abnormally, i.e. the return code is non-zero or a signal
 		// has been raised.
@@ -775,6 +897,12 @@ func (p *process) waiter() {
 				"signal":      status.Signal(),
 			}).Debug().Log("Exited")
 
+			if status.Signaled() {
+				p.setExit(-1, status.Signal().String())
+			} else {
+				p.setExit(exiterr.ExitCode(), "")
+			}
+
 			if status.Exited() {
 				if status.ExitStatus() == 255 {
 					// If ffmpeg has been killed with a SIGINT, SIGTERM, etc., then it exited normally,
@@ -805,6 +933,7 @@ func (p *process) waiter() {
 	} else {
 		// The process exited normally, i.e. the return code is zero and no signal
 		// has been raised
+		p.setExit(0, "")
 		p.setState(stateFinished)
 	}
 
@@ -1,6 +1,7 @@
 package process
 
 import (
+	"os"
 	"testing"
 	"time"
 
@@ -78,6 +79,54 @@ func TestStaleProcess(t *testing.T) {
 	require.Equal(t, "killed", p.Status().State)
 }
 
+func TestStaleCallback(t *testing.T) {
+	stale := make(chan struct{}, 1)
+
+	p, _ := New(Config{
+		Binary: "sleep",
+		Args: []string{
+			"10",
+		},
+		Reconnect:    false,
+		StaleTimeout: time.Second,
+		OnStale: func() {
+			stale <- struct{}{}
+		},
+	})
+
+	p.Start()
+
+	select {
+	case <-stale:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "stale callback hasn't been called")
+	}
+
+	p.Stop(false)
+}
+
+func TestArgsCallback(t *testing.T) {
+	p, _ := New(Config{
+		Binary: "sleep",
+		Args: []string{
+			"hello",
+		},
+		Reconnect:    false,
+		StaleTimeout: 0,
+		OnArgs: func(args []string) []string {
+			return []string{"10"}
+		},
+	})
+
+	p.Start()
+
+	time.Sleep(2 * time.Second)
+
+	require.Equal(t, "running", p.Status().State)
+
+	p.Stop(false)
+}
+
 func TestStaleReconnectProcess(t *testing.T) {
 	p, _ := New(Config{
 		Binary: "sleep",
@@ -235,3 +284,136 @@ func TestProcessForceKill(t *testing.T) {
 
 	require.Equal(t, "killed", p.Status().State)
 }
+
+func TestProcessStopTimeout(t *testing.T) {
+	binary, err := testhelper.BuildBinary("ignoresigint", "../internal/testhelper")
+	require.NoError(t, err, "Failed to build helper program")
+
+	p, _ := New(Config{
+		Binary:       binary,
+		Args:         []string{},
+		Reconnect:    false,
+		StaleTimeout: 0,
+		StopTimeout:  time.Second,
+	})
+
+	err = p.Start()
+	require.NoError(t, err)
+
+	time.Sleep(3 * time.Second)
+
+	p.Stop(false)
+
+	time.Sleep(500 * time.Millisecond)
+
+	require.Equal(t, "finishing", p.Status().State)
+
+	time.Sleep(2 * time.Second)
+
+	require.Equal(t, "killed", p.Status().State)
+}
+
+func TestProcessForceKillImmediately(t *testing.T) {
+	binary, err := testhelper.BuildBinary("ignoresigint", "../internal/testhelper")
+	require.NoError(t, err, "Failed to build helper program")
+
+	p, _ := New(Config{
+		Binary:       binary,
+		Args:         []string{},
+		Reconnect:    false,
+		StaleTimeout: 0,
+		StopTimeout:  time.Minute,
+	})
+
+	err = p.Start()
+	require.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+
+	p.Stop(false)
+
+	time.Sleep(500 * time.Millisecond)
+
+	require.Equal(t, "finishing", p.Status().State)
+
+	err = p.ForceKill()
+	require.NoError(t, err)
+
+	time.Sleep(time.Second)
+
+	require.Equal(t, "killed", p.Status().State)
+}
+
+func TestProcessForceKillWhileStarting(t *testing.T) {
+	p, _ := New(Config{
+		Binary:       "sleep",
+		Args:         []string{"10"},
+		Reconnect:    false,
+		StaleTimeout: 0,
+	})
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 10; i++ {
+			p.Start()
+			p.Stop(true)
+		}
+	}()
+
+	// A concurrent start must not race with killing the process
+	for {
+		select {
+		case <-done:
+			require.NotEqual(t, "running", p.Status().State)
+			return
+		default:
+			if err := p.ForceKill(); err != nil {
+				require.ErrorIs(t, err, os.ErrProcessDone)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestProcessExit(t *testing.T) {
+	p, _ := New(Config{
+		Binary:       "sh",
+		Args:         []string{"-c", "exit 3"},
+		Reconnect:    false,
+		StaleTimeout: 0,
+	})
+
+	require.Equal(t, -1, p.Status().Exit.Code)
+
+	p.Start()
+
+	time.Sleep(2 * time.Second)
+
+	status := p.Status()
+	require.Equal(t, "failed", status.State)
+	require.Equal(t, 3, status.Exit.Code)
+	require.Equal(t, "", status.Exit.Signal)
+
+	p.Stop(false)
+
+	p, _ = New(Config{
+		Binary:       "sh",
+		Args:         []string{"-c", "kill -KILL $$"},
+		Reconnect:    false,
+		StaleTimeout: 0,
+	})
+
+	p.Start()
+
+	time.Sleep(2 * time.Second)
+
+	status = p.Status()
+	require.Equal(t, "killed", status.State)
+	require.Equal(t, -1, status.Exit.Code)
+	require.Equal(t, "killed", status.Exit.Signal)
+
+	p.Stop(false)
+}
@@ -0,0 +1,134 @@
+package app
+
+import (
+	"reflect"
+	"strings"
+)
+
+// ConfigChange describes a single difference between two configs.
+type ConfigChange struct {
+	Field string      // Name of the field, e.g. "reconnect", "input:in", or "output:out.address"
+	Kind  string      // Kind of the change, either "added", "removed", or "changed"
+	Old   interface{} // Previous value, nil if the field has been added
+	New   interface{} // New value, nil if the field has been removed
+}
+
+// ConfigDiff is the list of changes between two configs.
+type ConfigDiff struct {
+	Changes []ConfigChange
+}
+
+// IsEmpty returns whether there are no changes.
+func (d ConfigDiff) IsEmpty() bool {
+	return len(d.Changes) == 0
+}
+
+// Diff returns the differences between this config and the other config. The server
+// assigned FFmpeg version constraint is not considered. Inputs and outputs are matched
+// by their ID.
+func (config *Config) Diff(other *Config) ConfigDiff {
+	diff := ConfigDiff{}
+
+	diff.Changes = append(diff.Changes, diffFields("", reflect.ValueOf(*config), reflect.ValueOf(*other), "FFVersion", "Input", "Output")...)
+	diff.Changes = append(diff.Changes, diffIO("input", config.Input, other.Input)...)
+	diff.Changes = append(diff.Changes, diffIO("output", config.Output, other.Output)...)
+
+	return diff
+}
+
+func diffIO(kind string, a, b []ConfigIO) []ConfigChange {
+	changes := []ConfigChange{}
+
+	ios := map[string]ConfigIO{}
+	for _, io := range b {
+		ios[io.ID] = io
+	}
+
+	for _, io := range a {
+		other, ok := ios[io.ID]
+		if !ok {
+			changes = append(changes, ConfigChange{
+				Field: kind + ":" + io.ID,
+				Kind:  "removed",
+				Old:   io,
+			})
+			continue
+		}
+
+		changes = append(changes, diffFields(kind+":"+io.ID+".", reflect.ValueOf(io), reflect.ValueOf(other), "ID")...)
+
+		delete(ios, io.ID)
+	}
+
+	// Keep the order of the added inputs or outputs
+	for _, io := range b {
+		if _, ok := ios[io.ID]; !ok {
+			continue
+		}
+
+		changes = append(changes, ConfigChange{
+			Field: kind + ":" + io.ID,
+			Kind:  "added",
+			New:   io,
+		})
+	}
+
+	return changes
+}
+
+// diffFields compares all exported fields of the two structs, except the excluded
+// ones. The JSON names of the fields will be used as names in the changes.
+func diffFields(prefix string, a, b reflect.Value, exclude ...string) []ConfigChange {
+	changes := []ConfigChange{}
+
+	t := a.Type()
+
+next:
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		for _, e := range exclude {
+			if field.Name == e {
+				continue next
+			}
+		}
+
+		va := a.Field(i).Interface()
+		vb := b.Field(i).Interface()
+
+		if isEmptyValue(va) && isEmptyValue(vb) {
+			continue
+		}
+
+		if reflect.DeepEqual(va, vb) {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if len(name) == 0 {
+			name = field.Name
+		}
+
+		changes = append(changes, ConfigChange{
+			Field: prefix + name,
+			Kind:  "changed",
+			Old:   va,
+			New:   vb,
+		})
+	}
+
+	return changes
+}
+
+// isEmptyValue returns whether the value is the zero value or an empty slice or map. This
+// avoids changes between nil and empty slices.
+func isEmptyValue(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map:
+		return rv.Len() == 0
+	}
+
+	return rv.IsZero()
+}
@@ -0,0 +1,9 @@
+package app
+
+// FilesystemInfo describes a filesystem that is registered with the restreamer.
+type FilesystemInfo struct {
+	Name  string // Name of the filesystem, e.g. "disk"
+	Type  string // Type of the filesystem, e.g. "disk" or "mem"
+	Size  int64  // Current size in bytes
+	Limit int64  // Max. size in bytes, 0 or negative if unlimited
+}
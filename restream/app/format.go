@@ -0,0 +1,90 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported format")
+
+// MarshalConfigs encodes the configs in the format, either "json", "yaml" or "toml". The names of
+// the fields are the same in all formats. In TOML the configs are an array of tables named "process".
+func MarshalConfigs(format string, configs []*Config) ([]byte, error) {
+	data, err := json.MarshalIndent(configs, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case "json":
+		return data, nil
+	case "yaml":
+		// Go through JSON in order to use the same names for the fields
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+
+		return yaml.Marshal(v)
+	case "toml":
+		var v interface{}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&v); err != nil {
+			return nil, err
+		}
+
+		return marshalTOML(map[string]interface{}{
+			"process": v,
+		})
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+}
+
+// UnmarshalConfigs decodes the configs from the data in the format, either "json", "yaml" or "toml".
+func UnmarshalConfigs(format string, data []byte) ([]*Config, error) {
+	switch format {
+	case "json":
+	case "yaml":
+		var v interface{}
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+
+		var err error
+		data, err = json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+	case "toml":
+		v, err := unmarshalTOML(data)
+		if err != nil {
+			return nil, err
+		}
+
+		processes, ok := v["process"]
+		if !ok {
+			processes = []interface{}{}
+		}
+
+		data, err = json.Marshal(processes)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+
+	configs := []*Config{}
+
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, err
+	}
+
+	return configs, nil
+}
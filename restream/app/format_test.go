@@ -0,0 +1,188 @@
+package app
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalConfigs(t *testing.T) {
+	configs := []*Config{
+		{
+			ID:        "process1",
+			Reference: "ref",
+			Input: []ConfigIO{
+				{ID: "in", Address: "testsrc", Options: []string{"-f", "lavfi"}},
+			},
+			Output: []ConfigIO{
+				{
+					ID:      "out",
+					Address: "/data/out.m3u8",
+					Options: []string{"-codec", "copy"},
+					Cleanup: []ConfigIOCleanup{
+						{Pattern: "/data/out_*.ts", MaxFiles: 10, PurgeOnDelete: true},
+					},
+				},
+			},
+			Options:        []string{"-loglevel", "info"},
+			Reconnect:      true,
+			ReconnectDelay: 15,
+			Autostart:      true,
+			LimitCPU:       42.5,
+			LimitMemory:    1024 * 1024,
+		},
+		{
+			ID: "process2",
+			Input: []ConfigIO{
+				{ID: "in", Address: "#process1:output=out"},
+			},
+			Output: []ConfigIO{
+				{ID: "out", Address: "-"},
+			},
+		},
+	}
+
+	for _, format := range []string{"json", "yaml", "toml"} {
+		data, err := MarshalConfigs(format, configs)
+		require.NoError(t, err, format)
+
+		decoded, err := UnmarshalConfigs(format, data)
+		require.NoError(t, err, format)
+
+		require.Equal(t, len(configs), len(decoded), format)
+
+		for i := range configs {
+			require.True(t, configs[i].Diff(decoded[i]).IsEmpty(), "%s: %+v", format, configs[i].Diff(decoded[i]))
+		}
+	}
+
+	_, err := MarshalConfigs("xml", configs)
+	require.ErrorIs(t, err, ErrUnsupportedFormat)
+
+	_, err = UnmarshalConfigs("xml", []byte{})
+	require.ErrorIs(t, err, ErrUnsupportedFormat)
+}
+
+func TestUnmarshalConfigsYAML(t *testing.T) {
+	data := []byte(`
+- id: process
+  input:
+    - id: in
+      address: testsrc
+      options: ["-f", "lavfi"]
+  output:
+    - id: out
+      address: "-"
+  reconnect_delay_seconds: 10
+`)
+
+	configs, err := UnmarshalConfigs("yaml", data)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(configs))
+
+	require.Equal(t, "process", configs[0].ID)
+	require.Equal(t, []string{"-f", "lavfi"}, configs[0].Input[0].Options)
+	require.Equal(t, "-", configs[0].Output[0].Address)
+	require.Equal(t, uint64(10), configs[0].ReconnectDelay)
+}
+
+func TestUnmarshalConfigsTOML(t *testing.T) {
+	data := []byte(`
+# A process
+[[process]]
+id = "process"
+reconnect_delay_seconds = 1_0
+options = [
+    "-loglevel", # the level
+    'info',
+]
+
+[[process.input]]
+id = "in"
+address = 'testsrc'
+options = ["-f", "lavfi"]
+
+[[process.output]]
+id = "out"
+address = "-"
+cleanup = [{ pattern = "/data/*.ts", max_files = 5 }]
+
+[[process]]
+id = "process2"
+"reference" = """
+foo \
+  bar"""
+`)
+
+	configs, err := UnmarshalConfigs("toml", data)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(configs))
+
+	require.Equal(t, "process", configs[0].ID)
+	require.Equal(t, []string{"-loglevel", "info"}, configs[0].Options)
+	require.Equal(t, []string{"-f", "lavfi"}, configs[0].Input[0].Options)
+	require.Equal(t, "-", configs[0].Output[0].Address)
+	require.Equal(t, "/data/*.ts", configs[0].Output[0].Cleanup[0].Pattern)
+	require.Equal(t, uint(5), configs[0].Output[0].Cleanup[0].MaxFiles)
+	require.Equal(t, uint64(10), configs[0].ReconnectDelay)
+
+	require.Equal(t, "process2", configs[1].ID)
+	require.Equal(t, "foo bar", configs[1].Reference)
+
+	configs, err = UnmarshalConfigs("toml", []byte{})
+	require.NoError(t, err)
+	require.Equal(t, 0, len(configs))
+
+	_, err = UnmarshalConfigs("toml", []byte("[[process]]\nid = \"a\"\nid = \"b\"\n"))
+	require.Error(t, err, "duplicate keys are not allowed")
+
+	_, err = UnmarshalConfigs("toml", []byte("[[process]]\nid = \"a\n"))
+	require.Error(t, err, "unterminated string")
+}
+
+func TestMarshalConfigsTOMLEscape(t *testing.T) {
+	configs := []*Config{
+		{
+			ID:        "process",
+			Reference: "quote \" backslash \\ tab \t newline \n unicode \u00e4 \x01",
+			Options:   []string{},
+		},
+	}
+
+	data, err := MarshalConfigs("toml", configs)
+	require.NoError(t, err)
+
+	decoded, err := UnmarshalConfigs("toml", data)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(decoded))
+	require.Equal(t, configs[0].Reference, decoded[0].Reference)
+}
+
+func TestMarshalConfigsTOMLLargeInteger(t *testing.T) {
+	configs := []*Config{
+		{
+			ID:                "process",
+			Options:           []string{},
+			LimitBandwidthOut: math.MaxInt64,
+		},
+	}
+
+	data, err := MarshalConfigs("toml", configs)
+	require.NoError(t, err)
+
+	decoded, err := UnmarshalConfigs("toml", data)
+	require.NoError(t, err)
+	require.Equal(t, uint64(math.MaxInt64), decoded[0].LimitBandwidthOut)
+
+	configs[0].LimitBandwidthOut = math.MaxInt64 + 1
+
+	_, err = MarshalConfigs("toml", configs)
+	require.Error(t, err, "integers in TOML are 64 bit signed")
+
+	_, err = UnmarshalConfigs("toml", []byte("[[process]]\nid = \"a\"\nlimit_bandwidth_out_bytes = 9223372036854775808\n"))
+	require.Error(t, err, "integers in TOML are 64 bit signed")
+
+	_, err = UnmarshalConfigs("toml", []byte("[[process]]\nid = \"a\"\nlimit_bandwidth_out_bytes = 0xffffffffffffffff\n"))
+	require.Error(t, err, "integers in TOML are 64 bit signed")
+}
@@ -9,10 +9,17 @@ type LogEntry struct {
 	Data      string
 }
 
+// LogAnnotation is a marker for a change of the process, e.g. an update of its config.
+type LogAnnotation struct {
+	Timestamp time.Time
+	Reason    string // "update" or "reload"
+}
+
 type LogHistoryEntry struct {
-	CreatedAt time.Time
-	Prelude   []string
-	Log       []LogEntry
+	CreatedAt   time.Time
+	Prelude     []string
+	Log         []LogEntry
+	Annotations []LogAnnotation // Changes of the process that happened before this entry started
 }
 
 type Log struct {
@@ -0,0 +1,7 @@
+package app
+
+type Metric struct {
+	Name   string            // Name of the metric, e.g. "restream_process_cpu_percent"
+	Labels map[string]string // Labels of the metric, e.g. the process ID
+	Value  float64
+}
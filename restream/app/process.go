@@ -1,27 +1,47 @@
 package app
 
 import (
+	"strconv"
+	"time"
+
 	"github.com/datarhei/core/v16/process"
 )
 
 type ConfigIOCleanup struct {
-	Pattern       string `json:"pattern"`
-	MaxFiles      uint   `json:"max_files"`
-	MaxFileAge    uint   `json:"max_file_age_seconds"`
-	PurgeOnDelete bool   `json:"purge_on_delete"`
+	Pattern         string `json:"pattern"`
+	MaxFiles        uint   `json:"max_files"`
+	MaxFileAge      uint   `json:"max_file_age_seconds"`
+	RetentionWindow uint   `json:"retention_window_seconds"` // Like MaxFileAge, but the most recent file is never removed
+	PurgeOnDelete   bool   `json:"purge_on_delete"`
+	Filesystem      string `json:"filesystem"` // Name of the filesystem of the tee target the rule is for, the pattern is relative to its base
 }
 
 type ConfigIO struct {
-	ID      string            `json:"id"`
-	Address string            `json:"address"`
-	Options []string          `json:"options"`
-	Cleanup []ConfigIOCleanup `json:"cleanup"`
+	ID             string            `json:"id"`
+	Address        string            `json:"address"`
+	Addresses      []string          `json:"addresses"` // Alternative addresses for an input, see Config.SourceSelection
+	Options        []string          `json:"options"`
+	Cleanup        []ConfigIOCleanup `json:"cleanup"`
+	Optional       bool              `json:"optional"`                // An invalid optional input is dropped instead of rejecting the whole process
+	OutputType     string            `json:"output_type"`             // "auto", "tee", or "single", whether the address of an output is for the tee muxer. "auto" (default) detects it from the address
+	Reconnect      bool              `json:"reconnect"`               // Whether FFmpeg should reconnect to an HTTP input instead of failing
+	ReconnectDelay uint64            `json:"reconnect_delay_seconds"` // seconds, max. delay between reconnects to the input, 0 for the reconnect delay of the process
 }
 
 func (io ConfigIO) Clone() ConfigIO {
 	clone := ConfigIO{
-		ID:      io.ID,
-		Address: io.Address,
+		ID:             io.ID,
+		Address:        io.Address,
+		Optional:       io.Optional,
+		OutputType:     io.OutputType,
+		Reconnect:      io.Reconnect,
+		ReconnectDelay: io.ReconnectDelay,
+	}
+
+	// Configs without alternative addresses are kept as they are
+	if io.Addresses != nil {
+		clone.Addresses = make([]string, len(io.Addresses))
+		copy(clone.Addresses, io.Addresses)
 	}
 
 	clone.Options = make([]string, len(io.Options))
@@ -34,33 +54,74 @@ func (io ConfigIO) Clone() ConfigIO {
 }
 
 type Config struct {
-	ID             string     `json:"id"`
-	Reference      string     `json:"reference"`
-	FFVersion      string     `json:"ffversion"`
-	Input          []ConfigIO `json:"input"`
-	Output         []ConfigIO `json:"output"`
-	Options        []string   `json:"options"`
-	Reconnect      bool       `json:"reconnect"`
-	ReconnectDelay uint64     `json:"reconnect_delay_seconds"` // seconds
-	Autostart      bool       `json:"autostart"`
-	StaleTimeout   uint64     `json:"stale_timeout_seconds"` // seconds
-	LimitCPU       float64    `json:"limit_cpu_usage"`       // percent
-	LimitMemory    uint64     `json:"limit_memory_bytes"`    // bytes
-	LimitWaitFor   uint64     `json:"limit_waitfor_seconds"` // seconds
+	ID                    string            `json:"id"`
+	Reference             string            `json:"reference"`
+	FFVersion             string            `json:"ffversion"`
+	Input                 []ConfigIO        `json:"input"`
+	Output                []ConfigIO        `json:"output"`
+	Options               []string          `json:"options"`
+	Reconnect             bool              `json:"reconnect"`
+	ReconnectDelay        uint64            `json:"reconnect_delay_seconds"` // seconds
+	Autostart             bool              `json:"autostart"`
+	StaleTimeout          uint64            `json:"stale_timeout_seconds"`        // seconds
+	LimitCPU              float64           `json:"limit_cpu_usage"`              // percent
+	LimitMemory           uint64            `json:"limit_memory_bytes"`           // bytes
+	LimitWaitFor          uint64            `json:"limit_waitfor_seconds"`        // seconds
+	Priority              int               `json:"priority"`                     // higher values are preferred if the number of processes is limited
+	StaleAction           string            `json:"stale_action"`                 // "restart" or "stop", what to do if the process is stale
+	StaleMetric           string            `json:"stale_metric"`                 // "frames" (default), "bytes", or "either", the progress that has to advance for the process not to be stale
+	WorkingDir            string            `json:"working_dir"`                  // directory relative to the base of the disk filesystems where file outputs must be written to
+	MaxAge                uint64            `json:"max_age_seconds"`              // seconds, delete the stopped process after it hasn't been updated for this duration
+	SourceSelection       string            `json:"source_selection"`             // "failover" (default), "round-robin", or "random", how to select among the addresses of an input on each start
+	FailureGracePeriod    uint64            `json:"failure_grace_period_seconds"` // seconds, a failed process that reconnects is reported as "starting" for this duration
+	Vars                  map[string]string `json:"vars"`                         // variables that can be used as {vars:name} placeholders, the names consist of lowercase letters
+	BreakerFailures       uint64            `json:"breaker_failures"`             // number of failures within the breaker window after which the process will be disabled
+	BreakerWindow         uint64            `json:"breaker_window_seconds"`       // seconds, only failures within this window are counted, 0 for counting all consecutive failures
+	InitialConnectRetries uint64            `json:"initial_connect_retries"`      // number of failed starts without any progress after which the process will be stopped, 0 for retrying forever
+	FFmpegBinary          string            `json:"ffmpeg_binary"`                // name of the ffmpeg binary to use, empty for the default binary
+	GroupID               string            `json:"group_id"`                     // ID of the group of processes that can be started, stopped, and deleted together
+	ReadAtNativeRate      bool              `json:"read_at_native_rate"`          // read the inputs in realtime in order to limit the input bandwidth to the bitrate of the streams, see CreateCommand
+	LimitBandwidthOut     uint64            `json:"limit_bandwidth_out_bytes"`    // bytes per second, max. bitrate of each output, see CreateCommand
+	ReferenceBehavior     string            `json:"reference_behavior"`           // "ignore" (default), "block-start", or "start-dependency", what to do on start if a referenced process is not started
+	OnDemand              bool              `json:"on_demand"`                    // the process is only started as long as it is demanded via the keyframe endpoint of the playout API, autostart is ignored
+	IdleTimeout           uint64            `json:"idle_timeout_seconds"`         // seconds, stop an on demand process after it hasn't been demanded for this duration, 0 for the default of 30 seconds
+	TemplateOnly          bool              `json:"template_only"`                // the process is only stored and validated, but it can't be started
+	IgnoreFullDisk        bool              `json:"ignore_full_disk"`             // the process keeps running if a filesystem is full, writing to it might fail and files might be incomplete
+	LogRateLimit          uint64            `json:"log_rate_limit_lines"`         // lines per second, further log lines within a second are dropped, 0 for no limit
 }
 
 func (config *Config) Clone() *Config {
 	clone := &Config{
-		ID:             config.ID,
-		Reference:      config.Reference,
-		FFVersion:      config.FFVersion,
-		Reconnect:      config.Reconnect,
-		ReconnectDelay: config.ReconnectDelay,
-		Autostart:      config.Autostart,
-		StaleTimeout:   config.StaleTimeout,
-		LimitCPU:       config.LimitCPU,
-		LimitMemory:    config.LimitMemory,
-		LimitWaitFor:   config.LimitWaitFor,
+		ID:                    config.ID,
+		Reference:             config.Reference,
+		FFVersion:             config.FFVersion,
+		Reconnect:             config.Reconnect,
+		ReconnectDelay:        config.ReconnectDelay,
+		Autostart:             config.Autostart,
+		StaleTimeout:          config.StaleTimeout,
+		LimitCPU:              config.LimitCPU,
+		LimitMemory:           config.LimitMemory,
+		LimitWaitFor:          config.LimitWaitFor,
+		Priority:              config.Priority,
+		StaleAction:           config.StaleAction,
+		StaleMetric:           config.StaleMetric,
+		WorkingDir:            config.WorkingDir,
+		MaxAge:                config.MaxAge,
+		SourceSelection:       config.SourceSelection,
+		FailureGracePeriod:    config.FailureGracePeriod,
+		BreakerFailures:       config.BreakerFailures,
+		BreakerWindow:         config.BreakerWindow,
+		InitialConnectRetries: config.InitialConnectRetries,
+		FFmpegBinary:          config.FFmpegBinary,
+		GroupID:               config.GroupID,
+		ReadAtNativeRate:      config.ReadAtNativeRate,
+		LimitBandwidthOut:     config.LimitBandwidthOut,
+		ReferenceBehavior:     config.ReferenceBehavior,
+		OnDemand:              config.OnDemand,
+		IdleTimeout:           config.IdleTimeout,
+		TemplateOnly:          config.TemplateOnly,
+		IgnoreFullDisk:        config.IgnoreFullDisk,
+		LogRateLimit:          config.LogRateLimit,
 	}
 
 	clone.Input = make([]ConfigIO, len(config.Input))
@@ -76,10 +137,24 @@ func (config *Config) Clone() *Config {
 	clone.Options = make([]string, len(config.Options))
 	copy(clone.Options, config.Options)
 
+	if config.Vars != nil {
+		clone.Vars = make(map[string]string, len(config.Vars))
+		for name, value := range config.Vars {
+			clone.Vars[name] = value
+		}
+	}
+
 	return clone
 }
 
-// CreateCommand created the FFmpeg command from this config.
+// CreateCommand creates the FFmpeg command from the config.
+//
+// The bandwidth limits are placed in front of the options of each input or output, such
+// that they can be overridden by the options. FFmpeg can't limit the bandwidth of an input
+// in bytes. Instead, ReadAtNativeRate adds "-readrate 1" to each input, i.e. the inputs
+// are read in realtime, which limits the input bandwidth to the bitrate of the streams.
+// A LimitBandwidthOut adds "-maxrate" and "-bufsize" with the limit in bits per second
+// to each output. These only apply to encoded streams.
 func (config *Config) CreateCommand() []string {
 	var command []string
 
@@ -87,12 +162,34 @@ func (config *Config) CreateCommand() []string {
 	command = append(command, config.Options...)
 
 	for _, input := range config.Input {
+		if config.ReadAtNativeRate {
+			command = append(command, "-readrate", "1")
+		}
+
+		if input.Reconnect {
+			command = append(command, "-reconnect", "1", "-reconnect_streamed", "1")
+
+			delay := input.ReconnectDelay
+			if delay == 0 {
+				delay = config.ReconnectDelay
+			}
+
+			if delay != 0 {
+				command = append(command, "-reconnect_delay_max", strconv.FormatUint(delay, 10))
+			}
+		}
+
 		// Add the resolved input to the process command
 		command = append(command, input.Options...)
 		command = append(command, "-i", input.Address)
 	}
 
 	for _, output := range config.Output {
+		if config.LimitBandwidthOut != 0 {
+			bitrate := strconv.FormatUint(config.LimitBandwidthOut*8, 10)
+			command = append(command, "-maxrate", bitrate, "-bufsize", bitrate)
+		}
+
 		// Add the resolved output to the process command
 		command = append(command, output.Options...)
 		command = append(command, output.Address)
@@ -108,6 +205,7 @@ type Process struct {
 	CreatedAt int64   `json:"created_at"`
 	UpdatedAt int64   `json:"updated_at"`
 	Order     string  `json:"order"`
+	Schema    uint64  `json:"schema"` // Version of the format of the config, older configs will be migrated on load
 }
 
 func (process *Process) Clone() *Process {
@@ -118,11 +216,26 @@ func (process *Process) Clone() *Process {
 		CreatedAt: process.CreatedAt,
 		UpdatedAt: process.UpdatedAt,
 		Order:     process.Order,
+		Schema:    process.Schema,
 	}
 
 	return clone
 }
 
+// StateTransition is a change of the state of a process.
+type StateTransition struct {
+	From string    // Previous state
+	To   string    // New state
+	Time time.Time // Time of the change
+}
+
+// AuditEntry is a change of a process.
+type AuditEntry struct {
+	Timestamp time.Time // Time of the change
+	Operation string    // "add", "update", "start", "stop", or "reload"
+	Actor     string    // Who or what changed the process, empty if unknown
+}
+
 type ProcessStates struct {
 	Finished  uint64
 	Starting  uint64
@@ -142,15 +255,20 @@ func (p *ProcessStates) Marshal(s process.States) {
 }
 
 type State struct {
-	Order     string        // Current order, e.g. "start", "stop"
-	State     string        // Current state, e.g. "running"
-	States    ProcessStates // Cumulated process states
-	Time      int64         // Unix timestamp of last status change
-	Duration  float64       // Runtime in seconds since last status change
-	Reconnect float64       // Seconds until next reconnect, negative if not reconnecting
-	LastLog   string        // Last recorded line from the process
-	Progress  Progress      // Progress data of the process
-	Memory    uint64        // Current memory consumption in bytes
-	CPU       float64       // Current CPU consumption in percent
-	Command   []string      // ffmpeg command line parameters
+	Order       string        // Current order, e.g. "start", "stop"
+	State       string        // Current state, e.g. "running"
+	States      ProcessStates // Cumulated process states
+	Time        int64         // Unix timestamp of last status change
+	Duration    float64       // Runtime in seconds since last status change
+	Reconnect   float64       // Seconds until next reconnect, negative if not reconnecting
+	ReconnectAt int64         // Unix timestamp of the next reconnect, -1 if not reconnecting
+	LastLog     string        // Last recorded line from the process
+	Progress    Progress      // Progress data of the process
+	Memory      uint64        // Current memory consumption in bytes
+	CPU         float64       // Current CPU consumption in percent
+	Command     []string      // ffmpeg command line parameters
+	Preempted   bool          // Whether the process has been stopped in favour of a process with higher priority
+	Stale       uint64        // Number of times the process has been detected as stale
+	ExitCode    int           // Exit code of the last run of ffmpeg, -1 if it is still running, never ran, or has been terminated by a signal
+	ExitSignal  string        // Signal that terminated the last run of ffmpeg, e.g. "killed", empty if there was no signal
 }
@@ -24,3 +24,46 @@ func TestCreateCommand(t *testing.T) {
 		"-output", "oututoption", "outputAddress",
 	}, command)
 }
+
+func TestCreateCommandBandwidthLimit(t *testing.T) {
+	config := &Config{
+		Options: []string{"-global", "global"},
+		Input: []ConfigIO{
+			{Address: "inputAddress", Options: []string{"-input", "inputoption"}},
+		},
+		Output: []ConfigIO{
+			{Address: "outputAddress", Options: []string{"-output", "oututoption"}},
+		},
+		ReadAtNativeRate:  true,
+		LimitBandwidthOut: 125000,
+	}
+
+	command := config.CreateCommand()
+	require.Equal(t, []string{
+		"-global", "global",
+		"-readrate", "1", "-input", "inputoption", "-i", "inputAddress",
+		"-maxrate", "1000000", "-bufsize", "1000000", "-output", "oututoption", "outputAddress",
+	}, command)
+}
+
+func TestCreateCommandInputReconnect(t *testing.T) {
+	config := &Config{
+		Input: []ConfigIO{
+			{Address: "http://example.com/a.m3u8", Reconnect: true, ReconnectDelay: 5},
+			{Address: "http://example.com/b.m3u8", Reconnect: true, Options: []string{"-input", "inputoption"}},
+			{Address: "rtmp://example.com/live/c"},
+		},
+		Output: []ConfigIO{
+			{Address: "outputAddress"},
+		},
+		ReconnectDelay: 15,
+	}
+
+	command := config.CreateCommand()
+	require.Equal(t, []string{
+		"-reconnect", "1", "-reconnect_streamed", "1", "-reconnect_delay_max", "5", "-i", "http://example.com/a.m3u8",
+		"-reconnect", "1", "-reconnect_streamed", "1", "-reconnect_delay_max", "15", "-input", "inputoption", "-i", "http://example.com/b.m3u8",
+		"-i", "rtmp://example.com/live/c",
+		"outputAddress",
+	}, command)
+}
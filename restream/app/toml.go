@@ -0,0 +1,840 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The TOML codec works on the values as they are produced by encoding/json, i.e. maps,
+// slices, strings, booleans and json.Number. Date and time values are not supported
+// because they can't be represented in JSON. Null values are omitted while encoding.
+// Integers in TOML are signed 64 bit values, larger integers are rejected in both directions.
+
+// marshalTOML encodes the table in TOML.
+func marshalTOML(table map[string]interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	if err := encodeTOMLTable(buf, nil, table); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func encodeTOMLTable(buf *bytes.Buffer, path []string, table map[string]interface{}) error {
+	keys := make([]string, 0, len(table))
+	for key := range table {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	// The key/value pairs have to be written before any sub-tables
+	for _, key := range keys {
+		value := table[key]
+		if value == nil || isTOMLTable(value) || isTOMLArrayOfTables(value) {
+			continue
+		}
+
+		buf.WriteString(encodeTOMLKey(key))
+		buf.WriteString(" = ")
+
+		if err := encodeTOMLValue(buf, value); err != nil {
+			return fmt.Errorf("%s: %w", strings.Join(append(path, key), "."), err)
+		}
+
+		buf.WriteString("\n")
+	}
+
+	for _, key := range keys {
+		value := table[key]
+		subpath := append(append([]string{}, path...), key)
+
+		if isTOMLTable(value) {
+			buf.WriteString("\n[" + encodeTOMLPath(subpath) + "]\n")
+
+			if err := encodeTOMLTable(buf, subpath, value.(map[string]interface{})); err != nil {
+				return err
+			}
+		} else if isTOMLArrayOfTables(value) {
+			for _, element := range value.([]interface{}) {
+				buf.WriteString("\n[[" + encodeTOMLPath(subpath) + "]]\n")
+
+				if err := encodeTOMLTable(buf, subpath, element.(map[string]interface{})); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func isTOMLTable(value interface{}) bool {
+	_, ok := value.(map[string]interface{})
+
+	return ok
+}
+
+func isTOMLArrayOfTables(value interface{}) bool {
+	array, ok := value.([]interface{})
+	if !ok || len(array) == 0 {
+		return false
+	}
+
+	for _, element := range array {
+		if !isTOMLTable(element) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func encodeTOMLValue(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		buf.WriteString(encodeTOMLString(v))
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		s := v.String()
+		if !strings.ContainsAny(s, ".eE") {
+			if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+				return fmt.Errorf("unsupported number: %s, integers must fit into 64 bit signed", s)
+			}
+		}
+		buf.WriteString(s)
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return fmt.Errorf("unsupported number: %v", v)
+		}
+		buf.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	case []interface{}:
+		buf.WriteString("[")
+		n := 0
+		for _, element := range v {
+			if element == nil {
+				continue
+			}
+
+			if n != 0 {
+				buf.WriteString(", ")
+			}
+
+			if err := encodeTOMLValue(buf, element); err != nil {
+				return err
+			}
+
+			n++
+		}
+		buf.WriteString("]")
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			if v[key] != nil {
+				keys = append(keys, key)
+			}
+		}
+
+		sort.Strings(keys)
+
+		buf.WriteString("{")
+		for i, key := range keys {
+			if i != 0 {
+				buf.WriteString(",")
+			}
+
+			buf.WriteString(" " + encodeTOMLKey(key) + " = ")
+
+			if err := encodeTOMLValue(buf, v[key]); err != nil {
+				return err
+			}
+		}
+		if len(keys) != 0 {
+			buf.WriteString(" ")
+		}
+		buf.WriteString("}")
+	default:
+		return fmt.Errorf("unsupported type: %T", value)
+	}
+
+	return nil
+}
+
+func encodeTOMLPath(path []string) string {
+	keys := make([]string, len(path))
+	for i, key := range path {
+		keys[i] = encodeTOMLKey(key)
+	}
+
+	return strings.Join(keys, ".")
+}
+
+func encodeTOMLKey(key string) string {
+	if len(key) == 0 {
+		return `""`
+	}
+
+	for i := 0; i < len(key); i++ {
+		if !isTOMLBareKeyChar(key[i]) {
+			return encodeTOMLString(key)
+		}
+	}
+
+	return key
+}
+
+func isTOMLBareKeyChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' || c == '-'
+}
+
+func encodeTOMLString(s string) string {
+	var b strings.Builder
+
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+
+	return b.String()
+}
+
+// unmarshalTOML decodes a TOML document into a table.
+func unmarshalTOML(data []byte) (map[string]interface{}, error) {
+	if !utf8.Valid(data) {
+		return nil, fmt.Errorf("invalid UTF-8")
+	}
+
+	p := &tomlParser{
+		data: string(data),
+		line: 1,
+	}
+
+	table, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", p.line, err)
+	}
+
+	return table, nil
+}
+
+type tomlParser struct {
+	data string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) eof() bool {
+	return p.pos >= len(p.data)
+}
+
+func (p *tomlParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+
+	return p.data[p.pos]
+}
+
+func (p *tomlParser) hasPrefix(prefix string) bool {
+	return strings.HasPrefix(p.data[p.pos:], prefix)
+}
+
+func (p *tomlParser) skipWhitespace() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+func (p *tomlParser) skipComment() {
+	if p.peek() != '#' {
+		return
+	}
+
+	for !p.eof() && p.peek() != '\n' {
+		p.pos++
+	}
+}
+
+// skipNewline consumes a line break and reports whether there was one.
+func (p *tomlParser) skipNewline() bool {
+	if p.hasPrefix("\r\n") {
+		p.pos += 2
+	} else if p.peek() == '\n' {
+		p.pos++
+	} else {
+		return false
+	}
+
+	p.line++
+
+	return true
+}
+
+// skipAll skips whitespace, comments and line breaks.
+func (p *tomlParser) skipAll() {
+	for {
+		p.skipWhitespace()
+		p.skipComment()
+
+		if !p.skipNewline() {
+			return
+		}
+	}
+}
+
+// expectLineEnd expects optional whitespace and a comment until the end of the line.
+func (p *tomlParser) expectLineEnd() error {
+	p.skipWhitespace()
+	p.skipComment()
+
+	if p.eof() || p.skipNewline() {
+		return nil
+	}
+
+	return fmt.Errorf("unexpected character %q", p.peek())
+}
+
+func (p *tomlParser) parse() (map[string]interface{}, error) {
+	root := map[string]interface{}{}
+	current := root
+
+	for {
+		p.skipAll()
+
+		if p.eof() {
+			return root, nil
+		}
+
+		if p.peek() == '[' {
+			table, err := p.parseHeader(root)
+			if err != nil {
+				return nil, err
+			}
+
+			current = table
+		} else {
+			if err := p.parseKeyValue(current); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := p.expectLineEnd(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseHeader parses a [table] or [[array]] header and returns the table the
+// following key/value pairs belong to.
+func (p *tomlParser) parseHeader(root map[string]interface{}) (map[string]interface{}, error) {
+	array := p.hasPrefix("[[")
+	if array {
+		p.pos += 2
+	} else {
+		p.pos++
+	}
+
+	p.skipWhitespace()
+
+	path, err := p.parseKey()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipWhitespace()
+
+	if array {
+		if !p.hasPrefix("]]") {
+			return nil, fmt.Errorf("expected ']]'")
+		}
+		p.pos += 2
+	} else {
+		if p.peek() != ']' {
+			return nil, fmt.Errorf("expected ']'")
+		}
+		p.pos++
+	}
+
+	parent, err := descendTOMLTable(root, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	key := path[len(path)-1]
+
+	if !array {
+		return descendTOMLTable(parent, []string{key})
+	}
+
+	table := map[string]interface{}{}
+
+	switch v := parent[key].(type) {
+	case nil:
+		parent[key] = []interface{}{table}
+	case []interface{}:
+		parent[key] = append(v, table)
+	default:
+		return nil, fmt.Errorf("%s: not an array of tables", strings.Join(path, "."))
+	}
+
+	return table, nil
+}
+
+// descendTOMLTable returns the table at the path, creating the missing tables. For an
+// array of tables its last element is used.
+func descendTOMLTable(table map[string]interface{}, path []string) (map[string]interface{}, error) {
+	for _, key := range path {
+		switch v := table[key].(type) {
+		case nil:
+			t := map[string]interface{}{}
+			table[key] = t
+			table = t
+		case map[string]interface{}:
+			table = v
+		case []interface{}:
+			if len(v) == 0 {
+				return nil, fmt.Errorf("%s: not a table", key)
+			}
+
+			t, ok := v[len(v)-1].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: not a table", key)
+			}
+
+			table = t
+		default:
+			return nil, fmt.Errorf("%s: not a table", key)
+		}
+	}
+
+	return table, nil
+}
+
+func (p *tomlParser) parseKeyValue(table map[string]interface{}) error {
+	path, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+
+	p.skipWhitespace()
+
+	if p.peek() != '=' {
+		return fmt.Errorf("expected '='")
+	}
+	p.pos++
+
+	p.skipWhitespace()
+
+	value, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+
+	table, err = descendTOMLTable(table, path[:len(path)-1])
+	if err != nil {
+		return err
+	}
+
+	key := path[len(path)-1]
+
+	if _, ok := table[key]; ok {
+		return fmt.Errorf("%s: duplicate key", strings.Join(path, "."))
+	}
+
+	table[key] = value
+
+	return nil
+}
+
+// parseKey parses a possibly dotted key.
+func (p *tomlParser) parseKey() ([]string, error) {
+	path := []string{}
+
+	for {
+		var key string
+		var err error
+
+		switch p.peek() {
+		case '"':
+			key, err = p.parseBasicString()
+		case '\'':
+			key, err = p.parseLiteralString()
+		default:
+			start := p.pos
+			for !p.eof() && isTOMLBareKeyChar(p.peek()) {
+				p.pos++
+			}
+
+			if start == p.pos {
+				return nil, fmt.Errorf("expected key")
+			}
+
+			key = p.data[start:p.pos]
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		path = append(path, key)
+
+		p.skipWhitespace()
+
+		if p.peek() != '.' {
+			return path, nil
+		}
+		p.pos++
+
+		p.skipWhitespace()
+	}
+}
+
+func (p *tomlParser) parseValue() (interface{}, error) {
+	switch {
+	case p.hasPrefix(`"""`):
+		return p.parseMultilineBasicString()
+	case p.hasPrefix(`'''`):
+		return p.parseMultilineLiteralString()
+	case p.peek() == '"':
+		return p.parseBasicString()
+	case p.peek() == '\'':
+		return p.parseLiteralString()
+	case p.peek() == '[':
+		return p.parseArray()
+	case p.peek() == '{':
+		return p.parseInlineTable()
+	}
+
+	start := p.pos
+	for !p.eof() && strings.IndexByte("0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ_+-.:", p.peek()) != -1 {
+		p.pos++
+	}
+
+	token := p.data[start:p.pos]
+
+	switch token {
+	case "":
+		return nil, fmt.Errorf("expected value")
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+
+	return parseTOMLNumber(token)
+}
+
+func parseTOMLNumber(token string) (interface{}, error) {
+	s := token
+
+	if strings.Contains(s, "_") {
+		if strings.HasPrefix(s, "_") || strings.HasSuffix(s, "_") || strings.Contains(s, "__") {
+			return nil, fmt.Errorf("invalid number: %s", token)
+		}
+
+		s = strings.ReplaceAll(s, "_", "")
+	}
+
+	for prefix, base := range map[string]int{"0x": 16, "0o": 8, "0b": 2} {
+		if strings.HasPrefix(s, prefix) {
+			n, err := strconv.ParseInt(s[2:], base, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number: %s", token)
+			}
+
+			return json.Number(strconv.FormatInt(n, 10)), nil
+		}
+	}
+
+	s = strings.TrimPrefix(s, "+")
+
+	if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return json.Number(s), nil
+	} else if errors.Is(err, strconv.ErrRange) {
+		return nil, fmt.Errorf("integer out of range: %s", token)
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) || strings.ContainsAny(s, "xXpP") {
+		return nil, fmt.Errorf("invalid or unsupported value: %s", token)
+	}
+
+	return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
+
+func (p *tomlParser) parseArray() (interface{}, error) {
+	array := []interface{}{}
+
+	// Skip the '['
+	p.pos++
+
+	for {
+		p.skipAll()
+
+		if p.peek() == ']' {
+			p.pos++
+			return array, nil
+		}
+
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		array = append(array, value)
+
+		p.skipAll()
+
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return nil, fmt.Errorf("expected ',' or ']'")
+		}
+	}
+}
+
+func (p *tomlParser) parseInlineTable() (interface{}, error) {
+	table := map[string]interface{}{}
+
+	// Skip the '{'
+	p.pos++
+
+	p.skipWhitespace()
+
+	if p.peek() == '}' {
+		p.pos++
+		return table, nil
+	}
+
+	for {
+		p.skipWhitespace()
+
+		if err := p.parseKeyValue(table); err != nil {
+			return nil, err
+		}
+
+		p.skipWhitespace()
+
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return table, nil
+		default:
+			return nil, fmt.Errorf("expected ',' or '}'")
+		}
+	}
+}
+
+func (p *tomlParser) parseLiteralString() (string, error) {
+	// Skip the opening quote
+	p.pos++
+
+	end := strings.IndexAny(p.data[p.pos:], "'\n")
+	if end == -1 || p.data[p.pos+end] != '\'' {
+		return "", fmt.Errorf("unterminated string")
+	}
+
+	s := p.data[p.pos : p.pos+end]
+	p.pos += end + 1
+
+	return s, nil
+}
+
+func (p *tomlParser) parseMultilineLiteralString() (string, error) {
+	p.pos += 3
+
+	end := strings.Index(p.data[p.pos:], `'''`)
+	if end == -1 {
+		return "", fmt.Errorf("unterminated string")
+	}
+
+	// Up to two quotes are allowed right before the closing delimiter
+	for i := 0; i < 2 && strings.HasPrefix(p.data[p.pos+end+1:], `'''`); i++ {
+		end++
+	}
+
+	s := p.data[p.pos : p.pos+end]
+	p.pos += end + 3
+
+	p.line += strings.Count(s, "\n")
+
+	return trimTOMLFirstNewline(s), nil
+}
+
+func (p *tomlParser) parseBasicString() (string, error) {
+	var b strings.Builder
+
+	// Skip the opening quote
+	p.pos++
+
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", fmt.Errorf("unterminated string")
+		}
+
+		c := p.peek()
+
+		if c == '"' {
+			p.pos++
+			return b.String(), nil
+		}
+
+		if c == '\\' {
+			if err := p.parseEscape(&b); err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		b.WriteByte(c)
+		p.pos++
+	}
+}
+
+func (p *tomlParser) parseMultilineBasicString() (string, error) {
+	var b strings.Builder
+
+	p.pos += 3
+
+	if p.hasPrefix("\r\n") || p.peek() == '\n' {
+		p.skipNewline()
+	}
+
+	for {
+		if p.eof() {
+			return "", fmt.Errorf("unterminated string")
+		}
+
+		if p.hasPrefix(`"""`) {
+			// Up to two quotes are allowed right before the closing delimiter
+			for i := 0; i < 2 && p.hasPrefix(`""""`); i++ {
+				b.WriteByte('"')
+				p.pos++
+			}
+
+			p.pos += 3
+			return b.String(), nil
+		}
+
+		c := p.peek()
+
+		if c == '\\' {
+			// A backslash at the end of a line trims all whitespace up to the next
+			// non-whitespace character
+			rest := strings.TrimLeft(p.data[p.pos+1:], " \t")
+			if strings.HasPrefix(rest, "\n") || strings.HasPrefix(rest, "\r\n") {
+				p.pos = len(p.data) - len(rest)
+				for {
+					p.skipWhitespace()
+					if !p.skipNewline() {
+						break
+					}
+				}
+				continue
+			}
+
+			if err := p.parseEscape(&b); err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		if c == '\n' {
+			p.line++
+		}
+
+		b.WriteByte(c)
+		p.pos++
+	}
+}
+
+func (p *tomlParser) parseEscape(b *strings.Builder) error {
+	// Skip the backslash
+	p.pos++
+
+	c := p.peek()
+	p.pos++
+
+	switch c {
+	case 'b':
+		b.WriteByte('\b')
+	case 't':
+		b.WriteByte('\t')
+	case 'n':
+		b.WriteByte('\n')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case 'e':
+		b.WriteByte(0x1b)
+	case '"':
+		b.WriteByte('"')
+	case '\\':
+		b.WriteByte('\\')
+	case 'u', 'U':
+		n := 4
+		if c == 'U' {
+			n = 8
+		}
+
+		if p.pos+n > len(p.data) {
+			return fmt.Errorf("invalid escape sequence")
+		}
+
+		r, err := strconv.ParseUint(p.data[p.pos:p.pos+n], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(r)) {
+			return fmt.Errorf("invalid escape sequence")
+		}
+
+		b.WriteRune(rune(r))
+		p.pos += n
+	default:
+		return fmt.Errorf("invalid escape sequence")
+	}
+
+	return nil
+}
+
+func trimTOMLFirstNewline(s string) string {
+	if strings.HasPrefix(s, "\r\n") {
+		return s[2:]
+	}
+
+	return strings.TrimPrefix(s, "\n")
+}
@@ -0,0 +1,299 @@
+package restream
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/datarhei/core/v16/restream/app"
+)
+
+// auditMetadataKey is the key of the process metadata for the audit trail. In the system
+// metadata it holds the audit trails of the deleted processes. The key is reserved and
+// can't be set or read with the metadata functions.
+const auditMetadataKey = "audit"
+
+// maxAuditEntries is the max. number of changes that are kept for a process.
+const maxAuditEntries = 100
+
+// maxDeletedAuditTrails is the max. number of deleted processes whose audit trail is kept.
+const maxDeletedAuditTrails = 100
+
+// isReservedMetadataKey returns whether the metadata key is used internally.
+func isReservedMetadataKey(key string) bool {
+	return key == auditMetadataKey || key == sequenceMetadataKey
+}
+
+// publicMetadata returns the metadata without the reserved keys.
+func publicMetadata(metadata map[string]interface{}) map[string]interface{} {
+	public := map[string]interface{}{}
+
+	for key, data := range metadata {
+		if isReservedMetadataKey(key) {
+			continue
+		}
+
+		public[key] = data
+	}
+
+	if len(public) == 0 {
+		return nil
+	}
+
+	return public
+}
+
+// audit records the operation on the task in its audit trail, if enabled. The oldest
+// entries will be removed if the trail is full. A process that is added with the ID of a
+// deleted process continues its audit trail. The caller has to hold the lock.
+func (r *restream) audit(t *task, operation, actor string) {
+	if !r.auditTrail || t == nil {
+		return
+	}
+
+	entries := t.auditEntries()
+
+	if len(entries) == 0 {
+		if deleted, ok := r.deletedAuditTrails()[t.id]; ok {
+			entries = deleted
+			r.forgetDeletedAudit(t.id)
+		}
+	}
+
+	t.setMetadata(auditMetadataKey, appendAuditEntry(entries, operation, actor))
+	r.markChanged(t.id)
+}
+
+// auditDelete records the deletion of the task, if enabled. The audit trail of the task is
+// kept in the system metadata. The caller has to hold the lock.
+func (r *restream) auditDelete(t *task, actor string) {
+	if !r.auditTrail || t == nil {
+		return
+	}
+
+	trails := r.deletedAuditTrails()
+	trails[t.id] = appendAuditEntry(t.auditEntries(), "delete", actor)
+
+	// Forget the trails of the processes that have been deleted first
+	for len(trails) > maxDeletedAuditTrails {
+		oldest := ""
+		for id, entries := range trails {
+			if len(oldest) == 0 || entries[len(entries)-1].Timestamp.Before(trails[oldest][len(trails[oldest])-1].Timestamp) {
+				oldest = id
+			}
+		}
+
+		delete(trails, oldest)
+	}
+
+	r.setDeletedAuditTrails(trails)
+}
+
+// forgetDeletedAudit removes the audit trail of the deleted process with the ID. The
+// caller has to hold the lock.
+func (r *restream) forgetDeletedAudit(id string) {
+	trails := r.deletedAuditTrails()
+	if _, ok := trails[id]; !ok {
+		return
+	}
+
+	delete(trails, id)
+
+	r.setDeletedAuditTrails(trails)
+}
+
+// deletedAuditTrails returns a copy of the audit trails of the deleted processes from the
+// system metadata.
+func (r *restream) deletedAuditTrails() map[string][]app.AuditEntry {
+	trails := map[string][]app.AuditEntry{}
+
+	data, ok := r.metadata[auditMetadataKey]
+	if !ok {
+		return trails
+	}
+
+	if t, ok := data.(map[string][]app.AuditEntry); ok {
+		for id, entries := range t {
+			trails[id] = entries
+		}
+
+		return trails
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return trails
+	}
+
+	json.Unmarshal(raw, &trails)
+
+	for id, entries := range trails {
+		if len(entries) == 0 {
+			delete(trails, id)
+		}
+	}
+
+	return trails
+}
+
+// setDeletedAuditTrails writes the audit trails of the deleted processes to the system
+// metadata.
+func (r *restream) setDeletedAuditTrails(trails map[string][]app.AuditEntry) {
+	if len(trails) == 0 {
+		delete(r.metadata, auditMetadataKey)
+	} else {
+		if r.metadata == nil {
+			r.metadata = make(map[string]interface{})
+		}
+
+		r.metadata[auditMetadataKey] = trails
+	}
+
+	if len(r.metadata) == 0 {
+		r.metadata = nil
+	}
+
+	r.markSystemChanged()
+}
+
+// appendAuditEntry appends an entry for the operation to the audit trail and removes the
+// oldest entries if the trail is full.
+func appendAuditEntry(entries []app.AuditEntry, operation, actor string) []app.AuditEntry {
+	entries = append(entries, app.AuditEntry{
+		Timestamp: time.Now(),
+		Operation: operation,
+		Actor:     actor,
+	})
+
+	if len(entries) > maxAuditEntries {
+		entries = entries[len(entries)-maxAuditEntries:]
+	}
+
+	return entries
+}
+
+// auditEntries returns the audit trail of the task from its metadata. After restoring
+// the processes from the store, the entries are not yet of the type app.AuditEntry.
+func (t *task) auditEntries() []app.AuditEntry {
+	data, ok := t.metadata[auditMetadataKey]
+	if !ok {
+		return []app.AuditEntry{}
+	}
+
+	if entries, ok := data.([]app.AuditEntry); ok {
+		return append([]app.AuditEntry{}, entries...)
+	}
+
+	entries := []app.AuditEntry{}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return entries
+	}
+
+	json.Unmarshal(raw, &entries)
+
+	return entries
+}
+
+// GetProcessHistory returns the recorded changes of the process, the oldest first. The
+// changes are only recorded if the audit trail is enabled. The changes of a deleted process
+// are available until a process with the same ID is added.
+func (r *restream) GetProcessHistory(id string) ([]app.AuditEntry, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	task, ok := r.tasks[id]
+	if !ok {
+		if entries, ok := r.deletedAuditTrails()[id]; ok {
+			return entries, nil
+		}
+
+		return nil, ErrUnknownProcess
+	}
+
+	return task.auditEntries(), nil
+}
+
+// actorRestreamer records the actor in the audit trail for all changes of processes.
+type actorRestreamer struct {
+	*restream
+
+	actor string
+}
+
+// WithActor returns a Restreamer that records the actor in the audit trail when
+// adding, updating, deleting, starting, stopping, restarting, or reloading a process,
+// also for the operations on groups.
+func (r *restream) WithActor(actor string) Restreamer {
+	return &actorRestreamer{
+		restream: r,
+		actor:    actor,
+	}
+}
+
+func (a *actorRestreamer) AddProcess(config *app.Config) error {
+	_, err := a.restream.addProcessAs(config, a.actor)
+
+	return err
+}
+
+func (a *actorRestreamer) AddProcessWithID(config *app.Config) (string, error) {
+	process, err := a.restream.addProcessAs(config, a.actor)
+	if err != nil {
+		return "", err
+	}
+
+	return process.ID, nil
+}
+
+func (a *actorRestreamer) AddProcessReturn(config *app.Config) (*app.Process, error) {
+	return a.restream.addProcessAs(config, a.actor)
+}
+
+func (a *actorRestreamer) UpdateProcess(id string, config *app.Config) error {
+	return a.restream.updateProcessAs(id, config, a.actor)
+}
+
+func (a *actorRestreamer) StartProcess(id string) error {
+	return a.restream.startProcessAs(id, a.actor)
+}
+
+func (a *actorRestreamer) StopProcess(id string) error {
+	return a.restream.stopProcessAs(id, a.actor)
+}
+
+func (a *actorRestreamer) ReloadProcess(id string) error {
+	return a.restream.reloadProcessAs(id, a.actor)
+}
+
+func (a *actorRestreamer) TryAddProcess(config *app.Config, timeout time.Duration) error {
+	return a.restream.tryAddProcessAs(config, timeout, a.actor)
+}
+
+func (a *actorRestreamer) PutProcess(config *app.Config) (bool, error) {
+	return a.restream.putProcessAs(config, a.actor)
+}
+
+func (a *actorRestreamer) DeleteProcess(id string) error {
+	return a.restream.deleteProcessAs(id, a.actor)
+}
+
+func (a *actorRestreamer) ForceDeleteProcess(id string) error {
+	return a.restream.forceDeleteProcessAs(id, a.actor)
+}
+
+func (a *actorRestreamer) RestartProcess(id string) error {
+	return a.restream.restartProcessAs(id, a.actor)
+}
+
+func (a *actorRestreamer) StartGroup(groupID string) error {
+	return a.restream.startGroupAs(groupID, a.actor)
+}
+
+func (a *actorRestreamer) StopGroup(groupID string) error {
+	return a.restream.stopGroupAs(groupID, a.actor)
+}
+
+func (a *actorRestreamer) DeleteGroup(groupID string) error {
+	return a.restream.deleteGroupAs(groupID, a.actor)
+}
@@ -0,0 +1,87 @@
+package restream
+
+// commit writes the changes of an operation to the store. In the "strict" failure mode the
+// changes are written immediately and the error of the store is returned, such that the
+// caller can revert the operation. Otherwise the changes are saved like with save. The
+// lock must be held.
+func (r *restream) commit() error {
+	if r.failureMode != "strict" {
+		r.save()
+		return nil
+	}
+
+	return r.persist()
+}
+
+// revertAdd removes the added process with the ID after its changes couldn't be written
+// to the store. The lock must be held.
+func (r *restream) revertAdd(id string) {
+	if err := r.stopProcess(id); err != nil {
+		r.logger.Error().WithField("id", id).WithError(err).Log("Failed to revert adding the process")
+		return
+	}
+
+	if err := r.deleteProcess(id); err != nil {
+		r.logger.Error().WithField("id", id).WithError(err).Log("Failed to revert adding the process")
+	}
+}
+
+// revertUpdate replaces the updated process with the ID with the replaced task after the
+// changes couldn't be written to the store. The lock must be held.
+func (r *restream) revertUpdate(id string, replaced *task) {
+	metadata := copyMetadata(replaced.metadata)
+
+	if err := r.updateProcess(id, replaced.process.Config.Clone()); err != nil {
+		r.logger.Error().WithField("id", id).WithError(err).Log("Failed to revert updating the process")
+		return
+	}
+
+	t := r.tasks[replaced.id]
+	t.process.CreatedAt = replaced.process.CreatedAt
+	t.process.UpdatedAt = replaced.process.UpdatedAt
+	t.process.Schema = replaced.process.Schema
+	t.metadata = metadata
+	t.annotations = replaced.annotations
+	t.changedAt = replaced.changedAt
+}
+
+// revertDelete adds the deleted task again after the changes couldn't be written to the
+// store. The lock must be held.
+func (r *restream) revertDelete(deleted *task) {
+	t, err := r.createTaskWithMetadata(deleted.process.Config.Clone(), copyMetadata(deleted.metadata))
+	if err == nil {
+		t.process.CreatedAt = deleted.process.CreatedAt
+		t.process.UpdatedAt = deleted.process.UpdatedAt
+		t.process.Order = deleted.process.Order
+		t.process.Schema = deleted.process.Schema
+		t.history = deleted.history
+		t.annotations = deleted.annotations
+		t.changedAt = deleted.changedAt
+
+		deleted.parser.TransferReportHistory(t.parser)
+
+		err = r.addTask(t)
+	}
+
+	if err == nil {
+		r.forgetDeletedAudit(deleted.id)
+	}
+
+	if err != nil {
+		r.logger.Error().WithField("id", deleted.id).WithError(err).Log("Failed to revert deleting the process")
+	}
+}
+
+// copyMetadata returns a shallow copy of the metadata of a process.
+func copyMetadata(metadata map[string]interface{}) map[string]interface{} {
+	if metadata == nil {
+		return nil
+	}
+
+	c := make(map[string]interface{}, len(metadata))
+	for key, value := range metadata {
+		c[key] = value
+	}
+
+	return c
+}
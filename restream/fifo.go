@@ -0,0 +1,196 @@
+package restream
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/datarhei/core/v16/restream/app"
+)
+
+// reNamedPipe matches the address of a named pipe, e.g. pipe:feed1. Names that start with a
+// digit are not allowed such that pipe:0 and pipe:1 still refer to stdin and stdout.
+var reNamedPipe = regexp.MustCompile(`^pipe:([A-Za-z_][A-Za-z0-9_.-]*)$`)
+
+// fifoPath returns the path of the FIFO for the named pipe.
+func (r *restream) fifoPath(name string) string {
+	return filepath.Join(r.pipeDir, name)
+}
+
+// resolveNamedPipes replaces the addresses of the named pipes in the inputs and outputs of
+// the config with the addresses of their FIFOs. It returns the paths of the FIFOs the outputs
+// are writing to.
+func (r *restream) resolveNamedPipes(config *app.Config) ([]string, error) {
+	resolve := func(address string) (string, bool, error) {
+		matches := reNamedPipe.FindStringSubmatch(address)
+		if matches == nil {
+			return address, false, nil
+		}
+
+		if len(r.pipeDir) == 0 {
+			return address, false, fmt.Errorf("named pipes are not enabled (%s)", address)
+		}
+
+		return "file:" + r.fifoPath(matches[1]), true, nil
+	}
+
+	for i, input := range config.Input {
+		address, _, err := resolve(input.Address)
+		if err != nil {
+			return nil, fmt.Errorf("the address for input '#%s:%s' is invalid: %w", config.ID, input.ID, err)
+		}
+
+		config.Input[i].Address = address
+
+		for j, a := range input.Addresses {
+			address, _, err := resolve(a)
+			if err != nil {
+				return nil, fmt.Errorf("the address for input '#%s:%s' is invalid: %w", config.ID, input.ID, err)
+			}
+
+			config.Input[i].Addresses[j] = address
+		}
+	}
+
+	fifos := []string{}
+
+	for i, output := range config.Output {
+		address, isPipe, err := resolve(output.Address)
+		if err != nil {
+			return nil, fmt.Errorf("the address for output '#%s:%s' is invalid: %w", config.ID, output.ID, err)
+		}
+
+		if !isPipe {
+			continue
+		}
+
+		path := strings.TrimPrefix(address, "file:")
+
+		for _, fifo := range fifos {
+			if fifo == path {
+				return nil, fmt.Errorf("the named pipe '%s' is written by more than one output of the process '%s'", output.Address, config.ID)
+			}
+		}
+
+		config.Output[i].Address = address
+		fifos = append(fifos, path)
+	}
+
+	return fifos, nil
+}
+
+// checkNamedPipes checks whether any other process, except the process with the excluded ID,
+// is writing to the same named pipes as the task. Reading from a named pipe of another process
+// is a reference to that process and must be allowed by the reference policy.
+func (r *restream) checkNamedPipes(t *task, exclude string) error {
+	for id, other := range r.tasks {
+		if id == exclude || id == t.id {
+			continue
+		}
+
+		for _, fifo := range t.fifos {
+			if containsString(other.fifos, fifo) {
+				return fmt.Errorf("the named pipe '%s' is already written by the process '%s'", "pipe:"+filepath.Base(fifo), id)
+			}
+
+			if containsString(r.readFIFOs(other), fifo) {
+				if err := r.checkReferencePolicy(id, t.id, "pipe:"+filepath.Base(fifo)); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, fifo := range r.readFIFOs(t) {
+			if containsString(other.fifos, fifo) {
+				if err := r.checkReferencePolicy(t.id, id, "pipe:"+filepath.Base(fifo)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// readFIFOs returns the paths of the FIFOs the inputs of the resolved config of the task
+// are reading from.
+func (r *restream) readFIFOs(t *task) []string {
+	fifos := []string{}
+
+	if len(r.pipeDir) == 0 {
+		return fifos
+	}
+
+	for _, input := range t.config.Input {
+		for _, address := range append([]string{input.Address}, input.Addresses...) {
+			path := strings.TrimPrefix(address, "file:")
+
+			if filepath.Dir(path) == filepath.Clean(r.pipeDir) {
+				fifos = append(fifos, path)
+			}
+		}
+	}
+
+	return fifos
+}
+
+// createFIFOs creates the FIFOs the task is writing to. Already existing FIFOs are kept.
+func (r *restream) createFIFOs(t *task) error {
+	if len(t.fifos) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(r.pipeDir, 0755); err != nil {
+		return fmt.Errorf("failed to create the directory for the named pipes: %w", err)
+	}
+
+	for _, fifo := range t.fifos {
+		if info, err := os.Stat(fifo); err == nil {
+			if info.Mode()&os.ModeNamedPipe == 0 {
+				return fmt.Errorf("the file '%s' for the named pipe already exists and is not a FIFO", fifo)
+			}
+
+			continue
+		}
+
+		if err := mkfifo(fifo, 0644); err != nil {
+			return fmt.Errorf("failed to create the FIFO '%s': %w", fifo, err)
+		}
+	}
+
+	return nil
+}
+
+// removeFIFOs removes the FIFOs of the task.
+func (r *restream) removeFIFOs(t *task, fifos []string) {
+	for _, fifo := range fifos {
+		if err := os.Remove(fifo); err != nil && !os.IsNotExist(err) {
+			t.logger.Warn().WithField("path", fifo).WithError(err).Log("Failed to remove FIFO")
+		}
+	}
+}
+
+// fifosExcept returns the FIFOs that are not in the excluded list.
+func fifosExcept(fifos, exclude []string) []string {
+	remaining := []string{}
+
+	for _, fifo := range fifos {
+		if !containsString(exclude, fifo) {
+			remaining = append(remaining, fifo)
+		}
+	}
+
+	return remaining
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package restream
+
+import (
+	"os"
+	"syscall"
+)
+
+func mkfifo(path string, mode os.FileMode) error {
+	return syscall.Mkfifo(path, uint32(mode.Perm()))
+}
@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package restream
+
+import (
+	"fmt"
+	"os"
+)
+
+func mkfifo(path string, mode os.FileMode) error {
+	return fmt.Errorf("named pipes are not supported on this platform")
+}
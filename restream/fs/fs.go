@@ -13,13 +13,41 @@ import (
 type Config struct {
 	FS     fs.Filesystem
 	Logger log.Logger
+
+	// OnNewFile is called for every new file that matches a cleanup pattern. A file is
+	// considered as new as soon as its size and modification time didn't change between
+	// two cleanup runs, i.e. it is not written to anymore. Files that already exist when
+	// the pattern is set are ignored.
+	OnNewFile func(id string, pattern Pattern, name string)
 }
 
 type Pattern struct {
-	Pattern       string
-	MaxFiles      uint
-	MaxFileAge    time.Duration
+	Pattern    string
+	MaxFiles   uint
+	MaxFileAge time.Duration
+
+	// RetentionWindow removes the files that have been modified before now minus the window.
+	// Files that have been modified exactly at the start of the window are kept. In contrast
+	// to MaxFileAge, the most recently modified file is never removed because it might be
+	// still written to, e.g. the current segment of a process.
+	RetentionWindow time.Duration
+
 	PurgeOnDelete bool
+	OutputID      string
+}
+
+// observedFile is the state of a file that matches a cleanup pattern
+type observedFile struct {
+	size     int64
+	modTime  time.Time
+	reported bool
+}
+
+// newFile is a file that is reported with the OnNewFile callback
+type newFile struct {
+	id      string
+	pattern Pattern
+	name    string
 }
 
 type Filesystem interface {
@@ -31,6 +59,12 @@ type Filesystem interface {
 	// UnsetCleanup
 	UnsetCleanup(id string)
 
+	// DryRunCleanup returns the files that would currently be removed by the cleanup
+	DryRunCleanup(id string) []string
+
+	// Cleanup returns the cleanup patterns that are currently set for the id
+	Cleanup(id string) []Pattern
+
 	// Start
 	Start()
 
@@ -44,6 +78,10 @@ type filesystem struct {
 	cleanupPatterns map[string][]Pattern
 	cleanupLock     sync.RWMutex
 
+	onNewFile    func(id string, pattern Pattern, name string)
+	observed     map[string]map[string]observedFile
+	observedLock sync.Mutex
+
 	stopTicker context.CancelFunc
 
 	startOnce sync.Once
@@ -56,6 +94,7 @@ func New(config Config) Filesystem {
 	rfs := &filesystem{
 		Filesystem: config.FS,
 		logger:     config.Logger,
+		onNewFile:  config.OnNewFile,
 	}
 
 	if rfs.logger == nil {
@@ -68,6 +107,7 @@ func New(config Config) Filesystem {
 	})
 
 	rfs.cleanupPatterns = make(map[string][]Pattern)
+	rfs.observed = make(map[string]map[string]observedFile)
 
 	// already drain the stop
 	rfs.stopOnce.Do(func() {})
@@ -111,6 +151,28 @@ func (rfs *filesystem) SetCleanup(id string, patterns []Pattern) {
 		}).Log("Add pattern")
 	}
 
+	if rfs.onNewFile != nil {
+		rfs.observedLock.Lock()
+
+		observed, ok := rfs.observed[id]
+		if !ok {
+			observed = make(map[string]observedFile)
+			rfs.observed[id] = observed
+		}
+
+		for _, p := range patterns {
+			for _, f := range rfs.Filesystem.List("/", p.Pattern) {
+				observed[f.Name()] = observedFile{
+					size:     f.Size(),
+					modTime:  f.ModTime(),
+					reported: true,
+				}
+			}
+		}
+
+		rfs.observedLock.Unlock()
+	}
+
 	rfs.cleanupLock.Lock()
 	defer rfs.cleanupLock.Unlock()
 
@@ -126,47 +188,200 @@ func (rfs *filesystem) UnsetCleanup(id string) {
 	patterns := rfs.cleanupPatterns[id]
 	delete(rfs.cleanupPatterns, id)
 
+	rfs.observedLock.Lock()
+	delete(rfs.observed, id)
+	rfs.observedLock.Unlock()
+
 	rfs.purge(patterns)
 }
 
 func (rfs *filesystem) cleanup() {
+	newFiles := []newFile{}
+
 	rfs.cleanupLock.RLock()
-	defer rfs.cleanupLock.RUnlock()
 
-	for _, patterns := range rfs.cleanupPatterns {
+	for id, patterns := range rfs.cleanupPatterns {
+		seen := map[string]struct{}{}
+
 		for _, pattern := range patterns {
-			filesAndDirs := rfs.Filesystem.List("/", pattern.Pattern)
+			files := rfs.files(pattern)
 
-			files := []fs.FileInfo{}
-			for _, f := range filesAndDirs {
-				if f.IsDir() {
-					continue
+			if rfs.onNewFile != nil {
+				// Observe each file only once per run, even if it matches multiple patterns
+				unseen := []fs.FileInfo{}
+				for _, f := range files {
+					if _, ok := seen[f.Name()]; ok {
+						continue
+					}
+
+					seen[f.Name()] = struct{}{}
+					unseen = append(unseen, f)
 				}
 
-				files = append(files, f)
+				for _, f := range rfs.observe(id, unseen) {
+					newFiles = append(newFiles, newFile{
+						id:      id,
+						pattern: pattern,
+						name:    f,
+					})
+				}
 			}
 
-			sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+			exceeded, expired, outdated := obsoleteFiles(pattern, files, time.Now())
 
-			if pattern.MaxFiles > 0 && uint(len(files)) > pattern.MaxFiles {
-				for i := uint(0); i < uint(len(files))-pattern.MaxFiles; i++ {
-					rfs.logger.Debug().WithField("path", files[i].Name()).Log("Remove file because MaxFiles is exceeded")
-					rfs.Filesystem.Remove(files[i].Name())
-				}
+			for _, f := range exceeded {
+				rfs.logger.Debug().WithField("path", f.Name()).Log("Remove file because MaxFiles is exceeded")
+				rfs.Filesystem.Remove(f.Name())
+			}
+
+			for _, f := range expired {
+				rfs.logger.Debug().WithField("path", f.Name()).Log("Remove file because MaxFileAge is exceeded")
+				rfs.Filesystem.Remove(f.Name())
 			}
 
-			if pattern.MaxFileAge > 0 {
-				bestBefore := time.Now().Add(-pattern.MaxFileAge)
+			for _, f := range outdated {
+				rfs.logger.Debug().WithField("path", f.Name()).Log("Remove file because RetentionWindow is exceeded")
+				rfs.Filesystem.Remove(f.Name())
+			}
+		}
 
-				for _, f := range files {
-					if f.ModTime().Before(bestBefore) {
-						rfs.logger.Debug().WithField("path", f.Name()).Log("Remove file because MaxFileAge is exceeded")
-						rfs.Filesystem.Remove(f.Name())
-					}
+		if rfs.onNewFile != nil {
+			rfs.forget(id, seen)
+		}
+	}
+
+	rfs.cleanupLock.RUnlock()
+
+	for _, f := range newFiles {
+		rfs.onNewFile(f.id, f.pattern, f.name)
+	}
+}
+
+// DryRunCleanup returns the names of the files that would currently be removed by the
+// cleanup patterns of the given group. Nothing will be removed.
+func (rfs *filesystem) DryRunCleanup(id string) []string {
+	rfs.cleanupLock.RLock()
+	defer rfs.cleanupLock.RUnlock()
+
+	names := []string{}
+	seen := map[string]struct{}{}
+
+	for _, pattern := range rfs.cleanupPatterns[id] {
+		exceeded, expired, outdated := obsoleteFiles(pattern, rfs.files(pattern), time.Now())
+
+		for _, files := range [][]fs.FileInfo{exceeded, expired, outdated} {
+			for _, f := range files {
+				if _, ok := seen[f.Name()]; ok {
+					continue
 				}
+
+				seen[f.Name()] = struct{}{}
+				names = append(names, f.Name())
 			}
 		}
 	}
+
+	return names
+}
+
+func (rfs *filesystem) Cleanup(id string) []Pattern {
+	rfs.cleanupLock.RLock()
+	defer rfs.cleanupLock.RUnlock()
+
+	return append([]Pattern{}, rfs.cleanupPatterns[id]...)
+}
+
+// files returns all files, without directories, that match the pattern.
+func (rfs *filesystem) files(pattern Pattern) []fs.FileInfo {
+	filesAndDirs := rfs.Filesystem.List("/", pattern.Pattern)
+
+	files := []fs.FileInfo{}
+	for _, f := range filesAndDirs {
+		if f.IsDir() {
+			continue
+		}
+
+		files = append(files, f)
+	}
+
+	return files
+}
+
+// obsoleteFiles returns the files that exceed the max. number of files, the files that
+// are older than the max. file age, and the files that are outside of the retention window
+// of the pattern. The files will be sorted by their modification time.
+func obsoleteFiles(pattern Pattern, files []fs.FileInfo, now time.Time) (exceeded, expired, outdated []fs.FileInfo) {
+	sort.SliceStable(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+
+	if pattern.MaxFiles > 0 && uint(len(files)) > pattern.MaxFiles {
+		exceeded = files[:uint(len(files))-pattern.MaxFiles]
+	}
+
+	if pattern.MaxFileAge > 0 {
+		bestBefore := now.Add(-pattern.MaxFileAge)
+
+		for _, f := range files {
+			if f.ModTime().Before(bestBefore) {
+				expired = append(expired, f)
+			}
+		}
+	}
+
+	if pattern.RetentionWindow > 0 && len(files) != 0 {
+		start := now.Add(-pattern.RetentionWindow)
+
+		// The most recent file is never removed
+		for _, f := range files[:len(files)-1] {
+			if f.ModTime().Before(start) {
+				outdated = append(outdated, f)
+			}
+		}
+	}
+
+	return exceeded, expired, outdated
+}
+
+// observe updates the state of the observed files of the given group and returns the
+// names of the files that are not written to anymore and haven't been reported yet.
+func (rfs *filesystem) observe(id string, files []fs.FileInfo) []string {
+	rfs.observedLock.Lock()
+	defer rfs.observedLock.Unlock()
+
+	observed, ok := rfs.observed[id]
+	if !ok {
+		observed = make(map[string]observedFile)
+		rfs.observed[id] = observed
+	}
+
+	names := []string{}
+
+	for _, f := range files {
+		o, ok := observed[f.Name()]
+		if ok && !o.reported && o.size == f.Size() && o.modTime.Equal(f.ModTime()) {
+			o.reported = true
+			names = append(names, f.Name())
+		} else {
+			o.size = f.Size()
+			o.modTime = f.ModTime()
+		}
+
+		observed[f.Name()] = o
+	}
+
+	return names
+}
+
+// forget removes the files from the observed files of the given group that didn't
+// match any pattern anymore.
+func (rfs *filesystem) forget(id string, seen map[string]struct{}) {
+	rfs.observedLock.Lock()
+	defer rfs.observedLock.Unlock()
+
+	for name := range rfs.observed[id] {
+		if _, ok := seen[name]; !ok {
+			delete(rfs.observed[id], name)
+		}
+	}
 }
 
 func (rfs *filesystem) purge(patterns []Pattern) (nfiles uint64) {
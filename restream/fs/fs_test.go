@@ -1,6 +1,7 @@
 package fs
 
 import (
+	iofs "io/fs"
 	"strings"
 	"testing"
 	"time"
@@ -166,3 +167,153 @@ func TestUnsetCleanup(t *testing.T) {
 
 	cleanfs.Stop()
 }
+
+func TestNewFile(t *testing.T) {
+	memfs, _ := fs.NewMemFilesystem(fs.MemConfig{})
+
+	files := []string{}
+
+	cleanfs := New(Config{
+		FS: memfs,
+		OnNewFile: func(id string, pattern Pattern, name string) {
+			require.Equal(t, "foobar", id)
+			require.Equal(t, "out", pattern.OutputID)
+			files = append(files, name)
+		},
+	}).(*filesystem)
+
+	cleanfs.WriteFileReader("/chunk_0.ts", strings.NewReader("chunk_0"))
+
+	cleanfs.SetCleanup("foobar", []Pattern{
+		{
+			Pattern:  "/*.ts",
+			OutputID: "out",
+		},
+		{
+			Pattern:  "/chunk_*",
+			OutputID: "out",
+		},
+	})
+
+	cleanfs.WriteFileReader("/chunk_1.ts", strings.NewReader("chunk_1"))
+
+	cleanfs.cleanup()
+	require.Empty(t, files, "files must not be reported before they are finished")
+
+	cleanfs.WriteFileReader("/chunk_1.ts", strings.NewReader("chunk_1 and more"))
+
+	cleanfs.cleanup()
+	require.Empty(t, files, "files that are still written to must not be reported")
+
+	cleanfs.cleanup()
+	require.Equal(t, []string{"/chunk_1.ts"}, files)
+
+	cleanfs.cleanup()
+	require.Equal(t, []string{"/chunk_1.ts"}, files, "files must be reported only once")
+
+	cleanfs.UnsetCleanup("foobar")
+
+	require.Empty(t, cleanfs.observed)
+}
+
+func TestDryRunCleanup(t *testing.T) {
+	memfs, _ := fs.NewMemFilesystem(fs.MemConfig{})
+
+	cleanfs := New(Config{
+		FS: memfs,
+	})
+
+	cleanfs.SetCleanup("foobar", []Pattern{
+		{
+			Pattern:  "/*.ts",
+			MaxFiles: 2,
+		},
+		{
+			Pattern:    "/chunk_*",
+			MaxFileAge: time.Hour,
+		},
+	})
+
+	for _, name := range []string{"/chunk_0.ts", "/chunk_1.ts", "/chunk_2.ts", "/chunk_3.ts", "/index.m3u8"} {
+		cleanfs.WriteFileReader(name, strings.NewReader(name))
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	require.Equal(t, []string{"/chunk_0.ts", "/chunk_1.ts"}, cleanfs.DryRunCleanup("foobar"))
+	require.Empty(t, cleanfs.DryRunCleanup("unknown"))
+
+	require.Equal(t, 5, int(cleanfs.Files()), "no files must be removed")
+}
+
+func TestCleanupPatterns(t *testing.T) {
+	memfs, _ := fs.NewMemFilesystem(fs.MemConfig{})
+
+	cleanfs := New(Config{
+		FS: memfs,
+	})
+
+	patterns := []Pattern{
+		{
+			Pattern:  "/*.ts",
+			MaxFiles: 2,
+		},
+		{
+			Pattern:    "/chunk_*",
+			MaxFileAge: time.Hour,
+		},
+	}
+
+	cleanfs.SetCleanup("foobar", patterns)
+
+	require.Equal(t, patterns, cleanfs.Cleanup("foobar"))
+	require.Empty(t, cleanfs.Cleanup("unknown"))
+
+	cleanfs.UnsetCleanup("foobar")
+
+	require.Empty(t, cleanfs.Cleanup("foobar"))
+}
+
+type testFileInfo struct {
+	name    string
+	modTime time.Time
+}
+
+func (f *testFileInfo) Name() string           { return f.name }
+func (f *testFileInfo) Size() int64            { return 0 }
+func (f *testFileInfo) Mode() iofs.FileMode    { return 0 }
+func (f *testFileInfo) ModTime() time.Time     { return f.modTime }
+func (f *testFileInfo) IsLink() (string, bool) { return "", false }
+func (f *testFileInfo) IsDir() bool            { return false }
+
+func TestRetentionWindow(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	window := 7 * 24 * time.Hour
+
+	files := []fs.FileInfo{
+		&testFileInfo{name: "/boundary.ts", modTime: now.Add(-window)},
+		&testFileInfo{name: "/before.ts", modTime: now.Add(-window - time.Nanosecond)},
+		&testFileInfo{name: "/after.ts", modTime: now.Add(-window + time.Nanosecond)},
+		&testFileInfo{name: "/old.ts", modTime: now.Add(-2 * window)},
+	}
+
+	_, _, outdated := obsoleteFiles(Pattern{RetentionWindow: window}, files, now)
+
+	names := []string{}
+	for _, f := range outdated {
+		names = append(names, f.Name())
+	}
+
+	require.Equal(t, []string{"/old.ts", "/before.ts"}, names, "files exactly at the boundary must be kept")
+
+	// The most recent file is kept, even if it is outside of the window
+	files = []fs.FileInfo{
+		&testFileInfo{name: "/old.ts", modTime: now.Add(-2 * window)},
+		&testFileInfo{name: "/current.ts", modTime: now.Add(-window - time.Hour)},
+	}
+
+	_, expired, outdated := obsoleteFiles(Pattern{RetentionWindow: window, MaxFileAge: window}, files, now)
+
+	require.Len(t, outdated, 1)
+	require.Equal(t, "/old.ts", outdated[0].Name())
+	require.Len(t, expired, 2, "MaxFileAge removes the most recent file as well")
+}
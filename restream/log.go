@@ -0,0 +1,240 @@
+package restream
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datarhei/core/v16/process"
+	"github.com/datarhei/core/v16/restream/app"
+)
+
+// logBroadcaster distributes the log lines of a process to all subscribers.
+type logBroadcaster struct {
+	subscribers map[chan app.LogEntry]chan struct{} // The value is closed together with the channel of the subscriber
+	next        *logBroadcaster                     // Broadcaster that took over the subscribers
+	lock        sync.RWMutex
+}
+
+func newLogBroadcaster() *logBroadcaster {
+	return &logBroadcaster{
+		subscribers: make(map[chan app.LogEntry]chan struct{}),
+	}
+}
+
+// Subscribe returns a channel that receives all new log lines until the context
+// is cancelled or the broadcaster is closed. If the receiver is too slow, lines
+// will be dropped.
+func (b *logBroadcaster) Subscribe(ctx context.Context) <-chan app.LogEntry {
+	ch := make(chan app.LogEntry, 1024)
+
+	done := make(chan struct{})
+
+	b.lock.Lock()
+	b.subscribers[ch] = done
+	b.lock.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			b.unsubscribe(ch)
+		case <-done:
+		}
+	}()
+
+	return ch
+}
+
+// unsubscribe removes the subscriber and closes its channel. A subscriber that has been
+// transferred is removed from the broadcaster it has been transferred to.
+func (b *logBroadcaster) unsubscribe(ch chan app.LogEntry) {
+	b.lock.Lock()
+
+	done, ok := b.subscribers[ch]
+	if !ok {
+		next := b.next
+		b.lock.Unlock()
+
+		if next != nil {
+			next.unsubscribe(ch)
+		}
+
+		return
+	}
+
+	delete(b.subscribers, ch)
+	close(ch)
+	close(done)
+
+	b.lock.Unlock()
+}
+
+// Publish sends the entry to all subscribers without blocking.
+func (b *logBroadcaster) Publish(entry app.LogEntry) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- entry:
+		default:
+		}
+	}
+}
+
+// TransferTo moves all subscribers to another broadcaster.
+func (b *logBroadcaster) TransferTo(dst *logBroadcaster) {
+	if b == dst {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	dst.lock.Lock()
+	defer dst.lock.Unlock()
+
+	for ch, done := range b.subscribers {
+		dst.subscribers[ch] = done
+		delete(b.subscribers, ch)
+	}
+
+	b.next = dst
+}
+
+// Close closes the channels of all subscribers.
+func (b *logBroadcaster) Close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for ch, done := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+		close(done)
+	}
+}
+
+// logParser is a process.Parser that publishes all log lines to a broadcaster
+// before passing them on to the actual parser.
+type logParser struct {
+	process.Parser
+
+	broadcaster *logBroadcaster
+}
+
+func newLogParser(parser process.Parser, broadcaster *logBroadcaster) process.Parser {
+	return &logParser{
+		Parser:      parser,
+		broadcaster: broadcaster,
+	}
+}
+
+func (p *logParser) Parse(line string) uint64 {
+	if !isProgressLine(line) {
+		p.broadcaster.Publish(app.LogEntry{
+			Timestamp: time.Now(),
+			Data:      line,
+		})
+	}
+
+	return p.Parser.Parse(line)
+}
+
+// isProgressLine returns whether the line is one of the progress or IO lines
+// that the parser doesn't write to the log.
+func isProgressLine(line string) bool {
+	for _, prefix := range []string{"frame=", "ffmpeg.inputs:", "ffmpeg.outputs:", "ffmpeg.progress:", "avstream.progress:"} {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// throttleParser is a process.Parser that passes on at most limit log lines per second to the
+// actual parser. The dropped lines are replaced by a line with the number of suppressed lines
+// as soon as the next line passes or the process stopped. Progress lines are never dropped.
+type throttleParser struct {
+	process.Parser
+
+	limit      uint64
+	window     time.Time // Start of the current second
+	lines      uint64    // Number of log lines in the current second
+	suppressed uint64    // Number of dropped log lines since the last marker
+	lock       sync.Mutex
+}
+
+func newThrottleParser(parser process.Parser, limit uint64) process.Parser {
+	return &throttleParser{
+		Parser: parser,
+		limit:  limit,
+	}
+}
+
+func (p *throttleParser) Parse(line string) uint64 {
+	if isProgressLine(line) {
+		return p.Parser.Parse(line)
+	}
+
+	p.lock.Lock()
+
+	suppressed := uint64(0)
+
+	if now := time.Now(); now.Sub(p.window) >= time.Second {
+		suppressed = p.suppressed
+
+		p.window = now
+		p.lines = 0
+		p.suppressed = 0
+	}
+
+	p.lines++
+
+	drop := p.lines > p.limit
+	if drop {
+		p.suppressed++
+	}
+
+	p.lock.Unlock()
+
+	if suppressed != 0 {
+		p.Parser.Parse(suppressedLine(suppressed))
+	}
+
+	if drop {
+		return 0
+	}
+
+	return p.Parser.Parse(line)
+}
+
+// ResetStats writes the marker for the remaining dropped lines to the log of the last run.
+func (p *throttleParser) ResetStats() {
+	p.lock.Lock()
+	suppressed := p.suppressed
+	p.suppressed = 0
+	p.lock.Unlock()
+
+	if suppressed != 0 {
+		p.Parser.Parse(suppressedLine(suppressed))
+	}
+
+	p.Parser.ResetStats()
+}
+
+func (p *throttleParser) ResetLog() {
+	p.lock.Lock()
+	p.window = time.Time{}
+	p.lines = 0
+	p.suppressed = 0
+	p.lock.Unlock()
+
+	p.Parser.ResetLog()
+}
+
+func suppressedLine(n uint64) string {
+	return strconv.FormatUint(n, 10) + " lines suppressed"
+}
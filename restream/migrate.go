@@ -0,0 +1,105 @@
+package restream
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/datarhei/core/v16/log"
+	"github.com/datarhei/core/v16/restream/app"
+)
+
+// processSchema is the current version of the format of the stored process configs.
+// Stored processes with an older version will be migrated on load.
+const processSchema uint64 = 1
+
+// migrateProcess normalizes a stored process of an older schema to the current schema.
+// The migrated process will be written to the store after loading.
+func (r *restream) migrateProcess(id string, process *app.Process) {
+	if process.Schema >= processSchema {
+		return
+	}
+
+	migrations := []string{}
+
+	if len(process.Order) == 0 {
+		process.Order = "stop"
+		migrations = append(migrations, "order")
+	}
+
+	if process.Config != nil {
+		migrations = append(migrations, migrateConfig(process.Config)...)
+	}
+
+	process.Schema = processSchema
+
+	r.logger.Info().WithFields(log.Fields{
+		"id":         id,
+		"schema":     processSchema,
+		"migrations": migrations,
+	}).Log("Migrated stored process")
+}
+
+// migrateConfig defaults the missing IDs of the inputs and outputs and renames the legacy
+// filesystem names of the cleanup rules. These are the only differences of the older
+// schemas. It returns the names of the applied migrations.
+func migrateConfig(config *app.Config) []string {
+	migrations := []string{}
+
+	for i := range config.Input {
+		if len(config.Input[i].ID) == 0 {
+			config.Input[i].ID = "input_" + strconv.Itoa(i)
+			migrations = append(migrations, "input "+strconv.Itoa(i)+" id")
+		}
+	}
+
+	for i, output := range config.Output {
+		if len(output.ID) == 0 {
+			config.Output[i].ID = "output_" + strconv.Itoa(i)
+			migrations = append(migrations, "output "+strconv.Itoa(i)+" id")
+		}
+	}
+
+	migrations = append(migrations, migrateCleanup(config)...)
+
+	return migrations
+}
+
+// legacyFilesystemNames are the former names of the filesystems
+var legacyFilesystemNames = map[string]string{
+	"diskfs": "disk",
+	"memfs":  "mem",
+}
+
+// migrateCleanup renames the legacy filesystem names in the prefixes of the cleanup patterns
+// and in the filesystems of the cleanup rules. Because the legacy names are still accepted
+// in new configs, it is applied to every added or updated config as well. It returns the
+// names of the applied migrations.
+func migrateCleanup(config *app.Config) []string {
+	migrations := []string{}
+
+	for i, output := range config.Output {
+		for j, c := range output.Cleanup {
+			pattern := c.Pattern
+
+			if prefix, _, ok := strings.Cut(pattern, ":"); ok {
+				if name, ok := legacyFilesystemNames[prefix]; ok {
+					pattern = name + strings.TrimPrefix(pattern, prefix)
+				}
+			}
+
+			filesystem := c.Filesystem
+
+			if name, ok := legacyFilesystemNames[filesystem]; ok {
+				filesystem = name
+			}
+
+			if pattern != c.Pattern || filesystem != c.Filesystem {
+				config.Output[i].Cleanup[j].Pattern = pattern
+				config.Output[i].Cleanup[j].Filesystem = filesystem
+				migrations = append(migrations, "output "+output.ID+" cleanup")
+			}
+		}
+	}
+
+	return migrations
+}
@@ -0,0 +1,287 @@
+package restream
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/datarhei/core/v16/restream/app"
+)
+
+// reStdoutReference matches an input address that reads from the stdout of another process
+var reStdoutReference = regexp.MustCompile(`^#(.+):stdout$`)
+
+// processPipe is an OS pipe that connects the stdout of a process with the
+// stdin of another process.
+type processPipe struct {
+	producer  string // ID of the process writing to the pipe
+	consumer  string // ID of the process reading from the pipe
+	reader    *os.File
+	writer    *os.File
+	consuming bool // Whether the process of the consumer is running
+	lock      sync.RWMutex
+}
+
+func newProcessPipe(producer, consumer string) (*processPipe, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &processPipe{
+		producer: producer,
+		consumer: consumer,
+		reader:   reader,
+		writer:   writer,
+	}
+
+	return p, nil
+}
+
+// Close closes both ends of the pipe.
+func (p *processPipe) Close() {
+	p.writer.Close()
+	p.reader.Close()
+}
+
+// SetConsuming sets whether the process of the consumer is running. As long as it is not
+// running, nobody reads from the pipe and the data written to the pipe will be discarded.
+// A write that is currently blocked because the pipe is full will be interrupted.
+func (p *processPipe) SetConsuming(consuming bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.setConsuming(consuming)
+}
+
+// UpdateConsuming sets whether the process of the consumer is running like SetConsuming. The
+// state is read by calling running while holding the lock, such that concurrent updates can't
+// replace a newer state by an older one.
+func (p *processPipe) UpdateConsuming(running func() bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.setConsuming(running())
+}
+
+func (p *processPipe) setConsuming(consuming bool) {
+	p.consuming = consuming
+
+	// Errors are ignored because the pipe might already be closed
+	if consuming {
+		p.writer.SetWriteDeadline(time.Time{})
+	} else {
+		p.writer.SetWriteDeadline(time.Now())
+	}
+}
+
+// Consuming returns whether the process of the consumer is running.
+func (p *processPipe) Consuming() bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.consuming
+}
+
+// pipeWriter is the stdout of a process. Everything written to it will be forwarded
+// to the attached pipe. As long as no pipe is attached or the consumer of the attached
+// pipe is not running, the data will be discarded.
+type pipeWriter struct {
+	pipe *processPipe
+	lock sync.RWMutex
+}
+
+func newPipeWriter() *pipeWriter {
+	return &pipeWriter{}
+}
+
+func (w *pipeWriter) Write(data []byte) (int, error) {
+	w.lock.RLock()
+	pipe := w.pipe
+	w.lock.RUnlock()
+
+	if pipe == nil || !pipe.Consuming() {
+		return len(data), nil
+	}
+
+	// Errors are ignored because the pipe might get closed or the consumer might
+	// stop while writing to it
+	pipe.writer.Write(data)
+
+	return len(data), nil
+}
+
+// Consumer returns the ID of the process that is reading from the attached pipe.
+func (w *pipeWriter) Consumer() string {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	if w.pipe == nil {
+		return ""
+	}
+
+	return w.pipe.consumer
+}
+
+// Attach attaches a pipe. A previously attached pipe can only be replaced by a pipe
+// with the same consumer.
+func (w *pipeWriter) Attach(pipe *processPipe) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.pipe != nil && w.pipe.consumer != pipe.consumer {
+		return fmt.Errorf("the stdout of the process '%s' is already used by the process '%s'", pipe.producer, w.pipe.consumer)
+	}
+
+	w.pipe = pipe
+
+	return nil
+}
+
+// Detach detaches the pipe, if it is currently attached.
+func (w *pipeWriter) Detach(pipe *processPipe) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.pipe == pipe {
+		w.pipe = nil
+	}
+}
+
+// TransferTo moves the attached pipe to another writer.
+func (w *pipeWriter) TransferTo(dst *pipeWriter) {
+	if w == dst {
+		return
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	dst.lock.Lock()
+	defer dst.lock.Unlock()
+
+	dst.pipe = w.pipe
+	w.pipe = nil
+}
+
+// Close closes the writing end of the attached pipe such that the consumer
+// reads an EOF.
+func (w *pipeWriter) Close() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.pipe == nil {
+		return
+	}
+
+	w.pipe.writer.Close()
+	w.pipe = nil
+}
+
+// stdoutProducer returns the ID of the process whose stdout is read by an input
+// of the config. Only one input can read from the stdout of another process.
+func stdoutProducer(config *app.Config) (string, error) {
+	producer := ""
+
+	for _, input := range config.Input {
+		matches := reStdoutReference.FindStringSubmatch(input.Address)
+		if matches == nil {
+			continue
+		}
+
+		if len(producer) != 0 {
+			return "", fmt.Errorf("only one input can read from the stdout of another process (process '%s')", config.ID)
+		}
+
+		producer = matches[1]
+	}
+
+	return producer, nil
+}
+
+// writesStdout returns whether any output of the config writes to stdout.
+func writesStdout(config *app.Config) bool {
+	for _, output := range config.Output {
+		switch output.Address {
+		case "-", "pipe:", "pipe:1":
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolveStdoutAddress validates that the input of the process with the ID can read
+// from the stdout of the referenced process and returns the address for stdin.
+func resolveStdoutAddress(tasks map[string]*task, id, address string) (string, error) {
+	matches := reStdoutReference.FindStringSubmatch(address)
+	if matches == nil {
+		return address, fmt.Errorf("invalid format (%s)", address)
+	}
+
+	if matches[1] == id {
+		return address, fmt.Errorf("self-reference not possible (%s)", address)
+	}
+
+	task, ok := tasks[matches[1]]
+	if !ok {
+		return address, fmt.Errorf("unknown process '%s' (%s)", matches[1], address)
+	}
+
+	if !writesStdout(task.config) {
+		return address, fmt.Errorf("the process '%s' has no outputs that write to stdout (%s)", matches[1], address)
+	}
+
+	if consumer := task.stdout.Consumer(); len(consumer) != 0 && consumer != id {
+		return address, fmt.Errorf("the stdout of the process '%s' is already used by the process '%s' (%s)", matches[1], consumer, address)
+	}
+
+	return "pipe:0", nil
+}
+
+// createPipe creates the pipe for reading from the stdout of the producer. The pipe
+// will be attached to the producer with attachPipe.
+func (r *restream) createPipe(t *task, producer string) error {
+	if len(producer) == 0 {
+		return nil
+	}
+
+	pipe, err := newProcessPipe(producer, t.id)
+	if err != nil {
+		return fmt.Errorf("failed to create pipe for the process '%s': %w", t.id, err)
+	}
+
+	t.stdin = pipe
+
+	return nil
+}
+
+// attachPipe connects the pipe of the task with the stdout of the producer.
+func (r *restream) attachPipe(t *task) error {
+	if t.stdin == nil {
+		return nil
+	}
+
+	producer, ok := r.tasks[t.stdin.producer]
+	if !ok {
+		return fmt.Errorf("unknown process '%s'", t.stdin.producer)
+	}
+
+	return producer.stdout.Attach(t.stdin)
+}
+
+// closePipe disconnects the pipe of the task from the producer and closes it.
+func (r *restream) closePipe(t *task) {
+	if t.stdin == nil {
+		return
+	}
+
+	if producer, ok := r.tasks[t.stdin.producer]; ok {
+		producer.stdout.Detach(t.stdin)
+	}
+
+	t.stdin.Close()
+	t.stdin = nil
+}
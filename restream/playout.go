@@ -0,0 +1,314 @@
+package restream
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/datarhei/core/v16/log"
+	"github.com/datarhei/core/v16/restream/app"
+)
+
+// playoutPrefixes are the prefixes of the input addresses that are read with a playout.
+var playoutPrefixes = []string{"avstream:", "playout:"}
+
+// SwitchInput replaces the address of the input of the process. If the input has a playout
+// and the process is running, FFmpeg will be told to switch to the new stream without
+// restarting and the process will continue with the new address after a restart.
+// Otherwise, or if the live switch fails, the process will be updated with the new address,
+// i.e. it will be restarted. If the current address has a playout prefix, e.g. "playout:",
+// the new address will get it too.
+func (r *restream) SwitchInput(id, inputid, address string) error {
+	if err := r.beginOperation(id); err != nil {
+		return err
+	}
+	defer r.endOperation(id)
+
+	r.lock.RLock()
+	s, err := r.prepareSwitchInput(id, inputid, strings.TrimSpace(address))
+	r.lock.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	if s.live {
+		if err := switchPlayoutStream(s.port, s.stream); err != nil {
+			r.logger.Warn().WithFields(log.Fields{
+				"id":    id,
+				"input": inputid,
+			}).WithError(err).Log("Switching the stream failed, updating the process")
+			s.live = false
+		}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	task, ok := r.tasks[id]
+	if !ok {
+		return ErrUnknownProcess
+	}
+
+	if s.live && task == s.task {
+		return r.switchInputLive(task, s)
+	}
+
+	config := task.process.Config.Clone()
+	for i, input := range config.Input {
+		if input.ID == inputid {
+			config.Input[i].Address = s.address
+		}
+	}
+
+	if err := r.updateProcess(id, config); err != nil {
+		return err
+	}
+
+	r.audit(r.tasks[id], "update", "")
+
+	if err := r.commit(); err != nil {
+		r.revertUpdate(id, task)
+		return err
+	}
+
+	return nil
+}
+
+// switchInputLive applies the input switch to the task after the playout switched the
+// stream. The running FFmpeg continues with the new command after a restart. The caller
+// has to hold the lock.
+func (r *restream) switchInputLive(t *task, s inputSwitch) error {
+	processConfig, config, command := t.process.Config, t.config, t.command
+	references, metadata := t.references, copyMetadata(t.metadata)
+	updatedAt, changedAt := t.process.UpdatedAt, t.changedAt
+
+	t.process.Config = s.processConfig
+	t.process.UpdatedAt = time.Now().Unix()
+	t.config = s.config
+	t.command = r.createCommand(t)
+	t.references = referencedProcesses(t.config)
+	t.changedAt = time.Now()
+	t.setLiveCommand(t.command)
+	t.annotate("switch")
+	r.audit(t, "update", "")
+	r.markChanged(t.id)
+
+	if err := r.commit(); err != nil {
+		t.process.Config, t.config, t.command = processConfig, config, command
+		t.references, t.metadata = references, metadata
+		t.process.UpdatedAt, t.changedAt = updatedAt, changedAt
+		t.setLiveCommand(command)
+
+		// Switch back to the stream that is stored
+		if err := switchPlayoutStream(s.port, s.previous); err != nil {
+			t.logger.Error().WithError(err).Log("Failed to switch back to the previous stream")
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// inputSwitch describes how the address of an input will be switched.
+type inputSwitch struct {
+	task          *task       // Task the switch has been prepared for
+	address       string      // New address for the config
+	stream        string      // Resolved address of the new stream for the playout
+	previous      string      // Resolved address of the current stream for the playout
+	port          int         // Port of the playout
+	live          bool        // Whether the stream can be switched without restarting the process
+	processConfig *app.Config // Config of the process with the new address
+	config        *app.Config // Resolved config of the running process with the new address
+}
+
+// prepareSwitchInput validates the new address for the input of the process and returns
+// how the input can be switched. The caller has to hold the lock.
+func (r *restream) prepareSwitchInput(id, inputid, address string) (inputSwitch, error) {
+	s := inputSwitch{
+		address: address,
+	}
+
+	t, ok := r.tasks[id]
+	if !ok {
+		return s, ErrUnknownProcess
+	}
+
+	if !t.valid {
+		return s, fmt.Errorf("invalid process definition")
+	}
+
+	s.task = t
+
+	config := t.process.Config.Clone()
+
+	index := -1
+	for i, input := range config.Input {
+		if input.ID == inputid {
+			index = i
+			break
+		}
+	}
+
+	if index == -1 {
+		return s, fmt.Errorf("unknown input ID '%s' for the process '%s'", inputid, id)
+	}
+
+	if len(address) == 0 {
+		return s, fmt.Errorf("the address for input '#%s:%s' must not be empty", id, inputid)
+	}
+
+	prefix := ""
+	for _, p := range playoutPrefixes {
+		if strings.HasPrefix(config.Input[index].Address, p) {
+			prefix = p
+			break
+		}
+	}
+
+	if !strings.HasPrefix(s.address, prefix) {
+		s.address = prefix + s.address
+	}
+
+	config.Input[index].Address = s.address
+	s.processConfig = config.Clone()
+
+	r.resolveConfig(config, t.metadata)
+
+	if err := r.resolveAddresses(r.tasks, config); err != nil {
+		return s, err
+	}
+
+	if err := r.validateInputAddresses(config, config.Input[index]); err != nil {
+		return s, err
+	}
+
+	if _, err := r.resolveNamedPipes(config); err != nil {
+		return s, err
+	}
+
+	s.port, ok = t.playout[inputid]
+	if !ok || len(prefix) == 0 || !t.ffmpeg.IsRunning() {
+		return s, nil
+	}
+
+	// The running FFmpeg keeps everything except for the address of the input, e.g. the
+	// assigned playout port
+	s.config = t.config.Clone()
+	s.config.Input[index].Address = config.Input[index].Address
+	s.stream = strings.TrimPrefix(config.Input[index].Address, prefix)
+	s.previous = strings.TrimPrefix(t.config.Input[index].Address, prefix)
+
+	// The process has to be updated if the new address requires a different preparation
+	// of the arguments than the process has been created with
+	command := s.config.CreateCommand()
+	if hasAlternativeAddresses(s.config) || usesSequence(command) != usesSequence(t.command) || usesSecrets(command) != usesSecrets(t.command) {
+		return s, nil
+	}
+
+	candidate := &task{
+		id:     t.id,
+		config: s.config,
+		logger: t.logger,
+	}
+
+	if err := r.checkOutputCollisions(candidate, id); err != nil {
+		return s, err
+	}
+
+	if err := r.checkPortConflicts(candidate, id); err != nil {
+		return s, err
+	}
+
+	s.live = true
+
+	return s, nil
+}
+
+// switchPlayoutStream tells the playout on the port to switch to the stream at the address.
+func switchPlayoutStream(port int, address string) error {
+	endpoint := "http://127.0.0.1:" + strconv.Itoa(port) + "/v1/stream"
+
+	request, err := http.NewRequest(http.MethodPut, endpoint, bytes.NewBufferString(address))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "text/plain")
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		data, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("the playout responded with %d: %s", response.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	return nil
+}
+
+// ReconcilePlayoutPorts cross-checks the assigned playout ports with the ports that are
+// referenced by the inputs of the current processes. Ports that are not referenced by
+// any process anymore are returned to the port range. An error is returned if a process
+// references a port that is not known as assigned, because it might get assigned again.
+func (r *restream) ReconcilePlayoutPorts() ([]int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	referenced := map[int]string{}
+
+	for _, t := range r.tasks {
+		for _, port := range t.playout {
+			referenced[port] = t.id
+		}
+	}
+
+	r.playoutPorts.lock.Lock()
+
+	leaked := []int{}
+	for port := range r.playoutPorts.ports {
+		if _, ok := referenced[port]; !ok {
+			leaked = append(leaked, port)
+		}
+	}
+
+	unknown := []string{}
+	for port, id := range referenced {
+		if _, ok := r.playoutPorts.ports[port]; !ok {
+			unknown = append(unknown, fmt.Sprintf("%d (%s)", port, id))
+		}
+	}
+
+	r.playoutPorts.lock.Unlock()
+
+	sort.Ints(leaked)
+
+	for _, port := range leaked {
+		r.putPlayoutPort(port)
+	}
+
+	if len(leaked) != 0 {
+		r.logger.Warn().WithField("ports", leaked).Log("Released leaked playout ports")
+	}
+
+	if len(unknown) != 0 {
+		sort.Strings(unknown)
+		return leaked, fmt.Errorf("playout ports are referenced by processes without being assigned: %s", strings.Join(unknown, ", "))
+	}
+
+	return leaked, nil
+}
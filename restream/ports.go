@@ -0,0 +1,212 @@
+package restream
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/datarhei/core/v16/restream/app"
+)
+
+// listenPort is a port a process is listening on.
+type listenPort struct {
+	transport string // "tcp" or "udp"
+	port      int
+}
+
+// defaultListenPorts are the ports that are used if the address of a listener has no port
+var defaultListenPorts = map[string]int{
+	"http":  80,
+	"https": 443,
+	"rtmp":  1935,
+	"rtmps": 443,
+	"rtsp":  554,
+}
+
+// listenPorts returns the ports the inputs and outputs of the config are listening on.
+func listenPorts(config *app.Config) []listenPort {
+	ports := []listenPort{}
+
+	for _, input := range config.Input {
+		for _, address := range append([]string{input.Address}, input.Addresses...) {
+			if p, ok := addressListenPort(address, input.Options, true); ok {
+				ports = append(ports, p)
+			}
+		}
+	}
+
+	for _, output := range config.Output {
+		for _, address := range targetAddresses(output) {
+			if p, ok := addressListenPort(address, output.Options, false); ok {
+				ports = append(ports, p)
+			}
+		}
+	}
+
+	return ports
+}
+
+// addressListenPort returns the port the address is listening on, either because of the
+// address itself or because of the options, e.g. "-listen 1" for RTMP. An UDP input is
+// always listening.
+func addressListenPort(address string, options []string, isInput bool) (listenPort, bool) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return listenPort{}, false
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	query := u.Query()
+	port := u.Port()
+
+	listen := false
+	transport := "tcp"
+
+	switch scheme {
+	case "udp":
+		transport = "udp"
+
+		if isInput {
+			listen = true
+		} else if localport := query.Get("localport"); len(localport) != 0 {
+			listen = true
+			port = localport
+		}
+	case "srt":
+		transport = "udp"
+
+		mode := query.Get("mode")
+		if len(mode) == 0 {
+			mode = optionValue(options, "-mode")
+		}
+
+		listen = mode == "listener"
+	case "tcp", "http", "https", "rtmp", "rtmps":
+		value := optionValue(options, "-listen")
+		if query.Has("listen") {
+			// A query parameter without a value enables listening as well
+			value = query.Get("listen")
+			if len(value) == 0 {
+				value = "1"
+			}
+		}
+
+		listen = len(value) != 0 && value != "0"
+	case "rtsp":
+		listen = strings.Contains(optionValue(options, "-rtsp_flags"), "listen")
+	}
+
+	if !listen {
+		return listenPort{}, false
+	}
+
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		n = defaultListenPorts[scheme]
+	}
+
+	if n <= 0 {
+		return listenPort{}, false
+	}
+
+	return listenPort{transport: transport, port: n}, true
+}
+
+// optionValue returns the value of the last occurrence of the option, or an empty string
+// if the option is not given.
+func optionValue(options []string, name string) string {
+	value := ""
+
+	for i := 0; i < len(options)-1; i++ {
+		if options[i] == name {
+			value = options[i+1]
+		}
+	}
+
+	return value
+}
+
+// GetPortConflicts returns the ports that more than one process is listening on, together
+// with the sorted IDs of these processes.
+func (r *restream) GetPortConflicts() map[int][]string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	listeners := map[listenPort]map[string]struct{}{}
+
+	for id, t := range r.tasks {
+		if !t.valid {
+			continue
+		}
+
+		for _, p := range listenPorts(t.config) {
+			if listeners[p] == nil {
+				listeners[p] = map[string]struct{}{}
+			}
+
+			listeners[p][id] = struct{}{}
+		}
+	}
+
+	// A conflict via TCP and via UDP on the same port lists each process only once
+	conflicting := map[int]map[string]struct{}{}
+
+	for p, ids := range listeners {
+		if len(ids) < 2 {
+			continue
+		}
+
+		if conflicting[p.port] == nil {
+			conflicting[p.port] = map[string]struct{}{}
+		}
+
+		for id := range ids {
+			conflicting[p.port][id] = struct{}{}
+		}
+	}
+
+	conflicts := map[int][]string{}
+
+	for port, ids := range conflicting {
+		for id := range ids {
+			conflicts[port] = append(conflicts[port], id)
+		}
+
+		sort.Strings(conflicts[port])
+	}
+
+	return conflicts
+}
+
+// checkPortConflicts checks whether the task listens on the same port as any other process,
+// except the process with the excluded ID. Conflicts are only rejected if enabled.
+func (r *restream) checkPortConflicts(t *task, exclude string) error {
+	if !r.rejectPortConflicts {
+		return nil
+	}
+
+	ports := listenPorts(t.config)
+	if len(ports) == 0 {
+		return nil
+	}
+
+	for id, other := range r.tasks {
+		if id == exclude || id == t.id || !other.valid {
+			continue
+		}
+
+		for _, port := range listenPorts(other.config) {
+			for _, p := range ports {
+				if p != port {
+					continue
+				}
+
+				return fmt.Errorf("%w: the %s port %d is already used by the process '%s'", ErrPortConflict, p.transport, p.port, id)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package restream
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/datarhei/core/v16/restream/app"
+)
+
+// ProbeRaw probes each input of the process with ffprobe and returns its unparsed JSON
+// output. The result is a JSON array with the output of ffprobe for each input in the
+// order of the inputs.
+func (r *restream) ProbeRaw(id string, timeout time.Duration) ([]byte, error) {
+	r.lock.RLock()
+	task, ok := r.tasks[id]
+	if !ok {
+		r.lock.RUnlock()
+		return nil, ErrUnknownProcess
+	}
+
+	if !task.valid {
+		r.lock.RUnlock()
+		return nil, fmt.Errorf("invalid process definition")
+	}
+
+	// The config of the task is replaced by a reload
+	inputs := make([]app.ConfigIO, len(task.config.Input))
+	for i, input := range task.config.Input {
+		inputs[i] = input.Clone()
+	}
+
+	r.lock.RUnlock()
+
+	if len(r.ffprobe) == 0 {
+		return nil, fmt.Errorf("no ffprobe binary provided")
+	}
+
+	if timeout <= 0 {
+		timeout = r.probeTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	outputs := [][]byte{}
+
+	for _, input := range inputs {
+		args := []string{"-v", "error", "-print_format", "json", "-show_format", "-show_streams"}
+		args = append(args, probeInputCommand(input)...)
+
+		if r.secrets != nil && usesSecrets(args) {
+			resolved, err := newSecrets(r.secrets).Resolve(args)
+			if err != nil {
+				return nil, err
+			}
+
+			args = resolved
+		}
+
+		output, err := r.runFFprobe(ctx, args)
+		if err != nil {
+			return nil, fmt.Errorf("failed to probe the input '#%s:%s': %w", id, input.ID, err)
+		}
+
+		outputs = append(outputs, output)
+	}
+
+	return append(append([]byte("["), bytes.Join(outputs, []byte(","))...), ']'), nil
+}
+
+// runFFprobe runs ffprobe with the arguments and returns its JSON output.
+func (r *restream) runFFprobe(ctx context.Context, args []string) ([]byte, error) {
+	stderr := bytes.Buffer{}
+
+	cmd := exec.CommandContext(ctx, r.ffprobe, args...)
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		if reason := strings.TrimSpace(stderr.String()); len(reason) != 0 {
+			return nil, fmt.Errorf("%w: %s", err, reason)
+		}
+
+		return nil, err
+	}
+
+	output = bytes.TrimSpace(output)
+
+	if !json.Valid(output) {
+		return nil, fmt.Errorf("invalid JSON output")
+	}
+
+	return output, nil
+}
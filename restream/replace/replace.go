@@ -3,6 +3,7 @@ package replace
 import (
 	"net/url"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/datarhei/core/v16/glob"
@@ -22,6 +23,9 @@ type Replacer interface {
 	// is returned by the template function.
 	RegisterTemplateFunc(placeholder string, template TemplateFn, defaults map[string]string)
 
+	// Templates returns the sorted names of the placeholders with a registered template.
+	Templates() []string
+
 	// Replace replaces all occurences of placeholder in str with value. The placeholder is of the
 	// form {placeholder}. It is possible to escape a characters in value with \\ by appending a ^
 	// and the character to escape to the placeholder name, e.g. {placeholder^:} to escape ":".
@@ -69,6 +73,18 @@ func (r *replacer) RegisterTemplateFunc(placeholder string, templateFn TemplateF
 	}
 }
 
+func (r *replacer) Templates() []string {
+	names := make([]string, 0, len(r.templates))
+
+	for name := range r.templates {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
 func (r *replacer) Replace(str, placeholder, value string, vars map[string]string, config *app.Config, section string) string {
 	str = r.re.ReplaceAllStringFunc(str, func(match string) string {
 		matches := r.re.FindStringSubmatch(match)
@@ -47,6 +47,17 @@ func TestReplaceTemplate(t *testing.T) {
 	require.Equal(t, "Hello World! E=mc\\\\:2?", replaced)
 }
 
+func TestTemplates(t *testing.T) {
+	r := New()
+	require.Equal(t, []string{}, r.Templates())
+
+	r.RegisterTemplate("foo:bar", "Hello {who}!", nil)
+	r.RegisterTemplateFunc("bar", func(*app.Config, string) string { return "" }, nil)
+	r.RegisterTemplate("foo:bar", "Hello {who}?", nil)
+
+	require.Equal(t, []string{"bar", "foo:bar"}, r.Templates())
+}
+
 func TestReplaceTemplateFunc(t *testing.T) {
 	r := New()
 	r.RegisterTemplateFunc("foo:bar", func(config *app.Config, kind string) string { return "Hello {who}! {what}?" }, nil)
@@ -2,13 +2,22 @@ package restream
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/datarhei/core/v16/ffmpeg"
@@ -26,35 +35,93 @@ import (
 	"github.com/datarhei/core/v16/restream/store"
 
 	"github.com/Masterminds/semver/v3"
+	"github.com/google/uuid"
 )
 
 // The Restreamer interface
 type Restreamer interface {
-	ID() string                                                  // ID of this instance
-	Name() string                                                // Arbitrary name of this instance
-	CreatedAt() time.Time                                        // Time of when this instance has been created
-	Start()                                                      // Start all processes that have a "start" order
-	Stop()                                                       // Stop all running process but keep their "start" order
-	AddProcess(config *app.Config) error                         // Add a new process
-	GetProcessIDs(idpattern, refpattern string) []string         // Get a list of process IDs based on patterns for ID and reference
-	DeleteProcess(id string) error                               // Delete a process
-	UpdateProcess(id string, config *app.Config) error           // Update a process
-	StartProcess(id string) error                                // Start a process
-	StopProcess(id string) error                                 // Stop a process
-	RestartProcess(id string) error                              // Restart a process
-	ReloadProcess(id string) error                               // Reload a process
-	GetProcess(id string) (*app.Process, error)                  // Get a process
-	GetProcessState(id string) (*app.State, error)               // Get the state of a process
-	GetProcessLog(id string) (*app.Log, error)                   // Get the logs of a process
-	GetPlayout(id, inputid string) (string, error)               // Get the URL of the playout API for a process
-	Probe(id string) app.Probe                                   // Probe a process
-	ProbeWithTimeout(id string, timeout time.Duration) app.Probe // Probe a process with specific timeout
-	Skills() skills.Skills                                       // Get the ffmpeg skills
-	ReloadSkills() error                                         // Reload the ffmpeg skills
-	SetProcessMetadata(id, key string, data interface{}) error   // Set metatdata to a process
-	GetProcessMetadata(id, key string) (interface{}, error)      // Get previously set metadata from a process
-	SetMetadata(key string, data interface{}) error              // Set general metadata
-	GetMetadata(key string) (interface{}, error)                 // Get previously set general metadata
+	ID() string                                                                          // ID of this instance
+	Name() string                                                                        // Arbitrary name of this instance
+	CreatedAt() time.Time                                                                // Time of when this instance has been created
+	Start()                                                                              // Start all processes that have a "start" order
+	StartContext(ctx context.Context) error                                              // Start all processes that have a "start" order until the context is cancelled
+	Stop()                                                                               // Stop all running process but keep their "start" order
+	StopContext(ctx context.Context)                                                     // Stop all running processes and kill those that did not exit before the context is done
+	AddProcess(config *app.Config) error                                                 // Add a new process
+	AddProcessWithID(config *app.Config) (string, error)                                 // Add a new process and return its ID
+	AddProcessReturn(config *app.Config) (*app.Process, error)                           // Add a new process and get a copy of the created process
+	TryAddProcess(config *app.Config, timeout time.Duration) error                       // Add a new process, fails with ErrBusy if the lock can't be acquired within the timeout
+	AddProcessFromTemplate(templateName, id string, vars map[string]string) error        // Add a new process based on a template
+	AddCanary(srcID, canaryID string, modify func(*app.Config)) error                    // Add a stopped copy of a process with a modified config
+	ImportConfigs(format string, data []byte) ([]*app.Config, error)                     // Decode process configs from JSON, YAML or TOML
+	ExportConfigs(format string, ids []string) ([]byte, error)                           // Encode the configs of processes as JSON, YAML or TOML
+	GetProcessIDs(idpattern, refpattern string) []string                                 // Get a list of process IDs based on patterns for ID and reference
+	GetProcessIDsChangedSince(since time.Time) []string                                  // Get a list of process IDs that have been changed after the given time
+	DeleteProcess(id string) error                                                       // Delete a process
+	ForceDeleteProcess(id string) error                                                  // Delete a process even if other processes are referencing it
+	GetProcessDependents(id string) []string                                             // Get a list of process IDs that are referencing a process
+	GetGroupIDs() []string                                                               // Get a list of all group IDs
+	GetProcessIDsByGroup(groupID string) []string                                        // Get a list of process IDs of a group
+	StartGroup(groupID string) error                                                     // Start all processes of a group
+	StopGroup(groupID string) error                                                      // Stop all processes of a group
+	StopProcessesByFilesystem(fsName string, timeout time.Duration) ([]string, error)    // Stop all processes that write to a filesystem
+	Filesystems() []app.FilesystemInfo                                                   // Get the registered filesystems with their current size and limit
+	DeleteGroup(groupID string) error                                                    // Delete all processes of a group
+	UpdateProcess(id string, config *app.Config) error                                   // Update a process
+	ApplyTemporaryConfig(id string, config *app.Config, duration time.Duration) error    // Update a process with a config and revert it after the duration
+	PutProcess(config *app.Config) (created bool, err error)                             // Add a new process or update an existing process, returns whether it has been added
+	DiffConfig(id string, config *app.Config) (app.ConfigDiff, error)                    // Get the differences between the config of a process and another config
+	MoveInput(id, inputID string, toIndex int) error                                     // Move an input of a process to another position, the process will be restarted
+	MoveOutput(id, outputID string, toIndex int) error                                   // Move an output of a process to another position, the process will be restarted
+	StartProcess(id string) error                                                        // Start a process
+	StopProcess(id string) error                                                         // Stop a process
+	RestartProcess(id string) error                                                      // Restart a process
+	ResetProcess(id string) error                                                        // Start a process again that has been disabled because of repeated failures
+	DemandProcess(id string) (func(), error)                                             // Start a process on demand and get a function for releasing it
+	ReloadProcess(id string) error                                                       // Reload a process
+	GetProcess(id string) (*app.Process, error)                                          // Get a process
+	GetResolvedConfig(id string) (*app.Config, error)                                    // Get the config of a process with all placeholders and references resolved
+	EstimateOutputBitrate(id string) (map[string]int64, error)                           // Get the configured bitrate of each output of a process
+	GetProcessState(id string) (*app.State, error)                                       // Get the state of a process
+	GetProcessStateLite(id string) (*app.State, error)                                   // Get the state of a process without progress and logs
+	GetStatesByReference(refpattern string) (map[string]map[string]*app.State, error)    // Get the states of the processes by their ID, grouped by their reference
+	GetProcessStateHistory(id string, since time.Time) ([]app.StateTransition, error)    // Get the state transitions of a process since the given time
+	GetStateTransitionCounts(id string, since time.Time) (map[string]int, error)         // Get the number of times a process entered each state since the given time
+	GetProcessHistory(id string) ([]app.AuditEntry, error)                               // Get the recorded changes of a process
+	GetProcessLog(id string) (*app.Log, error)                                           // Get the logs of a process
+	GetProcessLogHistory(id string, limit int) (*app.Log, error)                         // Get the logs of a process with only the most recent entries of the log history
+	ClearProcessLog(id string) error                                                     // Remove the current logs and the log history of a process without stopping it
+	StreamProcessLog(ctx context.Context, id string) (<-chan app.LogEntry, error)        // Get the new log lines of a process as they are emitted
+	WatchProcess(ctx context.Context, id string) (<-chan *app.State, error)              // Get the state of a process each time it changes
+	GetPlayout(id, inputid string) (string, error)                                       // Get the URL of the playout API for a process
+	DemandPlayout(id, inputid string) (string, func(), error)                            // Get the URL of the playout API for a process and demand the process until released
+	SwitchInput(id, inputid, address string) error                                       // Switch the address of an input of a process, live if possible
+	ReconcilePlayoutPorts() ([]int, error)                                               // Release the playout ports that are not referenced by any process anymore
+	GetPortConflicts() map[int][]string                                                  // Get the ports more than one process is listening on, with the IDs of these processes
+	Probe(id string) app.Probe                                                           // Probe a process
+	ProbeWithTimeout(id string, timeout time.Duration) app.Probe                         // Probe a process with specific timeout
+	ProbeRaw(id string, timeout time.Duration) ([]byte, error)                           // Probe the inputs of a process with ffprobe and return its JSON output
+	IsAddressAllowed(address string, isOutput bool) (bool, string, error)                // Check whether an address is allowed for an input or output
+	SupportedPlaceholders() []string                                                     // Get the names of all placeholders that can be used in a config
+	Validate() map[string][]string                                                       // Validate the configs of all processes and get the problems for each process
+	InvalidateProbe(id string)                                                           // Remove the cached probe of a process
+	RunProcessOnce(id string, command []string, timeout time.Duration) (*app.Log, error) // Run a process once with a different command and return its log
+	Skills() skills.Skills                                                               // Get the ffmpeg skills
+	ReloadSkills() error                                                                 // Reload the ffmpeg skills
+	SetProcessMetadata(id, key string, data interface{}) error                           // Set metatdata to a process
+	SetProcessMetadataBulk(ids []string, key string, data interface{}) ([]string, error) // Set metadata to multiple processes
+	GetProcessMetadata(id, key string) (interface{}, error)                              // Get previously set metadata from a process
+	SetMetadata(key string, data interface{}) error                                      // Set general metadata
+	GetMetadata(key string) (interface{}, error)                                         // Get previously set general metadata
+	StateHash() string                                                                   // Get a hash over the stored state for detecting changes
+	Reconcile() ([]string, error)                                                        // Apply the processes from the store to the current processes and return the IDs of the changed processes
+	Metrics() []app.Metric                                                               // Get the current metrics of this instance and all processes
+	TotalResourceUsage() (cpu float64, memory uint64)                                    // Get the summed CPU and memory usage of all running processes
+	SetMaxProcesses(n int64) (int64, error)                                              // Set the max. number of running processes and get the previous value
+	WithActor(actor string) Restreamer                                                   // Get a Restreamer that records the actor for all changes of processes
+	DryRunCleanup(id string) (map[string][]string, error)                                // Get the files per filesystem that would currently be removed by the cleanup of a process
+	GetEffectiveCleanup(id string) (map[string][]rfs.Pattern, error)                     // Get the registered cleanup patterns per filesystem of a process
+	FindOrphanedFiles(fsName string) ([]string, error)                                   // Get the files on a filesystem that aren't matched by any cleanup pattern
 }
 
 // Config is the required configuration for a new restreamer instance.
@@ -67,21 +134,226 @@ type Config struct {
 	FFmpeg       ffmpeg.FFmpeg
 	MaxProcesses int64
 	Logger       log.Logger
+
+	// CommandModifier is called with the ID and the command of a process after the
+	// command has been created from the config. The returned command will be used
+	// for running the process.
+	CommandModifier func(processID string, command []string) []string
+
+	// GenerateIDWhenEmpty enables the generation of an ID for a new process if
+	// its config doesn't have an ID.
+	GenerateIDWhenEmpty bool
+
+	// IDGenerator returns a new unique process ID. If not set, an UUID will be
+	// generated.
+	IDGenerator func() string
+
+	// Preempt allows to stop the running process with the lowest priority if the
+	// max. number of processes is reached and a process with a higher priority
+	// should be started.
+	Preempt bool
+
+	// ProbeCacheTTL is the duration a probe result will be cached for the same
+	// inputs. A value of 0 disables the cache.
+	ProbeCacheTTL time.Duration
+
+	// DefaultProbeTimeout is the timeout for Probe. If not set, the default is
+	// 20 seconds.
+	DefaultProbeTimeout time.Duration
+
+	// StopTimeout is the duration after which a process will be killed if it didn't
+	// exit after it has been stopped. If not set, the default of the process is used.
+	StopTimeout time.Duration
+
+	// Templates are named process configs that can be used to add new processes
+	// with AddProcessFromTemplate.
+	Templates map[string]*app.Config
+
+	// FFmpegBinaries are additional named FFmpeg instances. A process can select one of
+	// them with the FFmpegBinary field of its config. Otherwise FFmpeg will be used.
+	FFmpegBinaries map[string]ffmpeg.FFmpeg
+
+	// SweepInterval is the interval for checking for stopped processes that exceeded
+	// their max. age. If not set, the default is one minute.
+	SweepInterval time.Duration
+
+	// ConnectivityTimeout enables checking whether the inputs of a new process are
+	// reachable before it is added. The inputs are probed for at most this duration
+	// and the process is rejected if no stream could be found. A value of 0 disables
+	// the check.
+	ConnectivityTimeout time.Duration
+
+	// StateHistoryLength is the number of state transitions that are kept for each
+	// process. If not set, the default is 100.
+	StateHistoryLength int
+
+	// BulkSkipUnknown enables skipping unknown process IDs in bulk operations. Otherwise
+	// the operation fails without any changes if one of the IDs is unknown.
+	BulkSkipUnknown bool
+
+	// StrictVars enables rejecting processes that use variables of the form {vars:name}
+	// that are not defined in their config. Otherwise these placeholders are left untouched.
+	StrictVars bool
+
+	// WarnOnOutputCollision enables only logging a warning if a process writes to the
+	// same file as another process. Otherwise the process will be rejected.
+	WarnOnOutputCollision bool
+
+	// OnNewOutputFile is called for every new file that matches a cleanup pattern of an output
+	// of a process, as soon as the file is not written to anymore. The path is prefixed with the
+	// name of the filesystem, e.g. "mem:/live/segment_0001.ts".
+	OnNewOutputFile func(processID, outputID, path string)
+
+	// OnOutputFileComplete is called with the same files as OnNewOutputFile, e.g. for uploading
+	// finished segments. It is called in its own goroutine with the config of the output. A
+	// returned error is logged.
+	OnOutputFileComplete func(processID string, output app.ConfigIO, path string) error
+
+	// MaxPlayoutPorts is the max. number of playout ports that are assigned to the inputs of
+	// all processes at the same time. A process that would exceed it can't be added. Use 0 for
+	// no limit.
+	MaxPlayoutPorts int
+
+	// OnFilesystemFull is called when a disk filesystem is detected as full. The processes
+	// that are writing to the disk will be stopped.
+	OnFilesystemFull func(fsName string, size, limit int64)
+
+	// OnFilesystemRecovered is called when a full disk filesystem is not full anymore.
+	OnFilesystemRecovered func(fsName string, size, limit int64)
+
+	// FilesystemSelectionPolicy selects the disk filesystem for a file output with a relative
+	// path. With "least-full" the filesystem that consumes the least space (relative to its
+	// capacity, if all have one) is selected, with "round-robin" the filesystems take turns.
+	// The file will be written relative to the base of the selected filesystem, the resulting
+	// absolute path is stored with the process when it's added or updated. The default
+	// "first-match" resolves relative paths as before and selects the first filesystem whose
+	// base contains the path.
+	FilesystemSelectionPolicy string
+
+	// Secrets provides the values for the placeholders of the form {secret:name}. These
+	// placeholders are only replaced in the arguments for starting FFmpeg. The config, the
+	// command, and the logs of a process only contain the placeholders.
+	Secrets func(name string) (string, error)
+
+	// SaveDebounce coalesces all changes within this duration into one write to the store.
+	// Changes that happened in the meantime are lost if the restreamer terminates without
+	// calling Stop(). Use 0 for writing every change immediately.
+	SaveDebounce time.Duration
+
+	// PipeDir is the directory for the FIFOs of the named pipes. A process can write to a named
+	// pipe with an output address of the form pipe:name and other processes can read from it with
+	// the same address as input. The FIFO is created when the writing process is added and removed
+	// when it is deleted. Named pipes are not available if not set.
+	PipeDir string
+
+	// RequireSkills enables checking the codecs, formats, and filters in the options of a process
+	// and the muxer of every output against the skills of its FFmpeg binary when the process is
+	// added and started. A process that requires e.g. an encoder that is not available will be
+	// rejected. The muxer of an output is either given by the "-f" option or it is inferred from
+	// the extension of the address, e.g. "hls" for ".m3u8".
+	RequireSkills bool
+
+	// AuditTrail enables recording the last changes of a process, i.e. when it has been added,
+	// updated, started, stopped, or reloaded, and by whom. The changes are stored in the metadata
+	// of the process and are available with GetProcessHistory.
+	AuditTrail bool
+
+	// MaxStartConcurrency is the max. number of processes that are started in parallel by Start.
+	// A process that references another process that is started as well will be started after
+	// it. Use 0 or 1 for starting the processes one after the other.
+	MaxStartConcurrency int
+
+	// FFprobe is the path to the ffprobe binary that is used by ProbeRaw. ProbeRaw is not
+	// available if not set.
+	FFprobe string
+
+	// ReferencePolicy decides whether the process with the ID fromID is allowed to reference
+	// the outputs, the stdout, or the named pipes of the process with the ID toID. A reference
+	// that is not allowed will be rejected with ErrReferenceNotAllowed. All references are
+	// allowed if not set.
+	ReferencePolicy func(fromID, toID string) bool
+
+	// FailureMode is the behavior if the changes of adding, updating, or deleting a process
+	// can't be written to the store. With "best-effort" (default) the failure is only logged.
+	// With "strict" the changes are written immediately and the operation is reverted and
+	// returns the error of the store if writing fails. This also applies to the operations
+	// on groups and to temporary configs.
+	FailureMode string
+
+	// GlobalOptions are FFmpeg global options that are added in front of the global options of
+	// every process, e.g. "-hide_banner". Options that a process already has with the same
+	// values are not added again.
+	GlobalOptions []string
+
+	// RejectPortConflicts enables rejecting a process that listens on the same port as another
+	// process, e.g. an RTMP input with "-listen 1" or an SRT address in the listener mode.
+	// Otherwise the conflicts are only reported by GetPortConflicts.
+	RejectPortConflicts bool
 }
 
 type task struct {
-	valid     bool
-	id        string // ID of the task/process
-	reference string
-	process   *app.Process
-	config    *app.Config
-	command   []string // The actual command parameter for ffmpeg
-	ffmpeg    process.Process
-	parser    parse.Parser
-	playout   map[string]int
-	logger    log.Logger
-	usesDisk  bool // Whether this task uses the disk
-	metadata  map[string]interface{}
+	valid       bool
+	id          string // ID of the task/process
+	reference   string
+	process     *app.Process
+	config      *app.Config
+	command     []string // The actual command parameter for ffmpeg
+	ffmpeg      process.Process
+	parser      parse.Parser
+	playout     map[string]int
+	logger      log.Logger
+	usesDisk    bool // Whether this task uses the disk
+	metadata    map[string]interface{}
+	preempted   bool // Whether this task has been stopped in favour of a task with higher priority
+	counted     bool // Whether this task is accounted for in the number of started processes
+	logs        *logBroadcaster
+	states      *stateBroadcaster
+	references  []string     // IDs of the processes this task is referencing
+	stdout      *pipeWriter  // Stdout of the process, may be connected to the stdin of another process
+	stdin       *processPipe // Pipe for reading from the stdout of another process
+	fifos       []string     // Paths of the FIFOs of the named pipes the process is writing to
+	history     *stateHistory
+	changedAt   time.Time           // Time of the last change of the config or the order
+	annotations []app.LogAnnotation // Markers for the log of the changes of the process
+	stale       struct {
+		count   uint64 // Number of times the process has been detected as stale
+		restart bool   // Whether the process should be restarted after it exited
+		lock    sync.Mutex
+	}
+	down struct {
+		since time.Time // Time when the process stopped running, zero if it is running
+		lock  sync.Mutex
+	}
+	sources struct {
+		counter    uint64            // Number of times an address has been selected
+		index      int               // Index of the address in use with "failover"
+		progressed bool              // Whether the current run reported any progress
+		selected   map[string]string // Currently selected address for each input ID with alternative addresses
+		command    []string          // Command with the selected addresses
+		live       []string          // Command after an input has been switched live, used for restarts
+		lock       sync.Mutex
+	}
+	breaker struct {
+		failures []time.Time // Times of the consecutive failures of the process
+		open     bool        // Whether the process is about to be disabled
+		started  bool        // Whether the current run of the process reported progress
+		lock     sync.Mutex
+	}
+	connect struct {
+		connected bool   // Whether the process reported any progress since it has been started
+		attempts  uint64 // Number of failed attempts without any progress
+		lock      sync.Mutex
+	}
+	demand struct {
+		consumers int64       // Number of current consumers of a process on demand, only access atomically
+		idle      *time.Timer // Timer for stopping the process after the idle timeout
+		successor *task       // Task that replaced this task on an update
+	}
+	sequence struct {
+		value uint64 // Last used sequence number for the outputs
+		lock  sync.Mutex
+	}
+	temporary *temporaryConfig // Original config while the process runs with a temporary config
 }
 
 type restream struct {
@@ -92,7 +364,11 @@ type restream struct {
 	ffmpeg    ffmpeg.FFmpeg
 	maxProc   int64
 	nProc     int64
-	fs        struct {
+
+	// deferredStarts collects the tasks whose FFmpeg will be started by launchProcesses
+	deferredStarts []*task
+	deferring      bool // Whether startProcess adds the tasks to deferredStarts instead of starting FFmpeg
+	fs             struct {
 		list         []rfs.Filesystem
 		diskfs       []rfs.Filesystem
 		stopObserver context.CancelFunc
@@ -102,10 +378,83 @@ type restream struct {
 	logger   log.Logger
 	metadata map[string]interface{}
 
+	commandModifier func(processID string, command []string) []string
+
+	generateID  bool
+	idGenerator func() string
+	preempt     bool
+	stopTimeout time.Duration
+	templates   map[string]*app.Config
+	ffmpegs     map[string]ffmpeg.FFmpeg
+
+	connectivityTimeout time.Duration
+	stateHistoryLength  int
+	bulkSkipUnknown     bool
+	strictVars          bool
+	warnOnCollision     bool
+
+	onFilesystemFull      func(fsName string, size, limit int64)
+	onFilesystemRecovered func(fsName string, size, limit int64)
+	onOutputFileComplete  func(processID string, output app.ConfigIO, path string) error
+	secrets               func(name string) (string, error)
+	pipeDir               string
+	requireSkills         bool
+	auditTrail            bool
+	maxStartConcurrency   int
+	ffprobe               string
+	referencePolicy       func(fromID, toID string) bool
+	failureMode           string
+	globalOptions         []string
+	rejectPortConflicts   bool
+
+	playoutPorts struct {
+		max   int
+		ports map[int]struct{} // Currently assigned playout ports
+		lock  sync.Mutex
+	}
+
+	sweeper struct {
+		interval time.Duration
+		stop     context.CancelFunc
+	}
+
+	probeTimeout time.Duration
+
+	probeCache struct {
+		ttl     time.Duration
+		entries map[string]probeCacheEntry
+		lock    sync.Mutex
+	}
+
+	operations struct {
+		ids  map[string]struct{} // IDs of the processes with an operation in progress
+		lock sync.Mutex
+	}
+
+	fsSelection struct {
+		policy  string
+		counter uint64 // Number of round-robin selections
+		lock    sync.Mutex
+	}
+
+	saving struct {
+		debounce time.Duration
+		timer    *time.Timer         // Pending debounced write
+		all      bool                // Whether all data has to be written
+		system   bool                // Whether the system metadata changed
+		ids      map[string]struct{} // IDs of the added, changed, or removed processes
+		lock     sync.Mutex
+		write    sync.Mutex // Serializes the writes to the store
+	}
+
 	lock sync.RWMutex
 
 	startOnce sync.Once
 	stopOnce  sync.Once
+
+	// pendingStarts is whether not all processes with a "start" order have been started
+	// because starting them has been aborted
+	pendingStarts bool
 }
 
 // New returns a new instance that implements the Restreamer interface
@@ -117,6 +466,11 @@ func New(config Config) (Restreamer, error) {
 		store:     config.Store,
 		replace:   config.Replace,
 		logger:    config.Logger,
+
+		commandModifier: config.CommandModifier,
+		generateID:      config.GenerateIDWhenEmpty,
+		idGenerator:     config.IDGenerator,
+		preempt:         config.Preempt,
 	}
 
 	if r.logger == nil {
@@ -135,9 +489,27 @@ func New(config Config) (Restreamer, error) {
 	}
 
 	for _, fs := range config.Filesystems {
+		var onNewFile func(id string, pattern rfs.Pattern, name string)
+
+		if config.OnNewOutputFile != nil || config.OnOutputFileComplete != nil {
+			name := fs.Name()
+			onNewFile = func(id string, pattern rfs.Pattern, path string) {
+				path = name + ":" + path
+
+				if config.OnNewOutputFile != nil {
+					config.OnNewOutputFile(id, pattern.OutputID, path)
+				}
+
+				if config.OnOutputFileComplete != nil {
+					go r.outputFileComplete(id, pattern.OutputID, path)
+				}
+			}
+		}
+
 		fs := rfs.New(rfs.Config{
-			FS:     fs,
-			Logger: r.logger.WithComponent("Cleanup"),
+			FS:        fs,
+			Logger:    r.logger.WithComponent("Cleanup"),
+			OnNewFile: onNewFile,
 		})
 
 		r.fs.list = append(r.fs.list, fs)
@@ -152,17 +524,106 @@ func New(config Config) (Restreamer, error) {
 		r.replace = replace.New()
 	}
 
+	if r.idGenerator == nil {
+		r.idGenerator = func() string {
+			return uuid.New().String()
+		}
+	}
+
 	r.ffmpeg = config.FFmpeg
 	if r.ffmpeg == nil {
 		return nil, fmt.Errorf("ffmpeg must be provided")
 	}
 
 	r.maxProc = config.MaxProcesses
+	r.stopTimeout = config.StopTimeout
+	r.connectivityTimeout = config.ConnectivityTimeout
+
+	r.bulkSkipUnknown = config.BulkSkipUnknown
+	r.strictVars = config.StrictVars
+	r.warnOnCollision = config.WarnOnOutputCollision
+	r.onFilesystemFull = config.OnFilesystemFull
+	r.onFilesystemRecovered = config.OnFilesystemRecovered
+	r.onOutputFileComplete = config.OnOutputFileComplete
+	r.secrets = config.Secrets
+	r.pipeDir = config.PipeDir
+	r.requireSkills = config.RequireSkills
+	r.auditTrail = config.AuditTrail
+	r.maxStartConcurrency = config.MaxStartConcurrency
+	r.referencePolicy = config.ReferencePolicy
+	r.globalOptions = append([]string{}, config.GlobalOptions...)
+	r.rejectPortConflicts = config.RejectPortConflicts
+
+	r.failureMode = config.FailureMode
+	switch r.failureMode {
+	case "":
+		r.failureMode = "best-effort"
+	case "best-effort", "strict":
+	default:
+		return nil, fmt.Errorf("unknown failure mode '%s'", r.failureMode)
+	}
+
+	if len(config.FFprobe) != 0 {
+		ffprobe, err := exec.LookPath(config.FFprobe)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ffprobe binary given: %w", err)
+		}
+
+		r.ffprobe = ffprobe
+	}
+
+	r.fsSelection.policy = config.FilesystemSelectionPolicy
+	switch r.fsSelection.policy {
+	case "":
+		r.fsSelection.policy = "first-match"
+	case "first-match", "least-full", "round-robin":
+	default:
+		return nil, fmt.Errorf("unknown filesystem selection policy '%s'", r.fsSelection.policy)
+	}
+	r.playoutPorts.max = config.MaxPlayoutPorts
+	r.playoutPorts.ports = make(map[int]struct{})
+	r.stateHistoryLength = config.StateHistoryLength
+	if r.stateHistoryLength <= 0 {
+		r.stateHistoryLength = 100
+	}
+
+	r.sweeper.interval = config.SweepInterval
+	if r.sweeper.interval <= 0 {
+		r.sweeper.interval = time.Minute
+	}
+
+	r.templates = make(map[string]*app.Config)
+	for name, tmpl := range config.Templates {
+		r.templates[name] = tmpl.Clone()
+	}
+
+	r.ffmpegs = make(map[string]ffmpeg.FFmpeg)
+	for name, ff := range config.FFmpegBinaries {
+		if ff == nil {
+			return nil, fmt.Errorf("the ffmpeg binary '%s' must be provided", name)
+		}
+
+		r.ffmpegs[name] = ff
+	}
+
+	r.probeTimeout = config.DefaultProbeTimeout
+	if r.probeTimeout <= 0 {
+		r.probeTimeout = 20 * time.Second
+	}
+
+	r.probeCache.ttl = config.ProbeCacheTTL
+	r.probeCache.entries = make(map[string]probeCacheEntry)
+	r.operations.ids = make(map[string]struct{})
+	r.saving.debounce = config.SaveDebounce
+	r.saving.ids = make(map[string]struct{})
 
 	if err := r.load(); err != nil {
 		return nil, fmt.Errorf("failed to load data from DB (%w)", err)
 	}
 
+	// The loaded data might have been changed, e.g. by adding the FFmpeg version
+	// or by migrating processes to the current schema
+	r.markAllChanged()
 	r.save()
 
 	r.stopOnce.Do(func() {})
@@ -171,16 +632,20 @@ func New(config Config) (Restreamer, error) {
 }
 
 func (r *restream) Start() {
+	r.StartContext(context.Background())
+}
+
+// StartContext starts all processes that have a "start" order. If the context is cancelled
+// while starting the processes, the remaining processes will not be started. The already
+// started processes will keep running and an error is returned. The remaining processes
+// will be started by a later call of Start or StartContext.
+func (r *restream) StartContext(ctx context.Context) error {
 	r.startOnce.Do(func() {
 		r.lock.Lock()
 		defer r.lock.Unlock()
 
+		// The filesystem cleanup rules can be set
 		for id, t := range r.tasks {
-			if t.process.Order == "start" {
-				r.startProcess(id)
-			}
-
-			// The filesystem cleanup rules can be set
 			r.setCleanup(id, t.config)
 		}
 
@@ -195,11 +660,93 @@ func (r *restream) Start() {
 			}
 		}
 
+		ctx, cancel = context.WithCancel(context.Background())
+		r.sweeper.stop = cancel
+
+		go r.sweep(ctx, r.sweeper.interval)
+
+		r.pendingStarts = true
 		r.stopOnce = sync.Once{}
 	})
+
+	r.lock.Lock()
+
+	if !r.pendingStarts {
+		r.lock.Unlock()
+		return nil
+	}
+
+	tasks, err := r.startProcesses(ctx)
+
+	r.lock.Unlock()
+
+	// FFmpeg is started outside of the lock, such that the API stays responsive
+	if len(tasks) != 0 {
+		launched, launchErr := r.launchProcesses(ctx, tasks)
+		if launchErr != nil && err == nil {
+			err = fmt.Errorf("starting the processes aborted after %d processes: %w", launched, launchErr)
+		}
+	}
+
+	return err
+}
+
+// startProcesses starts all processes that have a "start" order and are not yet running.
+// If the context is cancelled, the remaining processes are left for a later call. With a
+// max. start concurrency, the tasks whose FFmpeg has to be started by launchProcesses are
+// returned. The caller has to hold the lock.
+func (r *restream) startProcesses(ctx context.Context) ([]*task, error) {
+	// Start the processes with the highest priority first, such that they
+	// will be preferred if the number of processes is limited.
+	ids := make([]string, 0, len(r.tasks))
+	for id := range r.tasks {
+		ids = append(ids, id)
+	}
+
+	sort.SliceStable(ids, func(i, j int) bool {
+		return r.tasks[ids[i]].process.Config.Priority > r.tasks[ids[j]].process.Config.Priority
+	})
+
+	started := 0
+
+	var err error
+
+	// FFmpeg will be started for all processes at once after their order
+	// and the number of running processes have been accounted for
+	deferred := len(r.deferredStarts)
+	r.deferring = r.maxStartConcurrency > 1
+
+	for _, id := range ids {
+		if r.tasks[id].process.Order != "start" {
+			continue
+		}
+
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = fmt.Errorf("starting the processes aborted after %d processes: %w", started, ctxErr)
+			break
+		}
+
+		r.startProcess(id)
+		started++
+	}
+
+	r.deferring = false
+	r.pendingStarts = err != nil
+
+	tasks := append([]*task{}, r.deferredStarts[deferred:]...)
+
+	return tasks, err
 }
 
+// Stop stops all running processes without a deadline. See StopContext.
 func (r *restream) Stop() {
+	r.StopContext(context.Background())
+}
+
+// StopContext stops all running processes concurrently and waits for them to exit. All
+// processes that didn't exit before the context is done will be killed with SIGKILL
+// without waiting for them any longer.
+func (r *restream) StopContext(ctx context.Context) {
 	r.stopOnce.Do(func() {
 		r.lock.Lock()
 		defer r.lock.Unlock()
@@ -207,1166 +754,5518 @@ func (r *restream) Stop() {
 		// Stop the currently running processes without
 		// altering their order such that on a subsequent
 		// Start() they will get restarted.
-		for id, t := range r.tasks {
-			if t.ffmpeg != nil {
-				t.ffmpeg.Stop(true)
-			}
+		r.stopProcesses(ctx)
 
+		for id := range r.tasks {
 			r.unsetCleanup(id)
 		}
 
 		r.fs.stopObserver()
+		r.sweeper.stop()
+
+		// Write the pending changes
+		r.saving.lock.Lock()
+		pending := r.saving.timer != nil && r.saving.timer.Stop()
+		r.saving.timer = nil
+		r.saving.lock.Unlock()
+
+		if pending {
+			r.persist()
+		}
 
 		// Stop the cleanup jobs
 		for _, fs := range r.fs.list {
 			fs.Stop()
 		}
 
+		r.pendingStarts = false
 		r.startOnce = sync.Once{}
 	})
 }
 
-func (r *restream) observe(ctx context.Context, fs fs.Filesystem, interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+// stopProcesses stops the processes of all tasks concurrently. The processes that are still
+// running when the context is done will be killed. The caller has to hold the lock.
+func (r *restream) stopProcesses(ctx context.Context) {
+	wg := sync.WaitGroup{}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			size, limit := fs.Size()
-			isFull := false
-			if limit > 0 && size >= limit {
-				isFull = true
+	for _, t := range r.tasks {
+		if t.ffmpeg == nil {
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(proc process.Process) {
+			defer wg.Done()
+			proc.Stop(true)
+		}(t.ffmpeg)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+
+	for id, t := range r.tasks {
+		if t.ffmpeg == nil || !t.ffmpeg.IsRunning() {
+			continue
+		}
+
+		r.logger.Warn().WithField("id", id).Log("Killing because it didn't stop before the deadline")
+
+		if err := t.ffmpeg.ForceKill(); err != nil {
+			r.logger.Warn().WithField("id", id).WithError(err).Log("Failed to kill process")
+		}
+	}
+}
+
+// launchProcesses starts FFmpeg for the tasks with at most maxStartConcurrency processes
+// in parallel. The tasks are started in stages, such that a task is only started after all
+// the tasks it references. If the context is cancelled, the remaining tasks are not started
+// anymore and the number of started tasks is returned with the error of the context. A task
+// that has been stopped, deleted, or replaced in the meantime is skipped. The caller must
+// not hold the lock.
+func (r *restream) launchProcesses(ctx context.Context, tasks []*task) (int, error) {
+	sem := make(chan struct{}, r.maxStartConcurrency)
+
+	launched := 0
+
+	var err error
+
+	for _, stage := range startStages(tasks) {
+		wg := sync.WaitGroup{}
+
+		for _, t := range stage {
+			if err == nil {
+				err = ctx.Err()
 			}
 
-			if isFull {
-				// Stop all tasks that write to this filesystem
-				r.lock.Lock()
-				for id, t := range r.tasks {
-					if !t.valid {
-						continue
-					}
+			if err == nil {
+				select {
+				case sem <- struct{}{}:
+				case <-ctx.Done():
+					err = ctx.Err()
+				}
+			}
 
-					if !t.usesDisk {
-						continue
-					}
+			r.lock.Lock()
 
-					if t.process.Order != "start" {
-						continue
-					}
+			pending := r.removeDeferredStart(t)
+			proc := t.ffmpeg
 
-					r.logger.Warn().Log("Shutting down because filesystem is full")
-					r.stopProcess(id)
+			// The process is not running and it will be started by a later call of StartContext
+			if err != nil {
+				if pending {
+					t.counted = false
+					r.nProc--
+					r.pendingStarts = true
 				}
+
 				r.lock.Unlock()
+				continue
+			}
+
+			r.lock.Unlock()
+
+			if !pending {
+				<-sem
+				continue
 			}
+
+			wg.Add(1)
+
+			go func(t *task, proc process.Process) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+
+				proc.Start()
+
+				r.lock.Lock()
+				defer r.lock.Unlock()
+
+				// Check whether the process has been stopped or replaced while it has been started
+				if task, ok := r.tasks[t.id]; !ok || task != t || t.ffmpeg != proc || t.process.Order != "start" {
+					proc.Stop(false)
+				}
+			}(t, proc)
+
+			launched++
 		}
+
+		wg.Wait()
 	}
+
+	return launched, err
 }
 
-func (r *restream) load() error {
-	data, err := r.store.Load()
-	if err != nil {
-		return err
+// removeDeferredStart removes the task from the tasks whose FFmpeg is about to be started by
+// launchProcesses. It returns whether the task has been one of them.
+func (r *restream) removeDeferredStart(t *task) bool {
+	for i, d := range r.deferredStarts {
+		if d == t {
+			r.deferredStarts = append(r.deferredStarts[:i], r.deferredStarts[i+1:]...)
+			return true
+		}
 	}
 
-	tasks := make(map[string]*task)
+	return false
+}
 
-	skills := r.ffmpeg.Skills()
-	ffversion := skills.FFmpeg.Version
-	if v, err := semver.NewVersion(ffversion); err == nil {
-		// Remove the patch level for the constraint
-		ffversion = fmt.Sprintf("%d.%d.0", v.Major(), v.Minor())
+// isDeferredStart returns whether FFmpeg of the task is about to be started by launchProcesses.
+func (r *restream) isDeferredStart(t *task) bool {
+	for _, d := range r.deferredStarts {
+		if d == t {
+			return true
+		}
 	}
 
-	for id, process := range data.Process {
-		if len(process.Config.FFVersion) == 0 {
-			process.Config.FFVersion = "^" + ffversion
+	return false
+}
+
+// startStages groups the tasks into stages. A task is in a later stage than all the
+// tasks it references. The order of the tasks within a stage is kept.
+func startStages(tasks []*task) [][]*task {
+	index := map[string]*task{}
+	for _, t := range tasks {
+		index[t.id] = t
+	}
+
+	depths := map[string]int{}
+
+	var depth func(t *task, visiting map[string]struct{}) int
+	depth = func(t *task, visiting map[string]struct{}) int {
+		if d, ok := depths[t.id]; ok {
+			return d
 		}
 
-		t := &task{
-			id:        id,
-			reference: process.Reference,
-			process:   process,
-			config:    process.Config.Clone(),
-			logger:    r.logger.WithField("id", id),
+		visiting[t.id] = struct{}{}
+		defer delete(visiting, t.id)
+
+		d := 0
+		for _, id := range t.references {
+			producer, ok := index[id]
+			if !ok {
+				continue
+			}
+
+			// Ignore circular references
+			if _, ok := visiting[id]; ok {
+				continue
+			}
+
+			if pd := depth(producer, visiting) + 1; pd > d {
+				d = pd
+			}
 		}
 
-		// Replace all placeholders in the config
-		resolvePlaceholders(t.config, r.replace)
+		depths[t.id] = d
 
-		tasks[id] = t
+		return d
 	}
 
-	for id, userdata := range data.Metadata.Process {
-		t, ok := tasks[id]
-		if !ok {
-			continue
+	stages := [][]*task{}
+
+	for _, t := range tasks {
+		d := depth(t, map[string]struct{}{})
+
+		for len(stages) <= d {
+			stages = append(stages, []*task{})
 		}
 
-		t.metadata = userdata
+		stages[d] = append(stages[d], t)
 	}
 
-	// Now that all tasks are defined and all placeholders are
-	// replaced, we can resolve references and validate the
-	// inputs and outputs.
-	for _, t := range tasks {
-		// Just warn if the ffmpeg version constraint doesn't match the available ffmpeg version
-		if c, err := semver.NewConstraint(t.config.FFVersion); err == nil {
-			if v, err := semver.NewVersion(skills.FFmpeg.Version); err == nil {
-				if !c.Check(v) {
-					r.logger.Warn().WithFields(log.Fields{
-						"id":         t.id,
-						"constraint": t.config.FFVersion,
-						"version":    skills.FFmpeg.Version,
-					}).WithError(fmt.Errorf("available FFmpeg version doesn't fit constraint; you have to update this process to adjust the constraint")).Log("")
+	return stages
+}
+
+// sweep periodically deletes the stopped processes that haven't been updated
+// for longer than their max. age.
+func (r *restream) sweep(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r.lock.Lock()
+			deleted := 0
+			for _, id := range r.expiredProcesses(now) {
+				if err := r.deleteProcess(id); err != nil {
+					r.logger.Warn().WithField("id", id).WithError(err).Log("Failed to delete expired process")
+					continue
 				}
-			} else {
-				r.logger.Warn().WithField("id", t.id).WithError(err).Log("")
+
+				r.logger.Info().WithField("id", id).Log("Deleted expired process")
+				deleted++
 			}
-		} else {
-			r.logger.Warn().WithField("id", t.id).WithError(err).Log("")
+
+			if deleted != 0 {
+				r.save()
+			}
+			r.lock.Unlock()
 		}
+	}
+}
 
-		err := r.resolveAddresses(tasks, t.config)
-		if err != nil {
-			r.logger.Warn().WithField("id", t.id).WithError(err).Log("Ignoring")
+// expiredProcesses returns the IDs of the processes that are stopped and exceeded
+// their max. age. Processes that are referenced by other processes will be kept.
+func (r *restream) expiredProcesses(now time.Time) []string {
+	ids := []string{}
+
+	for id, t := range r.tasks {
+		if t.process.Config.MaxAge == 0 {
 			continue
 		}
 
-		t.usesDisk, err = r.validateConfig(t.config)
-		if err != nil {
-			r.logger.Warn().WithField("id", t.id).WithError(err).Log("Ignoring")
+		if t.process.Order != "stop" {
 			continue
 		}
 
-		err = r.setPlayoutPorts(t)
-		if err != nil {
-			r.logger.Warn().WithField("id", t.id).WithError(err).Log("Ignoring")
+		if t.ffmpeg != nil && t.ffmpeg.IsRunning() {
 			continue
 		}
 
-		t.command = t.config.CreateCommand()
-		t.parser = r.ffmpeg.NewProcessParser(t.logger, t.id, t.reference)
+		if now.Unix()-t.process.UpdatedAt < int64(t.process.Config.MaxAge) {
+			continue
+		}
 
-		ffmpeg, err := r.ffmpeg.New(ffmpeg.ProcessConfig{
-			Reconnect:      t.config.Reconnect,
-			ReconnectDelay: time.Duration(t.config.ReconnectDelay) * time.Second,
-			StaleTimeout:   time.Duration(t.config.StaleTimeout) * time.Second,
-			LimitCPU:       t.config.LimitCPU,
-			LimitMemory:    t.config.LimitMemory,
-			LimitDuration:  time.Duration(t.config.LimitWaitFor) * time.Second,
-			Command:        t.command,
-			Parser:         t.parser,
-			Logger:         t.logger,
-		})
-		if err != nil {
-			return err
+		if len(r.getProcessDependents(id)) != 0 {
+			continue
 		}
 
-		t.ffmpeg = ffmpeg
-		t.valid = true
+		ids = append(ids, id)
 	}
 
-	r.tasks = tasks
-	r.metadata = data.Metadata.System
-
-	return nil
+	return ids
 }
 
-func (r *restream) save() {
-	data := store.NewStoreData()
+func (r *restream) observe(ctx context.Context, fs fs.Filesystem, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	isFull := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			isFull = r.checkFilesystem(fs, isFull)
+		}
+	}
+}
+
+// outputFileComplete calls the OnOutputFileComplete callback with the config of the output.
+func (r *restream) outputFileComplete(id, outputID, path string) {
+	output := app.ConfigIO{
+		ID: outputID,
+	}
+
+	r.lock.RLock()
+	if t, ok := r.tasks[id]; ok {
+		for _, o := range t.process.Config.Output {
+			if o.ID == outputID {
+				output = o.Clone()
+				break
+			}
+		}
+	}
+	r.lock.RUnlock()
+
+	if err := r.onOutputFileComplete(id, output, path); err != nil {
+		r.logger.Warn().WithFields(log.Fields{
+			"id":     id,
+			"output": outputID,
+			"path":   path,
+		}).WithError(err).Log("Failed to process the output file")
+	}
+}
+
+// checkFilesystem stops all processes that are writing to the disk if the filesystem
+// is full and returns whether it is full. Processes that ignore a full disk keep running.
+// The callbacks are only called if the filesystem became full or is not full anymore
+// compared to the previous check.
+func (r *restream) checkFilesystem(fs fs.Filesystem, wasFull bool) bool {
+	size, limit := fs.Size()
+	isFull := false
+	if limit > 0 && size >= limit {
+		isFull = true
+	}
+
+	if isFull {
+		// Stop all tasks that write to this filesystem
+		r.lock.Lock()
+		for id, t := range r.tasks {
+			if !t.valid {
+				continue
+			}
+
+			if !t.usesDisk {
+				continue
+			}
+
+			if t.process.Order != "start" {
+				continue
+			}
+
+			if t.config.IgnoreFullDisk {
+				t.logger.Warn().Log("Keep running although filesystem is full")
+				continue
+			}
+
+			r.logger.Warn().Log("Shutting down because filesystem is full")
+			r.stopProcess(id)
+		}
+		r.lock.Unlock()
+	}
+
+	if isFull && !wasFull && r.onFilesystemFull != nil {
+		r.onFilesystemFull(fs.Name(), size, limit)
+	} else if !isFull && wasFull && r.onFilesystemRecovered != nil {
+		r.onFilesystemRecovered(fs.Name(), size, limit)
+	}
+
+	return isFull
+}
+
+func (r *restream) load() error {
+	data, err := r.store.Load()
+	if err != nil {
+		return err
+	}
+
+	tasks := make(map[string]*task)
+
+	skills := r.ffmpeg.Skills()
+	ffversion := skills.FFmpeg.Version
+	if v, err := semver.NewVersion(ffversion); err == nil {
+		// Remove the patch level for the constraint
+		ffversion = fmt.Sprintf("%d.%d.0", v.Major(), v.Minor())
+	}
+
+	for id, process := range data.Process {
+		r.migrateProcess(id, process)
+
+		if len(process.Config.FFVersion) == 0 {
+			process.Config.FFVersion = "^" + ffversion
+		}
+
+		// There are no consumers for a process on demand yet
+		if process.Config.OnDemand && process.Order == "start" {
+			process.Order = "stop"
+		}
+
+		t := &task{
+			id:        id,
+			reference: process.Reference,
+			process:   process,
+			config:    process.Config.Clone(),
+			logger:    r.logger.WithField("id", id),
+			logs:      newLogBroadcaster(),
+			states:    newStateBroadcaster(),
+			stdout:    newPipeWriter(),
+			history:   newStateHistory(r.stateHistoryLength),
+			changedAt: time.Unix(process.UpdatedAt, 0),
+			metadata:  data.Metadata.Process[id],
+		}
+
+		// Replace all placeholders in the config
+		r.resolveConfig(t.config, t.metadata)
+
+		tasks[id] = t
+	}
+
+	// Now that all tasks are defined and all placeholders are
+	// replaced, we can resolve references and validate the
+	// inputs and outputs.
+	for _, t := range tasks {
+		// Just warn if the ffmpeg version constraint doesn't match the available ffmpeg version
+		if c, err := semver.NewConstraint(t.config.FFVersion); err == nil {
+			if v, err := semver.NewVersion(skills.FFmpeg.Version); err == nil {
+				if !c.Check(v) {
+					r.logger.Warn().WithFields(log.Fields{
+						"id":         t.id,
+						"constraint": t.config.FFVersion,
+						"version":    skills.FFmpeg.Version,
+					}).WithError(fmt.Errorf("available FFmpeg version doesn't fit constraint; you have to update this process to adjust the constraint")).Log("")
+				}
+			} else {
+				r.logger.Warn().WithField("id", t.id).WithError(err).Log("")
+			}
+		} else {
+			r.logger.Warn().WithField("id", t.id).WithError(err).Log("")
+		}
+
+		t.references = referencedProcesses(t.config)
+
+		producer, err := stdoutProducer(t.config)
+		if err != nil {
+			r.logger.Warn().WithField("id", t.id).WithError(err).Log("Ignoring")
+			continue
+		}
+
+		err = r.resolveAddresses(tasks, t.config)
+		if err != nil {
+			r.logger.Warn().WithField("id", t.id).WithError(err).Log("Ignoring")
+			continue
+		}
+
+		t.usesDisk, err = r.validateConfig(t.config)
+		if err != nil {
+			r.logger.Warn().WithField("id", t.id).WithError(err).Log("Ignoring")
+			continue
+		}
+
+		ff, err := r.ffmpegFor(t.config)
+		if err != nil {
+			r.logger.Warn().WithField("id", t.id).WithError(err).Log("Ignoring")
+			continue
+		}
+
+		err = r.createWorkingDir(t)
+		if err != nil {
+			r.logger.Warn().WithField("id", t.id).WithError(err).Log("Ignoring")
+			continue
+		}
+
+		t.fifos, err = r.resolveNamedPipes(t.config)
+		if err != nil {
+			r.logger.Warn().WithField("id", t.id).WithError(err).Log("Ignoring")
+			continue
+		}
+
+		err = r.setPlayoutPorts(t)
+		if err != nil {
+			r.logger.Warn().WithField("id", t.id).WithError(err).Log("Ignoring")
+			continue
+		}
+
+		err = r.createPipe(t, producer)
+		if err != nil {
+			r.logger.Warn().WithField("id", t.id).WithError(err).Log("Ignoring")
+			continue
+		}
+
+		t.command = r.createCommand(t)
+		t.parser = ff.NewProcessParser(t.logger, t.id, t.reference)
+
+		ffmpeg, err := r.createProcess(t)
+		if err != nil {
+			return err
+		}
+
+		t.ffmpeg = ffmpeg
+		t.valid = true
+	}
+
+	r.tasks = tasks
+	r.metadata = data.Metadata.System
+
+	for _, t := range tasks {
+		if err := r.createFIFOs(t); err != nil {
+			r.logger.Warn().WithField("id", t.id).WithError(err).Log("Failed to create named pipes")
+		}
+	}
+
+	// Connect the processes that read from the stdout of other processes
+	for _, t := range tasks {
+		if err := r.attachPipe(t); err != nil {
+			r.logger.Warn().WithField("id", t.id).WithError(err).Log("Failed to connect to stdout")
+			r.closePipe(t)
+		}
+	}
+
+	return nil
+}
+
+// save writes the data to the store. If the writes are debounced, the data will
+// be written as soon as the debounce duration passed.
+func (r *restream) save() {
+	if r.saving.debounce <= 0 {
+		r.persist()
+		return
+	}
+
+	r.saving.lock.Lock()
+	defer r.saving.lock.Unlock()
+
+	if r.saving.timer != nil {
+		return
+	}
+
+	r.saving.timer = time.AfterFunc(r.saving.debounce, func() {
+		r.saving.lock.Lock()
+		r.saving.timer = nil
+		r.saving.lock.Unlock()
+
+		r.lock.RLock()
+		defer r.lock.RUnlock()
+
+		r.persist()
+	})
+}
+
+// markChanged marks the processes with the IDs as changed such that only their data has to
+// be written if the store supports it. Removed processes are marked as changed as well.
+func (r *restream) markChanged(ids ...string) {
+	r.saving.lock.Lock()
+	defer r.saving.lock.Unlock()
+
+	for _, id := range ids {
+		r.saving.ids[id] = struct{}{}
+	}
+}
+
+// markSystemChanged marks the system metadata as changed.
+func (r *restream) markSystemChanged() {
+	r.saving.lock.Lock()
+	defer r.saving.lock.Unlock()
+
+	r.saving.system = true
+}
+
+// markAllChanged marks all data as changed such that it will be written completely.
+func (r *restream) markAllChanged() {
+	r.saving.lock.Lock()
+	defer r.saving.lock.Unlock()
+
+	r.saving.all = true
+}
+
+// persist writes the changes to the store. If the store is a store.DeltaStore, only
+// the changed processes are written and nothing is written if nothing changed. Otherwise
+// all data is written. The lock must be held at least for reading.
+func (r *restream) persist() error {
+	r.saving.write.Lock()
+	defer r.saving.write.Unlock()
+
+	r.saving.lock.Lock()
+	all, system, ids := r.saving.all, r.saving.system, r.saving.ids
+	r.saving.all, r.saving.system, r.saving.ids = false, false, make(map[string]struct{})
+	r.saving.lock.Unlock()
+
+	var err error
+
+	if s, ok := r.store.(store.DeltaStore); ok && !all {
+		if len(ids) == 0 && !system {
+			return nil
+		}
+
+		err = s.StoreDelta(r.storeDelta(ids, system))
+	} else {
+		err = r.store.Store(r.storeData())
+	}
+
+	if err != nil {
+		// The next write has to contain everything that hasn't been written
+		r.markAllChanged()
+		r.logger.Error().WithError(err).Log("Failed to store the processes")
+	}
+
+	return err
+}
+
+// storeDelta returns the changes of the processes with the IDs and of the system metadata.
+func (r *restream) storeDelta(ids map[string]struct{}, system bool) store.StoreDelta {
+	delta := store.NewStoreDelta()
+
+	for id := range ids {
+		t, ok := r.tasks[id]
+		if !ok {
+			delta.Removed = append(delta.Removed, id)
+			continue
+		}
+
+		delta.Process[id] = t.storedProcess()
+		delta.Metadata[id] = t.metadata
+	}
+
+	sort.Strings(delta.Removed)
+
+	if system {
+		delta.SystemChanged = true
+		delta.System = r.metadata
+	}
+
+	return delta
+}
+
+func (r *restream) storeData() store.StoreData {
+	data := store.NewStoreData()
+
+	for id, t := range r.tasks {
+		data.Process[id] = t.storedProcess()
+		data.Metadata.System = r.metadata
+		data.Metadata.Process[id] = t.metadata
+	}
+
+	return data
+}
+
+// StateHash returns the hex encoded SHA256 hash over the data that is written
+// to the store. The hash only changes if the stored data changes.
+func (r *restream) StateHash() string {
+	r.lock.RLock()
+	data := r.storeData()
+	// The keys of the maps are sorted by the JSON encoder
+	payload, err := json.Marshal(data)
+	r.lock.RUnlock()
+
+	if err != nil {
+		r.logger.Warn().WithError(err).Log("Failed to encode the state")
+		return ""
+	}
+
+	hash := sha256.Sum256(payload)
+
+	return hex.EncodeToString(hash[:])
+}
+
+func (r *restream) ID() string {
+	return r.id
+}
+
+func (r *restream) Name() string {
+	return r.name
+}
+
+func (r *restream) CreatedAt() time.Time {
+	return r.createdAt
+}
+
+var ErrUnknownProcess = errors.New("unknown process")
+var ErrReservedMetadataKey = errors.New("reserved metadata key")
+var ErrProcessExists = errors.New("process already exists")
+var ErrInputUnreachable = errors.New("input is not reachable")
+var ErrOutputCollision = errors.New("output collision")
+var ErrProcessDisabled = errors.New("process is disabled")
+var ErrUnknownGroup = errors.New("unknown group")
+var ErrBusy = errors.New("restreamer is busy")
+var ErrUnknownFilesystem = errors.New("unknown filesystem")
+var ErrReferenceNotStarted = errors.New("referenced process is not started")
+var ErrOperationInProgress = errors.New("another operation on the process is in progress")
+var ErrTemplateOnly = errors.New("process is template-only")
+var ErrMissingSkills = errors.New("required FFmpeg capabilities are not available")
+var ErrReferenceNotAllowed = errors.New("reference is not allowed")
+var ErrPortConflict = errors.New("port conflict")
+var ErrIDGeneration = errors.New("failed to generate a process ID")
+
+// The categories of a ValidationError
+var ErrMissingIO = errors.New("no inputs or outputs")
+var ErrInvalidValue = errors.New("invalid value")
+var ErrEmptyIOID = errors.New("empty ID")
+var ErrDuplicateIOID = errors.New("duplicate ID")
+var ErrInputAddressEmpty = errors.New("empty input address")
+var ErrOutputAddressEmpty = errors.New("empty output address")
+var ErrInvalidAddress = errors.New("invalid address")
+var ErrAddressOutsideBase = errors.New("address outside of the base directory")
+var ErrUnresolvedPlaceholder = errors.New("unresolved placeholders")
+
+// beginOperation marks an operation on the process with the ID as in progress. It returns
+// ErrOperationInProgress if there is already an operation in progress for this process.
+func (r *restream) beginOperation(id string) error {
+	r.operations.lock.Lock()
+	defer r.operations.lock.Unlock()
+
+	if _, ok := r.operations.ids[id]; ok {
+		return fmt.Errorf("%w: %s", ErrOperationInProgress, id)
+	}
+
+	r.operations.ids[id] = struct{}{}
+
+	return nil
+}
+
+// endOperation marks the operation on the process with the ID as finished.
+func (r *restream) endOperation(id string) {
+	r.operations.lock.Lock()
+	defer r.operations.lock.Unlock()
+
+	delete(r.operations.ids, id)
+}
+
+func (r *restream) AddProcess(config *app.Config) error {
+	_, err := r.AddProcessReturn(config)
+
+	return err
+}
+
+func (r *restream) AddProcessWithID(config *app.Config) (string, error) {
+	process, err := r.AddProcessReturn(config)
+	if err != nil {
+		return "", err
+	}
+
+	return process.ID, nil
+}
+
+// AddProcessReturn adds a new process like AddProcess and returns a copy of the
+// created process.
+func (r *restream) AddProcessReturn(config *app.Config) (*app.Process, error) {
+	return r.addProcessAs(config, "")
+}
+
+// maxIDAttempts is the max. number of IDs that are generated for a new process until
+// an unused ID has been found.
+const maxIDAttempts = 10
+
+// generateProcessID returns a new ID from the ID generator that is not yet used by any
+// process. The caller has to hold the lock at least for reading.
+func (r *restream) generateProcessID() (string, error) {
+	for i := 0; i < maxIDAttempts; i++ {
+		id := r.idGenerator()
+		if _, ok := r.tasks[id]; !ok {
+			return id, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: no unused ID generated after %d attempts", ErrIDGeneration, maxIDAttempts)
+}
+
+// addProcessAs adds a new process like AddProcessReturn. The actor will be
+// recorded in the audit trail.
+func (r *restream) addProcessAs(config *app.Config, actor string) (*app.Process, error) {
+	rlock := func() bool {
+		r.lock.RLock()
+		return true
+	}
+
+	lock := func() bool {
+		r.lock.Lock()
+		return true
+	}
+
+	return r.addProcess(config, actor, rlock, r.lock.RUnlock, lock)
+}
+
+// addProcess adds a new process. The task is created while holding the lock that is acquired
+// with rlock and released with runlock. The task is added while holding the lock that is
+// acquired with lock. If any of the locks can't be acquired, ErrBusy is returned.
+func (r *restream) addProcess(config *app.Config, actor string, rlock func() bool, runlock func(), lock func() bool) (*app.Process, error) {
+	if !rlock() {
+		return nil, ErrBusy
+	}
+
+	if r.generateID && len(strings.TrimSpace(config.ID)) == 0 {
+		id, err := r.generateProcessID()
+		if err != nil {
+			runlock()
+			return nil, err
+		}
+
+		config.ID = id
+	}
+
+	r.placeOutputs(config)
+
+	t, err := r.createTask(config)
+	runlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if r.connectivityTimeout > 0 {
+		if err := r.checkConnectivity(t, r.connectivityTimeout); err != nil {
+			r.unsetPlayoutPorts(t)
+			r.closePipe(t)
+			return nil, err
+		}
+	}
+
+	if !lock() {
+		r.unsetPlayoutPorts(t)
+		r.closePipe(t)
+		return nil, ErrBusy
+	}
+
+	defer r.lock.Unlock()
+
+	if err := r.addTask(t); err != nil {
+		return nil, err
+	}
+
+	r.audit(t, "add", actor)
+
+	if err := r.commit(); err != nil {
+		r.revertAdd(t.id)
+		return nil, err
+	}
+
+	return t.process.Clone(), nil
+}
+
+// AddCanary adds a stopped copy of the process with the ID srcID with the ID canaryID. The
+// config of the copy can be changed with the modify function. None of the outputs of the
+// canary must write to the same addresses as the outputs of the source process.
+func (r *restream) AddCanary(srcID, canaryID string, modify func(*app.Config)) error {
+	canaryID = strings.TrimSpace(canaryID)
+
+	if len(canaryID) == 0 || canaryID == srcID {
+		return fmt.Errorf("the canary requires an ID that is different from the ID of the process '%s'", srcID)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	src, ok := r.tasks[srcID]
+	if !ok {
+		return ErrUnknownProcess
+	}
+
+	config := src.process.Config.Clone()
+
+	if modify != nil {
+		modify(config)
+	}
+
+	config.ID = canaryID
+	config.Autostart = false
+
+	r.placeOutputs(config)
+
+	t, err := r.createTask(config)
+	if err != nil {
+		return err
+	}
+
+	srcAddresses := map[string]struct{}{}
+	for _, address := range outputAddresses(src.config) {
+		srcAddresses[address] = struct{}{}
+	}
+
+	for _, address := range outputAddresses(t.config) {
+		if _, ok := srcAddresses[address]; ok {
+			r.unsetPlayoutPorts(t)
+			r.closePipe(t)
+			return fmt.Errorf("%w: the canary '%s' writes to the same output '%s' as the process '%s'", ErrOutputCollision, canaryID, address, srcID)
+		}
+	}
+
+	if err := r.addTask(t); err != nil {
+		return err
+	}
+
+	if err := r.commit(); err != nil {
+		r.revertAdd(t.id)
+		return err
+	}
+
+	return nil
+}
+
+// outputAddresses returns all addresses the outputs of the resolved config are writing to,
+// including the targets of the tee muxer. Outputs to stdout are not considered.
+func outputAddresses(config *app.Config) []string {
+	addresses := []string{}
+
+	for _, output := range config.Output {
+		addresses = append(addresses, targetAddresses(output)...)
+	}
+
+	return addresses
+}
+
+// targetAddresses returns the addresses of the targets of the output without the
+// options for the tee muxer and the "file:" prefix.
+func targetAddresses(output app.ConfigIO) []string {
+	addresses := []string{}
+
+	for _, address := range outputTargets(output) {
+		address = strings.TrimPrefix(reTeeOptions.ReplaceAllString(address, ""), "file:")
+
+		if len(address) == 0 || address == "-" || strings.HasPrefix(address, "pipe:") {
+			continue
+		}
+
+		addresses = append(addresses, address)
+	}
+
+	return addresses
+}
+
+// ImportConfigs decodes process configs from the data in the format, either "json", "yaml" or "toml".
+// The configs are not added. Every config requires a unique ID and at least one input and
+// one output.
+func (r *restream) ImportConfigs(format string, data []byte) ([]*app.Config, error) {
+	configs, err := app.UnmarshalConfigs(format, data)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := map[string]struct{}{}
+
+	for i, config := range configs {
+		if config == nil {
+			return nil, fmt.Errorf("the config #%d is empty", i)
+		}
+
+		config.ID = strings.TrimSpace(config.ID)
+
+		if len(config.ID) == 0 {
+			return nil, fmt.Errorf("the config #%d has no ID", i)
+		}
+
+		if _, ok := ids[config.ID]; ok {
+			return nil, fmt.Errorf("the ID '%s' is used by more than one config", config.ID)
+		}
+
+		ids[config.ID] = struct{}{}
+
+		if len(config.Input) == 0 {
+			return nil, fmt.Errorf("at least one input must be defined for the process '#%s'", config.ID)
+		}
+
+		if len(config.Output) == 0 {
+			return nil, fmt.Errorf("at least one output must be defined for the process '#%s'", config.ID)
+		}
+	}
+
+	return configs, nil
+}
+
+// ExportConfigs encodes the configs of the processes with the IDs in the format, either
+// "json", "yaml" or "toml". The configs are exported as they have been added, i.e. without
+// resolved placeholders.
+func (r *restream) ExportConfigs(format string, ids []string) ([]byte, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	configs := []*app.Config{}
+
+	for _, id := range ids {
+		t, ok := r.tasks[id]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownProcess, id)
+		}
+
+		configs = append(configs, t.process.Config.Clone())
+	}
+
+	return app.MarshalConfigs(format, configs)
+}
+
+// TryAddProcess adds a new process like AddProcess, but returns ErrBusy if the lock
+// can't be acquired within the timeout.
+func (r *restream) TryAddProcess(config *app.Config, timeout time.Duration) error {
+	return r.tryAddProcessAs(config, timeout, "")
+}
+
+// tryAddProcessAs adds a new process like TryAddProcess. The actor will be recorded in the
+// audit trail.
+func (r *restream) tryAddProcessAs(config *app.Config, timeout time.Duration, actor string) error {
+	deadline := time.Now().Add(timeout)
+
+	lock := func() bool {
+		return r.tryLock(time.Until(deadline))
+	}
+
+	_, err := r.addProcess(config, actor, lock, r.lock.Unlock, lock)
+
+	return err
+}
+
+// tryLock tries to acquire the lock within the timeout and returns whether it has
+// been acquired. If the timeout is exceeded, the lock will be released as soon as it
+// has been acquired in the background.
+func (r *restream) tryLock(timeout time.Duration) bool {
+	acquired := make(chan struct{})
+
+	go func() {
+		r.lock.Lock()
+		close(acquired)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-acquired:
+		return true
+	case <-timer.C:
+		go func() {
+			<-acquired
+			r.lock.Unlock()
+		}()
+
+		return false
+	}
+}
+
+// addTask adds a task that has been created with createTask and starts its process,
+// if it has a "start" order. The caller has to hold the lock.
+func (r *restream) addTask(t *task) error {
+	_, ok := r.tasks[t.id]
+	if ok {
+		r.closePipe(t)
+		return ErrProcessExists
+	}
+
+	if err := r.checkNamedPipes(t, ""); err != nil {
+		r.closePipe(t)
+		return err
+	}
+
+	if err := r.checkOutputCollisions(t, ""); err != nil {
+		r.closePipe(t)
+		return err
+	}
+
+	if err := r.checkPortConflicts(t, ""); err != nil {
+		r.closePipe(t)
+		return err
+	}
+
+	if err := r.createWorkingDir(t); err != nil {
+		r.closePipe(t)
+		return err
+	}
+
+	if err := r.createFIFOs(t); err != nil {
+		r.closePipe(t)
+		return err
+	}
+
+	if err := r.attachPipe(t); err != nil {
+		r.closePipe(t)
+		r.removeFIFOs(t, t.fifos)
+		return err
+	}
+
+	r.tasks[t.id] = t
+	r.markChanged(t.id)
+
+	// set filesystem cleanup rules
+	r.setCleanup(t.id, t.config)
+
+	if t.process.Order == "start" {
+		err := r.startProcess(t.id)
+		if err != nil {
+			r.closePipe(t)
+			r.removeFIFOs(t, t.fifos)
+			delete(r.tasks, t.id)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *restream) createTask(config *app.Config) (*task, error) {
+	return r.createTaskWithMetadata(config, nil)
+}
+
+// createTaskWithMetadata creates a task like createTask, but the placeholders for the
+// metadata will be resolved with the given metadata.
+func (r *restream) createTaskWithMetadata(config *app.Config, metadata map[string]interface{}) (*task, error) {
+	id := strings.TrimSpace(config.ID)
+
+	if len(id) == 0 {
+		return nil, fmt.Errorf("an empty ID is not allowed")
+	}
+
+	ff, err := r.ffmpegFor(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// The legacy filesystem names are only accepted for compatibility
+	migrateCleanup(config)
+
+	config.FFVersion = "^" + ff.Skills().FFmpeg.Version
+	if v, err := semver.NewVersion(config.FFVersion); err == nil {
+		// Remove the patch level for the constraint
+		config.FFVersion = fmt.Sprintf("^%d.%d.0", v.Major(), v.Minor())
+	}
+
+	process := &app.Process{
+		ID:        config.ID,
+		Reference: config.Reference,
+		Config:    config.Clone(),
+		Order:     "stop",
+		CreatedAt: time.Now().Unix(),
+		Schema:    processSchema,
+	}
+
+	process.UpdatedAt = process.CreatedAt
+
+	// A process on demand is only started if it is demanded and a
+	// template-only process is never started
+	if config.Autostart && !config.OnDemand && !config.TemplateOnly {
+		process.Order = "start"
+	}
+
+	t := &task{
+		id:        config.ID,
+		reference: process.Reference,
+		process:   process,
+		config:    process.Config.Clone(),
+		logger:    r.logger.WithField("id", process.ID),
+		logs:      newLogBroadcaster(),
+		states:    newStateBroadcaster(),
+		stdout:    newPipeWriter(),
+		history:   newStateHistory(r.stateHistoryLength),
+		changedAt: time.Now(),
+		metadata:  metadata,
+	}
+
+	r.resolveConfig(t.config, t.metadata)
+
+	t.references = referencedProcesses(t.config)
+
+	producer, err := stdoutProducer(t.config)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.resolveAddresses(r.tasks, t.config)
+	if err != nil {
+		return nil, err
+	}
+
+	t.usesDisk, err = r.validateConfig(t.config)
+	if err != nil {
+		return nil, err
+	}
+
+	t.fifos, err = r.resolveNamedPipes(t.config)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.setPlayoutPorts(t)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.createPipe(t, producer)
+	if err != nil {
+		return nil, err
+	}
+
+	t.command = r.createCommand(t)
+	t.parser = ff.NewProcessParser(t.logger, t.id, t.reference)
+
+	ffmpeg, err := r.createProcess(t)
+	if err != nil {
+		r.closePipe(t)
+		return nil, err
+	}
+
+	t.ffmpeg = ffmpeg
+	t.valid = true
+
+	return t, nil
+}
+
+// createCommand creates the FFmpeg command from the resolved config of the task
+// and applies the command modifier, if any.
+func (r *restream) createCommand(t *task) []string {
+	command := t.config.CreateCommand()
+
+	if r.commandModifier != nil {
+		command = r.commandModifier(t.id, command)
+	}
+
+	return command
+}
+
+// createProcess creates the FFmpeg process for the task based on its resolved
+// config and command.
+func (r *restream) createProcess(t *task) (process.Process, error) {
+	var proc process.Process
+
+	staleAction := t.config.StaleAction
+	reconnect := t.config.Reconnect
+
+	// The callbacks of the process use the config the process has been created from
+	// because the config of the task will be replaced by a reload
+	config := t.config
+
+	t.sources.lock.Lock()
+	t.sources.selected = nil
+	t.sources.command = nil
+	t.sources.live = nil
+	t.sources.lock.Unlock()
+
+	// A restart uses the command of a live switched input
+	onArgs := t.liveArgs
+
+	parser := newLogParser(t.parser, t.logs)
+	if t.config.InitialConnectRetries != 0 || t.config.BreakerFailures != 0 {
+		parser = &connectParser{Parser: parser, task: t}
+	}
+
+	switch t.config.StaleMetric {
+	case "bytes", "either":
+		parser = &staleParser{Parser: parser, metric: t.config.StaleMetric, progress: t.parser.Progress}
+	}
+
+	var stdin io.Reader
+	pipe := t.stdin
+	if pipe != nil {
+		stdin = pipe.reader
+	}
+
+	var stdout io.Writer
+	if writesStdout(t.config) {
+		stdout = t.stdout
+	}
+
+	if hasAlternativeAddresses(t.config) {
+		config := t.config.Clone()
+		onArgs = func([]string) []string {
+			return r.selectSources(t, config)
+		}
+
+		parser = &sourceParser{Parser: parser, task: t}
+	}
+
+	if usesSequence(t.command) {
+		t.seedSequence()
+
+		prev := onArgs
+		onArgs = func(args []string) []string {
+			if prev != nil {
+				args = prev(args)
+			}
+
+			return r.resolveSequence(t, args)
+		}
+	}
+
+	if r.secrets != nil && usesSecrets(t.command) {
+		secrets := newSecrets(r.secrets)
+		parser = &secretParser{Parser: parser, secrets: secrets}
+
+		prev := onArgs
+		onArgs = func(args []string) []string {
+			if prev != nil {
+				args = prev(args)
+			}
+
+			args, err := secrets.Resolve(args)
+			if err != nil {
+				t.logger.Error().WithError(err).Log("")
+			}
+
+			return args
+		}
+	}
+
+	if t.config.LogRateLimit != 0 {
+		parser = newThrottleParser(parser, t.config.LogRateLimit)
+	}
+
+	ff, err := r.ffmpegFor(t.config)
+	if err != nil {
+		return nil, err
+	}
+
+	proc, err = ff.New(ffmpeg.ProcessConfig{
+		Reconnect:      t.config.Reconnect,
+		ReconnectDelay: time.Duration(t.config.ReconnectDelay) * time.Second,
+		StaleTimeout:   time.Duration(t.config.StaleTimeout) * time.Second,
+		StopTimeout:    r.stopTimeout,
+		LimitCPU:       t.config.LimitCPU,
+		LimitMemory:    t.config.LimitMemory,
+		LimitDuration:  time.Duration(t.config.LimitWaitFor) * time.Second,
+		Command:        t.command,
+		Parser:         parser,
+		Logger:         t.logger,
+		OnExit: func() {
+			r.onExit(t, proc)
+		},
+		OnStale: func() {
+			r.onStale(t, proc, staleAction, reconnect)
+		},
+		OnStateChange: func(from, to string) {
+			r.onStateChange(t, config, from, to)
+
+			// The producer must not block while nobody reads from the pipe. The state
+			// changes are reported concurrently, therefore the current state is used.
+			if pipe != nil {
+				pipe.UpdateConsuming(func() bool {
+					return proc.Status().State == "running"
+				})
+			}
+		},
+		OnArgs: onArgs,
+		Stdin:  stdin,
+		Stdout: stdout,
+	})
+
+	return proc, err
+}
+
+// ffmpegFor returns the FFmpeg instance for the binary that is selected in the config.
+func (r *restream) ffmpegFor(config *app.Config) (ffmpeg.FFmpeg, error) {
+	if len(config.FFmpegBinary) == 0 {
+		return r.ffmpeg, nil
+	}
+
+	ff, ok := r.ffmpegs[config.FFmpegBinary]
+	if !ok {
+		return nil, fmt.Errorf("unknown ffmpeg binary '%s' for the process '%s'", config.FFmpegBinary, config.ID)
+	}
+
+	return ff, nil
+}
+
+// hasAlternativeAddresses returns whether any input of the config has alternative addresses.
+func hasAlternativeAddresses(config *app.Config) bool {
+	for _, input := range config.Input {
+		if len(input.Addresses) != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// selectSources selects one of the addresses for each input with alternative addresses
+// according to the source selection of the config and returns the resulting command.
+// It is called before each start of the process. With "failover" the next address is
+// used after a run that never reported any progress.
+func (r *restream) selectSources(t *task, config *app.Config) []string {
+	t.sources.lock.Lock()
+	defer t.sources.lock.Unlock()
+
+	config = config.Clone()
+	selected := map[string]string{}
+
+	if t.sources.counter != 0 && !t.sources.progressed {
+		t.sources.index++
+	}
+
+	t.sources.progressed = false
+
+	for i, input := range config.Input {
+		if len(input.Addresses) == 0 {
+			continue
+		}
+
+		addresses := append([]string{input.Address}, input.Addresses...)
+
+		var index int
+		switch config.SourceSelection {
+		case "random":
+			index = rand.Intn(len(addresses))
+		case "round-robin":
+			index = int(t.sources.counter % uint64(len(addresses)))
+		default:
+			index = t.sources.index % len(addresses)
+		}
+
+		config.Input[i].Address = addresses[index]
+		selected[input.ID] = addresses[index]
+	}
+
+	t.sources.counter++
+	t.sources.selected = selected
+
+	command := config.CreateCommand()
+	if r.commandModifier != nil {
+		command = r.commandModifier(t.id, command)
+	}
+
+	t.sources.command = command
+
+	t.logger.Debug().WithField("addresses", selected).Log("Selected input addresses")
+
+	return command
+}
+
+// resetBreaker closes the circuit breaker of the task and forgets the failures.
+func (t *task) resetBreaker() {
+	t.breaker.lock.Lock()
+	defer t.breaker.lock.Unlock()
+
+	t.breaker.failures = nil
+	t.breaker.open = false
+	t.breaker.started = false
+}
+
+// setLiveCommand sets the command the process will use after a restart.
+func (t *task) setLiveCommand(command []string) {
+	t.sources.lock.Lock()
+	defer t.sources.lock.Unlock()
+
+	t.sources.live = make([]string, len(command))
+	copy(t.sources.live, command)
+}
+
+// liveArgs returns the command of a live switched input, if any, instead of the arguments.
+func (t *task) liveArgs(args []string) []string {
+	t.sources.lock.Lock()
+	defer t.sources.lock.Unlock()
+
+	if t.sources.live == nil {
+		return args
+	}
+
+	command := make([]string, len(t.sources.live))
+	copy(command, t.sources.live)
+
+	return command
+}
+
+// currentCommand returns a copy of the command the process is running with.
+func (t *task) currentCommand() []string {
+	t.sources.lock.Lock()
+	defer t.sources.lock.Unlock()
+
+	command := t.command
+	if t.sources.command != nil {
+		command = t.sources.command
+	}
+
+	c := make([]string, len(command))
+	copy(c, command)
+
+	return c
+}
+
+// onStateChange is called by the process of a task after its state changed. It records
+// the transition and keeps track of since when the process is down. The config is the
+// config the process has been created from.
+func (r *restream) onStateChange(t *task, config *app.Config, from, to string) {
+	t.history.Add(from, to)
+
+	t.down.lock.Lock()
+	defer t.down.lock.Unlock()
+
+	if to == "running" {
+		t.down.since = time.Time{}
+	} else if to == "failed" && t.down.since.IsZero() {
+		t.down.since = time.Now()
+	}
+
+	r.checkBreaker(t, config, to)
+	r.checkInitialConnect(t, config, to)
+
+	if t.states.HasSubscribers() {
+		go r.publishState(t)
+	}
+}
+
+// publishState sends the current state of the process of the task to its subscribers.
+func (r *restream) publishState(t *task) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	// Check whether the task is still the current one
+	if task, ok := r.tasks[t.id]; !ok || task != t || !task.valid {
+		return
+	}
+
+	t.states.Publish(r.processState(t))
+}
+
+// connectParser marks the task as connected as soon as the process reports progress. The
+// current run of the process counts as a successful start for the circuit breaker.
+type connectParser struct {
+	process.Parser
+
+	task *task
+}
+
+func (p *connectParser) Parse(line string) uint64 {
+	n := p.Parser.Parse(line)
+
+	if n != 0 {
+		p.task.connect.lock.Lock()
+		p.task.connect.connected = true
+		p.task.connect.lock.Unlock()
+
+		p.task.breaker.lock.Lock()
+		if !p.task.breaker.started {
+			p.task.breaker.started = true
+			p.task.breaker.failures = nil
+		}
+		p.task.breaker.lock.Unlock()
+	}
+
+	return n
+}
+
+// sourceParser records whether the current run of the process reported any progress
+// for the selection of the input addresses.
+type sourceParser struct {
+	process.Parser
+
+	task *task
+}
+
+func (p *sourceParser) Parse(line string) uint64 {
+	n := p.Parser.Parse(line)
+
+	if n != 0 {
+		p.task.sources.lock.Lock()
+		p.task.sources.progressed = true
+		p.task.sources.lock.Unlock()
+	}
+
+	return n
+}
+
+// staleParser reports progress to the process depending on the stale metric. With "bytes"
+// there's only progress if the number of written bytes of the outputs changed. With "either"
+// it's sufficient that the frames or the written bytes advance.
+type staleParser struct {
+	process.Parser
+
+	metric   string
+	progress func() app.Progress
+	bytes    uint64 // Number of written bytes at the last call
+	lock     sync.Mutex
+}
+
+func (p *staleParser) Parse(line string) uint64 {
+	n := p.Parser.Parse(line)
+
+	progress := p.progress()
+
+	bytes := progress.Size
+	if len(progress.Output) != 0 {
+		bytes = 0
+		for _, output := range progress.Output {
+			bytes += output.Size
+		}
+	}
+
+	p.lock.Lock()
+	advanced := bytes != p.bytes
+	p.bytes = bytes
+	p.lock.Unlock()
+
+	if advanced {
+		return 1
+	}
+
+	if p.metric == "either" {
+		return n
+	}
+
+	return 0
+}
+
+// checkInitialConnect counts the failures of the process of a task as long as it didn't
+// report any progress since it has been started. If the number of the configured retries
+// is reached, the process will be stopped and it remains in the failed state.
+func (r *restream) checkInitialConnect(t *task, config *app.Config, state string) {
+	if config.InitialConnectRetries == 0 || state != "failed" {
+		return
+	}
+
+	t.connect.lock.Lock()
+	defer t.connect.lock.Unlock()
+
+	if t.connect.connected {
+		return
+	}
+
+	t.connect.attempts++
+
+	if t.connect.attempts != config.InitialConnectRetries {
+		return
+	}
+
+	t.logger.Warn().WithField("attempts", t.connect.attempts).Log("Giving up because the process never connected")
+
+	go func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+
+		// Check whether the task is still the current one
+		if task, ok := r.tasks[t.id]; !ok || task != t || task.process.Order != "start" {
+			return
+		}
+
+		r.stopProcess(t.id)
+		r.save()
+	}()
+}
+
+// checkBreaker counts the consecutive failed starts of the process of a task, i.e. the
+// runs that failed without reporting any progress. If the number of failures within the
+// configured window is reached, the process will be disabled, i.e. it will be stopped and
+// not be started again until it is reset.
+func (r *restream) checkBreaker(t *task, config *app.Config, state string) {
+	if config.BreakerFailures == 0 {
+		return
+	}
+
+	t.breaker.lock.Lock()
+	defer t.breaker.lock.Unlock()
+
+	switch state {
+	case "running":
+		t.breaker.started = false
+		return
+	case "finished":
+		t.breaker.failures = nil
+		return
+	}
+
+	if state != "failed" || t.breaker.open || t.breaker.started {
+		return
+	}
+
+	now := time.Now()
+
+	t.breaker.failures = append(t.breaker.failures, now)
+
+	if config.BreakerWindow != 0 {
+		window := now.Add(-time.Duration(config.BreakerWindow) * time.Second)
+
+		for len(t.breaker.failures) != 0 && t.breaker.failures[0].Before(window) {
+			t.breaker.failures = t.breaker.failures[1:]
+		}
+	}
+
+	if uint64(len(t.breaker.failures)) < config.BreakerFailures {
+		return
+	}
+
+	t.breaker.open = true
+
+	t.logger.Warn().WithField("failures", len(t.breaker.failures)).Log("Disabling process because of repeated failures")
+
+	go func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+
+		// Check whether the task is still the current one
+		if task, ok := r.tasks[t.id]; !ok || task != t || task.process.Order != "start" {
+			return
+		}
+
+		r.stopProcess(t.id)
+		t.process.Order = "disabled"
+		r.markChanged(t.id)
+		r.save()
+	}()
+}
+
+// onStale is called by the process of a task right before it will be stopped
+// because it is stale. With the stale action "stop" the process will be stopped and
+// with "restart" it will be restarted, regardless of whether it should reconnect.
+// Without a stale action, the process will only be restarted if it should reconnect.
+func (r *restream) onStale(t *task, proc process.Process, action string, reconnect bool) {
+	t.stale.lock.Lock()
+	t.stale.count++
+	if action == "restart" && !reconnect {
+		t.stale.restart = true
+	}
+	t.stale.lock.Unlock()
+
+	t.logger.Warn().WithField("action", action).Log("Process is stale")
+
+	if action != "stop" {
+		return
+	}
+
+	go func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+
+		// Check whether the task still uses this process
+		if task, ok := r.tasks[t.id]; !ok || task != t || task.ffmpeg != proc {
+			return
+		}
+
+		r.stopProcess(t.id)
+		r.save()
+	}()
+}
+
+// onExit is called by the process of a task after it exited.
+func (r *restream) onExit(t *task, proc process.Process) {
+	t.stale.lock.Lock()
+	restart := t.stale.restart
+	t.stale.restart = false
+	t.stale.lock.Unlock()
+
+	if !restart {
+		return
+	}
+
+	go func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+
+		// Check whether the task still uses this process and should be running
+		if task, ok := r.tasks[t.id]; !ok || task != t || task.ffmpeg != proc || task.process.Order != "start" {
+			return
+		}
+
+		t.logger.Info().Log("Restarting stale process")
+
+		if err := r.stopProcess(t.id); err != nil {
+			t.logger.Error().WithError(err).Log("Failed to restart stale process")
+			return
+		}
+
+		if err := r.startProcess(t.id); err != nil {
+			t.logger.Error().WithError(err).Log("Failed to restart stale process")
+		}
+
+		r.save()
+	}()
+}
+
+func (r *restream) setCleanup(id string, config *app.Config) {
+	for _, c := range r.cleanupPatterns(config) {
+		c.fs.SetCleanup(id, []rfs.Pattern{c.pattern})
+	}
+}
+
+// cleanupPattern is a cleanup rule of a process with the pattern resolved for the filesystem.
+type cleanupPattern struct {
+	fs      rfs.Filesystem
+	pattern rfs.Pattern
+}
+
+// cleanupPatterns returns the cleanup rules of the outputs of the config with the patterns
+// resolved for the filesystems they apply to.
+func (r *restream) cleanupPatterns(config *app.Config) []cleanupPattern {
+	patterns := []cleanupPattern{}
+
+	// A template-only process never writes any files
+	if config.TemplateOnly {
+		return patterns
+	}
+
+	rePrefix := regexp.MustCompile(`^([a-z]+):`)
+
+	for _, output := range config.Output {
+		for _, c := range output.Cleanup {
+			var target rfs.Filesystem
+			var pattern string
+
+			matches := rePrefix.FindStringSubmatch(c.Pattern)
+			if len(c.Filesystem) != 0 {
+				// A rule for a tee target is for the filesystem this target writes to
+				target = r.targetFilesystem(output, c.Filesystem)
+				if target == nil {
+					continue
+				}
+
+				base := strings.TrimSuffix(target.Metadata("base"), "/")
+				pattern = filepath.Join("/", strings.TrimPrefix(c.Pattern, base))
+			} else if matches == nil {
+				// A pattern without a name is for the disk filesystem the output writes to
+				target = r.outputFilesystem(output)
+				if target == nil {
+					continue
+				}
+
+				base := strings.TrimSuffix(target.Metadata("base"), "/")
+				pattern = filepath.Join("/", strings.TrimPrefix(c.Pattern, base))
+			} else {
+				target = r.namedFilesystem(matches[1])
+				if target == nil {
+					continue
+				}
+
+				pattern = rePrefix.ReplaceAllString(c.Pattern, "")
+			}
+
+			patterns = append(patterns, cleanupPattern{
+				fs: target,
+				pattern: rfs.Pattern{
+					Pattern:         scopePattern(pattern, target, config),
+					MaxFiles:        c.MaxFiles,
+					MaxFileAge:      time.Duration(c.MaxFileAge) * time.Second,
+					RetentionWindow: time.Duration(c.RetentionWindow) * time.Second,
+					PurgeOnDelete:   c.PurgeOnDelete,
+					OutputID:        output.ID,
+				},
+			})
+		}
+	}
+
+	return patterns
+}
+
+// outputFilesystem returns the disk filesystem whose base contains a file the output writes
+// to, or nil if the output doesn't write to any disk filesystem.
+func (r *restream) outputFilesystem(output app.ConfigIO) rfs.Filesystem {
+	addresses := targetAddresses(output)
+
+	for _, fs := range r.fs.diskfs {
+		if belowBase(addresses, fs.Metadata("base")) {
+			return fs
+		}
+	}
+
+	return nil
+}
+
+// namedFilesystem returns the filesystem with the name. The legacy names of the cleanup
+// rules have been replaced by migrateCleanup.
+func (r *restream) namedFilesystem(name string) rfs.Filesystem {
+	for _, fs := range r.fs.list {
+		if fs.Name() == name {
+			return fs
+		}
+	}
+
+	return nil
+}
+
+// targetFilesystem returns the filesystem with the name, if any target of the output
+// writes to it.
+func (r *restream) targetFilesystem(output app.ConfigIO, name string) rfs.Filesystem {
+	fs := r.namedFilesystem(name)
+	if fs == nil {
+		return nil
+	}
+
+	if !belowBase(targetAddresses(output), fs.Metadata("base")) {
+		return nil
+	}
+
+	return fs
+}
+
+// scopePattern places the cleanup pattern inside of the working directory of the
+// process, if the filesystem is a disk filesystem.
+func scopePattern(pattern string, fs rfs.Filesystem, config *app.Config) string {
+	dir := workingDir(config)
+
+	if len(dir) == 0 || fs.Type() != "disk" {
+		return pattern
+	}
+
+	if strings.HasPrefix(pattern, dir+"/") {
+		return pattern
+	}
+
+	return filepath.Join(dir, pattern)
+}
+
+func (r *restream) unsetCleanup(id string) {
+	for _, fs := range r.fs.list {
+		fs.UnsetCleanup(id)
+	}
+}
+
+// DryRunCleanup returns the files that would currently be removed by the cleanup rules of
+// the process, grouped by the name of the filesystem. Filesystems without any files to
+// remove are omitted. Nothing will be removed.
+func (r *restream) DryRunCleanup(id string) (map[string][]string, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if _, ok := r.tasks[id]; !ok {
+		return nil, ErrUnknownProcess
+	}
+
+	files := map[string][]string{}
+
+	for _, fs := range r.fs.list {
+		names := fs.DryRunCleanup(id)
+		if len(names) == 0 {
+			continue
+		}
+
+		files[fs.Name()] = names
+	}
+
+	return files, nil
+}
+
+// GetEffectiveCleanup returns the cleanup patterns of the process as they are registered with
+// the filesystems, i.e. after resolving the placeholders and the filesystem names, grouped by
+// the name of the filesystem. Filesystems without any patterns are omitted.
+func (r *restream) GetEffectiveCleanup(id string) (map[string][]rfs.Pattern, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if _, ok := r.tasks[id]; !ok {
+		return nil, ErrUnknownProcess
+	}
+
+	patterns := map[string][]rfs.Pattern{}
+
+	for _, fs := range r.fs.list {
+		p := fs.Cleanup(id)
+		if len(p) == 0 {
+			continue
+		}
+
+		patterns[fs.Name()] = p
+	}
+
+	return patterns, nil
+}
+
+// FindOrphanedFiles returns the sorted names of the files on the filesystem with the name
+// that are not matched by any cleanup pattern of any current process, e.g. files of renamed
+// outputs or of deleted processes. Nothing will be removed.
+func (r *restream) FindOrphanedFiles(fsName string) ([]string, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	filesystem := r.namedFilesystem(fsName)
+	if filesystem == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFilesystem, fsName)
+	}
+
+	matched := map[string]struct{}{}
+
+	// The cleanup rules of the filesystem are only registered while the processes are
+	// started, therefore the patterns are resolved from the configs of the processes
+	for _, t := range r.tasks {
+		for _, c := range r.cleanupPatterns(t.config) {
+			if c.fs != filesystem {
+				continue
+			}
+
+			for _, f := range filesystem.List("/", c.pattern.Pattern) {
+				matched[f.Name()] = struct{}{}
+			}
+		}
+	}
+
+	names := []string{}
+
+	for _, f := range filesystem.List("/", "") {
+		if f.IsDir() {
+			continue
+		}
+
+		if _, ok := matched[f.Name()]; ok {
+			continue
+		}
+
+		names = append(names, f.Name())
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (r *restream) setPlayoutPorts(t *task) error {
+	r.unsetPlayoutPorts(t)
+
+	t.playout = make(map[string]int)
+
+	// A template-only process is never started and doesn't need any ports
+	if t.config.TemplateOnly {
+		return nil
+	}
+
+	for i, input := range t.config.Input {
+		if !strings.HasPrefix(input.Address, "avstream:") && !strings.HasPrefix(input.Address, "playout:") {
+			continue
+		}
+
+		options := []string{}
+		skip := false
+
+		for _, o := range input.Options {
+			if skip {
+				continue
+			}
+
+			if o == "-playout_httpport" {
+				skip = true
+				continue
+			}
+
+			options = append(options, o)
+		}
+
+		if port, err := r.getPlayoutPort(); err == nil {
+			options = append(options, "-playout_httpport", strconv.Itoa(port))
+
+			t.logger.WithFields(log.Fields{
+				"port":  port,
+				"input": input.ID,
+			}).Debug().Log("Assinging playout port")
+
+			t.playout[input.ID] = port
+		} else if err != net.ErrNoPortrangerProvided {
+			r.unsetPlayoutPorts(t)
+			return err
+		}
+
+		input.Options = options
+		t.config.Input[i] = input
+	}
+
+	return nil
+}
+
+func (r *restream) unsetPlayoutPorts(t *task) {
+	if t.playout == nil {
+		return
+	}
+
+	for _, port := range t.playout {
+		r.putPlayoutPort(port)
+	}
+
+	t.playout = nil
+}
+
+// getPlayoutPort returns a new port for a playout, if the max. number of
+// assigned playout ports is not yet reached.
+func (r *restream) getPlayoutPort() (int, error) {
+	r.playoutPorts.lock.Lock()
+	defer r.playoutPorts.lock.Unlock()
+
+	if r.playoutPorts.max > 0 && len(r.playoutPorts.ports) >= r.playoutPorts.max {
+		return 0, fmt.Errorf("max. number of playout ports (%d) reached", r.playoutPorts.max)
+	}
+
+	port, err := r.ffmpeg.GetPort()
+	if err != nil {
+		return 0, err
+	}
+
+	r.playoutPorts.ports[port] = struct{}{}
+
+	return port, nil
+}
+
+func (r *restream) putPlayoutPort(port int) {
+	r.playoutPorts.lock.Lock()
+	defer r.playoutPorts.lock.Unlock()
+
+	r.ffmpeg.PutPort(port)
+
+	delete(r.playoutPorts.ports, port)
+}
+
+func (r *restream) validateConfig(config *app.Config) (bool, error) {
+	if len(config.Input) == 0 {
+		return false, newValidationError(ErrMissingIO, config.ID, "", "", "input", "at least one input must be defined for the process '%s'", config.ID)
+	}
+
+	names := []string{}
+	for name := range config.Vars {
+		if !reVarName.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) != 0 {
+		sort.Strings(names)
+		return false, newValidationError(ErrInvalidValue, config.ID, "", "", "vars", "the names of the variables %s of the process '%s' are invalid, only lowercase letters are allowed", strings.Join(names, ", "), config.ID)
+	}
+
+	switch config.StaleAction {
+	case "", "restart", "stop":
+	default:
+		return false, newValidationError(ErrInvalidValue, config.ID, "", "", "stale_action", "unknown stale action '%s' for the process '%s'", config.StaleAction, config.ID)
+	}
+
+	switch config.StaleMetric {
+	case "", "frames", "bytes", "either":
+	default:
+		return false, newValidationError(ErrInvalidValue, config.ID, "", "", "stale_metric", "unknown stale metric '%s' for the process '%s'", config.StaleMetric, config.ID)
+	}
+
+	switch config.SourceSelection {
+	case "", "failover", "round-robin", "random":
+	default:
+		return false, newValidationError(ErrInvalidValue, config.ID, "", "", "source_selection", "unknown source selection '%s' for the process '%s'", config.SourceSelection, config.ID)
+	}
+
+	switch config.ReferenceBehavior {
+	case "", "ignore", "block-start", "start-dependency":
+	default:
+		return false, newValidationError(ErrInvalidValue, config.ID, "", "", "reference_behavior", "unknown reference behavior '%s' for the process '%s'", config.ReferenceBehavior, config.ID)
+	}
+
+	if config.ReadAtNativeRate {
+		ff, err := r.ffmpegFor(config)
+		if err != nil {
+			return false, err
+		}
+
+		// The -readrate option is available since FFmpeg 5.0
+		readrate, _ := semver.NewConstraint(">= 5.0")
+		if v, err := semver.NewVersion(ff.Skills().FFmpeg.Version); err != nil || !readrate.Check(v) {
+			return false, newValidationError(ErrInvalidValue, config.ID, "", "", "read_at_native_rate", "reading the inputs at the native rate requires at least FFmpeg 5.0 for the process '%s'", config.ID)
+		}
+	}
+
+	if r.strictVars {
+		if names := unresolvedVars(config); len(names) != 0 {
+			return false, newValidationError(ErrUnresolvedPlaceholder, config.ID, "", "", "vars", "unknown variables for the process '%s': %s", config.ID, strings.Join(names, ", "))
+		}
+	}
+
+	if keys := unsupportedMetadata(config); len(keys) != 0 {
+		return false, newValidationError(ErrInvalidValue, config.ID, "", "", "metadata", "the metadata keys %s of the process '%s' can't be used as placeholders, only lowercase letters are allowed", strings.Join(keys, ", "), config.ID)
+	}
+
+	if keys := unresolvedMetadata(config); len(keys) != 0 {
+		return false, newValidationError(ErrUnresolvedPlaceholder, config.ID, "", "", "metadata", "unknown metadata keys for the process '%s': %s", config.ID, strings.Join(keys, ", "))
+	}
+
+	if r.secrets == nil && usesSecrets(config.CreateCommand()) {
+		return false, newValidationError(ErrUnresolvedPlaceholder, config.ID, "", "", "", "the process '%s' uses secrets, but no secrets provider is available", config.ID)
+	}
+
+	if r.requireSkills {
+		if err := r.checkSkills(config); err != nil {
+			return false, err
+		}
+	}
+
+	var err error
+
+	ids := map[string]bool{}
+	inputs := []app.ConfigIO{}
+
+	for i, io := range config.Input {
+		io.ID = strings.TrimSpace(io.ID)
+
+		if len(io.ID) == 0 {
+			return false, newValidationError(ErrEmptyIOID, config.ID, "input", "", "id", "empty input IDs are not allowed (process '%s')", config.ID)
+		}
+
+		if _, found := ids[io.ID]; found {
+			return false, newValidationError(ErrDuplicateIOID, config.ID, "input", io.ID, "id", "the input ID '%s' is already in use for the process `%s`", io.ID, config.ID)
+		}
+
+		ids[io.ID] = true
+
+		if err := r.validateInputAddresses(config, io); err != nil {
+			if !io.Optional {
+				return false, err
+			}
+
+			r.logger.Warn().WithField("id", config.ID).WithError(err).Log("Dropping optional input")
+			continue
+		}
+
+		inputs = append(inputs, config.Input[i])
+	}
+
+	if len(inputs) == 0 {
+		return false, newValidationError(ErrMissingIO, config.ID, "", "", "input", "at least one valid input must be defined for the process '%s'", config.ID)
+	}
+
+	config.Input = inputs
+
+	if len(config.Output) == 0 {
+		return false, newValidationError(ErrMissingIO, config.ID, "", "", "output", "at least one output must be defined for the process '#%s'", config.ID)
+	}
+
+	ids = map[string]bool{}
+	hasFiles := false
+
+	workingDir := workingDir(config)
+
+	for _, io := range config.Output {
+		io.ID = strings.TrimSpace(io.ID)
+
+		if len(io.ID) == 0 {
+			return false, newValidationError(ErrEmptyIOID, config.ID, "output", "", "id", "empty output IDs are not allowed (process '%s')", config.ID)
+		}
+
+		if _, found := ids[io.ID]; found {
+			return false, newValidationError(ErrDuplicateIOID, config.ID, "output", io.ID, "id", "the output ID '%s' is already in use for the process `%s`", io.ID, config.ID)
+		}
+
+		ids[io.ID] = true
+
+		io.Address = strings.TrimSpace(io.Address)
+
+		if len(io.Address) == 0 {
+			return false, newValidationError(ErrOutputAddressEmpty, config.ID, "output", io.ID, "address", "the address for output '#%s:%s' must not be empty", config.ID, io.ID)
+		}
+
+		switch io.OutputType {
+		case "", "auto", "tee", "single":
+		default:
+			return false, newValidationError(ErrInvalidValue, config.ID, "output", io.ID, "output_type", "the output type '%s' of the output '#%s:%s' is invalid, must be 'auto', 'tee', or 'single'", io.OutputType, config.ID, io.ID)
+		}
+
+		isFile := false
+		io.Address, isFile, err = r.checkOutputAddress(io.Address, io.OutputType, workingDir)
+		if err != nil {
+			return false, newValidationError(addressErrorKind(err), config.ID, "output", io.ID, "address", "the address for output '#%s:%s' is invalid: %w", config.ID, io.ID, err)
+		}
+
+		if isFile {
+			hasFiles = true
+		}
+
+		for _, c := range io.Cleanup {
+			if len(c.Filesystem) != 0 && r.targetFilesystem(io, c.Filesystem) == nil {
+				return false, newValidationError(ErrInvalidValue, config.ID, "output", io.ID, "cleanup", "no target of the output '#%s:%s' writes to the filesystem '%s' of the cleanup rule", config.ID, io.ID, c.Filesystem)
+			}
+		}
+	}
+
+	return hasFiles, nil
+}
+
+// checkOutputAddress validates the output address like validateOutputAddress relative to
+// the working directory on the disk filesystems. The address is valid if it is valid for
+// any of the disk filesystems.
+func (r *restream) checkOutputAddress(address, outputType, workingDir string) (string, bool, error) {
+	if len(r.fs.diskfs) == 0 {
+		basedir := "/"
+		if len(workingDir) != 0 {
+			basedir = workingDir + "/"
+		}
+
+		return r.validateOutputAddress(address, outputType, basedir)
+	}
+
+	hasFiles := false
+	maxFails := 0
+
+	var err error
+
+	for _, fs := range r.fs.diskfs {
+		basedir := fs.Metadata("base")
+		if len(workingDir) != 0 {
+			basedir = filepath.Join(basedir, workingDir) + "/"
+		}
+
+		isFile := false
+		address, isFile, err = r.validateOutputAddress(address, outputType, basedir)
+		if err != nil {
+			maxFails++
+		}
+
+		if isFile {
+			hasFiles = true
+		}
+	}
+
+	if maxFails == len(r.fs.diskfs) {
+		return address, false, err
+	}
+
+	return address, hasFiles, nil
+}
+
+// isRelativeFileAddress returns whether the output address is a file with a relative path.
+func isRelativeFileAddress(address, outputType string) bool {
+	if isTeeAddress(address, outputType) {
+		return false
+	}
+
+	address = strings.TrimPrefix(address, "file:")
+
+	if url.HasScheme(address) || address == "-" || strings.HasPrefix(address, "pipe:") {
+		return false
+	}
+
+	return !filepath.IsAbs(address)
+}
+
+// placeOutputs places the relative file outputs of the config on the filesystem that the
+// filesystem selection policy selects by rewriting their addresses to absolute paths. It is
+// called when a process is added or updated, such that the choice is stored with the process.
+func (r *restream) placeOutputs(config *app.Config) {
+	if len(r.fs.diskfs) == 0 || r.fsSelection.policy == "first-match" {
+		return
+	}
+
+	for i, io := range config.Output {
+		address := strings.TrimSpace(io.Address)
+		if len(address) == 0 || !isRelativeFileAddress(address, io.OutputType) {
+			continue
+		}
+
+		fs := r.selectFilesystem()
+
+		config.Output[i].Address = filepath.Join(fs.Metadata("base"), workingDir(config), strings.TrimPrefix(address, "file:"))
+	}
+}
+
+// selectFilesystem returns the disk filesystem for a relative file output according to
+// the filesystem selection policy.
+func (r *restream) selectFilesystem() rfs.Filesystem {
+	r.fsSelection.lock.Lock()
+	defer r.fsSelection.lock.Unlock()
+
+	if r.fsSelection.policy == "round-robin" {
+		fs := r.fs.diskfs[r.fsSelection.counter%uint64(len(r.fs.diskfs))]
+		r.fsSelection.counter++
+
+		return fs
+	}
+
+	// Compare the consumed space relative to the capacity only if all filesystems have a capacity
+	relative := true
+	for _, fs := range r.fs.diskfs {
+		if _, limit := fs.Size(); limit <= 0 {
+			relative = false
+			break
+		}
+	}
+
+	var selected rfs.Filesystem
+	fullness := 0.0
+
+	for _, fs := range r.fs.diskfs {
+		size, limit := fs.Size()
+
+		f := float64(size)
+		if relative {
+			f /= float64(limit)
+		}
+
+		if selected == nil || f < fullness {
+			selected = fs
+			fullness = f
+		}
+	}
+
+	return selected
+}
+
+// createWorkingDir creates the working directory of the process on every disk filesystem
+// the process writes files to.
+func (r *restream) createWorkingDir(t *task) error {
+	dir := workingDir(t.config)
+	if len(dir) == 0 {
+		return nil
+	}
+
+	for _, fs := range r.fs.diskfs {
+		base := filepath.Join(fs.Metadata("base"), dir)
+
+		for _, output := range t.config.Output {
+			if !belowBase(targetAddresses(output), base) {
+				continue
+			}
+
+			if err := fs.MkdirAll(dir, 0755); err != nil {
+				return fmt.Errorf("failed to create the working directory '%s' for the process '%s': %w", dir, t.id, err)
+			}
+
+			break
+		}
+	}
+
+	return nil
+}
+
+// workingDir returns the cleaned working directory of the config as absolute path, or an
+// empty string if the process doesn't have a working directory.
+func workingDir(config *app.Config) string {
+	if len(config.WorkingDir) == 0 {
+		return ""
+	}
+
+	dir := filepath.Join("/", config.WorkingDir)
+	if dir == "/" {
+		return ""
+	}
+
+	return dir
+}
+
+// reVar matches the placeholders for process variables, e.g. {vars:region}
+var reVar = regexp.MustCompile(`{vars:([a-z:]+)(?:\^.)?(?:,.*?)?}`)
+
+// reVarName matches the names of the variables the replacer supports
+var reVarName = regexp.MustCompile(`^[a-z]+$`)
+
+// reMetadata matches the placeholders for metadata keys, e.g. {metadata:title}. Like for the
+// variables, the replacer only supports keys with lowercase letters.
+var reMetadata = regexp.MustCompile(`{metadata:([a-z:]+)(?:\^.)?(?:,.*?)?}`)
+
+// reMetadataAny matches the placeholders for any metadata key, including unsupported keys
+var reMetadataAny = regexp.MustCompile(`{metadata:([^{}^,]+)(?:\^.)?(?:,.*?)?}`)
+
+// unresolvedVars returns the sorted names of the variables that are still
+// present in the resolved config.
+func unresolvedVars(config *app.Config) []string {
+	return placeholderNames(config, reVar)
+}
+
+// unresolvedMetadata returns the sorted keys of the metadata placeholders that are
+// still present in the resolved config. In an unresolved config these are all
+// metadata keys the config depends on.
+func unresolvedMetadata(config *app.Config) []string {
+	return placeholderNames(config, reMetadata)
+}
+
+// unsupportedMetadata returns the sorted keys of the metadata placeholders in the config
+// that the replacer doesn't support.
+func unsupportedMetadata(config *app.Config) []string {
+	supported := map[string]struct{}{}
+	for _, key := range placeholderNames(config, reMetadata) {
+		supported[key] = struct{}{}
+	}
+
+	keys := []string{}
+	for _, key := range placeholderNames(config, reMetadataAny) {
+		if _, ok := supported[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// placeholderNames returns the sorted names of the placeholders in the config that
+// are matched by the first group of the regular expression.
+func placeholderNames(config *app.Config, re *regexp.Regexp) []string {
+	names := map[string]struct{}{}
+
+	find := func(str string) {
+		for _, matches := range re.FindAllStringSubmatch(str, -1) {
+			names[matches[1]] = struct{}{}
+		}
+	}
+
+	for _, option := range config.Options {
+		find(option)
+	}
+
+	for _, ios := range [][]app.ConfigIO{config.Input, config.Output} {
+		for _, io := range ios {
+			find(io.Address)
+
+			for _, address := range io.Addresses {
+				find(address)
+			}
+
+			for _, option := range io.Options {
+				find(option)
+			}
+
+			for _, cleanup := range io.Cleanup {
+				find(cleanup.Pattern)
+			}
+		}
+	}
+
+	list := []string{}
+	for name := range names {
+		list = append(list, name)
+	}
+
+	sort.Strings(list)
+
+	return list
+}
+
+// validateInputAddresses validates the address and the alternative addresses of an input.
+func (r *restream) validateInputAddresses(config *app.Config, io app.ConfigIO) error {
+	var err error
+
+	// The alternative addresses are validated the same way as the address
+	for _, address := range append([]string{io.Address}, io.Addresses...) {
+		address = strings.TrimSpace(address)
+
+		if len(address) == 0 {
+			return newValidationError(ErrInputAddressEmpty, config.ID, "input", io.ID, "address", "the address for input '#%s:%s' must not be empty", config.ID, io.ID)
+		}
+
+		if len(r.fs.diskfs) != 0 {
+			maxFails := 0
+			for _, fs := range r.fs.diskfs {
+				address, err = r.validateInputAddress(address, fs.Metadata("base"))
+				if err != nil {
+					maxFails++
+				}
+			}
+
+			if maxFails == len(r.fs.diskfs) {
+				return newValidationError(ErrInvalidAddress, config.ID, "input", io.ID, "address", "the address for input '#%s:%s' (%s) is invalid: %w", config.ID, io.ID, address, err)
+			}
+		} else {
+			address, err = r.validateInputAddress(address, "/")
+			if err != nil {
+				return newValidationError(ErrInvalidAddress, config.ID, "input", io.ID, "address", "the address for input '#%s:%s' (%s) is invalid: %w", config.ID, io.ID, address, err)
+			}
+		}
+
+		if io.Reconnect && !supportsReconnect(address) {
+			return newValidationError(ErrInvalidValue, config.ID, "input", io.ID, "reconnect", "reconnecting is only supported for HTTP addresses, the input '#%s:%s' reads from %s", config.ID, io.ID, address)
+		}
+	}
+
+	return nil
+}
+
+// supportsReconnect returns whether FFmpeg is able to reconnect to the input address.
+func supportsReconnect(address string) bool {
+	address = strings.ToLower(address)
+
+	return strings.HasPrefix(address, "http://") || strings.HasPrefix(address, "https://")
+}
+
+// IsAddressAllowed validates the address the same way as the address of an input or an
+// output of a process. It returns whether the address is allowed, the name of the
+// filesystem the address points to, if any, and the reason why it is not allowed.
+func (r *restream) IsAddressAllowed(address string, isOutput bool) (bool, string, error) {
+	address = strings.TrimSpace(address)
+
+	if len(address) == 0 {
+		return false, "", fmt.Errorf("the address must not be empty")
+	}
+
+	validate := func(basedir string) ([]string, error) {
+		if !isOutput {
+			address, err := r.validateInputAddress(address, basedir)
+			return []string{strings.TrimPrefix(address, "file:")}, err
+		}
+
+		address, _, err := r.validateOutputAddress(address, "auto", basedir)
+
+		return targetAddresses(app.ConfigIO{Address: address}), err
+	}
+
+	basedirs := []string{"/"}
+
+	if len(r.fs.diskfs) != 0 {
+		basedirs = []string{}
+		for _, fs := range r.fs.diskfs {
+			basedirs = append(basedirs, fs.Metadata("base"))
+		}
+	}
+
+	var err error
+
+	for _, basedir := range basedirs {
+		var addresses []string
+
+		addresses, err = validate(basedir)
+		if err != nil {
+			continue
+		}
+
+		for _, fs := range r.fs.list {
+			if belowBase(addresses, fs.Metadata("base")) {
+				return true, fs.Name(), nil
+			}
+		}
+
+		return true, "", nil
+	}
+
+	return false, "", err
+}
+
+// Validate checks the configs of all processes again, e.g. after an upgrade of FFmpeg or a
+// change of the filesystems. It returns the list of problems for every process that has
+// problems. The processes are not changed.
+func (r *restream) Validate() map[string][]string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	problems := map[string][]string{}
+
+	for id, t := range r.tasks {
+		if p := r.validateTask(t); len(p) != 0 {
+			problems[id] = p
+		}
+	}
+
+	return problems
+}
+
+// validateTask validates a copy of the config of the task the same way as the config of a
+// new process and returns the problems.
+func (r *restream) validateTask(t *task) []string {
+	problems := []string{}
+
+	config := t.process.Config.Clone()
+
+	r.resolveConfig(config, t.metadata)
+
+	if err := r.checkFFVersion(config); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if _, err := stdoutProducer(config); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	// The addresses can't be validated if the references can't be resolved
+	if err := r.resolveAddresses(r.tasks, config); err != nil {
+		return append(problems, err.Error())
+	}
+
+	if _, err := r.validateConfig(config); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if _, err := r.resolveNamedPipes(config); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	return problems
+}
+
+// checkFFVersion returns an error if the version of the FFmpeg binary for the config doesn't
+// fit the FFmpeg version constraint of the config.
+func (r *restream) checkFFVersion(config *app.Config) error {
+	ff, err := r.ffmpegFor(config)
+	if err != nil {
+		return err
+	}
+
+	if len(config.FFVersion) == 0 {
+		return nil
+	}
+
+	c, err := semver.NewConstraint(config.FFVersion)
+	if err != nil {
+		return fmt.Errorf("invalid FFmpeg version constraint '%s': %w", config.FFVersion, err)
+	}
+
+	version := ff.Skills().FFmpeg.Version
+
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return fmt.Errorf("invalid FFmpeg version '%s': %w", version, err)
+	}
+
+	if !c.Check(v) {
+		return fmt.Errorf("the available FFmpeg version %s doesn't fit the constraint '%s'", version, config.FFVersion)
+	}
+
+	return nil
+}
+
+func (r *restream) validateInputAddress(address, basedir string) (string, error) {
+	if ok := url.HasScheme(address); ok {
+		if err := url.Validate(address); err != nil {
+			return address, err
+		}
+	}
+
+	if !r.ffmpeg.ValidateInputAddress(address) {
+		return address, fmt.Errorf("address is not allowed")
+	}
+
+	return address, nil
+}
+
+// outputFiles returns the absolute paths of all files the outputs of the resolved config
+// are writing to.
+func outputFiles(config *app.Config) []string {
+	files := []string{}
+
+	for _, output := range config.Output {
+		for _, address := range outputTargets(output) {
+			address = reTeeOptions.ReplaceAllString(address, "")
+			address = strings.TrimPrefix(address, "file:")
+
+			if len(address) == 0 || address == "-" || strings.HasPrefix(address, "pipe:") || url.HasScheme(address) {
+				continue
+			}
+
+			path, err := filepath.Abs(address)
+			if err != nil || strings.HasPrefix(path, "/dev/") {
+				continue
+			}
+
+			files = append(files, path)
+		}
+	}
+
+	return files
+}
+
+// checkOutputCollisions checks whether the task writes to the same files as any other
+// process, except the process with the excluded ID. Depending on the configuration only
+// a warning will be logged.
+func (r *restream) checkOutputCollisions(t *task, exclude string) error {
+	files := outputFiles(t.config)
+	if len(files) == 0 {
+		return nil
+	}
+
+	for id, other := range r.tasks {
+		if id == exclude || id == t.id || !other.valid {
+			continue
+		}
+
+		for _, file := range outputFiles(other.config) {
+			for _, f := range files {
+				if f != file {
+					continue
+				}
+
+				err := fmt.Errorf("%w: the file '%s' is already written by the process '%s'", ErrOutputCollision, file, id)
+
+				if !r.warnOnCollision {
+					return err
+				}
+
+				t.logger.Warn().WithError(err).Log("")
+			}
+		}
+	}
+
+	return nil
+}
+
+// ValidationError describes why the config of a process is invalid. The category of the
+// failure can be checked with errors.Is, e.g. errors.Is(err, ErrDuplicateIOID).
+type ValidationError struct {
+	Kind      error  // Category of the failure, one of the ErrXXX errors
+	ProcessID string // ID of the process
+	IOType    string // "input" or "output", empty if the failure is not about an input or output
+	IOID      string // ID of the input or output, if known
+	Field     string // JSON name of the invalid field of the config or of the input or output
+	Message   string
+	Err       error // Cause of the failure, if any
+}
+
+// newValidationError returns a ValidationError with the formatted message. An error that is
+// wrapped in the message with %w is the cause of the failure.
+func newValidationError(kind error, processID, ioType, ioID, field, format string, args ...interface{}) *ValidationError {
+	err := fmt.Errorf(format, args...)
+
+	return &ValidationError{
+		Kind:      kind,
+		ProcessID: processID,
+		IOType:    ioType,
+		IOID:      ioID,
+		Field:     field,
+		Message:   err.Error(),
+		Err:       errors.Unwrap(err),
+	}
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return e.Kind == target
+}
+
+// addressErrorKind returns the category of the error of an invalid output address.
+func addressErrorKind(err error) error {
+	if errors.Is(err, ErrAddressOutsideBase) {
+		return ErrAddressOutsideBase
+	}
+
+	return ErrInvalidAddress
+}
+
+// outsideBaseError is returned if the path of an output is not inside of the base directory.
+type outsideBaseError struct {
+	path    string
+	basedir string
+}
+
+func (e *outsideBaseError) Error() string {
+	return fmt.Sprintf("%s is not inside of %s", e.path, e.basedir)
+}
+
+func (e *outsideBaseError) Is(target error) bool {
+	return target == ErrAddressOutsideBase
+}
+
+// TeeTargetError describes why a single target of a tee muxer address is invalid.
+type TeeTargetError struct {
+	Index   int    // Index of the target in the tee muxer address, starting at 0
+	Address string // Address of the target without the tee options
+	Err     error
+}
+
+// TeeError is returned if one or more targets of a tee muxer address are invalid.
+type TeeError struct {
+	Targets []TeeTargetError
+}
+
+func (e *TeeError) Error() string {
+	errs := make([]string, len(e.Targets))
+	for i, t := range e.Targets {
+		errs[i] = fmt.Sprintf("target %d (%s): %s", t.Index, t.Address, t.Err)
+	}
+
+	return "invalid tee targets: " + strings.Join(errs, "; ")
+}
+
+// isTeeAddress returns whether the address is an address for the tee muxer. With the output
+// type "auto", an address that contains a "|" or starts with a "[" is assumed to be one.
+func isTeeAddress(address, outputType string) bool {
+	switch outputType {
+	case "tee":
+		return true
+	case "single":
+		return false
+	}
+
+	return strings.Contains(address, "|") || strings.HasPrefix(address, "[")
+}
+
+// reTeeOptions matches the options of a target of the tee muxer, e.g. "[f=flv:onfail=ignore]"
+var reTeeOptions = regexp.MustCompile(`^\[[^\]]*\]`)
+
+// outputTargets returns the addresses of all targets of the output.
+func outputTargets(output app.ConfigIO) []string {
+	if !isTeeAddress(output.Address, output.OutputType) {
+		return []string{output.Address}
+	}
+
+	return strings.Split(output.Address, "|")
+}
+
+func (r *restream) validateOutputAddress(address, outputType, basedir string) (string, bool, error) {
+	if isTeeAddress(address, outputType) {
+		addresses := strings.Split(address, "|")
+
+		isFile := false
+
+		teeErr := &TeeError{}
+
+		for i, a := range addresses {
+			options := reTeeOptions.FindString(a)
+			a = reTeeOptions.ReplaceAllString(a, "")
+
+			va, file, err := r.validateOutputAddress(a, "single", basedir)
+			if err != nil {
+				teeErr.Targets = append(teeErr.Targets, TeeTargetError{
+					Index:   i,
+					Address: a,
+					Err:     err,
+				})
+				continue
+			}
+
+			if file {
+				isFile = true
+			}
+
+			addresses[i] = options + va
+		}
+
+		if len(teeErr.Targets) != 0 {
+			return address, false, teeErr
+		}
+
+		return strings.Join(addresses, "|"), isFile, nil
+	}
+
+	address = strings.TrimPrefix(address, "file:")
+
+	if ok := url.HasScheme(address); ok {
+		if err := url.Validate(address); err != nil {
+			return address, false, err
+		}
+
+		if !r.ffmpeg.ValidateOutputAddress(address) {
+			return address, false, fmt.Errorf("address is not allowed")
+		}
+
+		return address, false, nil
+	}
+
+	if address == "-" {
+		return "pipe:", false, nil
+	}
+
+	// Named pipes will be resolved to their FIFO
+	if reNamedPipe.MatchString(address) {
+		return address, false, nil
+	}
+
+	address, err := filepath.Abs(address)
+	if err != nil {
+		return address, false, fmt.Errorf("not a valid path (%w)", err)
+	}
+
+	if strings.HasPrefix(address, "/dev/") {
+		if !r.ffmpeg.ValidateOutputAddress("file:" + address) {
+			return address, false, fmt.Errorf("address is not allowed")
+		}
+
+		return "file:" + address, false, nil
+	}
+
+	if !strings.HasPrefix(address, basedir) {
+		return address, false, &outsideBaseError{path: address, basedir: basedir}
+	}
+
+	// The sequence placeholders are resolved on every start and must not leave the base directory
+	if resolved, err := filepath.Abs(replaceSequence(address, 0)); err != nil || !strings.HasPrefix(resolved, basedir) {
+		return address, false, &outsideBaseError{path: replaceSequence(address, 0), basedir: basedir}
+	}
+
+	if !r.ffmpeg.ValidateOutputAddress("file:" + address) {
+		return address, false, fmt.Errorf("address is not allowed")
+	}
+
+	return "file:" + address, true, nil
+}
+
+func (r *restream) resolveAddresses(tasks map[string]*task, config *app.Config) error {
+	for i, input := range config.Input {
+		// Resolve any references
+		address, err := r.resolveAddress(tasks, config.ID, input.Address)
+		if err != nil {
+			return fmt.Errorf("reference error for '#%s:%s': %w", config.ID, input.ID, err)
+		}
+
+		input.Address = address
+
+		for j, address := range input.Addresses {
+			address, err := r.resolveAddress(tasks, config.ID, address)
+			if err != nil {
+				return fmt.Errorf("reference error for '#%s:%s': %w", config.ID, input.ID, err)
+			}
+
+			input.Addresses[j] = address
+		}
+
+		config.Input[i] = input
+	}
+
+	return nil
+}
+
+var reReference = regexp.MustCompile(`^#(.+):output=(.+)`)
+
+// referencedProcesses returns the IDs of all processes that are referenced
+// by the inputs of the config.
+func referencedProcesses(config *app.Config) []string {
+	ids := []string{}
+
+	for _, input := range config.Input {
+		for _, address := range append([]string{input.Address}, input.Addresses...) {
+			matches := reReference.FindStringSubmatch(address)
+			if matches == nil {
+				matches = reStdoutReference.FindStringSubmatch(address)
+				if matches == nil {
+					continue
+				}
+			}
+
+			ids = append(ids, matches[1])
+		}
+	}
+
+	return ids
+}
+
+func (r *restream) resolveAddress(tasks map[string]*task, id, address string) (string, error) {
+	if len(address) == 0 {
+		return address, fmt.Errorf("empty address")
+	}
+
+	if address[0] != '#' {
+		return address, nil
+	}
+
+	if matches := reStdoutReference.FindStringSubmatch(address); matches != nil {
+		if err := r.checkReferencePolicy(id, matches[1], address); err != nil {
+			return address, err
+		}
+
+		return resolveStdoutAddress(tasks, id, address)
+	}
+
+	matches := reReference.FindStringSubmatch(address)
+	if matches == nil {
+		return address, fmt.Errorf("invalid format (%s)", address)
+	}
+
+	if matches[1] == id {
+		return address, fmt.Errorf("self-reference not possible (%s)", address)
+	}
+
+	if err := r.checkReferencePolicy(id, matches[1], address); err != nil {
+		return address, err
+	}
+
+	task, ok := tasks[matches[1]]
+	if !ok {
+		return address, fmt.Errorf("unknown process '%s' (%s)", matches[1], address)
+	}
+
+	for _, x := range task.config.Output {
+		if x.ID == matches[2] {
+			return x.Address, nil
+		}
+	}
+
+	return address, fmt.Errorf("the process '%s' has no outputs with the ID '%s' (%s)", matches[1], matches[2], address)
+}
+
+// checkReferencePolicy returns ErrReferenceNotAllowed if the reference policy doesn't allow
+// the process with the ID from to reference the process with the ID to.
+func (r *restream) checkReferencePolicy(from, to, address string) error {
+	if r.referencePolicy == nil || from == to {
+		return nil
+	}
+
+	if !r.referencePolicy(from, to) {
+		return fmt.Errorf("%w: the process '%s' is not allowed to reference the process '%s' (%s)", ErrReferenceNotAllowed, from, to, address)
+	}
+
+	return nil
+}
+
+func (r *restream) UpdateProcess(id string, config *app.Config) error {
+	return r.updateProcessAs(id, config, "")
+}
+
+// updateProcessAs updates the process like UpdateProcess. The actor will be
+// recorded in the audit trail.
+func (r *restream) updateProcessAs(id string, config *app.Config, actor string) error {
+	if err := r.beginOperation(id); err != nil {
+		return err
+	}
+	defer r.endOperation(id)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	replaced := r.tasks[id]
+
+	if err := r.updateProcess(id, config); err != nil {
+		return err
+	}
+
+	r.audit(r.tasks[config.ID], "update", actor)
+
+	if err := r.commit(); err != nil {
+		r.revertUpdate(config.ID, replaced)
+		return err
+	}
+
+	return nil
+}
+
+// updateProcess replaces the process with a new process based on the config. The
+// new process keeps the order of the replaced process. The caller has to hold the lock.
+func (r *restream) updateProcess(id string, config *app.Config) error {
+	task, ok := r.tasks[id]
+	if !ok {
+		return ErrUnknownProcess
+	}
+
+	r.placeOutputs(config)
+
+	// Keep the metadata of the process
+	t, err := r.createTaskWithMetadata(config, task.metadata)
+	if err != nil {
+		return err
+	}
+
+	// This would require a major version jump
+	//t.process.CreatedAt = task.process.CreatedAt
+	t.process.UpdatedAt = time.Now().Unix()
+	t.process.Order = task.process.Order
+
+	if id != t.id {
+		_, ok := r.tasks[t.id]
+		if ok {
+			r.closePipe(t)
+			return ErrProcessExists
+		}
+	}
+
+	if err := r.checkNamedPipes(t, id); err != nil {
+		r.closePipe(t)
+		return err
+	}
+
+	if err := r.checkOutputCollisions(t, id); err != nil {
+		r.closePipe(t)
+		return err
+	}
+
+	if err := r.checkPortConflicts(t, id); err != nil {
+		r.closePipe(t)
+		return err
+	}
+
+	if err := r.createWorkingDir(t); err != nil {
+		r.closePipe(t)
+		return err
+	}
+
+	if err := r.stopProcess(id); err != nil {
+		r.closePipe(t)
+		return err
+	}
+
+	// Keep the log history, including the log of the last run of the replaced process
+	task.parser.ResetLog()
+	task.parser.TransferReportHistory(t.parser)
+
+	t.annotations = task.annotations
+	t.annotate("update")
+
+	// Keep the log subscribers of the process
+	task.logs.TransferTo(t.logs)
+	task.states.TransferTo(t.states)
+
+	// Keep the process connected that reads from the stdout
+	task.stdout.TransferTo(t.stdout)
+
+	// Keep the state transitions
+	t.history = task.history
+
+	// Keep the consumers of the process
+	atomic.StoreInt64(&t.demand.consumers, atomic.LoadInt64(&task.demand.consumers))
+	if task.demand.idle != nil {
+		task.demand.idle.Stop()
+		task.demand.idle = nil
+	}
+	task.demand.successor = t
+
+	// Keep the sequence number, also if it hasn't been stored yet
+	t.keepSequence(task)
+
+	if t.config.OnDemand && atomic.LoadInt64(&t.demand.consumers) == 0 {
+		t.process.Order = "stop"
+	}
+
+	// Keep the FIFOs that are still written by the process
+	task.fifos = fifosExcept(task.fifos, t.fifos)
+
+	if err := r.deleteProcess(id); err != nil {
+		r.closePipe(t)
+		return err
+	}
+
+	r.tasks[t.id] = t
+	r.markChanged(t.id)
+
+	if err := r.createFIFOs(t); err != nil {
+		t.logger.Warn().WithError(err).Log("Failed to create named pipes")
+	}
+
+	if err := r.attachPipe(t); err != nil {
+		t.logger.Warn().WithError(err).Log("Failed to connect to stdout")
+		r.closePipe(t)
+	}
+
+	// set filesystem cleanup rules
+	r.setCleanup(t.id, t.config)
+
+	if t.process.Order == "start" {
+		r.startProcess(t.id)
+	}
+
+	return nil
+}
+
+// MoveInput moves the input with the ID to the given index in the config of the process.
+// The process will be updated, i.e. it will be restarted if it is running.
+func (r *restream) MoveInput(id, inputID string, toIndex int) error {
+	return r.moveIO(id, "input", inputID, toIndex)
+}
+
+// MoveOutput moves the output with the ID to the given index in the config of the process.
+// The process will be updated, i.e. it will be restarted if it is running.
+func (r *restream) MoveOutput(id, outputID string, toIndex int) error {
+	return r.moveIO(id, "output", outputID, toIndex)
+}
+
+func (r *restream) moveIO(id, kind, ioID string, toIndex int) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	task, ok := r.tasks[id]
+	if !ok {
+		return ErrUnknownProcess
+	}
+
+	config := task.process.Config.Clone()
+
+	ios := config.Input
+	if kind == "output" {
+		ios = config.Output
+	}
+
+	index := -1
+	for i, io := range ios {
+		if io.ID == ioID {
+			index = i
+			break
+		}
+	}
+
+	if index == -1 {
+		return fmt.Errorf("unknown %s '%s' of the process '%s'", kind, ioID, id)
+	}
+
+	if toIndex < 0 || toIndex >= len(ios) {
+		return fmt.Errorf("the index %d for the %s '%s' of the process '%s' is out of range", toIndex, kind, ioID, id)
+	}
+
+	if index == toIndex {
+		return nil
+	}
+
+	io := ios[index]
+	ios = append(ios[:index], ios[index+1:]...)
+	ios = append(ios[:toIndex], append([]app.ConfigIO{io}, ios[toIndex:]...)...)
+
+	if kind == "output" {
+		config.Output = ios
+	} else {
+		config.Input = ios
+	}
+
+	if err := r.updateProcess(id, config); err != nil {
+		return err
+	}
+
+	if err := r.commit(); err != nil {
+		r.revertUpdate(id, task)
+		return err
+	}
+
+	return nil
+}
+
+// Reconcile loads the processes from the store and applies the differences to the
+// current processes. Processes that are not in the store anymore are deleted, new processes
+// are added, and processes with a different config or order are updated. Unchanged
+// processes are not touched. It returns the IDs of all changed processes.
+func (r *restream) Reconcile() ([]string, error) {
+	data, err := r.store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// The metadata of all processes and the system will be replaced
+	r.markAllChanged()
+
+	changed := map[string]struct{}{}
+	failed := []string{}
+
+	for id := range r.tasks {
+		if _, ok := data.Process[id]; ok {
+			continue
+		}
+
+		r.stopProcess(id)
+
+		if err := r.deleteProcess(id); err != nil {
+			failed = append(failed, id+" ("+err.Error()+")")
+			continue
+		}
+
+		changed[id] = struct{}{}
+	}
+
+	ids := make([]string, 0, len(data.Process))
+	for id := range data.Process {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	pending := []string{}
+
+	for _, id := range ids {
+		process := data.Process[id]
+
+		t, ok := r.tasks[id]
+		if !ok {
+			pending = append(pending, id)
+			continue
+		}
+
+		if !t.process.Config.Diff(process.Config).IsEmpty() {
+			if err := r.updateProcess(id, process.Config.Clone()); err != nil {
+				failed = append(failed, id+" ("+err.Error()+")")
+				continue
+			}
+
+			changed[id] = struct{}{}
+		}
+
+		if r.tasks[id].process.Order != process.Order {
+			if err := r.reconcileOrder(id, process.Order); err != nil {
+				failed = append(failed, id+" ("+err.Error()+")")
+				continue
+			}
+
+			changed[id] = struct{}{}
+		}
+	}
+
+	// New processes might reference each other, therefore they are added
+	// as long as any of them can be added.
+	for len(pending) != 0 {
+		remaining := []string{}
+		errs := []string{}
+
+		for _, id := range pending {
+			process := data.Process[id]
+
+			t, err := r.createTask(process.Config.Clone())
+			if err == nil {
+				t.process.Order = process.Order
+				t.process.CreatedAt = process.CreatedAt
+				t.process.UpdatedAt = process.UpdatedAt
+
+				err = r.addTask(t)
+			}
+
+			if err != nil {
+				remaining = append(remaining, id)
+				errs = append(errs, id+" ("+err.Error()+")")
+				continue
+			}
+
+			changed[id] = struct{}{}
+		}
+
+		if len(remaining) == len(pending) {
+			failed = append(failed, errs...)
+			break
+		}
+
+		pending = remaining
+	}
+
+	for id, t := range r.tasks {
+		t.metadata = data.Metadata.Process[id]
+
+		// The command depends on the metadata
+		if len(unresolvedMetadata(t.process.Config)) == 0 {
+			continue
+		}
+
+		if err := r.reloadProcess(id); err != nil {
+			failed = append(failed, id+" ("+err.Error()+")")
+		}
+	}
+
+	r.metadata = data.Metadata.System
+
+	changedIDs := make([]string, 0, len(changed))
+	for id := range changed {
+		changedIDs = append(changedIDs, id)
+	}
+
+	sort.Strings(changedIDs)
+
+	if len(changedIDs) != 0 {
+		r.save()
+	}
+
+	if len(failed) != 0 {
+		return changedIDs, fmt.Errorf("failed to reconcile processes: %s", strings.Join(failed, ", "))
+	}
+
+	return changedIDs, nil
+}
+
+// reconcileOrder starts or stops the process according to the order.
+func (r *restream) reconcileOrder(id, order string) error {
+	switch order {
+	case "start":
+		return r.startProcess(id)
+	case "disabled":
+		if err := r.stopProcess(id); err != nil {
+			return err
+		}
+
+		r.tasks[id].process.Order = "disabled"
+		r.markChanged(id)
+
+		return nil
+	default:
+		return r.stopProcess(id)
+	}
+}
+
+// PutProcess adds the process if there's no process with the same ID. Otherwise the
+// existing process will be updated. If the config is the same as the config of the
+// existing process, nothing will be changed. It returns whether the process has been added.
+func (r *restream) PutProcess(config *app.Config) (bool, error) {
+	return r.putProcessAs(config, "")
+}
+
+// putProcessAs adds or updates the process like PutProcess. The actor will be recorded in
+// the audit trail.
+func (r *restream) putProcessAs(config *app.Config, actor string) (bool, error) {
+	id := strings.TrimSpace(config.ID)
+
+	r.lock.RLock()
+	task, ok := r.tasks[id]
+	unchanged := ok && task.process.Config.Diff(config).IsEmpty()
+	r.lock.RUnlock()
+
+	if unchanged {
+		return false, nil
+	}
+
+	if ok {
+		return false, r.updateProcessAs(id, config, actor)
+	}
+
+	if _, err := r.addProcessAs(config, actor); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *restream) DiffConfig(id string, config *app.Config) (app.ConfigDiff, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	task, ok := r.tasks[id]
+	if !ok {
+		return app.ConfigDiff{}, ErrUnknownProcess
+	}
+
+	return task.process.Config.Diff(config), nil
+}
+
+func (r *restream) GetProcessIDs(idpattern, refpattern string) []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if len(idpattern) == 0 && len(refpattern) == 0 {
+		ids := make([]string, len(r.tasks))
+		i := 0
+
+		for id := range r.tasks {
+			ids[i] = id
+			i++
+		}
+
+		return ids
+	}
+
+	idmap := map[string]int{}
+	count := 0
+
+	if len(idpattern) != 0 {
+		for id := range r.tasks {
+			match, err := glob.Match(idpattern, id)
+			if err != nil {
+				return nil
+			}
+
+			if !match {
+				continue
+			}
+
+			idmap[id]++
+		}
+
+		count++
+	}
+
+	if len(refpattern) != 0 {
+		for _, t := range r.tasks {
+			match, err := glob.Match(refpattern, t.reference)
+			if err != nil {
+				return nil
+			}
+
+			if !match {
+				continue
+			}
+
+			idmap[t.id]++
+		}
+
+		count++
+	}
+
+	ids := []string{}
+
+	for id, n := range idmap {
+		if n != count {
+			continue
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+func (r *restream) GetProcess(id string) (*app.Process, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	task, ok := r.tasks[id]
+	if !ok {
+		return &app.Process{}, ErrUnknownProcess
+	}
+
+	process := task.process.Clone()
+
+	return process, nil
+}
+
+// GetResolvedConfig returns a copy of the config the process is actually running with, i.e.
+// all placeholders and references are resolved and the playout ports are injected. If the
+// process is invalid, the config is only resolved as far as the last resolution succeeded.
+func (r *restream) GetResolvedConfig(id string) (*app.Config, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	task, ok := r.tasks[id]
+	if !ok {
+		return nil, ErrUnknownProcess
+	}
+
+	config := task.config.Clone()
+
+	task.sources.lock.Lock()
+	for i, input := range config.Input {
+		if address, ok := task.sources.selected[input.ID]; ok {
+			config.Input[i].Address = address
+		}
+	}
+	task.sources.lock.Unlock()
+
+	return config, nil
+}
+
+// EstimateOutputBitrate returns the bitrate in bit/s for each output ID, as configured by the
+// bitrate options of the output in the resolved config. The bitrate is -1 if it can't be
+// derived from the options, e.g. because a stream is copied or the encoder's default is used.
+func (r *restream) EstimateOutputBitrate(id string) (map[string]int64, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	task, ok := r.tasks[id]
+	if !ok {
+		return nil, ErrUnknownProcess
+	}
+
+	bitrates := map[string]int64{}
+
+	for _, output := range task.config.Output {
+		bitrates[output.ID] = outputBitrate(output.Options)
+	}
+
+	return bitrates, nil
+}
+
+// outputBitrate returns the sum of the video and audio bitrate in the options of an output, or -1
+// if there's no bitrate for any stream or if a stream that is not disabled is copied. The video
+// bitrate is taken from -b:v, or if not available, from -maxrate. The audio bitrate is taken from -b:a.
+func outputBitrate(options []string) int64 {
+	video, audio := int64(-1), int64(-1)
+	maxrate := int64(-1)
+	videoCopy, audioCopy := false, false
+	noVideo, noAudio := false, false
+
+	for i, option := range options {
+		value := ""
+		if i+1 < len(options) {
+			value = options[i+1]
+		}
+
+		switch option {
+		case "-b:v":
+			video = parseBitrate(value)
+		case "-maxrate", "-maxrate:v":
+			maxrate = parseBitrate(value)
+		case "-b:a":
+			audio = parseBitrate(value)
+		case "-c", "-codec":
+			if value == "copy" {
+				videoCopy, audioCopy = true, true
+			}
+		case "-c:v", "-codec:v", "-vcodec":
+			videoCopy = value == "copy"
+		case "-c:a", "-codec:a", "-acodec":
+			audioCopy = value == "copy"
+		case "-vn":
+			noVideo = true
+		case "-an":
+			noAudio = true
+		}
+	}
+
+	if video == -1 {
+		video = maxrate
+	}
+
+	if noVideo {
+		video, videoCopy = 0, false
+	}
+
+	if noAudio {
+		audio, audioCopy = 0, false
+	}
+
+	if videoCopy || audioCopy || (video == -1 && audio == -1) {
+		return -1
+	}
+
+	if video == -1 {
+		video = 0
+	}
+
+	if audio == -1 {
+		audio = 0
+	}
+
+	return video + audio
+}
+
+// parseBitrate parses a bitrate in bit/s with an optional SI prefix, e.g. "128k" or "2.5M", as
+// FFmpeg does. It returns -1 if the value is not a valid bitrate.
+func parseBitrate(value string) int64 {
+	binary := strings.HasSuffix(value, "i")
+	value = strings.TrimSuffix(value, "i")
+
+	exponent := 0
+	if len(value) != 0 {
+		switch value[len(value)-1] {
+		case 'k', 'K':
+			exponent = 1
+		case 'M':
+			exponent = 2
+		case 'G':
+			exponent = 3
+		}
+	}
+
+	if exponent != 0 {
+		value = value[:len(value)-1]
+	} else if binary {
+		return -1
+	}
+
+	base := 1000.0
+	if binary {
+		base = 1024
+	}
+
+	bitrate, err := strconv.ParseFloat(value, 64)
+	if err != nil || bitrate < 0 {
+		return -1
+	}
+
+	return int64(bitrate * math.Pow(base, float64(exponent)))
+}
+
+var ErrProcessReferenced = errors.New("process is referenced by other processes")
+
+func (r *restream) DeleteProcess(id string) error {
+	return r.deleteProcessAs(id, "")
+}
+
+// deleteProcessAs deletes the process like DeleteProcess. The actor will be recorded in the
+// audit trail.
+func (r *restream) deleteProcessAs(id, actor string) error {
+	if err := r.beginOperation(id); err != nil {
+		return err
+	}
+	defer r.endOperation(id)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if dependents := r.getProcessDependents(id); len(dependents) != 0 {
+		return fmt.Errorf("%w: %s", ErrProcessReferenced, strings.Join(dependents, ", "))
+	}
+
+	deleted := r.tasks[id]
+
+	err := r.deleteProcess(id)
+	if err != nil {
+		return err
+	}
+
+	r.auditDelete(deleted, actor)
+
+	if err := r.commit(); err != nil {
+		r.revertDelete(deleted)
+		return err
+	}
+
+	return nil
+}
+
+func (r *restream) ForceDeleteProcess(id string) error {
+	return r.forceDeleteProcessAs(id, "")
+}
+
+// forceDeleteProcessAs deletes the process like ForceDeleteProcess. The actor will be
+// recorded in the audit trail.
+func (r *restream) forceDeleteProcessAs(id, actor string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	deleted := r.tasks[id]
+
+	err := r.deleteProcess(id)
+	if err != nil {
+		return err
+	}
+
+	r.auditDelete(deleted, actor)
+
+	if err := r.commit(); err != nil {
+		r.revertDelete(deleted)
+		return err
+	}
+
+	return nil
+}
+
+// GetProcessIDsChangedSince returns the sorted IDs of the processes that have been
+// added, updated, started, or stopped after the given time.
+func (r *restream) GetProcessIDsChangedSince(since time.Time) []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	ids := []string{}
+
+	for id, t := range r.tasks {
+		if t.changedAt.After(since) {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
+
+func (r *restream) GetProcessDependents(id string) []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.getProcessDependents(id)
+}
+
+func (r *restream) getProcessDependents(id string) []string {
+	dependents := []string{}
+
+	for _, t := range r.tasks {
+		for _, ref := range t.references {
+			if ref == id {
+				dependents = append(dependents, t.id)
+				break
+			}
+		}
+	}
+
+	sort.Strings(dependents)
+
+	return dependents
+}
+
+func (r *restream) GetGroupIDs() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	groups := map[string]struct{}{}
+
+	for _, t := range r.tasks {
+		if len(t.process.Config.GroupID) == 0 {
+			continue
+		}
+
+		groups[t.process.Config.GroupID] = struct{}{}
+	}
+
+	ids := make([]string, 0, len(groups))
+	for id := range groups {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
+
+func (r *restream) GetProcessIDsByGroup(groupID string) []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	ids := r.getGroupProcessIDs(groupID)
+
+	sort.Strings(ids)
+
+	return ids
+}
+
+// getGroupProcessIDs returns the IDs of all processes of the group. Referenced processes
+// are placed before the processes that are referencing them.
+func (r *restream) getGroupProcessIDs(groupID string) []string {
+	if len(groupID) == 0 {
+		return []string{}
+	}
+
+	members := []string{}
+	for id, t := range r.tasks {
+		if t.process.Config.GroupID == groupID {
+			members = append(members, id)
+		}
+	}
+
+	sort.Strings(members)
+
+	ids := []string{}
+	visited := map[string]bool{}
+
+	var visit func(id string)
+	visit = func(id string) {
+		if visited[id] {
+			return
+		}
+
+		visited[id] = true
+
+		for _, ref := range r.tasks[id].references {
+			if t, ok := r.tasks[ref]; ok && t.process.Config.GroupID == groupID {
+				visit(ref)
+			}
+		}
+
+		ids = append(ids, id)
+	}
+
+	for _, id := range members {
+		visit(id)
+	}
+
+	return ids
+}
+
+// StartGroup starts all processes of the group. Referenced processes are started first.
+func (r *restream) StartGroup(groupID string) error {
+	return r.startGroupAs(groupID, "")
+}
+
+// startGroupAs starts the processes of the group like StartGroup. The actor will be recorded
+// in the audit trail of each started process.
+func (r *restream) startGroupAs(groupID, actor string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	ids := r.getGroupProcessIDs(groupID)
+	if len(ids) == 0 {
+		return ErrUnknownGroup
+	}
+
+	failed := []string{}
+	started := []string{}
+
+	for _, id := range ids {
+		order := r.tasks[id].process.Order
+
+		if err := r.startProcess(id); err != nil {
+			failed = append(failed, id+" ("+err.Error()+")")
+			continue
+		}
+
+		if order != "start" {
+			started = append(started, id)
+			r.audit(r.tasks[id], "start", actor)
+		}
+	}
+
+	if err := r.commit(); err != nil {
+		// Stop the started processes again, the referencing processes first
+		for i := len(started) - 1; i >= 0; i-- {
+			r.stopProcess(started[i])
+		}
+
+		return err
+	}
+
+	if len(failed) != 0 {
+		return fmt.Errorf("failed to start processes of the group '%s': %s", groupID, strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// StopGroup stops all processes of the group. Referencing processes are stopped first.
+func (r *restream) StopGroup(groupID string) error {
+	return r.stopGroupAs(groupID, "")
+}
+
+// stopGroupAs stops the processes of the group like StopGroup. The actor will be recorded
+// in the audit trail of each stopped process.
+func (r *restream) stopGroupAs(groupID, actor string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	ids := r.getGroupProcessIDs(groupID)
+	if len(ids) == 0 {
+		return ErrUnknownGroup
+	}
+
+	failed := []string{}
+	stopped := []string{}
+
+	for i := len(ids) - 1; i >= 0; i-- {
+		order := r.tasks[ids[i]].process.Order
+
+		if err := r.stopProcess(ids[i]); err != nil {
+			failed = append(failed, ids[i]+" ("+err.Error()+")")
+			continue
+		}
+
+		if order == "start" {
+			stopped = append(stopped, ids[i])
+			r.audit(r.tasks[ids[i]], "stop", actor)
+		}
+	}
+
+	if err := r.commit(); err != nil {
+		// Start the stopped processes again, the referenced processes first
+		for i := len(stopped) - 1; i >= 0; i-- {
+			r.startProcess(stopped[i])
+		}
+
+		return err
+	}
+
+	if len(failed) != 0 {
+		return fmt.Errorf("failed to stop processes of the group '%s': %s", groupID, strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// DeleteGroup deletes all processes of the group. Either all or none of the processes
+// will be deleted. The processes must not run and must not be referenced by processes
+// of other groups.
+func (r *restream) DeleteGroup(groupID string) error {
+	return r.deleteGroupAs(groupID, "")
+}
+
+// deleteGroupAs deletes the processes of the group like DeleteGroup. The actor will be
+// recorded in the audit trail of each deleted process.
+func (r *restream) deleteGroupAs(groupID, actor string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	ids := r.getGroupProcessIDs(groupID)
+	if len(ids) == 0 {
+		return ErrUnknownGroup
+	}
+
+	for _, id := range ids {
+		if r.tasks[id].process.Order == "start" {
+			return fmt.Errorf("the process with the ID '%s' is still running", id)
+		}
+
+		for _, dependent := range r.getProcessDependents(id) {
+			if r.tasks[dependent].process.Config.GroupID != groupID {
+				return fmt.Errorf("%w: %s", ErrProcessReferenced, dependent)
+			}
+		}
+	}
+
+	deleted := []*task{}
+
+	for i := len(ids) - 1; i >= 0; i-- {
+		task := r.tasks[ids[i]]
+
+		if err := r.deleteProcess(ids[i]); err == nil {
+			deleted = append(deleted, task)
+			r.auditDelete(task, actor)
+		}
+	}
+
+	if err := r.commit(); err != nil {
+		// Add the deleted processes again, the referenced processes first
+		for i := len(deleted) - 1; i >= 0; i-- {
+			r.revertDelete(deleted[i])
+		}
+
+		return err
+	}
 
-	for id, t := range r.tasks {
-		data.Process[id] = t.process
-		data.Metadata.System = r.metadata
-		data.Metadata.Process[id] = t.metadata
+	return nil
+}
+
+func (r *restream) deleteProcess(id string) error {
+	task, ok := r.tasks[id]
+	if !ok {
+		return ErrUnknownProcess
 	}
 
-	r.store.Store(data)
+	if task.process.Order == "start" {
+		return fmt.Errorf("the process with the ID '%s' is still running", id)
+	}
+
+	r.unsetPlayoutPorts(task)
+	r.unsetCleanup(id)
+	r.closePipe(task)
+	task.stopTemporary()
+
+	task.logs.Close()
+	task.states.Close()
+	task.stdout.Close()
+
+	r.removeFIFOs(task, task.fifos)
+
+	delete(r.tasks, id)
+	r.markChanged(id)
+
+	return nil
 }
 
-func (r *restream) ID() string {
-	return r.id
+func (r *restream) StartProcess(id string) error {
+	return r.startProcessAs(id, "")
 }
 
-func (r *restream) Name() string {
-	return r.name
+// startProcessAs starts the process like StartProcess. The actor will be recorded in the
+// audit trail.
+func (r *restream) startProcessAs(id, actor string) error {
+	if err := r.beginOperation(id); err != nil {
+		return err
+	}
+	defer r.endOperation(id)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	err := r.startProcess(id)
+	if err != nil {
+		return err
+	}
+
+	r.audit(r.tasks[id], "start", actor)
+	r.save()
+
+	return nil
 }
 
-func (r *restream) CreatedAt() time.Time {
-	return r.createdAt
+func (r *restream) startProcess(id string) error {
+	return r.startProcessFrom(id, map[string]struct{}{})
 }
 
-var ErrUnknownProcess = errors.New("unknown process")
-var ErrProcessExists = errors.New("process already exists")
+// startProcessFrom starts the process like startProcess. The visited processes are already
+// about to be started because of the reference behavior of other processes.
+func (r *restream) startProcessFrom(id string, visited map[string]struct{}) error {
+	task, ok := r.tasks[id]
+	if !ok {
+		return ErrUnknownProcess
+	}
 
-func (r *restream) AddProcess(config *app.Config) error {
-	r.lock.RLock()
-	t, err := r.createTask(config)
-	r.lock.RUnlock()
+	if !task.valid {
+		return fmt.Errorf("invalid process definition")
+	}
 
-	if err != nil {
+	if task.process.Order == "disabled" {
+		return ErrProcessDisabled
+	}
+
+	if task.config.TemplateOnly {
+		return fmt.Errorf("%w: %s", ErrTemplateOnly, id)
+	}
+
+	// The skills might have changed since the process has been added
+	if r.requireSkills {
+		if err := r.checkSkills(task.config); err != nil {
+			return err
+		}
+	}
+
+	status := task.ffmpeg.Status()
+
+	if task.process.Order == "start" && status.Order == "start" {
+		return nil
+	}
+
+	// The process has already been started by a process that references it
+	if r.isDeferredStart(task) {
+		return nil
+	}
+
+	visited[id] = struct{}{}
+
+	if err := r.checkReferences(task, visited); err != nil {
+		return err
+	}
+
+	if !task.counted && r.maxProc > 0 && r.nProc >= r.maxProc {
+		if !r.preemptProcess(task.process.Config.Priority) {
+			return fmt.Errorf("max. number of running processes (%d) reached", r.maxProc)
+		}
+	}
+
+	task.process.Order = "start"
+	task.preempted = false
+	task.changedAt = time.Now()
+	r.markChanged(id)
+
+	task.connect.lock.Lock()
+	task.connect.connected = false
+	task.connect.attempts = 0
+	task.connect.lock.Unlock()
+
+	task.resetBreaker()
+
+	if r.deferring {
+		r.deferredStarts = append(r.deferredStarts, task)
+	} else {
+		task.ffmpeg.Start()
+	}
+
+	if !task.counted {
+		task.counted = true
+		r.nProc++
+	}
+
+	return nil
+}
+
+// checkReferences applies the reference behavior of the task to all referenced processes
+// that are not started. Depending on the behavior, the start of the task is rejected or
+// the referenced processes are started first.
+func (r *restream) checkReferences(t *task, visited map[string]struct{}) error {
+	behavior := t.config.ReferenceBehavior
+
+	if behavior != "block-start" && behavior != "start-dependency" {
+		return nil
+	}
+
+	for _, id := range t.references {
+		producer, ok := r.tasks[id]
+		if !ok || producer.process.Order == "start" {
+			continue
+		}
+
+		if _, ok := visited[id]; ok {
+			continue
+		}
+
+		if behavior == "block-start" {
+			return fmt.Errorf("%w: %s", ErrReferenceNotStarted, id)
+		}
+
+		if err := r.startProcessFrom(id, visited); err != nil {
+			return fmt.Errorf("failed to start the referenced process '%s': %w", id, err)
+		}
+	}
+
+	return nil
+}
+
+// preemptProcess stops the running process with the lowest priority, if preemption
+// is enabled and its priority is lower than the given priority. It returns whether
+// a process has been stopped.
+func (r *restream) preemptProcess(priority int) bool {
+	if !r.preempt {
+		return false
+	}
+
+	var victim *task
+
+	for _, t := range r.tasks {
+		if !t.valid || t.process.Order != "start" {
+			continue
+		}
+
+		if t.process.Config.Priority >= priority {
+			continue
+		}
+
+		if victim == nil || t.process.Config.Priority < victim.process.Config.Priority {
+			victim = t
+		}
+	}
+
+	if victim == nil {
+		return false
+	}
+
+	victim.logger.Warn().WithFields(log.Fields{
+		"priority": victim.process.Config.Priority,
+		"favoured": priority,
+	}).Log("Stopping because a process with higher priority is starting")
+
+	if err := r.stopProcess(victim.id); err != nil {
+		return false
+	}
+
+	victim.preempted = true
+
+	return true
+}
+
+func (r *restream) StopProcess(id string) error {
+	return r.stopProcessAs(id, "")
+}
+
+// stopProcessAs stops the process like StopProcess. The actor will be recorded in the
+// audit trail.
+func (r *restream) stopProcessAs(id, actor string) error {
+	if err := r.beginOperation(id); err != nil {
 		return err
 	}
+	defer r.endOperation(id)
 
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
-	_, ok := r.tasks[t.id]
-	if ok {
-		return ErrProcessExists
+	err := r.stopProcess(id)
+	if err != nil {
+		return err
 	}
 
-	r.tasks[t.id] = t
+	r.audit(r.tasks[id], "stop", actor)
+	r.save()
 
-	// set filesystem cleanup rules
-	r.setCleanup(t.id, t.config)
+	return nil
+}
 
-	if t.process.Order == "start" {
-		err := r.startProcess(t.id)
-		if err != nil {
-			delete(r.tasks, t.id)
-			return err
+func (r *restream) stopProcess(id string) error {
+	return r.stopProcessWait(id, true)
+}
+
+// stopProcessWait stops the process like stopProcess. If wait is false, it will not be
+// waited for the process to exit.
+func (r *restream) stopProcessWait(id string, wait bool) error {
+	task, ok := r.tasks[id]
+	if !ok {
+		return ErrUnknownProcess
+	}
+
+	if task.ffmpeg == nil {
+		return nil
+	}
+
+	task.resetBreaker()
+
+	// A disabled process is already stopped
+	if task.process.Order == "disabled" {
+		task.process.Order = "stop"
+		task.changedAt = time.Now()
+		r.markChanged(id)
+		return nil
+	}
+
+	status := task.ffmpeg.Status()
+
+	if task.process.Order == "stop" && status.Order == "stop" {
+		return nil
+	}
+
+	task.process.Order = "stop"
+	task.changedAt = time.Now()
+	r.markChanged(id)
+
+	// The process is not launched anymore if it is about to be started
+	r.removeDeferredStart(task)
+
+	task.ffmpeg.Stop(wait)
+
+	if task.counted {
+		task.counted = false
+		r.nProc--
+	}
+
+	return nil
+}
+
+// Filesystems returns the name, type, current size, and limit of every registered
+// filesystem in the order they have been registered.
+func (r *restream) Filesystems() []app.FilesystemInfo {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	infos := make([]app.FilesystemInfo, 0, len(r.fs.list))
+
+	for _, fs := range r.fs.list {
+		size, limit := fs.Size()
+
+		infos = append(infos, app.FilesystemInfo{
+			Name:  fs.Name(),
+			Type:  fs.Type(),
+			Size:  size,
+			Limit: limit,
+		})
+	}
+
+	return infos
+}
+
+// StopProcessesByFilesystem stops all started processes with an output that writes to
+// the filesystem with the name and returns their sorted IDs. All processes are stopped
+// at the same time and it is waited up to the timeout for them to exit.
+func (r *restream) StopProcessesByFilesystem(fsName string, timeout time.Duration) ([]string, error) {
+	r.lock.Lock()
+
+	var filesystem rfs.Filesystem
+
+	for _, fs := range r.fs.list {
+		if fs.Name() == fsName {
+			filesystem = fs
+			break
+		}
+	}
+
+	if filesystem == nil {
+		r.lock.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFilesystem, fsName)
+	}
+
+	base := filesystem.Metadata("base")
+
+	ids := []string{}
+
+	for id, t := range r.tasks {
+		if !t.valid || t.process.Order != "start" {
+			continue
+		}
+
+		if !belowBase(outputAddresses(t.config), base) {
+			continue
 		}
+
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	procs := make([]process.Process, len(ids))
+
+	for i, id := range ids {
+		procs[i] = r.tasks[id].ffmpeg
+		r.stopProcessWait(id, false)
 	}
 
 	r.save()
+	r.lock.Unlock()
 
-	return nil
+	// Wait without the lock, such that the processes can be managed in the meantime
+	deadline := time.Now().Add(timeout)
+
+	for i, proc := range procs {
+		for proc.IsRunning() {
+			if time.Now().After(deadline) {
+				return ids, fmt.Errorf("the process '%s' didn't stop within %s", ids[i], timeout)
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	return ids, nil
 }
 
-func (r *restream) createTask(config *app.Config) (*task, error) {
-	id := strings.TrimSpace(config.ID)
+// belowBase returns whether any of the addresses is a path or URL below the base of a filesystem.
+func belowBase(addresses []string, base string) bool {
+	base = strings.TrimSuffix(base, "/")
+	if len(base) == 0 {
+		return false
+	}
 
-	if len(id) == 0 {
-		return nil, fmt.Errorf("an empty ID is not allowed")
+	for _, address := range addresses {
+		if address == base || strings.HasPrefix(address, base+"/") {
+			return true
+		}
 	}
 
-	config.FFVersion = "^" + r.ffmpeg.Skills().FFmpeg.Version
-	if v, err := semver.NewVersion(config.FFVersion); err == nil {
-		// Remove the patch level for the constraint
-		config.FFVersion = fmt.Sprintf("^%d.%d.0", v.Major(), v.Minor())
+	return false
+}
+
+// defaultIdleTimeout is the idle timeout of a process on demand without an IdleTimeout.
+const defaultIdleTimeout = 30 * time.Second
+
+// DemandProcess starts the process, if it is a process on demand. The process stays started
+// until the returned release function has been called for every demand and the idle timeout
+// of the process passed without a new demand.
+func (r *restream) DemandProcess(id string) (func(), error) {
+	return r.demand(id, func(t *task) error {
+		if !t.config.OnDemand {
+			return fmt.Errorf("the process '%s' is not a process on demand", id)
+		}
+
+		return nil
+	})
+}
+
+// demand adds a consumer to the process with the ID, if it is a process on demand. The check
+// is called with the task while holding the lock, an error aborts the demand. For any other
+// process the returned release function does nothing.
+//
+// The write lock is only acquired if the process has to be started, i.e. for the first
+// consumer. Any further consumer of a started process only requires the read lock.
+func (r *restream) demand(id string, check func(t *task) error) (func(), error) {
+	r.lock.RLock()
+
+	t, ok := r.tasks[id]
+	if !ok {
+		r.lock.RUnlock()
+		return nil, ErrUnknownProcess
 	}
 
-	process := &app.Process{
-		ID:        config.ID,
-		Reference: config.Reference,
-		Config:    config.Clone(),
-		Order:     "stop",
-		CreatedAt: time.Now().Unix(),
+	if err := check(t); err != nil {
+		r.lock.RUnlock()
+		return nil, err
 	}
 
-	process.UpdatedAt = process.CreatedAt
-
-	if config.Autostart {
-		process.Order = "start"
+	if !t.config.OnDemand {
+		r.lock.RUnlock()
+		return func() {}, nil
 	}
 
-	t := &task{
-		id:        config.ID,
-		reference: process.Reference,
-		process:   process,
-		config:    process.Config.Clone(),
-		logger:    r.logger.WithField("id", process.ID),
+	// Other holders of the read lock can only add consumers, therefore the process
+	// keeps its consumers until the read lock is released.
+	if atomic.LoadInt64(&t.demand.consumers) != 0 && t.process.Order == "start" {
+		atomic.AddInt64(&t.demand.consumers, 1)
+		r.lock.RUnlock()
+		return r.releaser(t), nil
 	}
 
-	resolvePlaceholders(t.config, r.replace)
+	r.lock.RUnlock()
 
-	err := r.resolveAddresses(r.tasks, t.config)
-	if err != nil {
-		return nil, err
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// The process might have been changed in the meantime
+	t, ok = r.tasks[id]
+	if !ok {
+		return nil, ErrUnknownProcess
 	}
 
-	t.usesDisk, err = r.validateConfig(t.config)
-	if err != nil {
+	if err := check(t); err != nil {
 		return nil, err
 	}
 
-	err = r.setPlayoutPorts(t)
-	if err != nil {
-		return nil, err
+	if !t.config.OnDemand {
+		return func() {}, nil
 	}
 
-	t.command = t.config.CreateCommand()
-	t.parser = r.ffmpeg.NewProcessParser(t.logger, t.id, t.reference)
+	return r.demandProcess(t)
+}
 
-	ffmpeg, err := r.ffmpeg.New(ffmpeg.ProcessConfig{
-		Reconnect:      t.config.Reconnect,
-		ReconnectDelay: time.Duration(t.config.ReconnectDelay) * time.Second,
-		StaleTimeout:   time.Duration(t.config.StaleTimeout) * time.Second,
-		LimitCPU:       t.config.LimitCPU,
-		LimitMemory:    t.config.LimitMemory,
-		LimitDuration:  time.Duration(t.config.LimitWaitFor) * time.Second,
-		Command:        t.command,
-		Parser:         t.parser,
-		Logger:         t.logger,
-	})
-	if err != nil {
+// demandProcess starts the process on demand and adds a consumer. The returned release
+// function removes the consumer again, even if the process has been updated or renamed
+// in the meantime.
+func (r *restream) demandProcess(t *task) (func(), error) {
+	if t.demand.idle != nil {
+		t.demand.idle.Stop()
+		t.demand.idle = nil
+	}
+
+	if err := r.startProcess(t.id); err != nil {
 		return nil, err
 	}
 
-	t.ffmpeg = ffmpeg
-	t.valid = true
+	atomic.AddInt64(&t.demand.consumers, 1)
 
-	return t, nil
-}
+	r.save()
 
-func (r *restream) setCleanup(id string, config *app.Config) {
-	rePrefix := regexp.MustCompile(`^([a-z]+):`)
+	return r.releaser(t), nil
+}
 
-	for _, output := range config.Output {
-		for _, c := range output.Cleanup {
-			matches := rePrefix.FindStringSubmatch(c.Pattern)
-			if matches == nil {
-				continue
-			}
+// releaser returns the release function for a consumer of the process on demand. The
+// function can be called more than once, only the first call releases the process.
+func (r *restream) releaser(t *task) func() {
+	once := sync.Once{}
 
-			name := matches[1]
+	release := func() {
+		once.Do(func() {
+			r.releaseProcess(t)
+		})
+	}
 
-			// Support legacy names
-			if name == "diskfs" {
-				name = "disk"
-			} else if name == "memfs" {
-				name = "mem"
-			}
+	return release
+}
 
-			for _, fs := range r.fs.list {
-				if fs.Name() != name {
-					continue
-				}
+// releaseProcess removes a consumer from the process on demand. Without any consumers, the
+// process will be stopped after its idle timeout.
+func (r *restream) releaseProcess(t *task) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
 
-				pattern := rfs.Pattern{
-					Pattern:       rePrefix.ReplaceAllString(c.Pattern, ""),
-					MaxFiles:      c.MaxFiles,
-					MaxFileAge:    time.Duration(c.MaxFileAge) * time.Second,
-					PurgeOnDelete: c.PurgeOnDelete,
-				}
+	// Follow the task through its updates, it might have been renamed
+	for t.demand.successor != nil {
+		t = t.demand.successor
+	}
 
-				fs.SetCleanup(id, []rfs.Pattern{
-					pattern,
-				})
+	if task, ok := r.tasks[t.id]; !ok || task != t || atomic.LoadInt64(&t.demand.consumers) == 0 {
+		return
+	}
 
-				break
-			}
-		}
+	if atomic.AddInt64(&t.demand.consumers, -1) != 0 {
+		return
 	}
-}
 
-func (r *restream) unsetCleanup(id string) {
-	for _, fs := range r.fs.list {
-		fs.UnsetCleanup(id)
+	timeout := time.Duration(t.config.IdleTimeout) * time.Second
+	if timeout == 0 {
+		timeout = defaultIdleTimeout
 	}
-}
 
-func (r *restream) setPlayoutPorts(t *task) error {
-	r.unsetPlayoutPorts(t)
+	var idle *time.Timer
 
-	t.playout = make(map[string]int)
+	idle = time.AfterFunc(timeout, func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
 
-	for i, input := range t.config.Input {
-		if !strings.HasPrefix(input.Address, "avstream:") && !strings.HasPrefix(input.Address, "playout:") {
-			continue
+		// Check whether the process has been demanded or replaced in the meantime
+		if task, ok := r.tasks[t.id]; !ok || task != t || t.demand.idle != idle {
+			return
 		}
 
-		options := []string{}
-		skip := false
+		t.demand.idle = nil
 
-		for _, o := range input.Options {
-			if skip {
-				continue
-			}
+		t.logger.Info().Log("Stopping idle process")
 
-			if o == "-playout_httpport" {
-				skip = true
-				continue
-			}
+		r.stopProcess(t.id)
+		r.save()
+	})
 
-			options = append(options, o)
-		}
+	t.demand.idle = idle
+}
 
-		if port, err := r.ffmpeg.GetPort(); err == nil {
-			options = append(options, "-playout_httpport", strconv.Itoa(port))
+// ResetProcess starts a process that has been disabled because of repeated failures.
+func (r *restream) ResetProcess(id string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
 
-			t.logger.WithFields(log.Fields{
-				"port":  port,
-				"input": input.ID,
-			}).Debug().Log("Assinging playout port")
+	task, ok := r.tasks[id]
+	if !ok {
+		return ErrUnknownProcess
+	}
 
-			t.playout[input.ID] = port
-		} else if err != net.ErrNoPortrangerProvided {
-			return err
-		}
+	task.resetBreaker()
 
-		input.Options = options
-		t.config.Input[i] = input
+	if task.process.Order != "disabled" {
+		return nil
 	}
 
-	return nil
-}
+	task.process.Order = "stop"
+	r.markChanged(id)
 
-func (r *restream) unsetPlayoutPorts(t *task) {
-	if t.playout == nil {
-		return
+	if err := r.startProcess(id); err != nil {
+		task.process.Order = "disabled"
+		return err
 	}
 
-	for _, port := range t.playout {
-		r.ffmpeg.PutPort(port)
-	}
+	r.save()
 
-	t.playout = nil
+	return nil
 }
 
-func (r *restream) validateConfig(config *app.Config) (bool, error) {
-	if len(config.Input) == 0 {
-		return false, fmt.Errorf("at least one input must be defined for the process '%s'", config.ID)
+func (r *restream) RestartProcess(id string) error {
+	return r.restartProcessAs(id, "")
+}
+
+// restartProcessAs restarts the process like RestartProcess. The actor will be recorded in
+// the audit trail.
+func (r *restream) restartProcessAs(id, actor string) error {
+	if err := r.beginOperation(id); err != nil {
+		return err
 	}
+	defer r.endOperation(id)
 
-	var err error
+	r.lock.Lock()
+	defer r.lock.Unlock()
 
-	ids := map[string]bool{}
+	if err := r.restartProcess(id); err != nil {
+		return err
+	}
 
-	for _, io := range config.Input {
-		io.ID = strings.TrimSpace(io.ID)
+	if r.auditTrail {
+		r.audit(r.tasks[id], "restart", actor)
+		r.save()
+	}
 
-		if len(io.ID) == 0 {
-			return false, fmt.Errorf("empty input IDs are not allowed (process '%s')", config.ID)
-		}
+	return nil
+}
 
-		if _, found := ids[io.ID]; found {
-			return false, fmt.Errorf("the input ID '%s' is already in use for the process `%s`", io.ID, config.ID)
-		}
+func (r *restream) restartProcess(id string) error {
+	task, ok := r.tasks[id]
+	if !ok {
+		return ErrUnknownProcess
+	}
 
-		ids[io.ID] = true
+	if !task.valid {
+		return fmt.Errorf("invalid process definition")
+	}
 
-		io.Address = strings.TrimSpace(io.Address)
+	if task.process.Order == "stop" {
+		return nil
+	}
 
-		if len(io.Address) == 0 {
-			return false, fmt.Errorf("the address for input '#%s:%s' must not be empty", config.ID, io.ID)
-		}
+	task.ffmpeg.Kill(true)
 
-		if len(r.fs.diskfs) != 0 {
-			maxFails := 0
-			for _, fs := range r.fs.diskfs {
-				io.Address, err = r.validateInputAddress(io.Address, fs.Metadata("base"))
-				if err != nil {
-					maxFails++
-				}
-			}
+	return nil
+}
 
-			if maxFails == len(r.fs.diskfs) {
-				return false, fmt.Errorf("the address for input '#%s:%s' (%s) is invalid: %w", config.ID, io.ID, io.Address, err)
-			}
-		} else {
-			io.Address, err = r.validateInputAddress(io.Address, "/")
-			if err != nil {
-				return false, fmt.Errorf("the address for input '#%s:%s' (%s) is invalid: %w", config.ID, io.ID, io.Address, err)
-			}
-		}
-	}
+func (r *restream) ReloadProcess(id string) error {
+	return r.reloadProcessAs(id, "")
+}
 
-	if len(config.Output) == 0 {
-		return false, fmt.Errorf("at least one output must be defined for the process '#%s'", config.ID)
+// reloadProcessAs reloads the process like ReloadProcess. The actor will be recorded in the
+// audit trail.
+func (r *restream) reloadProcessAs(id, actor string) error {
+	if err := r.beginOperation(id); err != nil {
+		return err
 	}
+	defer r.endOperation(id)
 
-	ids = map[string]bool{}
-	hasFiles := false
+	r.lock.Lock()
+	defer r.lock.Unlock()
 
-	for _, io := range config.Output {
-		io.ID = strings.TrimSpace(io.ID)
+	err := r.reloadProcess(id)
+	if err != nil {
+		return err
+	}
 
-		if len(io.ID) == 0 {
-			return false, fmt.Errorf("empty output IDs are not allowed (process '%s')", config.ID)
-		}
+	r.audit(r.tasks[id], "reload", actor)
+	r.save()
 
-		if _, found := ids[io.ID]; found {
-			return false, fmt.Errorf("the output ID '%s' is already in use for the process `%s`", io.ID, config.ID)
-		}
+	return nil
+}
 
-		ids[io.ID] = true
+func (r *restream) reloadProcess(id string) error {
+	t, ok := r.tasks[id]
+	if !ok {
+		return ErrUnknownProcess
+	}
 
-		io.Address = strings.TrimSpace(io.Address)
+	valid := t.valid
+	config := t.config
+	command := t.command
 
-		if len(io.Address) == 0 {
-			return false, fmt.Errorf("the address for output '#%s:%s' must not be empty", config.ID, io.ID)
-		}
+	t.valid = false
 
-		if len(r.fs.diskfs) != 0 {
-			maxFails := 0
-			for _, fs := range r.fs.diskfs {
-				isFile := false
-				io.Address, isFile, err = r.validateOutputAddress(io.Address, fs.Metadata("base"))
-				if err != nil {
-					maxFails++
-				}
+	t.config = t.process.Config.Clone()
 
-				if isFile {
-					hasFiles = true
-				}
-			}
+	r.resolveConfig(t.config, t.metadata)
 
-			if maxFails == len(r.fs.diskfs) {
-				return false, fmt.Errorf("the address for output '#%s:%s' is invalid: %w", config.ID, io.ID, err)
-			}
-		} else {
-			isFile := false
-			io.Address, isFile, err = r.validateOutputAddress(io.Address, "/")
-			if err != nil {
-				return false, fmt.Errorf("the address for output '#%s:%s' is invalid: %w", config.ID, io.ID, err)
-			}
+	t.references = referencedProcesses(t.config)
 
-			if isFile {
-				hasFiles = true
-			}
-		}
+	producer, err := stdoutProducer(t.config)
+	if err != nil {
+		return err
 	}
 
-	return hasFiles, nil
-}
-
-func (r *restream) validateInputAddress(address, basedir string) (string, error) {
-	if ok := url.HasScheme(address); ok {
-		if err := url.Validate(address); err != nil {
-			return address, err
-		}
+	err = r.resolveAddresses(r.tasks, t.config)
+	if err != nil {
+		return err
 	}
 
-	if !r.ffmpeg.ValidateInputAddress(address) {
-		return address, fmt.Errorf("address is not allowed")
+	t.usesDisk, err = r.validateConfig(t.config)
+	if err != nil {
+		return err
 	}
 
-	return address, nil
-}
+	ff, err := r.ffmpegFor(t.config)
+	if err != nil {
+		return err
+	}
 
-func (r *restream) validateOutputAddress(address, basedir string) (string, bool, error) {
-	// If the address contains a "|" or it starts with a "[", then assume that it
-	// is an address for the tee muxer.
-	if strings.Contains(address, "|") || strings.HasPrefix(address, "[") {
-		addresses := strings.Split(address, "|")
+	if err := r.createWorkingDir(t); err != nil {
+		return err
+	}
 
-		isFile := false
+	fifos := t.fifos
 
-		teeOptions := regexp.MustCompile(`^\[[^\]]*\]`)
+	t.fifos, err = r.resolveNamedPipes(t.config)
+	if err != nil {
+		t.fifos = fifos
+		return err
+	}
 
-		for i, a := range addresses {
-			options := teeOptions.FindString(a)
-			a = teeOptions.ReplaceAllString(a, "")
+	if err := r.checkNamedPipes(t, ""); err != nil {
+		t.fifos = fifos
+		return err
+	}
 
-			va, file, err := r.validateOutputAddress(a, basedir)
-			if err != nil {
-				return address, false, err
-			}
+	err = r.setPlayoutPorts(t)
+	if err != nil {
+		return err
+	}
 
-			if file {
-				isFile = true
-			}
+	t.command = r.createCommand(t)
 
-			addresses[i] = options + va
-		}
+	// Keep the current process if nothing changed that would require a new process
+	if valid && samePipe(t, producer) && equalCommand(command, t.command) && equalProcessSettings(config, t.config) {
+		t.valid = true
+		return nil
+	}
 
-		return strings.Join(addresses, "|"), isFile, nil
+	order := "stop"
+	if t.process.Order == "start" {
+		order = "start"
+		r.stopProcess(id)
 	}
 
-	address = strings.TrimPrefix(address, "file:")
+	// Replace the pipe only if the process reads from a different stdout
+	if t.stdin == nil || t.stdin.producer != producer {
+		r.closePipe(t)
 
-	if ok := url.HasScheme(address); ok {
-		if err := url.Validate(address); err != nil {
-			return address, false, err
+		if err := r.createPipe(t, producer); err != nil {
+			return err
 		}
 
-		if !r.ffmpeg.ValidateOutputAddress(address) {
-			return address, false, fmt.Errorf("address is not allowed")
+		if err := r.attachPipe(t); err != nil {
+			r.closePipe(t)
+			return err
 		}
+	}
 
-		return address, false, nil
+	// Replace the FIFOs that are not written anymore by the process
+	r.removeFIFOs(t, fifosExcept(fifos, t.fifos))
+
+	if err := r.createFIFOs(t); err != nil {
+		return err
 	}
 
-	if address == "-" {
-		return "pipe:", false, nil
+	// The new parser continues the log history of the previous process
+	parser := ff.NewProcessParser(t.logger, t.id, t.reference)
+	if t.parser != nil {
+		t.parser.ResetLog()
+		t.parser.TransferReportHistory(parser)
 	}
 
-	address, err := filepath.Abs(address)
+	t.parser = parser
+	t.annotate("reload")
+
+	ffmpeg, err := r.createProcess(t)
 	if err != nil {
-		return address, false, fmt.Errorf("not a valid path (%w)", err)
+		return err
 	}
 
-	if strings.HasPrefix(address, "/dev/") {
-		if !r.ffmpeg.ValidateOutputAddress("file:" + address) {
-			return address, false, fmt.Errorf("address is not allowed")
-		}
+	t.ffmpeg = ffmpeg
+	t.valid = true
 
-		return "file:" + address, false, nil
+	if order == "start" {
+		r.startProcess(id)
 	}
 
-	if !strings.HasPrefix(address, basedir) {
-		return address, false, fmt.Errorf("%s is not inside of %s", address, basedir)
-	}
+	return nil
+}
 
-	if !r.ffmpeg.ValidateOutputAddress("file:" + address) {
-		return address, false, fmt.Errorf("address is not allowed")
+// samePipe returns whether the task already reads from the stdout of the producer.
+func samePipe(t *task, producer string) bool {
+	if t.stdin == nil {
+		return len(producer) == 0
 	}
 
-	return "file:" + address, true, nil
+	return t.stdin.producer == producer
 }
 
-func (r *restream) resolveAddresses(tasks map[string]*task, config *app.Config) error {
-	for i, input := range config.Input {
-		// Resolve any references
-		address, err := r.resolveAddress(tasks, config.ID, input.Address)
-		if err != nil {
-			return fmt.Errorf("reference error for '#%s:%s': %w", config.ID, input.ID, err)
-		}
-
-		input.Address = address
+func equalCommand(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
 
-		config.Input[i] = input
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
 	}
 
-	return nil
+	return true
 }
 
-func (r *restream) resolveAddress(tasks map[string]*task, id, address string) (string, error) {
-	re := regexp.MustCompile(`^#(.+):output=(.+)`)
-
-	if len(address) == 0 {
-		return address, fmt.Errorf("empty address")
+// equalProcessSettings returns whether the settings of the resolved configs that are
+// not part of the command, but are used for creating the process, are equal.
+func equalProcessSettings(a, b *app.Config) bool {
+	if a.Reconnect != b.Reconnect || a.ReconnectDelay != b.ReconnectDelay {
+		return false
 	}
 
-	if address[0] != '#' {
-		return address, nil
+	if a.StaleTimeout != b.StaleTimeout || a.StaleAction != b.StaleAction || a.StaleMetric != b.StaleMetric {
+		return false
 	}
 
-	matches := re.FindStringSubmatch(address)
-	if matches == nil {
-		return address, fmt.Errorf("invalid format (%s)", address)
+	if a.LimitCPU != b.LimitCPU || a.LimitMemory != b.LimitMemory || a.LimitWaitFor != b.LimitWaitFor {
+		return false
 	}
 
-	if matches[1] == id {
-		return address, fmt.Errorf("self-reference not possible (%s)", address)
+	if a.FFmpegBinary != b.FFmpegBinary || a.SourceSelection != b.SourceSelection {
+		return false
 	}
 
-	task, ok := tasks[matches[1]]
-	if !ok {
-		return address, fmt.Errorf("unknown process '%s' (%s)", matches[1], address)
+	if len(a.Input) != len(b.Input) {
+		return false
 	}
 
-	for _, x := range task.config.Output {
-		if x.ID == matches[2] {
-			return x.Address, nil
+	for i := range a.Input {
+		if !equalCommand(a.Input[i].Addresses, b.Input[i].Addresses) {
+			return false
 		}
 	}
 
-	return address, fmt.Errorf("the process '%s' has no outputs with the ID '%s' (%s)", matches[1], matches[2], address)
+	return true
 }
 
-func (r *restream) UpdateProcess(id string, config *app.Config) error {
-	r.lock.Lock()
-	defer r.lock.Unlock()
-
-	t, err := r.createTask(config)
-	if err != nil {
-		return err
-	}
+func (r *restream) GetProcessStateHistory(id string, since time.Time) ([]app.StateTransition, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
 
 	task, ok := r.tasks[id]
 	if !ok {
-		return ErrUnknownProcess
+		return nil, ErrUnknownProcess
 	}
 
-	// This would require a major version jump
-	//t.process.CreatedAt = task.process.CreatedAt
-	t.process.UpdatedAt = time.Now().Unix()
-	task.parser.TransferReportHistory(t.parser)
-	t.process.Order = task.process.Order
+	return task.history.Since(since), nil
+}
 
-	if id != t.id {
-		_, ok := r.tasks[t.id]
-		if ok {
-			return ErrProcessExists
-		}
-	}
+// GetStateTransitionCounts returns how many times the process entered each state at or
+// after the given time, keyed by the name of the state. Only the recorded transitions of
+// the state history are counted.
+func (r *restream) GetStateTransitionCounts(id string, since time.Time) (map[string]int, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
 
-	if err := r.stopProcess(id); err != nil {
-		return err
+	task, ok := r.tasks[id]
+	if !ok {
+		return nil, ErrUnknownProcess
 	}
 
-	if err := r.deleteProcess(id); err != nil {
-		return err
+	counts := map[string]int{}
+
+	for _, t := range task.history.Since(since) {
+		counts[t.To]++
 	}
 
-	r.tasks[t.id] = t
+	return counts, nil
+}
 
-	// set filesystem cleanup rules
-	r.setCleanup(t.id, t.config)
+func (r *restream) GetProcessState(id string) (*app.State, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
 
-	if t.process.Order == "start" {
-		r.startProcess(t.id)
+	task, ok := r.tasks[id]
+	if !ok {
+		return &app.State{}, ErrUnknownProcess
 	}
 
-	r.save()
+	if !task.valid {
+		return &app.State{}, nil
+	}
 
-	return nil
+	return r.processState(task), nil
 }
 
-func (r *restream) GetProcessIDs(idpattern, refpattern string) []string {
+// GetStatesByReference returns the states of all processes with a reference that matches the
+// glob pattern, grouped by their reference. The states of each reference are keyed by the ID
+// of their process. An empty pattern matches all references.
+func (r *restream) GetStatesByReference(refpattern string) (map[string]map[string]*app.State, error) {
 	r.lock.RLock()
 	defer r.lock.RUnlock()
 
-	if len(idpattern) == 0 && len(refpattern) == 0 {
-		ids := make([]string, len(r.tasks))
-		i := 0
-
-		for id := range r.tasks {
-			ids[i] = id
-			i++
-		}
-
-		return ids
-	}
-
-	idmap := map[string]int{}
-	count := 0
+	states := map[string]map[string]*app.State{}
 
-	if len(idpattern) != 0 {
-		for id := range r.tasks {
-			match, err := glob.Match(idpattern, id)
+	for id, t := range r.tasks {
+		if len(refpattern) != 0 {
+			match, err := glob.Match(refpattern, t.reference)
 			if err != nil {
-				return nil
+				return nil, err
 			}
 
 			if !match {
 				continue
 			}
+		}
 
-			idmap[id]++
+		state := &app.State{}
+		if t.valid {
+			state = r.processState(t)
 		}
 
-		count++
+		if _, ok := states[t.reference]; !ok {
+			states[t.reference] = map[string]*app.State{}
+		}
+
+		states[t.reference][id] = state
 	}
 
-	if len(refpattern) != 0 {
-		for _, t := range r.tasks {
-			match, err := glob.Match(refpattern, t.reference)
-			if err != nil {
-				return nil
-			}
+	return states, nil
+}
 
-			if !match {
-				continue
-			}
+// GetProcessStateLite returns the state of the process with only the order, the state, the
+// time of the last state change, the exit of the last run, and the current resource usage.
+// The progress, the command, and the last log line are not available. Use it for frequent
+// polling of the state.
+func (r *restream) GetProcessStateLite(id string) (*app.State, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
 
-			idmap[t.id]++
-		}
+	task, ok := r.tasks[id]
+	if !ok {
+		return &app.State{}, ErrUnknownProcess
+	}
 
-		count++
+	if !task.valid {
+		return &app.State{}, nil
 	}
 
-	ids := []string{}
+	return r.processStateLite(task, task.ffmpeg.Status()), nil
+}
 
-	for id, n := range idmap {
-		if n != count {
+// SetMaxProcesses sets the max. number of running processes and returns the previous value. Use
+// 0 for no limit. Lowering it below the number of running processes doesn't stop any process,
+// but no process can be started until enough processes have been stopped.
+func (r *restream) SetMaxProcesses(n int64) (int64, error) {
+	if n < 0 {
+		return 0, fmt.Errorf("the max. number of running processes must not be negative")
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	previous := r.maxProc
+	r.maxProc = n
+
+	return previous, nil
+}
+
+// TotalResourceUsage returns the sum of the current CPU usage in percent and the sum of the
+// current memory consumption in bytes of all running processes.
+func (r *restream) TotalResourceUsage() (cpu float64, memory uint64) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for _, t := range r.tasks {
+		if !t.valid || !t.ffmpeg.IsRunning() {
 			continue
 		}
 
-		ids = append(ids, id)
+		status := t.ffmpeg.Status()
+
+		cpu += status.CPU.Current
+		memory += status.Memory.Current
 	}
 
-	return ids
+	return cpu, memory
 }
 
-func (r *restream) GetProcess(id string) (*app.Process, error) {
+// processStates are the states a process can be in. They are reported as one metric each.
+var processStates = []string{"failed", "finished", "finishing", "killed", "running", "starting"}
+
+// Metrics returns the current metrics of all processes, sorted by process ID, followed
+// by the metrics of this instance.
+func (r *restream) Metrics() []app.Metric {
 	r.lock.RLock()
 	defer r.lock.RUnlock()
 
-	task, ok := r.tasks[id]
-	if !ok {
-		return &app.Process{}, ErrUnknownProcess
+	metrics := []app.Metric{}
+
+	ids := make([]string, 0, len(r.tasks))
+	for id := range r.tasks {
+		ids = append(ids, id)
 	}
 
-	process := task.process.Clone()
+	sort.Strings(ids)
 
-	return process, nil
-}
+	running := 0
 
-func (r *restream) DeleteProcess(id string) error {
-	r.lock.Lock()
-	defer r.lock.Unlock()
+	for _, id := range ids {
+		task := r.tasks[id]
+		if !task.valid {
+			continue
+		}
 
-	err := r.deleteProcess(id)
-	if err != nil {
-		return err
+		state := r.processState(task)
+
+		if state.State == "running" {
+			running++
+		}
+
+		// Number of times the process has been started again
+		reconnects := uint64(0)
+		if state.States.Starting > 1 {
+			reconnects = state.States.Starting - 1
+		}
+
+		labels := map[string]string{"processid": id}
+
+		metrics = append(metrics,
+			app.Metric{Name: "restream_process_cpu_percent", Labels: labels, Value: state.CPU},
+			app.Metric{Name: "restream_process_memory_bytes", Labels: labels, Value: float64(state.Memory)},
+			app.Metric{Name: "restream_process_uptime_seconds", Labels: labels, Value: state.Duration},
+			app.Metric{Name: "restream_process_bitrate", Labels: labels, Value: state.Progress.Bitrate},
+			app.Metric{Name: "restream_process_reconnects", Labels: labels, Value: float64(reconnects)},
+		)
+
+		for _, s := range processStates {
+			value := float64(0)
+			if state.State == s {
+				value = 1
+			}
+
+			metrics = append(metrics, app.Metric{
+				Name:   "restream_process_state",
+				Labels: map[string]string{"processid": id, "state": s},
+				Value:  value,
+			})
+		}
 	}
 
-	r.save()
+	metrics = append(metrics,
+		app.Metric{Name: "restream_processes", Labels: map[string]string{}, Value: float64(len(r.tasks))},
+		app.Metric{Name: "restream_processes_running", Labels: map[string]string{}, Value: float64(running)},
+		app.Metric{Name: "restream_processes_started", Labels: map[string]string{}, Value: float64(r.nProc)},
+	)
 
-	return nil
+	return metrics
+}
+
+// processStateLite returns the state of the task from the status of its process without
+// the progress, the command, and the logs.
+func (r *restream) processStateLite(task *task, status process.Status) *app.State {
+	state := &app.State{}
+
+	state.Order = task.process.Order
+	state.State = status.State
+	state.Time = status.Time.Unix()
+	state.Memory = status.Memory.Current
+	state.CPU = status.CPU.Current
+	state.ExitCode = status.Exit.Code
+	state.ExitSignal = status.Exit.Signal
+
+	// Don't report a failure as long as the process is down for less than the grace period
+	if state.Order == "start" && state.State == "failed" && task.config.Reconnect && task.config.FailureGracePeriod != 0 {
+		task.down.lock.Lock()
+		since := task.down.since
+		task.down.lock.Unlock()
+
+		if !since.IsZero() && time.Since(since) < time.Duration(task.config.FailureGracePeriod)*time.Second {
+			state.State = "starting"
+		}
+	}
+
+	return state
 }
 
-func (r *restream) deleteProcess(id string) error {
-	task, ok := r.tasks[id]
-	if !ok {
-		return ErrUnknownProcess
+// processState returns the current state of the process of a valid task. The caller
+// has to hold the lock.
+func (r *restream) processState(task *task) *app.State {
+	status := task.ffmpeg.Status()
+
+	state := r.processStateLite(task, status)
+
+	state.States.Marshal(status.States)
+	state.Duration = status.Duration.Round(10 * time.Millisecond).Seconds()
+	state.Reconnect = -1
+	state.ReconnectAt = -1
+	state.Command = task.currentCommand()
+	state.Preempted = task.preempted
+
+	task.stale.lock.Lock()
+	state.Stale = task.stale.count
+	task.stale.lock.Unlock()
+
+	if state.Order == "start" && !task.ffmpeg.IsRunning() && task.config.Reconnect {
+		state.Reconnect = float64(task.config.ReconnectDelay) - state.Duration
+
+		if state.Reconnect < 0 {
+			state.Reconnect = 0
+		}
+
+		state.ReconnectAt = time.Now().Add(time.Duration(state.Reconnect * float64(time.Second))).Unix()
 	}
 
-	if task.process.Order != "stop" {
-		return fmt.Errorf("the process with the ID '%s' is still running", id)
-	}
+	state.Progress = task.parser.Progress()
 
-	r.unsetPlayoutPorts(task)
-	r.unsetCleanup(id)
+	// The resolved config doesn't contain the dropped optional inputs, such
+	// that the indices match the inputs of the command.
+	for i, p := range state.Progress.Input {
+		if int(p.Index) >= len(task.config.Input) {
+			continue
+		}
 
-	delete(r.tasks, id)
+		state.Progress.Input[i].ID = task.config.Input[p.Index].ID
+	}
 
-	return nil
-}
+	for i, p := range state.Progress.Output {
+		if int(p.Index) >= len(task.process.Config.Output) {
+			continue
+		}
 
-func (r *restream) StartProcess(id string) error {
-	r.lock.Lock()
-	defer r.lock.Unlock()
+		state.Progress.Output[i].ID = task.process.Config.Output[p.Index].ID
+	}
 
-	err := r.startProcess(id)
-	if err != nil {
-		return err
+	report := task.parser.Report()
+
+	if len(report.Log) != 0 {
+		state.LastLog = report.Log[len(report.Log)-1].Data
 	}
 
-	r.save()
+	return state
+}
 
-	return nil
+func (r *restream) GetProcessLog(id string) (*app.Log, error) {
+	return r.GetProcessLogHistory(id, 0)
 }
 
-func (r *restream) startProcess(id string) error {
+// GetProcessLogHistory returns the log of the process with only the most recent limit
+// entries of the log history. A limit of 0 or less returns the complete log history.
+func (r *restream) GetProcessLogHistory(id string, limit int) (*app.Log, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
 	task, ok := r.tasks[id]
 	if !ok {
-		return ErrUnknownProcess
+		return &app.Log{}, ErrUnknownProcess
 	}
 
 	if !task.valid {
-		return fmt.Errorf("invalid process definition")
+		return &app.Log{}, nil
 	}
 
-	status := task.ffmpeg.Status()
+	log := &app.Log{}
 
-	if task.process.Order == "start" && status.Order == "start" {
-		return nil
-	}
+	current := task.parser.Report()
 
-	if r.maxProc > 0 && r.nProc >= r.maxProc {
-		return fmt.Errorf("max. number of running processes (%d) reached", r.maxProc)
+	log.CreatedAt = current.CreatedAt
+	log.Prelude = current.Prelude
+	log.Log = make([]app.LogEntry, len(current.Log))
+	for i, line := range current.Log {
+		log.Log[i] = app.LogEntry{
+			Timestamp: line.Timestamp,
+			Data:      line.Data,
+		}
 	}
 
-	task.process.Order = "start"
+	history := task.parser.ReportHistory()
 
-	task.ffmpeg.Start()
+	for _, h := range history {
+		e := app.LogHistoryEntry{
+			CreatedAt: h.CreatedAt,
+			Prelude:   h.Prelude,
+		}
 
-	r.nProc++
+		e.Log = make([]app.LogEntry, len(h.Log))
+		for i, line := range h.Log {
+			e.Log[i] = app.LogEntry{
+				Timestamp: line.Timestamp,
+				Data:      line.Data,
+			}
+		}
 
-	return nil
-}
+		log.History = append(log.History, e)
+	}
 
-func (r *restream) StopProcess(id string) error {
-	r.lock.Lock()
-	defer r.lock.Unlock()
+	annotateLog(log, task.annotations)
 
-	err := r.stopProcess(id)
-	if err != nil {
-		return err
+	if limit > 0 && len(log.History) > limit {
+		log.History = log.History[len(log.History)-limit:]
 	}
 
-	r.save()
-
-	return nil
+	return log, nil
 }
 
-func (r *restream) stopProcess(id string) error {
+// ClearProcessLog removes the current log lines, the log history, and the log annotations
+// of a process. FFmpeg keeps running and its new log lines will be collected as before.
+func (r *restream) ClearProcessLog(id string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
 	task, ok := r.tasks[id]
 	if !ok {
 		return ErrUnknownProcess
 	}
 
-	if task.ffmpeg == nil {
+	if !task.valid {
 		return nil
 	}
 
-	status := task.ffmpeg.Status()
-
-	if task.process.Order == "stop" && status.Order == "stop" {
-		return nil
-	}
+	task.parser.Reset()
+	task.annotations = nil
 
-	task.process.Order = "stop"
+	return nil
+}
 
-	task.ffmpeg.Stop(true)
+// annotateLog adds every annotation to the first entry of the log history that started after
+// the annotation. The annotations that happened after the start of the last entry are added
+// to the current log.
+func annotateLog(log *app.Log, annotations []app.LogAnnotation) {
+next:
+	for _, a := range annotations {
+		for i, e := range log.History {
+			if e.CreatedAt.Before(a.Timestamp) {
+				continue
+			}
 
-	r.nProc--
+			log.History[i].Annotations = append(log.History[i].Annotations, a)
+			continue next
+		}
 
-	return nil
+		log.Annotations = append(log.Annotations, a)
+	}
 }
 
-func (r *restream) RestartProcess(id string) error {
+func (r *restream) StreamProcessLog(ctx context.Context, id string) (<-chan app.LogEntry, error) {
 	r.lock.RLock()
 	defer r.lock.RUnlock()
 
-	return r.restartProcess(id)
-}
-
-func (r *restream) restartProcess(id string) error {
 	task, ok := r.tasks[id]
 	if !ok {
-		return ErrUnknownProcess
-	}
-
-	if !task.valid {
-		return fmt.Errorf("invalid process definition")
-	}
-
-	if task.process.Order == "stop" {
-		return nil
+		return nil, ErrUnknownProcess
 	}
 
-	task.ffmpeg.Kill(true)
-
-	return nil
+	return task.logs.Subscribe(ctx), nil
 }
 
-func (r *restream) ReloadProcess(id string) error {
-	r.lock.Lock()
-	defer r.lock.Unlock()
+// WatchProcess returns a channel that receives the state of the process each time it
+// changes, until the context is cancelled. If the process is deleted, the channel
+// will be closed.
+func (r *restream) WatchProcess(ctx context.Context, id string) (<-chan *app.State, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
 
-	err := r.reloadProcess(id)
-	if err != nil {
-		return err
+	task, ok := r.tasks[id]
+	if !ok {
+		return nil, ErrUnknownProcess
 	}
 
-	r.save()
-
-	return nil
+	return task.states.Subscribe(ctx), nil
 }
 
-func (r *restream) reloadProcess(id string) error {
-	t, ok := r.tasks[id]
-	if !ok {
-		return ErrUnknownProcess
-	}
-
-	t.valid = false
+func (r *restream) Probe(id string) app.Probe {
+	return r.ProbeWithTimeout(id, r.probeTimeout)
+}
 
-	t.config = t.process.Config.Clone()
+func (r *restream) ProbeWithTimeout(id string, timeout time.Duration) app.Probe {
+	r.lock.RLock()
 
-	resolvePlaceholders(t.config, r.replace)
+	appprobe := app.Probe{}
 
-	err := r.resolveAddresses(r.tasks, t.config)
-	if err != nil {
-		return err
+	task, ok := r.tasks[id]
+	if !ok {
+		appprobe.Log = append(appprobe.Log, fmt.Sprintf("Unknown process ID (%s)", id))
+		r.lock.RUnlock()
+		return appprobe
 	}
 
-	t.usesDisk, err = r.validateConfig(t.config)
-	if err != nil {
-		return err
-	}
+	// The config of the task is replaced by a reload
+	valid := task.valid
+	config := task.config.Clone()
 
-	err = r.setPlayoutPorts(t)
-	if err != nil {
-		return err
+	r.lock.RUnlock()
+
+	if !valid {
+		return appprobe
 	}
 
-	t.command = t.config.CreateCommand()
+	command := probeCommand(config)
 
-	order := "stop"
-	if t.process.Order == "start" {
-		order = "start"
-		r.stopProcess(id)
+	if probe, ok := r.getCachedProbe(config.FFmpegBinary, command); ok {
+		return probe
 	}
 
-	t.parser = r.ffmpeg.NewProcessParser(t.logger, t.id, t.reference)
-
-	ffmpeg, err := r.ffmpeg.New(ffmpeg.ProcessConfig{
-		Reconnect:      t.config.Reconnect,
-		ReconnectDelay: time.Duration(t.config.ReconnectDelay) * time.Second,
-		StaleTimeout:   time.Duration(t.config.StaleTimeout) * time.Second,
-		LimitCPU:       t.config.LimitCPU,
-		LimitMemory:    t.config.LimitMemory,
-		LimitDuration:  time.Duration(t.config.LimitWaitFor) * time.Second,
-		Command:        t.command,
-		Parser:         t.parser,
-		Logger:         t.logger,
-	})
+	ff, err := r.ffmpegFor(config)
 	if err != nil {
-		return err
+		appprobe.Log = append(appprobe.Log, err.Error())
+		return appprobe
 	}
 
-	t.ffmpeg = ffmpeg
-	t.valid = true
+	appprobe = r.probe(ff, command, task.logger, timeout)
 
-	if order == "start" {
-		r.startProcess(id)
+	if len(appprobe.Streams) != 0 {
+		r.setCachedProbe(config.FFmpegBinary, command, appprobe)
 	}
 
-	return nil
+	return appprobe
 }
 
-func (r *restream) GetProcessState(id string) (*app.State, error) {
-	state := &app.State{}
-
-	r.lock.RLock()
-	defer r.lock.RUnlock()
+// RunProcessOnce runs FFmpeg with the given command for the process and returns its
+// log after it exited or after the timeout. The config and the managed FFmpeg process
+// of the task are not affected. While the command is running, it counts towards the
+// max. number of processes. The outputs of the command must be valid outputs for the
+// process.
+func (r *restream) RunProcessOnce(id string, command []string, timeout time.Duration) (*app.Log, error) {
+	if timeout <= 0 {
+		return nil, fmt.Errorf("the timeout for running the process '%s' once must be positive", id)
+	}
 
+	r.lock.Lock()
 	task, ok := r.tasks[id]
 	if !ok {
-		return state, ErrUnknownProcess
-	}
-
-	if !task.valid {
-		return state, nil
+		r.lock.Unlock()
+		return nil, ErrUnknownProcess
 	}
 
-	status := task.ffmpeg.Status()
-
-	state.Order = task.process.Order
-	state.State = status.State
-	state.States.Marshal(status.States)
-	state.Time = status.Time.Unix()
-	state.Memory = status.Memory.Current
-	state.CPU = status.CPU.Current
-	state.Duration = status.Duration.Round(10 * time.Millisecond).Seconds()
-	state.Reconnect = -1
-	state.Command = make([]string, len(task.command))
-	copy(state.Command, task.command)
-
-	if state.Order == "start" && !task.ffmpeg.IsRunning() && task.config.Reconnect {
-		state.Reconnect = float64(task.config.ReconnectDelay) - state.Duration
-
-		if state.Reconnect < 0 {
-			state.Reconnect = 0
+	for _, address := range commandOutputs(command) {
+		if _, _, err := r.checkOutputAddress(address, "", workingDir(task.config)); err != nil {
+			r.lock.Unlock()
+			return nil, newValidationError(addressErrorKind(err), id, "output", "", "address", "the output '%s' of the command for the process '%s' is invalid: %w", address, id, err)
 		}
 	}
 
-	state.Progress = task.parser.Progress()
-
-	for i, p := range state.Progress.Input {
-		if int(p.Index) >= len(task.process.Config.Input) {
-			continue
-		}
+	if r.maxProc > 0 && r.nProc >= r.maxProc {
+		r.lock.Unlock()
+		return nil, fmt.Errorf("max. number of running processes (%d) reached", r.maxProc)
+	}
 
-		state.Progress.Input[i].ID = task.process.Config.Input[p.Index].ID
+	ff, err := r.ffmpegFor(task.config)
+	if err != nil {
+		r.lock.Unlock()
+		return nil, err
 	}
 
-	for i, p := range state.Progress.Output {
-		if int(p.Index) >= len(task.process.Config.Output) {
-			continue
-		}
+	r.nProc++
+	r.lock.Unlock()
 
-		state.Progress.Output[i].ID = task.process.Config.Output[p.Index].ID
-	}
+	defer func() {
+		r.lock.Lock()
+		r.nProc--
+		r.lock.Unlock()
+	}()
 
-	report := task.parser.Report()
+	parser := ff.NewProcessParser(task.logger, task.id, task.reference)
 
-	if len(report.Log) != 0 {
-		state.LastLog = report.Log[len(report.Log)-1].Data
-	}
+	var wg sync.WaitGroup
 
-	return state, nil
-}
+	wg.Add(1)
 
-func (r *restream) GetProcessLog(id string) (*app.Log, error) {
-	r.lock.RLock()
-	defer r.lock.RUnlock()
+	ffmpeg, err := ff.New(ffmpeg.ProcessConfig{
+		Reconnect:   false,
+		StopTimeout: r.stopTimeout,
+		Command:     command,
+		Parser:      parser,
+		Logger:      task.logger.WithField("once", true),
+		OnExit: func() {
+			wg.Done()
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	task, ok := r.tasks[id]
-	if !ok {
-		return &app.Log{}, ErrUnknownProcess
+	if err := ffmpeg.Start(); err != nil {
+		return nil, err
 	}
 
-	if !task.valid {
-		return &app.Log{}, nil
-	}
+	timer := time.AfterFunc(timeout, func() {
+		ffmpeg.Stop(false)
+	})
 
-	log := &app.Log{}
+	wg.Wait()
 
-	current := task.parser.Report()
+	timer.Stop()
 
-	log.CreatedAt = current.CreatedAt
-	log.Prelude = current.Prelude
-	log.Log = make([]app.LogEntry, len(current.Log))
-	for i, line := range current.Log {
+	report := parser.Report()
+
+	log := &app.Log{}
+	log.CreatedAt = report.CreatedAt
+	log.Prelude = report.Prelude
+	log.Log = make([]app.LogEntry, len(report.Log))
+	for i, line := range report.Log {
 		log.Log[i] = app.LogEntry{
 			Timestamp: line.Timestamp,
 			Data:      line.Data,
 		}
 	}
 
-	history := task.parser.ReportHistory()
+	return log, nil
+}
 
-	for _, h := range history {
-		e := app.LogHistoryEntry{
-			CreatedAt: h.CreatedAt,
-			Prelude:   h.Prelude,
-		}
+// flagOptions are the options of FFmpeg that don't have a value
+var flagOptions = map[string]struct{}{
+	"-y": {}, "-n": {}, "-re": {}, "-stdin": {}, "-nostdin": {}, "-hide_banner": {}, "-stats": {}, "-nostats": {},
+	"-shortest": {}, "-an": {}, "-vn": {}, "-sn": {}, "-dn": {}, "-copyts": {}, "-start_at_zero": {}, "-xerror": {},
+	"-benchmark": {}, "-benchmark_all": {}, "-dump": {}, "-hex": {}, "-ignore_unknown": {}, "-copy_unknown": {},
+	"-debug_ts": {}, "-autorotate": {}, "-noautorotate": {}, "-accurate_seek": {}, "-noaccurate_seek": {},
+	"-seek_timestamp": {}, "-fix_sub_duration": {}, "-find_stream_info": {}, "-bitexact": {}, "-vstats": {},
+	"-qphist": {}, "-intra": {}, "-psnr": {}, "-report": {},
+}
 
-		e.Log = make([]app.LogEntry, len(h.Log))
-		for i, line := range h.Log {
-			e.Log[i] = app.LogEntry{
-				Timestamp: line.Timestamp,
-				Data:      line.Data,
+// commandOutputs returns the addresses of the outputs of the FFmpeg command. These are the
+// arguments that are neither an option, nor the value of an option, nor an input. The last
+// argument is always considered to be an output.
+func commandOutputs(command []string) []string {
+	outputs := []string{}
+
+	for i := 0; i < len(command); i++ {
+		arg := command[i]
+
+		if len(arg) > 1 && arg[0] == '-' {
+			name := strings.SplitN(arg, ":", 2)[0]
+			if _, ok := flagOptions[name]; !ok {
+				// The value of the option, for -i the address of an input
+				i++
 			}
+
+			continue
 		}
 
-		log.History = append(log.History, e)
+		outputs = append(outputs, arg)
 	}
 
-	return log, nil
-}
+	if len(command) != 0 {
+		last := command[len(command)-1]
+		if len(outputs) == 0 || outputs[len(outputs)-1] != last {
+			outputs = append(outputs, last)
+		}
+	}
 
-func (r *restream) Probe(id string) app.Probe {
-	return r.ProbeWithTimeout(id, 20*time.Second)
+	return outputs
 }
 
-func (r *restream) ProbeWithTimeout(id string, timeout time.Duration) app.Probe {
-	r.lock.RLock()
-
+// probe runs the probe command and waits until it exited or the timeout is reached.
+func (r *restream) probe(ff ffmpeg.FFmpeg, command []string, logger log.Logger, timeout time.Duration) app.Probe {
 	appprobe := app.Probe{}
 
-	task, ok := r.tasks[id]
-	if !ok {
-		appprobe.Log = append(appprobe.Log, fmt.Sprintf("Unknown process ID (%s)", id))
-		r.lock.RUnlock()
-		return appprobe
-	}
-
-	r.lock.RUnlock()
+	prober := ff.NewProbeParser(logger)
 
-	if !task.valid {
-		return appprobe
-	}
+	var parser process.Parser = prober
+	var onArgs func([]string) []string
 
-	var command []string
+	if r.secrets != nil && usesSecrets(command) {
+		secrets := newSecrets(r.secrets)
+		parser = &secretParser{Parser: prober, secrets: secrets}
 
-	// Copy global options
-	command = append(command, task.config.Options...)
+		onArgs = func(args []string) []string {
+			args, err := secrets.Resolve(args)
+			if err != nil {
+				logger.Error().WithError(err).Log("")
+			}
 
-	for _, input := range task.config.Input {
-		// Add the resolved input to the process command
-		command = append(command, input.Options...)
-		command = append(command, "-i", input.Address)
+			return args
+		}
 	}
 
-	prober := r.ffmpeg.NewProbeParser(task.logger)
-
 	var wg sync.WaitGroup
 
 	wg.Add(1)
 
-	ffmpeg, err := r.ffmpeg.New(ffmpeg.ProcessConfig{
+	ffmpeg, err := ff.New(ffmpeg.ProcessConfig{
 		Reconnect:      false,
 		ReconnectDelay: 0,
 		StaleTimeout:   timeout,
 		Command:        command,
-		Parser:         prober,
-		Logger:         task.logger,
+		Parser:         parser,
+		Logger:         logger,
 		OnExit: func() {
 			wg.Done()
 		},
+		OnArgs: onArgs,
 	})
 
 	if err != nil {
@@ -1378,9 +6277,125 @@ func (r *restream) ProbeWithTimeout(id string, timeout time.Duration) app.Probe
 
 	wg.Wait()
 
-	appprobe = prober.Probe()
+	return prober.Probe()
+}
 
-	return appprobe
+// checkConnectivity probes the inputs of the task and returns an error if no
+// stream could be found within the timeout.
+func (r *restream) checkConnectivity(t *task, timeout time.Duration) error {
+	ff, err := r.ffmpegFor(t.config)
+	if err != nil {
+		return err
+	}
+
+	appprobe := r.probe(ff, probeCommand(t.config), t.logger, timeout)
+
+	if len(appprobe.Streams) != 0 {
+		return nil
+	}
+
+	reason := "no streams found"
+	if len(appprobe.Log) != 0 {
+		reason = appprobe.Log[len(appprobe.Log)-1]
+	}
+
+	return fmt.Errorf("%w for the process '%s': %s", ErrInputUnreachable, t.id, reason)
+}
+
+type probeCacheEntry struct {
+	probe   app.Probe
+	expires time.Time
+}
+
+// probeCommand returns the FFmpeg command for probing the inputs of the config.
+func probeCommand(config *app.Config) []string {
+	var command []string
+
+	// Copy global options
+	command = append(command, config.Options...)
+
+	for _, input := range config.Input {
+		// Add the resolved input to the process command
+		command = append(command, probeInputCommand(input)...)
+	}
+
+	return command
+}
+
+// probeInputCommand returns the options and the address for probing the input.
+func probeInputCommand(input app.ConfigIO) []string {
+	command := append([]string{}, input.Options...)
+
+	return append(command, "-i", input.Address)
+}
+
+// probeCacheKey returns the key of the probe of the command with the FFmpeg binary. The
+// same command might find different streams with a different binary.
+func probeCacheKey(binary string, command []string) string {
+	return binary + "\x00\x00" + strings.Join(command, "\x00")
+}
+
+func (r *restream) getCachedProbe(binary string, command []string) (app.Probe, bool) {
+	if r.probeCache.ttl <= 0 {
+		return app.Probe{}, false
+	}
+
+	r.probeCache.lock.Lock()
+	defer r.probeCache.lock.Unlock()
+
+	key := probeCacheKey(binary, command)
+
+	entry, ok := r.probeCache.entries[key]
+	if !ok {
+		return app.Probe{}, false
+	}
+
+	if time.Now().After(entry.expires) {
+		delete(r.probeCache.entries, key)
+		return app.Probe{}, false
+	}
+
+	return entry.probe, true
+}
+
+func (r *restream) setCachedProbe(binary string, command []string, probe app.Probe) {
+	if r.probeCache.ttl <= 0 {
+		return
+	}
+
+	r.probeCache.lock.Lock()
+	defer r.probeCache.lock.Unlock()
+
+	now := time.Now()
+
+	// Remove all expired entries
+	for key, entry := range r.probeCache.entries {
+		if now.After(entry.expires) {
+			delete(r.probeCache.entries, key)
+		}
+	}
+
+	r.probeCache.entries[probeCacheKey(binary, command)] = probeCacheEntry{
+		probe:   probe,
+		expires: now.Add(r.probeCache.ttl),
+	}
+}
+
+func (r *restream) InvalidateProbe(id string) {
+	r.lock.RLock()
+	task, ok := r.tasks[id]
+	if !ok || !task.valid {
+		r.lock.RUnlock()
+		return
+	}
+
+	key := probeCacheKey(task.config.FFmpegBinary, probeCommand(task.config))
+	r.lock.RUnlock()
+
+	r.probeCache.lock.Lock()
+	defer r.probeCache.lock.Unlock()
+
+	delete(r.probeCache.entries, key)
 }
 
 func (r *restream) Skills() skills.Skills {
@@ -1412,6 +6427,36 @@ func (r *restream) GetPlayout(id, inputid string) (string, error) {
 	return "127.0.0.1:" + strconv.Itoa(port), nil
 }
 
+// DemandPlayout returns the address of the playout API for an input of a process like GetPlayout.
+// A process on demand is started and stays started until the returned release function has been
+// called and the idle timeout passed. For any other process the release function does nothing.
+//
+// The keyframe endpoint of the playout API is the only endpoint that demands a process. All other
+// endpoints of the playout API, as well as the outputs of a process, don't start a process on demand.
+func (r *restream) DemandPlayout(id, inputid string) (string, func(), error) {
+	var addr string
+
+	release, err := r.demand(id, func(t *task) error {
+		if !t.valid {
+			return fmt.Errorf("invalid process definition")
+		}
+
+		port, ok := t.playout[inputid]
+		if !ok {
+			return fmt.Errorf("no playout for input ID '%s' and process '%s'", inputid, id)
+		}
+
+		addr = "127.0.0.1:" + strconv.Itoa(port)
+
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return addr, release, nil
+}
+
 var ErrMetadataKeyNotFound = errors.New("unknown key")
 
 func (r *restream) SetProcessMetadata(id, key string, data interface{}) error {
@@ -1422,23 +6467,28 @@ func (r *restream) SetProcessMetadata(id, key string, data interface{}) error {
 		return fmt.Errorf("a key for storing the data has to be provided")
 	}
 
+	if isReservedMetadataKey(key) {
+		return fmt.Errorf("%w: %s", ErrReservedMetadataKey, key)
+	}
+
 	task, ok := r.tasks[id]
 	if !ok {
 		return ErrUnknownProcess
 	}
 
-	if task.metadata == nil {
-		task.metadata = make(map[string]interface{})
+	if data == nil && task.usesMetadata(key) {
+		return fmt.Errorf("the metadata '%s' is used by the config of the process '%s'", key, id)
 	}
 
-	if data == nil {
-		delete(task.metadata, key)
-	} else {
-		task.metadata[key] = data
-	}
+	task.setMetadata(key, data)
+	r.markChanged(id)
 
-	if len(task.metadata) == 0 {
-		task.metadata = nil
+	// The command depends on the metadata
+	if task.usesMetadata(key) {
+		if err := r.reloadProcess(id); err != nil {
+			r.save()
+			return err
+		}
 	}
 
 	r.save()
@@ -1446,6 +6496,134 @@ func (r *restream) SetProcessMetadata(id, key string, data interface{}) error {
 	return nil
 }
 
+// SetProcessMetadataBulk sets the data for the key to all processes with the given IDs and
+// returns the IDs of the updated processes. Unknown IDs are either skipped or the whole
+// operation fails without changes, depending on the BulkSkipUnknown config.
+func (r *restream) SetProcessMetadataBulk(ids []string, key string, data interface{}) ([]string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if len(key) == 0 {
+		return nil, fmt.Errorf("a key for storing the data has to be provided")
+	}
+
+	if isReservedMetadataKey(key) {
+		return nil, fmt.Errorf("%w: %s", ErrReservedMetadataKey, key)
+	}
+
+	tasks := []*task{}
+	unknown := []string{}
+	seen := map[string]struct{}{}
+
+	for _, id := range ids {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+
+		seen[id] = struct{}{}
+
+		task, ok := r.tasks[id]
+		if !ok {
+			unknown = append(unknown, id)
+			continue
+		}
+
+		tasks = append(tasks, task)
+	}
+
+	if len(unknown) != 0 && !r.bulkSkipUnknown {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProcess, strings.Join(unknown, ", "))
+	}
+
+	if data == nil {
+		for _, task := range tasks {
+			if task.usesMetadata(key) {
+				return nil, fmt.Errorf("the metadata '%s' is used by the config of the process '%s'", key, task.id)
+			}
+		}
+	}
+
+	updated := []string{}
+	var err error
+
+	for _, task := range tasks {
+		task.setMetadata(key, data)
+		r.markChanged(task.id)
+		updated = append(updated, task.id)
+
+		// The command depends on the metadata
+		if task.usesMetadata(key) {
+			if rerr := r.reloadProcess(task.id); rerr != nil && err == nil {
+				err = rerr
+			}
+		}
+	}
+
+	if len(updated) != 0 {
+		r.save()
+	}
+
+	return updated, err
+}
+
+// usesMetadata returns whether the config of the process has a placeholder for the metadata key.
+func (t *task) usesMetadata(key string) bool {
+	for _, k := range unresolvedMetadata(t.process.Config) {
+		if k == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// metadataString returns the metadata as string for a placeholder. Other data than
+// strings are encoded as JSON.
+func metadataString(data interface{}) string {
+	if s, ok := data.(string); ok {
+		return s
+	}
+
+	value, err := json.Marshal(data)
+	if err != nil {
+		return ""
+	}
+
+	return string(value)
+}
+
+// maxLogAnnotations is the max. number of log annotations that are kept for each process.
+const maxLogAnnotations = 100
+
+// annotate records a marker with the reason for the log of the task.
+func (t *task) annotate(reason string) {
+	t.annotations = append(t.annotations, app.LogAnnotation{
+		Timestamp: time.Now(),
+		Reason:    reason,
+	})
+
+	if len(t.annotations) > maxLogAnnotations {
+		t.annotations = t.annotations[len(t.annotations)-maxLogAnnotations:]
+	}
+}
+
+// setMetadata sets the data for the key. If data is nil, the key will be removed.
+func (t *task) setMetadata(key string, data interface{}) {
+	if t.metadata == nil {
+		t.metadata = make(map[string]interface{})
+	}
+
+	if data == nil {
+		delete(t.metadata, key)
+	} else {
+		t.metadata[key] = data
+	}
+
+	if len(t.metadata) == 0 {
+		t.metadata = nil
+	}
+}
+
 func (r *restream) GetProcessMetadata(id, key string) (interface{}, error) {
 	r.lock.RLock()
 	defer r.lock.RUnlock()
@@ -1456,11 +6634,11 @@ func (r *restream) GetProcessMetadata(id, key string) (interface{}, error) {
 	}
 
 	if len(key) == 0 {
-		return task.metadata, nil
+		return publicMetadata(task.metadata), nil
 	}
 
 	data, ok := task.metadata[key]
-	if !ok {
+	if !ok || isReservedMetadataKey(key) {
 		return nil, ErrMetadataKeyNotFound
 	}
 
@@ -1475,6 +6653,10 @@ func (r *restream) SetMetadata(key string, data interface{}) error {
 		return fmt.Errorf("a key for storing the data has to be provided")
 	}
 
+	if isReservedMetadataKey(key) {
+		return fmt.Errorf("%w: %s", ErrReservedMetadataKey, key)
+	}
+
 	if r.metadata == nil {
 		r.metadata = make(map[string]interface{})
 	}
@@ -1489,6 +6671,7 @@ func (r *restream) SetMetadata(key string, data interface{}) error {
 		r.metadata = nil
 	}
 
+	r.markSystemChanged()
 	r.save()
 
 	return nil
@@ -1499,27 +6682,127 @@ func (r *restream) GetMetadata(key string) (interface{}, error) {
 	defer r.lock.RUnlock()
 
 	if len(key) == 0 {
-		return r.metadata, nil
+		return publicMetadata(r.metadata), nil
 	}
 
 	data, ok := r.metadata[key]
-	if !ok {
+	if !ok || isReservedMetadataKey(key) {
 		return nil, ErrMetadataKeyNotFound
 	}
 
 	return data, nil
 }
 
+// builtinPlaceholders are the placeholders that are resolved without a registered template.
+// "vars:*" and "metadata:*" stand for all variables and metadata keys. "sequence" is
+// resolved on every start of the process, see resolveSequence.
+var builtinPlaceholders = []string{"processid", "reference", "inputid", "outputid", "sequence", "vars:*", "metadata:*"}
+
+// SupportedPlaceholders returns the sorted names of all placeholders that can be used
+// in a process config, including the placeholders with a registered template.
+func (r *restream) SupportedPlaceholders() []string {
+	names := map[string]struct{}{}
+
+	for _, name := range builtinPlaceholders {
+		names[name] = struct{}{}
+	}
+
+	for _, name := range r.replace.Templates() {
+		names[name] = struct{}{}
+	}
+
+	if r.secrets != nil {
+		names["secret:*"] = struct{}{}
+	}
+
+	list := make([]string, 0, len(names))
+	for name := range names {
+		list = append(list, name)
+	}
+
+	sort.Strings(list)
+
+	return list
+}
+
+// resolveConfig replaces all placeholders in the config and adds the global options of the
+// instance to the global options of the config.
+func (r *restream) resolveConfig(config *app.Config, metadata map[string]interface{}) {
+	resolvePlaceholders(config, r.replace, metadata)
+
+	config.Options = mergeGlobalOptions(r.globalOptions, config.Options)
+}
+
+// mergeGlobalOptions returns the options with the global options in front. An option is
+// the name of the option together with its values, e.g. "-init_hw_device vaapi". Global
+// options that are already part of the options are not added.
+func mergeGlobalOptions(global, options []string) []string {
+	if len(global) == 0 {
+		return options
+	}
+
+	existing := map[string]struct{}{}
+	for _, option := range splitOptions(options) {
+		existing[strings.Join(option, "\x00")] = struct{}{}
+	}
+
+	merged := []string{}
+
+	for _, option := range splitOptions(global) {
+		if _, ok := existing[strings.Join(option, "\x00")]; ok {
+			continue
+		}
+
+		merged = append(merged, option...)
+	}
+
+	return append(merged, options...)
+}
+
+// splitOptions splits the options into the names of the options together with their values.
+func splitOptions(options []string) [][]string {
+	split := [][]string{}
+
+	for _, option := range options {
+		if len(split) == 0 || strings.HasPrefix(option, "-") {
+			split = append(split, []string{option})
+			continue
+		}
+
+		split[len(split)-1] = append(split[len(split)-1], option)
+	}
+
+	return split
+}
+
 // resolvePlaceholders replaces all placeholders in the config. The config
 // will be modified in place.
-func resolvePlaceholders(config *app.Config, r replace.Replacer) {
+func resolvePlaceholders(config *app.Config, r replace.Replacer, metadata map[string]interface{}) {
 	vars := map[string]string{
 		"processid": config.ID,
 		"reference": config.Reference,
 	}
 
+	// Replace the variables and the metadata of the process, e.g. {vars:region} or {metadata:title}
+	resolveVars := func(str, section string) string {
+		for name, value := range config.Vars {
+			str = r.Replace(str, "vars:"+name, value, nil, nil, section)
+		}
+
+		for key, data := range metadata {
+			if isReservedMetadataKey(key) {
+				continue
+			}
+
+			str = r.Replace(str, "metadata:"+key, metadataString(data), nil, nil, section)
+		}
+
+		return str
+	}
+
 	for i, option := range config.Options {
 		// Replace any known placeholders
+		option = resolveVars(option, "global")
 		option = r.Replace(option, "diskfs", "", vars, config, "global")
 		option = r.Replace(option, "fs:*", "", vars, config, "global")
 
@@ -1534,17 +6817,29 @@ func resolvePlaceholders(config *app.Config, r replace.Replacer) {
 
 		vars["inputid"] = input.ID
 
-		input.Address = r.Replace(input.Address, "inputid", input.ID, nil, nil, "input")
-		input.Address = r.Replace(input.Address, "processid", config.ID, nil, nil, "input")
-		input.Address = r.Replace(input.Address, "reference", config.Reference, nil, nil, "input")
-		input.Address = r.Replace(input.Address, "diskfs", "", vars, config, "input")
-		input.Address = r.Replace(input.Address, "memfs", "", vars, config, "input")
-		input.Address = r.Replace(input.Address, "fs:*", "", vars, config, "input")
-		input.Address = r.Replace(input.Address, "rtmp", "", vars, config, "input")
-		input.Address = r.Replace(input.Address, "srt", "", vars, config, "input")
+		resolveAddress := func(address string) string {
+			address = resolveVars(address, "input")
+			address = r.Replace(address, "inputid", input.ID, nil, nil, "input")
+			address = r.Replace(address, "processid", config.ID, nil, nil, "input")
+			address = r.Replace(address, "reference", config.Reference, nil, nil, "input")
+			address = r.Replace(address, "diskfs", "", vars, config, "input")
+			address = r.Replace(address, "memfs", "", vars, config, "input")
+			address = r.Replace(address, "fs:*", "", vars, config, "input")
+			address = r.Replace(address, "rtmp", "", vars, config, "input")
+			address = r.Replace(address, "srt", "", vars, config, "input")
+
+			return address
+		}
+
+		input.Address = resolveAddress(input.Address)
+
+		for j, address := range input.Addresses {
+			input.Addresses[j] = resolveAddress(address)
+		}
 
 		for j, option := range input.Options {
 			// Replace any known placeholders
+			option = resolveVars(option, "input")
 			option = r.Replace(option, "inputid", input.ID, nil, nil, "input")
 			option = r.Replace(option, "processid", config.ID, nil, nil, "input")
 			option = r.Replace(option, "reference", config.Reference, nil, nil, "input")
@@ -1568,6 +6863,7 @@ func resolvePlaceholders(config *app.Config, r replace.Replacer) {
 
 		vars["outputid"] = output.ID
 
+		output.Address = resolveVars(output.Address, "output")
 		output.Address = r.Replace(output.Address, "outputid", output.ID, nil, nil, "output")
 		output.Address = r.Replace(output.Address, "processid", config.ID, nil, nil, "output")
 		output.Address = r.Replace(output.Address, "reference", config.Reference, nil, nil, "output")
@@ -1579,6 +6875,7 @@ func resolvePlaceholders(config *app.Config, r replace.Replacer) {
 
 		for j, option := range output.Options {
 			// Replace any known placeholders
+			option = resolveVars(option, "output")
 			option = r.Replace(option, "outputid", output.ID, nil, nil, "output")
 			option = r.Replace(option, "processid", config.ID, nil, nil, "output")
 			option = r.Replace(option, "reference", config.Reference, nil, nil, "output")
@@ -1591,6 +6888,7 @@ func resolvePlaceholders(config *app.Config, r replace.Replacer) {
 
 		for j, cleanup := range output.Cleanup {
 			// Replace any known placeholders
+			cleanup.Pattern = resolveVars(cleanup.Pattern, "output")
 			cleanup.Pattern = r.Replace(cleanup.Pattern, "outputid", output.ID, nil, nil, "output")
 			cleanup.Pattern = r.Replace(cleanup.Pattern, "processid", config.ID, nil, nil, "output")
 			cleanup.Pattern = r.Replace(cleanup.Pattern, "reference", config.Reference, nil, nil, "output")
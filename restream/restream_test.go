@@ -1,15 +1,35 @@
 package restream
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/datarhei/core/v16/ffmpeg"
+	"github.com/datarhei/core/v16/ffmpeg/parse"
+	"github.com/datarhei/core/v16/ffmpeg/skills"
 	"github.com/datarhei/core/v16/internal/testhelper"
+	"github.com/datarhei/core/v16/io/fs"
+	"github.com/datarhei/core/v16/log"
 	"github.com/datarhei/core/v16/net"
+	"github.com/datarhei/core/v16/process"
 	"github.com/datarhei/core/v16/restream/app"
+	rfs "github.com/datarhei/core/v16/restream/fs"
 	"github.com/datarhei/core/v16/restream/replace"
+	"github.com/datarhei/core/v16/restream/store"
 
 	"github.com/stretchr/testify/require"
 )
@@ -79,6 +99,41 @@ func getDummyProcess() *app.Config {
 	}
 }
 
+// dummyFFmpeg wraps a FFmpeg and records the configs of all created processes
+type dummyFFmpeg struct {
+	ffmpeg.FFmpeg
+
+	configs []ffmpeg.ProcessConfig
+	parsers int
+	lock    sync.Mutex
+}
+
+func (f *dummyFFmpeg) NewProcessParser(logger log.Logger, id, reference string) parse.Parser {
+	f.lock.Lock()
+	f.parsers++
+	f.lock.Unlock()
+
+	return f.FFmpeg.NewProcessParser(logger, id, reference)
+}
+
+func (f *dummyFFmpeg) New(config ffmpeg.ProcessConfig) (process.Process, error) {
+	f.lock.Lock()
+	f.configs = append(f.configs, config)
+	f.lock.Unlock()
+
+	return f.FFmpeg.New(config)
+}
+
+func (f *dummyFFmpeg) Configs() []ffmpeg.ProcessConfig {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	configs := make([]ffmpeg.ProcessConfig, len(f.configs))
+	copy(configs, f.configs)
+
+	return configs
+}
+
 func TestAddProcess(t *testing.T) {
 	rs, err := getDummyRestreamer(nil, nil, nil, nil)
 	require.NoError(t, err)
@@ -419,6 +474,89 @@ func TestProbeProcess(t *testing.T) {
 	require.Equal(t, 3, len(probe.Streams))
 }
 
+func TestProbeRaw(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+	process.Input = append(process.Input, app.ConfigIO{
+		ID:      "in2",
+		Address: "anullsrc=r=44100:cl=stereo",
+		Options: []string{"-f", "lavfi"},
+	})
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	_, err = rs.ProbeRaw(process.ID, 5*time.Second)
+	require.Error(t, err, "no ffprobe binary has been provided")
+
+	// The fake ffprobe reports the probed address and fails for unreachable addresses
+	ffprobe := filepath.Join(t.TempDir(), "ffprobe")
+	err = os.WriteFile(ffprobe, []byte(`#!/bin/sh
+for address; do :; done
+case "$address" in
+*unreachable*) echo "$address: Connection refused" >&2; exit 1;;
+esac
+echo "{\"format\":{\"filename\":\"$address\"},\"streams\":[{\"index\":0,\"side_data_list\":[]}],\"args\":\"$*\"}"
+`), 0755)
+	require.NoError(t, err)
+
+	rs.ffprobe = ffprobe
+
+	_, err = rs.ProbeRaw("foobar", 5*time.Second)
+	require.ErrorIs(t, err, ErrUnknownProcess)
+
+	data, err := rs.ProbeRaw(process.ID, 5*time.Second)
+	require.NoError(t, err)
+
+	probes := []struct {
+		Format struct {
+			Filename string `json:"filename"`
+		} `json:"format"`
+		Streams []map[string]interface{} `json:"streams"`
+		Args    string                   `json:"args"`
+	}{}
+
+	err = json.Unmarshal(data, &probes)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(probes))
+
+	require.Equal(t, "testsrc=size=1280x720:rate=25", probes[0].Format.Filename)
+	require.Equal(t, "-v error -print_format json -show_format -show_streams -f lavfi -re -i testsrc=size=1280x720:rate=25", probes[0].Args)
+	require.Contains(t, probes[0].Streams[0], "side_data_list", "fields that are not part of the probe are kept")
+	require.Equal(t, "anullsrc=r=44100:cl=stereo", probes[1].Format.Filename)
+
+	process.Input[1].Address = "rtmp://example.com/live/unreachable"
+
+	err = rs.UpdateProcess(process.ID, process)
+	require.NoError(t, err)
+
+	_, err = rs.ProbeRaw(process.ID, 5*time.Second)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "rtmp://example.com/live/unreachable: Connection refused")
+
+	// Probing while the process is reloaded must not read the replaced config
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 10; i++ {
+			rs.ReloadProcess(process.ID)
+		}
+	}()
+
+	for i := 0; i < 10; i++ {
+		rs.ProbeRaw(process.ID, 5*time.Second)
+		rs.ProbeWithTimeout(process.ID, 5*time.Second)
+	}
+
+	<-done
+}
+
 func TestProcessMetadata(t *testing.T) {
 	rs, err := getDummyRestreamer(nil, nil, nil, nil)
 	require.NoError(t, err)
@@ -501,6 +639,40 @@ func TestLogTransfer(t *testing.T) {
 	require.Equal(t, 1, len(log.History))
 }
 
+func TestLogTransferReload(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	parser := rs.tasks[process.ID].parser
+	parser.ResetLog()
+	parser.Parse("run 0")
+	parser.ResetLog()
+	parser.Parse("run 1")
+
+	rs.lock.Lock()
+	rs.tasks[process.ID].process.Config.Options = append(rs.tasks[process.ID].process.Config.Options, "-y")
+	rs.lock.Unlock()
+
+	err = rs.ReloadProcess(process.ID)
+	require.NoError(t, err)
+
+	require.NotSame(t, parser, rs.tasks[process.ID].parser, "the parser must be replaced")
+
+	// The history and the log of the current run are kept
+	log, err := rs.GetProcessLog(process.ID)
+	require.NoError(t, err)
+	require.Len(t, log.History, 2)
+	require.Equal(t, []string{"run 0"}, log.History[0].Prelude)
+	require.Equal(t, []string{"run 1"}, log.History[1].Prelude)
+}
+
 func TestPlayoutNoRange(t *testing.T) {
 	rs, err := getDummyRestreamer(nil, nil, nil, nil)
 	require.NoError(t, err)
@@ -577,6 +749,85 @@ func TestAddressReference(t *testing.T) {
 	require.Equal(t, nil, err, "should resolve reference")
 }
 
+func TestReferencePolicy(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	// Processes can only reference processes of the same tenant, given by the prefix of the ID
+	rs.referencePolicy = func(fromID, toID string) bool {
+		from, _, _ := strings.Cut(fromID, "-")
+		to, _, _ := strings.Cut(toID, "-")
+
+		return from == to
+	}
+
+	source := getDummyProcess()
+	source.ID = "a-source"
+
+	err = rs.AddProcess(source)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.ID = "b-process"
+	process.Input[0].Address = "#a-source:output=out"
+
+	err = rs.AddProcess(process)
+	require.ErrorIs(t, err, ErrReferenceNotAllowed)
+
+	process.ID = "a-process"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	// The stdout of a process can't be read across tenants either
+	source.ID = "a-pipe"
+	source.Output[0].Address = "-"
+
+	err = rs.AddProcess(source)
+	require.NoError(t, err)
+
+	process.ID = "b-pipe"
+	process.Input[0].Address = "#a-pipe:stdout"
+
+	err = rs.AddProcess(process)
+	require.ErrorIs(t, err, ErrReferenceNotAllowed)
+
+	// Named pipes can't be read across tenants either
+	rs.pipeDir = t.TempDir()
+
+	source.ID = "a-fifo"
+	source.Output[0].Address = "pipe:feed"
+
+	err = rs.AddProcess(source)
+	require.NoError(t, err)
+
+	process.ID = "b-fifo"
+	process.Input[0].Address = "pipe:feed"
+
+	err = rs.AddProcess(process)
+	require.ErrorIs(t, err, ErrReferenceNotAllowed)
+
+	process.ID = "a-fifo-reader"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	// A writer is not allowed to be referenced by a reader of another tenant
+	process.ID = "b-fifo"
+	process.Input[0].Address = "pipe:other"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	source.ID = "a-other"
+	source.Output[0].Address = "pipe:other"
+
+	err = rs.AddProcess(source)
+	require.ErrorIs(t, err, ErrReferenceNotAllowed)
+}
+
 func TestConfigValidation(t *testing.T) {
 	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
 	require.NoError(t, err)
@@ -679,7 +930,7 @@ func TestOutputAddressValidation(t *testing.T) {
 	}
 
 	for path, r := range paths {
-		path, _, err := rs.validateOutputAddress(path, "/core/data")
+		path, _, err := rs.validateOutputAddress(path, "auto", "/core/data")
 
 		if r.err {
 			require.Error(t, err)
@@ -691,6 +942,66 @@ func TestOutputAddressValidation(t *testing.T) {
 	}
 }
 
+func TestTeeAddressValidation(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	address := "/core/data/a.ts|[f=mpegts]/etc/passwd|http://example.com|[onfail=ignore]/core/../etc/shadow"
+
+	_, _, err = rs.validateOutputAddress(address, "auto", "/core/data")
+	require.Error(t, err)
+
+	var teeErr *TeeError
+	require.ErrorAs(t, err, &teeErr)
+	require.Equal(t, 2, len(teeErr.Targets))
+
+	require.Equal(t, 1, teeErr.Targets[0].Index)
+	require.Equal(t, "/etc/passwd", teeErr.Targets[0].Address)
+	require.Error(t, teeErr.Targets[0].Err)
+
+	require.Equal(t, 3, teeErr.Targets[1].Index)
+	require.Equal(t, "/core/../etc/shadow", teeErr.Targets[1].Address)
+	require.Error(t, teeErr.Targets[1].Err)
+}
+
+func TestOutputType(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	address, isFile, err := rs.validateOutputAddress("/core/data/foo|bar.mp4", "auto", "/core/data")
+	require.Error(t, err, "the address is detected as tee muxer address")
+	require.False(t, isFile)
+
+	address, isFile, err = rs.validateOutputAddress("/core/data/foo|bar.mp4", "single", "/core/data")
+	require.NoError(t, err)
+	require.True(t, isFile)
+	require.Equal(t, "file:/core/data/foo|bar.mp4", address)
+
+	address, _, err = rs.validateOutputAddress("http://example.com", "tee", "/core/data")
+	require.NoError(t, err)
+	require.Equal(t, "http://example.com", address)
+
+	_, _, err = rs.validateOutputAddress("[f=mpegts]/etc/passwd", "tee", "/core/data")
+	require.Error(t, err)
+
+	var teeErr *TeeError
+	require.ErrorAs(t, err, &teeErr)
+
+	require.Equal(t, []string{"/core/data/foo|bar.mp4"}, outputFiles(&app.Config{
+		Output: []app.ConfigIO{{Address: "file:/core/data/foo|bar.mp4", OutputType: "single"}},
+	}))
+
+	process := getDummyProcess()
+	process.Output[0].OutputType = "foobar"
+
+	err = rs.AddProcess(process)
+	require.Error(t, err, "unknown output types are not allowed")
+}
+
 func TestMetadata(t *testing.T) {
 	rs, err := getDummyRestreamer(nil, nil, nil, nil)
 	require.NoError(t, err)
@@ -906,3 +1217,6391 @@ func TestProcessLimit(t *testing.T) {
 	require.Equal(t, float64(61), status.CPU.Limit)
 	require.Equal(t, uint64(42), status.Memory.Limit)
 }
+
+func TestCommandModifier(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+	rs.commandModifier = func(processID string, command []string) []string {
+		return append([]string{"-nostats", "-id", processID}, command...)
+	}
+
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"-nostats", "-id", "process"}, state.Command[:3])
+
+	err = rs.ReloadProcess(process.ID)
+	require.NoError(t, err)
+
+	state, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"-nostats", "-id", "process", "-loglevel", "info"}, state.Command[:5])
+}
+
+func TestGenerateProcessID(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+	process.ID = ""
+
+	_, err = rs.AddProcessWithID(process)
+	require.Error(t, err, "Succeeded to add process without ID")
+
+	rs.generateID = true
+
+	process.Output[0].Address = "http://example.com/{processid}.m3u8"
+
+	id1, err := rs.AddProcessWithID(process)
+	require.NoError(t, err)
+	require.NotEmpty(t, id1)
+	require.Equal(t, id1, process.ID)
+
+	process = getDummyProcess()
+	process.ID = ""
+
+	id2, err := rs.AddProcessWithID(process)
+	require.NoError(t, err)
+	require.NotEmpty(t, id2)
+	require.NotEqual(t, id1, id2)
+
+	task, ok := rs.tasks[id1]
+	require.True(t, ok)
+	require.Equal(t, "http://example.com/"+id1+".m3u8", task.config.Output[0].Address)
+
+	p, err := rs.GetProcess(id1)
+	require.NoError(t, err)
+	require.Equal(t, id1, p.Config.ID)
+
+	// A generator that only returns used IDs must not block forever
+	rs.idGenerator = func() string { return id1 }
+
+	process = getDummyProcess()
+	process.ID = ""
+
+	_, err = rs.AddProcessWithID(process)
+	require.ErrorIs(t, err, ErrIDGeneration)
+
+	process.ID = ""
+
+	err = rs.TryAddProcess(process, time.Second)
+	require.ErrorIs(t, err, ErrIDGeneration)
+
+	// The lock has been released
+	require.NoError(t, rs.AddProcess(getDummyProcess()))
+}
+
+func TestProcessPriority(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+	rs.maxProc = 1
+
+	low := getDummyProcess()
+	low.ID = "low"
+	low.Priority = 1
+
+	high := getDummyProcess()
+	high.ID = "high"
+	high.Priority = 10
+
+	require.NoError(t, rs.AddProcess(low))
+	require.NoError(t, rs.AddProcess(high))
+
+	rs.tasks["low"].process.Order = "start"
+	rs.tasks["high"].process.Order = "start"
+
+	rs.Start()
+
+	require.Equal(t, "start", rs.tasks["high"].ffmpeg.Status().Order)
+	require.Equal(t, "stop", rs.tasks["low"].ffmpeg.Status().Order)
+
+	rs.Stop()
+}
+
+func TestProcessPreemption(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+	rs.maxProc = 1
+
+	low := getDummyProcess()
+	low.ID = "low"
+	low.Priority = 1
+
+	high := getDummyProcess()
+	high.ID = "high"
+	high.Priority = 10
+
+	require.NoError(t, rs.AddProcess(low))
+	require.NoError(t, rs.AddProcess(high))
+
+	require.NoError(t, rs.StartProcess("low"))
+	require.Error(t, rs.StartProcess("high"), "shouldn't start without preemption")
+
+	rs.preempt = true
+
+	require.NoError(t, rs.StartProcess("high"))
+
+	state, _ := rs.GetProcessState("low")
+	require.Equal(t, "stop", state.Order)
+	require.True(t, state.Preempted)
+
+	state, _ = rs.GetProcessState("high")
+	require.Equal(t, "start", state.Order)
+	require.False(t, state.Preempted)
+
+	require.Error(t, rs.StartProcess("low"), "shouldn't preempt a process with higher priority")
+
+	rs.StopProcess("high")
+}
+
+func TestProbeCache(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+	rs.probeCache.ttl = time.Minute
+
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	ffmpeg := &dummyFFmpeg{FFmpeg: rs.ffmpeg}
+	rs.ffmpeg = ffmpeg
+
+	probe := rs.ProbeWithTimeout(process.ID, 5*time.Second)
+	require.Equal(t, 3, len(probe.Streams))
+	require.Equal(t, 1, len(ffmpeg.Configs()))
+
+	probe = rs.ProbeWithTimeout(process.ID, 5*time.Second)
+	require.Equal(t, 3, len(probe.Streams))
+	require.Equal(t, 1, len(ffmpeg.Configs()), "cached probe shouldn't spawn a process")
+
+	rs.InvalidateProbe(process.ID)
+
+	probe = rs.ProbeWithTimeout(process.ID, 5*time.Second)
+	require.Equal(t, 3, len(probe.Streams))
+	require.Equal(t, 2, len(ffmpeg.Configs()))
+
+	// The same command with a different binary is probed again
+	pinned := &dummyFFmpeg{FFmpeg: rs.ffmpeg}
+	rs.ffmpegs["pinned"] = pinned
+
+	process.ID = "pinned"
+	process.FFmpegBinary = "pinned"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	probe = rs.ProbeWithTimeout(process.ID, 5*time.Second)
+	require.Equal(t, 3, len(probe.Streams))
+	require.Equal(t, 2, len(pinned.Configs()), "the probe should spawn a process of the binary")
+}
+
+func TestStreamProcessLog(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	ffmpeg := &dummyFFmpeg{FFmpeg: rs.ffmpeg}
+	rs.ffmpeg = ffmpeg
+
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	_, err = rs.StreamProcessLog(context.Background(), "foobar")
+	require.Error(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ch, err := rs.StreamProcessLog(ctx, process.ID)
+	require.NoError(t, err)
+
+	configs := ffmpeg.Configs()
+	require.Equal(t, 1, len(configs))
+
+	parser := configs[0].Parser
+	parser.ResetLog()
+	parser.Parse("foobar")
+	parser.Parse("frame=1 fps=25")
+	parser.Parse("barfoo")
+
+	entry := <-ch
+	require.Equal(t, "foobar", entry.Data)
+
+	entry = <-ch
+	require.Equal(t, "barfoo", entry.Data)
+
+	cancel()
+
+	_, ok := <-ch
+	require.False(t, ok, "channel should be closed after the context has been cancelled")
+
+	ch, err = rs.StreamProcessLog(context.Background(), process.ID)
+	require.NoError(t, err)
+
+	err = rs.UpdateProcess(process.ID, getDummyProcess())
+	require.NoError(t, err)
+
+	configs = ffmpeg.Configs()
+	parser = configs[len(configs)-1].Parser
+	parser.ResetLog()
+	parser.Parse("after update")
+
+	entry = <-ch
+	require.Equal(t, "after update", entry.Data)
+
+	err = rs.DeleteProcess(process.ID)
+	require.NoError(t, err)
+
+	_, ok = <-ch
+	require.False(t, ok, "channel should be closed after the process has been deleted")
+}
+
+func TestBroadcasterUnsubscribe(t *testing.T) {
+	logs := newLogBroadcaster()
+	states := newStateBroadcaster()
+
+	// A transferred subscriber is removed from the broadcaster it has been transferred to
+	ctx, cancel := context.WithCancel(context.Background())
+
+	logch := logs.Subscribe(ctx)
+	statech := states.Subscribe(ctx)
+
+	nextLogs := newLogBroadcaster()
+	nextStates := newStateBroadcaster()
+
+	logs.TransferTo(nextLogs)
+	states.TransferTo(nextStates)
+
+	cancel()
+
+	require.Eventually(t, func() bool {
+		return !nextStates.HasSubscribers()
+	}, time.Second, 10*time.Millisecond)
+
+	_, ok := <-logch
+	require.False(t, ok)
+
+	_, ok = <-statech
+	require.False(t, ok)
+
+	// Closing the broadcasters ends the subscriptions without a cancelled context
+	n := runtime.NumGoroutine()
+
+	for i := 0; i < 100; i++ {
+		nextLogs.Subscribe(context.Background())
+		nextStates.Subscribe(context.Background())
+	}
+
+	require.GreaterOrEqual(t, runtime.NumGoroutine(), n+200)
+
+	nextLogs.Close()
+	nextStates.Close()
+
+	require.Eventually(t, func() bool {
+		// The condition runs in its own goroutine
+		return runtime.NumGoroutine() <= n+1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestWatchProcess(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	_, err = rs.WatchProcess(context.Background(), "foobar")
+	require.ErrorIs(t, err, ErrUnknownProcess)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ch, err := rs.WatchProcess(ctx, process.ID)
+	require.NoError(t, err)
+
+	waitForState := func(ch <-chan *app.State, states ...string) *app.State {
+		timeout := time.After(10 * time.Second)
+
+		for {
+			select {
+			case state, ok := <-ch:
+				require.True(t, ok, "channel has been closed unexpectedly")
+
+				for _, s := range states {
+					if state.State == s {
+						return state
+					}
+				}
+			case <-timeout:
+				require.Fail(t, "no state change", "expected one of %v", states)
+				return nil
+			}
+		}
+	}
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	state := waitForState(ch, "running")
+	require.Equal(t, "start", state.Order)
+
+	err = rs.StopProcess(process.ID)
+	require.NoError(t, err)
+
+	state = waitForState(ch, "finished", "killed")
+	require.Equal(t, "stop", state.Order)
+
+	cancel()
+
+	for range ch {
+	}
+
+	ch, err = rs.WatchProcess(context.Background(), process.ID)
+	require.NoError(t, err)
+
+	err = rs.UpdateProcess(process.ID, getDummyProcess())
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	waitForState(ch, "running")
+
+	err = rs.StopProcess(process.ID)
+	require.NoError(t, err)
+
+	err = rs.DeleteProcess(process.ID)
+	require.NoError(t, err)
+
+	timeout := time.After(10 * time.Second)
+
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			require.Fail(t, "channel should be closed after the process has been deleted")
+			return
+		}
+	}
+}
+
+func TestProcessDependents(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process1 := getDummyProcess()
+
+	process2 := getDummyProcess()
+	process2.ID = "process2"
+	process2.Input[0].Address = "#process:output=out"
+
+	process3 := getDummyProcess()
+	process3.ID = "process3"
+	process3.Input[0].Address = "#process:output=out"
+
+	require.NoError(t, rs.AddProcess(process1))
+	require.NoError(t, rs.AddProcess(process2))
+	require.NoError(t, rs.AddProcess(process3))
+
+	require.Equal(t, []string{"process2", "process3"}, rs.GetProcessDependents("process"))
+	require.Equal(t, []string{}, rs.GetProcessDependents("process2"))
+
+	err = rs.DeleteProcess("process")
+	require.ErrorIs(t, err, ErrProcessReferenced)
+	require.Contains(t, err.Error(), "process2")
+	require.Contains(t, err.Error(), "process3")
+
+	_, err = rs.GetProcess("process")
+	require.NoError(t, err)
+
+	require.NoError(t, rs.DeleteProcess("process3"))
+	require.Equal(t, []string{"process2"}, rs.GetProcessDependents("process"))
+
+	require.NoError(t, rs.ForceDeleteProcess("process"))
+
+	_, err = rs.GetProcess("process")
+	require.Error(t, err)
+}
+
+func TestStaleAction(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	ffmpeg := &dummyFFmpeg{FFmpeg: rs.ffmpeg}
+	rs.ffmpeg = ffmpeg
+
+	process := getDummyProcess()
+	process.StaleAction = "foobar"
+
+	err = rs.AddProcess(process)
+	require.Error(t, err, "shouldn't accept unknown stale action")
+
+	process.StaleAction = "stop"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	configs := ffmpeg.Configs()
+	configs[len(configs)-1].OnStale()
+
+	require.Eventually(t, func() bool {
+		state, _ := rs.GetProcessState(process.ID)
+		return state.Order == "stop"
+	}, 5*time.Second, 100*time.Millisecond)
+
+	state, _ := rs.GetProcessState(process.ID)
+	require.Equal(t, uint64(1), state.Stale)
+
+	process = getDummyProcess()
+	process.ID = "restart"
+	process.Reconnect = false
+	process.StaleAction = "restart"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	configs = ffmpeg.Configs()
+	configs[len(configs)-1].OnStale()
+
+	// This is what the process does after calling the callback
+	rs.tasks[process.ID].ffmpeg.Kill(true)
+
+	require.Eventually(t, func() bool {
+		state, _ := rs.GetProcessState(process.ID)
+		return state.State == "running" && state.States.Killed+state.States.Finished == 1
+	}, 5*time.Second, 100*time.Millisecond)
+
+	state, _ = rs.GetProcessState(process.ID)
+	require.Equal(t, "start", state.Order)
+	require.Equal(t, uint64(1), state.Stale)
+
+	rs.StopProcess(process.ID)
+
+	// Without a stale action a process that doesn't reconnect stays stopped
+	process = getDummyProcess()
+	process.ID = "default"
+	process.Reconnect = false
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	configs = ffmpeg.Configs()
+	configs[len(configs)-1].OnStale()
+
+	rs.tasks[process.ID].ffmpeg.Kill(true)
+
+	time.Sleep(time.Second)
+
+	state, _ = rs.GetProcessState(process.ID)
+	require.NotEqual(t, "running", state.State)
+	require.Equal(t, uint64(1), state.Stale)
+
+	// A restart doesn't undo a concurrent stop
+	process = getDummyProcess()
+	process.ID = "stopped"
+	process.Reconnect = false
+	process.StaleAction = "restart"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	configs = ffmpeg.Configs()
+	configs[len(configs)-1].OnStale()
+
+	err = rs.StopProcess(process.ID)
+	require.NoError(t, err)
+
+	time.Sleep(time.Second)
+
+	state, _ = rs.GetProcessState(process.ID)
+	require.Equal(t, "stop", state.Order)
+	require.NotEqual(t, "running", state.State)
+}
+
+func TestDiffConfig(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.Output = append(process.Output, app.ConfigIO{
+		ID:      "out2",
+		Address: "-",
+		Options: []string{"-f", "null"},
+		Cleanup: []app.ConfigIOCleanup{},
+	})
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	_, err = rs.DiffConfig("foobar", process)
+	require.Error(t, err)
+
+	diff, err := rs.DiffConfig(process.ID, getDummyProcess())
+	require.NoError(t, err)
+	require.Equal(t, []app.ConfigChange{
+		{Field: "output:out2", Kind: "removed", Old: process.Output[1]},
+	}, diff.Changes)
+
+	config := getDummyProcess()
+	config.Output = process.Output
+	config.Options = []string{"-loglevel", "error"}
+	config.Input = append(config.Input, app.ConfigIO{
+		ID:      "in2",
+		Address: "anullsrc",
+	})
+	config.Input[0].Address = "testsrc"
+
+	diff, err = rs.DiffConfig(process.ID, config)
+	require.NoError(t, err)
+	require.Equal(t, []app.ConfigChange{
+		{Field: "options", Kind: "changed", Old: []string{"-loglevel", "info"}, New: []string{"-loglevel", "error"}},
+		{Field: "input:in.address", Kind: "changed", Old: "testsrc=size=1280x720:rate=25", New: "testsrc"},
+		{Field: "input:in2", Kind: "added", New: config.Input[1]},
+	}, diff.Changes)
+
+	diff, err = rs.DiffConfig(process.ID, process)
+	require.NoError(t, err)
+	require.True(t, diff.IsEmpty())
+}
+
+func TestWorkingDir(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	root := t.TempDir()
+
+	diskfs, err := fs.NewRootedDiskFilesystem(fs.RootedDiskConfig{
+		Root: root,
+	})
+	require.NoError(t, err)
+
+	diskfs.SetMetadata("base", root)
+
+	cleanupfs := rfs.New(rfs.Config{
+		FS: diskfs,
+	})
+
+	rs.fs.list = append(rs.fs.list, cleanupfs)
+	rs.fs.diskfs = append(rs.fs.diskfs, cleanupfs)
+
+	process := getDummyProcess()
+	process.WorkingDir = "tenant"
+	process.Output[0].Address = root + "/other/out.ts"
+
+	err = rs.AddProcess(process)
+	require.Error(t, err, "output outside of the working directory must be rejected")
+
+	process.Output[0].Address = root + "/tenant/../other/out.ts"
+
+	err = rs.AddProcess(process)
+	require.Error(t, err, "output outside of the working directory must be rejected")
+
+	process.WorkingDir = "../tenant"
+	process.Output[0].Address = root + "/tenant/out.ts"
+	process.Output[0].Cleanup = []app.ConfigIOCleanup{
+		{Pattern: "disk:/*.ts", MaxFiles: 5},
+	}
+
+	_, err = rs.validateConfig(process.Clone())
+	require.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(root, "tenant"))
+	require.ErrorIs(t, err, os.ErrNotExist, "the validation must not create the working directory")
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	info, err := os.Stat(filepath.Join(root, "tenant"))
+	require.NoError(t, err)
+	require.True(t, info.IsDir())
+
+	require.Equal(t, "/tenant/*.ts", scopePattern("/*.ts", cleanupfs, process))
+	require.Equal(t, "/tenant/*.ts", scopePattern("/tenant/*.ts", cleanupfs, process))
+}
+
+func TestStopTimeout(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+	rs.stopTimeout = 2 * time.Second
+
+	ffmpeg := &dummyFFmpeg{FFmpeg: rs.ffmpeg}
+	rs.ffmpeg = ffmpeg
+
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	configs := ffmpeg.Configs()
+	require.Equal(t, 2*time.Second, configs[len(configs)-1].StopTimeout)
+}
+
+func TestAddProcessFromTemplate(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	tmpl := getDummyProcess()
+	tmpl.Reference = "{tpl:name}"
+	tmpl.Input[0].Address = "{tpl:source}"
+	tmpl.Output[0].Options = append(tmpl.Output[0].Options, "-metadata", "title={tpl:name}")
+
+	rs.templates["ingest"] = tmpl
+
+	err = rs.AddProcessFromTemplate("foobar", "process", nil)
+	require.ErrorIs(t, err, ErrUnknownTemplate)
+
+	err = rs.AddProcessFromTemplate("ingest", "process", map[string]string{
+		"name": "foobar",
+	})
+	require.Error(t, err, "shouldn't accept missing template variables")
+	require.Contains(t, err.Error(), "source")
+
+	err = rs.AddProcessFromTemplate("ingest", "process", map[string]string{
+		"name":   "foobar",
+		"source": "testsrc2=size=640x360:rate=25",
+	})
+	require.NoError(t, err)
+
+	config, err := rs.GetProcess("process")
+	require.NoError(t, err)
+
+	require.Equal(t, "process", config.ID)
+	require.Equal(t, "foobar", config.Reference)
+	require.Equal(t, "testsrc2=size=640x360:rate=25", config.Config.Input[0].Address)
+	require.Equal(t, "title=foobar", config.Config.Output[0].Options[len(config.Config.Output[0].Options)-1])
+
+	require.Equal(t, "{tpl:source}", tmpl.Input[0].Address, "template must not be modified")
+}
+
+func TestGetResolvedConfig(t *testing.T) {
+	replacer := replace.New()
+	replacer.RegisterTemplate("diskfs", "/mnt/diskfs", nil)
+
+	rs, err := getDummyRestreamer(nil, nil, nil, replacer)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.Options = []string{"-metadata", "dir={diskfs}"}
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	_, err = rs.GetResolvedConfig("foobar")
+	require.ErrorIs(t, err, ErrUnknownProcess)
+
+	config, err := rs.GetResolvedConfig(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"-metadata", "dir=/mnt/diskfs"}, config.Options)
+
+	p, err := rs.GetProcess(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"-metadata", "dir={diskfs}"}, p.Config.Options)
+}
+
+func TestMaxAge(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+	rs.sweeper.interval = 100 * time.Millisecond
+
+	process := getDummyProcess()
+	process.ID = "stopped"
+	process.MaxAge = 10
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	process = getDummyProcess()
+	process.ID = "running"
+	process.MaxAge = 10
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	rs.lock.Lock()
+	for _, t := range rs.tasks {
+		t.process.UpdatedAt = time.Now().Add(-time.Minute).Unix()
+	}
+	rs.lock.Unlock()
+
+	rs.Start()
+	defer rs.Stop()
+
+	require.Eventually(t, func() bool {
+		_, err := rs.GetProcess("stopped")
+		return err == ErrUnknownProcess
+	}, 5*time.Second, 100*time.Millisecond)
+
+	_, err = rs.GetProcess("running")
+	require.NoError(t, err)
+
+	rs.StopProcess("running")
+}
+
+func TestStateHash(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	hash := rs.StateHash()
+	require.NotEmpty(t, hash)
+
+	for i := 0; i < 5; i++ {
+		process := getDummyProcess()
+		process.ID = fmt.Sprintf("process%d", i)
+
+		err = rs.AddProcess(process)
+		require.NoError(t, err)
+	}
+
+	newHash := rs.StateHash()
+	require.NotEqual(t, hash, newHash)
+
+	rs.lock.Lock()
+	rs.save()
+	rs.lock.Unlock()
+
+	for i := 0; i < 10; i++ {
+		require.Equal(t, newHash, rs.StateHash())
+	}
+
+	err = rs.SetProcessMetadata("process0", "foo", "bar")
+	require.NoError(t, err)
+
+	require.NotEqual(t, newHash, rs.StateHash())
+}
+
+func TestConnectivityCheck(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+	rs.connectivityTimeout = 2 * time.Second
+
+	process := getDummyProcess()
+	process.ID = "reachable"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	process = getDummyProcess()
+	process.ID = "unreachable"
+	process.Input[0].Address = "rtmp://unreachable.example.com/live/stream"
+	process.Input[0].Options = []string{}
+
+	err = rs.AddProcess(process)
+	require.ErrorIs(t, err, ErrInputUnreachable)
+
+	_, err = rs.GetProcess(process.ID)
+	require.ErrorIs(t, err, ErrUnknownProcess)
+
+	rs.connectivityTimeout = 0
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err, "the connectivity check should be optional")
+}
+
+func TestDefaultProbeTimeout(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+	require.Equal(t, 20*time.Second, rs.probeTimeout)
+
+	rs.probeTimeout = 2 * time.Second
+
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	ffmpeg := &dummyFFmpeg{FFmpeg: rs.ffmpeg}
+	rs.ffmpeg = ffmpeg
+
+	rs.Probe(process.ID)
+
+	configs := ffmpeg.Configs()
+	require.Equal(t, 1, len(configs))
+	require.Equal(t, 2*time.Second, configs[0].StaleTimeout)
+}
+
+func TestRunProcessOnce(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+	rs.maxProc = 1
+
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	_, err = rs.RunProcessOnce("foobar", []string{"-i", "testsrc", "-f", "null", "-"}, time.Second)
+	require.ErrorIs(t, err, ErrUnknownProcess)
+
+	ffmpeg := &dummyFFmpeg{FFmpeg: rs.ffmpeg}
+	rs.ffmpeg = ffmpeg
+
+	command := []string{"-f", "lavfi", "-i", "testsrc2", "-f", "null", "-"}
+
+	log, err := rs.RunProcessOnce(process.ID, command, 2*time.Second)
+	require.NoError(t, err)
+	require.NotEqual(t, 0, len(log.Prelude))
+
+	configs := ffmpeg.Configs()
+	require.Equal(t, 1, len(configs))
+	require.Equal(t, command, configs[0].Command)
+
+	require.Equal(t, int64(0), rs.nProc)
+
+	p, err := rs.GetProcess(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "testsrc=size=1280x720:rate=25", p.Config.Input[0].Address)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "finished", state.State)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	_, err = rs.RunProcessOnce(process.ID, command, time.Second)
+	require.Error(t, err, "the max. number of processes should be respected")
+
+	rs.StopProcess(process.ID)
+
+	_, err = rs.RunProcessOnce(process.ID, command, 0)
+	require.Error(t, err, "the timeout must be positive")
+}
+
+func TestRunProcessOnceOutputs(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	root := t.TempDir()
+
+	diskfs, err := fs.NewRootedDiskFilesystem(fs.RootedDiskConfig{
+		Root: root,
+	})
+	require.NoError(t, err)
+
+	diskfs.SetMetadata("base", root)
+
+	rs.fs.list = append(rs.fs.list, rfs.New(rfs.Config{FS: diskfs}))
+	rs.fs.diskfs = append(rs.fs.diskfs, rs.fs.list[len(rs.fs.list)-1])
+
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	_, err = rs.RunProcessOnce(process.ID, []string{"-re", "-f", "lavfi", "-i", "testsrc2", "-c:v", "libx264", root + "/a.ts", "-f", "null", "-"}, time.Second)
+	require.NoError(t, err)
+
+	_, err = rs.RunProcessOnce(process.ID, []string{"-f", "lavfi", "-i", "testsrc2", "-y", "/etc/a.ts", "-f", "null", "-"}, time.Second)
+	require.ErrorIs(t, err, ErrAddressOutsideBase)
+
+	_, err = rs.RunProcessOnce(process.ID, []string{"-f", "lavfi", "-i", "testsrc2", "-f", "mpegts", root + "/../a.ts"}, time.Second)
+	require.ErrorIs(t, err, ErrAddressOutsideBase)
+}
+
+func TestCommandOutputs(t *testing.T) {
+	outputs := commandOutputs([]string{"-loglevel", "info", "-re", "-i", "rtmp://example.com/live", "-c:v:0", "copy", "-an", "out.mp4", "-f", "null", "-"})
+	require.Equal(t, []string{"out.mp4", "-"}, outputs)
+
+	outputs = commandOutputs([]string{"-i", "in.ts", "-f", "null"})
+	require.Equal(t, []string{"null"}, outputs)
+}
+
+func TestSourceSelection(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.SourceSelection = "foobar"
+	process.Input[0].Addresses = []string{
+		"testsrc2=size=1280x720:rate=25",
+		"testsrc=size=640x360:rate=25",
+	}
+
+	err = rs.AddProcess(process)
+	require.Error(t, err, "shouldn't accept unknown source selection")
+
+	process.SourceSelection = "round-robin"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	addresses := append([]string{process.Input[0].Address}, process.Input[0].Addresses...)
+
+	for i := 0; i < 4; i++ {
+		err = rs.StartProcess(process.ID)
+		require.NoError(t, err)
+
+		address := addresses[i%len(addresses)]
+
+		require.Eventually(t, func() bool {
+			state, _ := rs.GetProcessState(process.ID)
+			return state.State == "running"
+		}, 5*time.Second, 100*time.Millisecond)
+
+		state, err := rs.GetProcessState(process.ID)
+		require.NoError(t, err)
+		require.Contains(t, state.Command, address)
+
+		config, err := rs.GetResolvedConfig(process.ID)
+		require.NoError(t, err)
+		require.Equal(t, address, config.Input[0].Address)
+
+		err = rs.StopProcess(process.ID)
+		require.NoError(t, err)
+	}
+}
+
+func TestSourceSelectionFailover(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.ReconnectDelay = 1
+	process.Input[0].Address = "rtmp://unreachable.example.com/live/stream"
+	process.Input[0].Addresses = []string{
+		"testsrc2=size=1280x720:rate=25",
+	}
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		state, _ := rs.GetProcessState(process.ID)
+		return state.State == "running" && strings.Contains(strings.Join(state.Command, " "), "testsrc2")
+	}, 10*time.Second, 100*time.Millisecond, "the alternative address should be used after the primary failed")
+
+	// The address that works is kept after it reported progress
+	time.Sleep(1500 * time.Millisecond)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "running", state.State)
+	require.Contains(t, state.Command, "testsrc2=size=1280x720:rate=25")
+
+	err = rs.StopProcess(process.ID)
+	require.NoError(t, err)
+}
+
+func TestStdoutPipe(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	ffmpeg := &dummyFFmpeg{FFmpeg: rs.ffmpeg}
+	rs.ffmpeg = ffmpeg
+
+	producer := getDummyProcess()
+	producer.ID = "producer"
+
+	err = rs.AddProcess(producer)
+	require.NoError(t, err)
+
+	consumer := getDummyProcess()
+	consumer.ID = "consumer"
+	consumer.Input[0].Address = "#producer:stdout"
+	consumer.Input[0].Options = []string{"-f", "mpegts"}
+
+	err = rs.AddProcess(consumer)
+	require.NoError(t, err)
+
+	configs := ffmpeg.Configs()
+	require.Equal(t, 2, len(configs))
+	require.NotNil(t, configs[0].Stdout)
+	require.NotNil(t, configs[1].Stdin)
+
+	config, err := rs.GetResolvedConfig(consumer.ID)
+	require.NoError(t, err)
+	require.Equal(t, "pipe:0", config.Input[0].Address)
+
+	pipe := rs.tasks["consumer"].stdin
+	require.NotNil(t, pipe)
+
+	// The data is discarded as long as the consumer is not running
+	require.False(t, pipe.Consuming())
+
+	// The consuming state follows the process of the consumer, see TestStdoutPipeStoppedConsumer
+	pipe.SetConsuming(true)
+	require.True(t, pipe.Consuming())
+
+	_, err = rs.tasks["producer"].stdout.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	data := make([]byte, 5)
+	_, err = pipe.reader.Read(data)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+
+	err = rs.UpdateProcess(consumer.ID, consumer)
+	require.NoError(t, err)
+
+	require.Equal(t, "consumer", rs.tasks["producer"].stdout.Consumer())
+	require.NotEqual(t, pipe, rs.tasks["consumer"].stdin)
+
+	pipe = rs.tasks["consumer"].stdin
+
+	other := getDummyProcess()
+	other.ID = "other"
+	other.Input[0].Address = "#producer:stdout"
+
+	err = rs.AddProcess(other)
+	require.Error(t, err, "the stdout can only be used by one process")
+
+	err = rs.DeleteProcess("producer")
+	require.ErrorIs(t, err, ErrProcessReferenced)
+
+	err = rs.DeleteProcess("consumer")
+	require.NoError(t, err)
+
+	require.Equal(t, "", rs.tasks["producer"].stdout.Consumer())
+
+	_, err = pipe.reader.Read(data)
+	require.Error(t, err, "the pipe should be closed")
+
+	err = rs.AddProcess(other)
+	require.NoError(t, err)
+
+	other = getDummyProcess()
+	other.ID = "invalid"
+	other.Input[0].Address = "#other:stdout"
+	other.Output[0].Address = "/dev/null"
+
+	err = rs.AddProcess(other)
+	require.NoError(t, err)
+
+	other = getDummyProcess()
+	other.ID = "invalid2"
+	other.Input[0].Address = "#invalid:stdout"
+
+	err = rs.AddProcess(other)
+	require.Error(t, err, "the referenced process doesn't write to stdout")
+}
+
+func TestStdoutPipeStoppedConsumer(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	producer := getDummyProcess()
+	producer.ID = "producer"
+
+	err = rs.AddProcess(producer)
+	require.NoError(t, err)
+
+	consumer := getDummyProcess()
+	consumer.ID = "consumer"
+	consumer.Input[0].Address = "#producer:stdout"
+	consumer.Input[0].Options = []string{"-f", "mpegts"}
+
+	err = rs.AddProcess(consumer)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(producer.ID)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(consumer.ID)
+	require.NoError(t, err)
+
+	pipe := rs.tasks["consumer"].stdin
+
+	require.Eventually(t, pipe.Consuming, 5*time.Second, 100*time.Millisecond)
+
+	stdout := rs.tasks["producer"].stdout
+	written := make(chan struct{})
+
+	// The consumer doesn't read from stdin, i.e. the writes block as soon as the pipe is full
+	go func() {
+		data := make([]byte, 64*1024)
+
+		for i := 0; i < 32; i++ {
+			stdout.Write(data)
+		}
+
+		close(written)
+	}()
+
+	err = rs.StopProcess(consumer.ID)
+	require.NoError(t, err)
+
+	select {
+	case <-written:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the producer is blocked by the stopped consumer")
+	}
+
+	require.False(t, pipe.Consuming())
+
+	stopped := make(chan error, 1)
+
+	go func() {
+		stopped <- rs.StopProcess(producer.ID)
+	}()
+
+	select {
+	case err := <-stopped:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		require.Fail(t, "the producer didn't stop")
+	}
+}
+
+func TestFailureGracePeriod(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	ffmpeg := &dummyFFmpeg{FFmpeg: rs.ffmpeg}
+	rs.ffmpeg = ffmpeg
+
+	process := getDummyProcess()
+	process.Reconnect = true
+	process.ReconnectDelay = 60
+	process.FailureGracePeriod = 2
+	process.Output[0].Address = "rtmp://unreachable.example.com/live/stream"
+	process.Output[0].Options = []string{"-f", "flv"}
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return rs.tasks[process.ID].ffmpeg.Status().State == "failed"
+	}, 5*time.Second, 100*time.Millisecond)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "starting", state.State, "failure within the grace period shouldn't be reported")
+
+	time.Sleep(2 * time.Second)
+
+	state, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "failed", state.State)
+
+	// Simulate that the process has been up and failed again
+	configs := ffmpeg.Configs()
+	configs[len(configs)-1].OnStateChange("starting", "running")
+	configs[len(configs)-1].OnStateChange("running", "failed")
+
+	state, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "starting", state.State)
+
+	rs.StopProcess(process.ID)
+}
+
+func TestReconnectAt(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.Reconnect = true
+	process.ReconnectDelay = 60
+	process.Output[0].Address = "rtmp://unreachable.example.com/live/stream"
+	process.Output[0].Options = []string{"-f", "flv"}
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, float64(-1), state.Reconnect)
+	require.Equal(t, int64(-1), state.ReconnectAt)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		state, _ := rs.GetProcessState(process.ID)
+		return state.State == "failed"
+	}, 5*time.Second, 100*time.Millisecond)
+
+	state, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Greater(t, state.Reconnect, float64(0))
+
+	expected := time.Now().Add(time.Duration(state.Reconnect * float64(time.Second))).Unix()
+	require.InDelta(t, expected, state.ReconnectAt, 1)
+
+	rs.StopProcess(process.ID)
+
+	state, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), state.ReconnectAt)
+}
+
+func TestStateTransitionCounts(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	ffmpeg := &dummyFFmpeg{FFmpeg: rs.ffmpeg}
+	rs.ffmpeg = ffmpeg
+
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	_, err = rs.GetStateTransitionCounts("foobar", time.Time{})
+	require.ErrorIs(t, err, ErrUnknownProcess)
+
+	counts, err := rs.GetStateTransitionCounts(process.ID, time.Time{})
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{}, counts)
+
+	onStateChange := ffmpeg.Configs()[0].OnStateChange
+
+	onStateChange("finished", "starting")
+	onStateChange("starting", "running")
+	onStateChange("running", "failed")
+	time.Sleep(10 * time.Millisecond)
+
+	middle := time.Now()
+
+	onStateChange("failed", "starting")
+	onStateChange("starting", "running")
+	onStateChange("running", "failed")
+	onStateChange("failed", "starting")
+
+	counts, err = rs.GetStateTransitionCounts(process.ID, time.Time{})
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"starting": 3, "running": 2, "failed": 2}, counts)
+
+	counts, err = rs.GetStateTransitionCounts(process.ID, middle)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"starting": 2, "running": 1, "failed": 1}, counts)
+}
+
+func TestProcessStateHistory(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+	rs.stateHistoryLength = 3
+
+	ffmpeg := &dummyFFmpeg{FFmpeg: rs.ffmpeg}
+	rs.ffmpeg = ffmpeg
+
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	_, err = rs.GetProcessStateHistory("foobar", time.Time{})
+	require.ErrorIs(t, err, ErrUnknownProcess)
+
+	history, err := rs.GetProcessStateHistory(process.ID, time.Time{})
+	require.NoError(t, err)
+	require.Equal(t, 0, len(history))
+
+	onStateChange := ffmpeg.Configs()[0].OnStateChange
+
+	onStateChange("finished", "starting")
+	time.Sleep(10 * time.Millisecond)
+	onStateChange("starting", "running")
+	time.Sleep(10 * time.Millisecond)
+
+	middle := time.Now()
+
+	onStateChange("running", "failed")
+	time.Sleep(10 * time.Millisecond)
+	onStateChange("failed", "starting")
+
+	history, err = rs.GetProcessStateHistory(process.ID, time.Time{})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(history), "only the last transitions should be kept")
+	require.Equal(t, "running", history[0].To)
+	require.Equal(t, "failed", history[1].To)
+	require.Equal(t, "starting", history[2].To)
+
+	history, err = rs.GetProcessStateHistory(process.ID, middle)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(history))
+	require.Equal(t, "running", history[0].From)
+	require.Equal(t, "failed", history[1].From)
+
+	history, err = rs.GetProcessStateHistory(process.ID, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 0, len(history))
+}
+
+func TestSetProcessMetadataBulk(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	for i := 0; i < 3; i++ {
+		process := getDummyProcess()
+		process.ID = fmt.Sprintf("process%d", i)
+
+		err = rs.AddProcess(process)
+		require.NoError(t, err)
+	}
+
+	_, err = rs.SetProcessMetadataBulk([]string{"process0"}, "", "bar")
+	require.Error(t, err)
+
+	updated, err := rs.SetProcessMetadataBulk([]string{"process0", "process1", "process2", "process1"}, "deployment", "v1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"process0", "process1", "process2"}, updated)
+
+	for i := 0; i < 3; i++ {
+		data, err := rs.GetProcessMetadata(fmt.Sprintf("process%d", i), "deployment")
+		require.NoError(t, err)
+		require.Equal(t, "v1", data)
+	}
+
+	updated, err = rs.SetProcessMetadataBulk([]string{"process0", "foobar", "process2"}, "deployment", "v2")
+	require.ErrorIs(t, err, ErrUnknownProcess)
+	require.Contains(t, err.Error(), "foobar")
+	require.Nil(t, updated)
+
+	data, err := rs.GetProcessMetadata("process0", "deployment")
+	require.NoError(t, err)
+	require.Equal(t, "v1", data, "nothing should be changed if an ID is unknown")
+
+	rs.bulkSkipUnknown = true
+
+	updated, err = rs.SetProcessMetadataBulk([]string{"process0", "foobar", "process2"}, "deployment", "v2")
+	require.NoError(t, err)
+	require.Equal(t, []string{"process0", "process2"}, updated)
+
+	data, err = rs.GetProcessMetadata("process0", "deployment")
+	require.NoError(t, err)
+	require.Equal(t, "v2", data)
+
+	data, err = rs.GetProcessMetadata("process1", "deployment")
+	require.NoError(t, err)
+	require.Equal(t, "v1", data)
+}
+
+func TestProcessVars(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+	process.Vars = map[string]string{
+		"region": "eu",
+		"rate":   "25",
+	}
+	process.Options = []string{"-metadata", "region={vars:region}"}
+	process.Input[0].Address = "testsrc=size=1280x720:rate={vars:rate}"
+	process.Input[0].Options = []string{"-f", "lavfi", "-metadata", "{vars:region}"}
+	process.Output[0].Address = "http://{vars:region}.example.com/live.m3u8"
+	process.Output[0].Options = []string{"-f", "hls", "-metadata", "{vars:region}_{vars:unknown}"}
+	process.Output[0].Cleanup = []app.ConfigIOCleanup{
+		{Pattern: "memfs:/{vars:region}/*.ts"},
+	}
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	config, err := rs.GetResolvedConfig(process.ID)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"-metadata", "region=eu"}, config.Options)
+	require.Equal(t, "testsrc=size=1280x720:rate=25", config.Input[0].Address)
+	require.Equal(t, []string{"-f", "lavfi", "-metadata", "eu"}, config.Input[0].Options)
+	require.Equal(t, "http://eu.example.com/live.m3u8", config.Output[0].Address)
+	require.Equal(t, []string{"-f", "hls", "-metadata", "eu_{vars:unknown}"}, config.Output[0].Options, "unknown variables should be left untouched")
+	require.Equal(t, "mem:/eu/*.ts", config.Output[0].Cleanup[0].Pattern, "legacy filesystem names should be renamed")
+
+	rs.strictVars = true
+
+	process.ID = "strict"
+
+	err = rs.AddProcess(process)
+	require.Error(t, err, "unknown variables should be rejected")
+	require.Contains(t, err.Error(), "unknown")
+
+	process.Vars["unknown"] = "foobar"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	process.ID = "names"
+
+	for _, name := range []string{"*", "region2", "Region", ""} {
+		process.Vars = map[string]string{
+			"region":  "eu",
+			"rate":    "25",
+			"unknown": "foobar",
+			name:      "us",
+		}
+
+		err = rs.AddProcess(process)
+		require.ErrorIs(t, err, ErrInvalidValue, "the variable name '%s' should be rejected", name)
+	}
+}
+
+func TestOutputCollision(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	root := t.TempDir()
+
+	diskfs, err := fs.NewRootedDiskFilesystem(fs.RootedDiskConfig{
+		Root: root,
+	})
+	require.NoError(t, err)
+
+	diskfs.SetMetadata("base", root)
+
+	rs.fs.list = append(rs.fs.list, rfs.New(rfs.Config{FS: diskfs}))
+	rs.fs.diskfs = append(rs.fs.diskfs, rs.fs.list[len(rs.fs.list)-1])
+
+	process := getDummyProcess()
+	process.Output[0].Address = root + "/live/out.ts"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	process.ID = "other"
+	process.Output[0].Address = "file:" + root + "/live/../live/out.ts"
+
+	err = rs.AddProcess(process)
+	require.ErrorIs(t, err, ErrOutputCollision)
+	require.Contains(t, err.Error(), "'process'")
+
+	process.Output[0].Address = "[f=mpegts]" + root + "/live/out.ts|rtmp://example.com/live"
+
+	err = rs.AddProcess(process)
+	require.ErrorIs(t, err, ErrOutputCollision, "targets of the tee muxer must be considered")
+
+	process.Output[0].Address = root + "/live/other.ts"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	process.ID = "process"
+	process.Output[0].Address = root + "/live/out.ts"
+
+	err = rs.UpdateProcess("process", process)
+	require.NoError(t, err, "a process must not collide with itself")
+
+	process.ID = "other"
+
+	err = rs.UpdateProcess("other", process)
+	require.ErrorIs(t, err, ErrOutputCollision)
+
+	rs.warnOnCollision = true
+
+	err = rs.UpdateProcess("other", process)
+	require.NoError(t, err)
+}
+
+// countdownContext is a context that gets cancelled after its Err() has been called n times
+type countdownContext struct {
+	context.Context
+
+	n int
+}
+
+func (c *countdownContext) Err() error {
+	if c.n == 0 {
+		return context.Canceled
+	}
+
+	c.n--
+
+	return nil
+}
+
+func TestStartContext(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	for _, id := range []string{"process1", "process2", "process3"} {
+		process := getDummyProcess()
+		process.ID = id
+
+		err = rs.AddProcess(process)
+		require.NoError(t, err)
+
+		rs.tasks[id].process.Order = "start"
+	}
+
+	err = rs.StartContext(&countdownContext{Context: context.Background(), n: 2})
+	require.ErrorIs(t, err, context.Canceled)
+
+	running := 0
+	for _, id := range []string{"process1", "process2", "process3"} {
+		state, err := rs.GetProcessState(id)
+		require.NoError(t, err)
+
+		if state.State != "finished" {
+			running++
+		}
+	}
+
+	require.Equal(t, 2, running, "the already started processes should keep running")
+
+	err = rs.StartContext(context.Background())
+	require.NoError(t, err)
+
+	for _, id := range []string{"process1", "process2", "process3"} {
+		state, err := rs.GetProcessState(id)
+		require.NoError(t, err)
+		require.NotEqual(t, "finished", state.State, "the remaining processes should be started later")
+	}
+
+	rs.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = rs.StartContext(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+
+	rs.Stop()
+
+	err = rs.StartContext(context.Background())
+	require.NoError(t, err)
+
+	rs.Stop()
+}
+
+func TestOnNewOutputFile(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	files := make(chan string, 10)
+
+	rsi, err = New(Config{
+		FFmpeg:      rsi.(*restream).ffmpeg,
+		Filesystems: []fs.Filesystem{memfs},
+		OnNewOutputFile: func(processID, outputID, path string) {
+			files <- processID + "/" + outputID + "/" + path
+		},
+	})
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.Output[0].Cleanup = []app.ConfigIOCleanup{
+		{Pattern: "mem:/live/*.ts"},
+	}
+
+	err = rsi.AddProcess(process)
+	require.NoError(t, err)
+
+	rsi.Start()
+	defer rsi.Stop()
+
+	memfs.WriteFileReader("/live/segment_0.ts", strings.NewReader("segment_0"))
+	memfs.WriteFileReader("/live/index.m3u8", strings.NewReader("index"))
+
+	select {
+	case file := <-files:
+		require.Equal(t, "process/out/mem:/live/segment_0.ts", file)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the new file hasn't been reported")
+	}
+
+	require.Empty(t, files)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+	process.BreakerFailures = 3
+	process.BreakerWindow = 60
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	task := rs.tasks[process.ID]
+
+	rs.onStateChange(task, task.config, "running", "failed")
+	rs.onStateChange(task, task.config, "running", "failed")
+	rs.onStateChange(task, task.config, "running", "finished")
+	rs.onStateChange(task, task.config, "running", "failed")
+	rs.onStateChange(task, task.config, "running", "failed")
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order, "only consecutive failures should be counted")
+
+	rs.onStateChange(task, task.config, "running", "failed")
+
+	require.Eventually(t, func() bool {
+		state, err := rs.GetProcessState(process.ID)
+		require.NoError(t, err)
+
+		return state.Order == "disabled"
+	}, 5*time.Second, 100*time.Millisecond)
+
+	require.False(t, task.ffmpeg.IsRunning())
+
+	err = rs.StartProcess(process.ID)
+	require.ErrorIs(t, err, ErrProcessDisabled)
+
+	err = rs.ResetProcess(process.ID)
+	require.NoError(t, err)
+
+	state, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order)
+	require.True(t, task.ffmpeg.IsRunning())
+
+	rs.onStateChange(task, task.config, "running", "failed")
+
+	state, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order, "the failures should be reset")
+
+	// The failure of a run that reported progress is not a failed start
+	parser := &connectParser{Parser: task.parser, task: task}
+
+	for i := 0; i < 3; i++ {
+		rs.onStateChange(task, task.config, "starting", "running")
+		parser.Parse("frame=   25 fps= 25 q=-1.0 size=       1kB time=00:00:01.00 bitrate=   8.2kbits/s speed=   1x")
+		rs.onStateChange(task, task.config, "running", "failed")
+	}
+
+	state, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order, "only failed starts should be counted")
+
+	// The breaker opens again after the disabled process has been stopped and started
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 3; i++ {
+			rs.onStateChange(task, task.config, "starting", "running")
+			rs.onStateChange(task, task.config, "running", "failed")
+		}
+
+		require.Eventually(t, func() bool {
+			state, err := rs.GetProcessState(process.ID)
+			require.NoError(t, err)
+
+			return state.Order == "disabled"
+		}, 5*time.Second, 100*time.Millisecond)
+
+		err = rs.StopProcess(process.ID)
+		require.NoError(t, err)
+
+		err = rs.StartProcess(process.ID)
+		require.NoError(t, err)
+	}
+
+	err = rs.StopProcess(process.ID)
+	require.NoError(t, err)
+
+	err = rs.DeleteProcess(process.ID)
+	require.NoError(t, err)
+}
+
+func TestMetrics(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	for _, id := range []string{"process2", "process1"} {
+		process := getDummyProcess()
+		process.ID = id
+
+		err = rsi.AddProcess(process)
+		require.NoError(t, err)
+	}
+
+	err = rsi.StartProcess("process1")
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		state, _ := rsi.GetProcessState("process1")
+		return state.State == "running"
+	}, 5*time.Second, 100*time.Millisecond)
+
+	metrics := rsi.Metrics()
+
+	names := []string{}
+	values := map[string]float64{}
+
+	for _, m := range metrics {
+		key := m.Name
+		if id, ok := m.Labels["processid"]; ok {
+			key += "/" + id
+
+			if state, ok := m.Labels["state"]; ok {
+				key += "/" + state
+			}
+		}
+
+		names = append(names, key)
+		values[key] = m.Value
+	}
+
+	expected := []string{}
+	for _, id := range []string{"process1", "process2"} {
+		expected = append(expected,
+			"restream_process_cpu_percent/"+id,
+			"restream_process_memory_bytes/"+id,
+			"restream_process_uptime_seconds/"+id,
+			"restream_process_bitrate/"+id,
+			"restream_process_reconnects/"+id,
+			"restream_process_state/"+id+"/failed",
+			"restream_process_state/"+id+"/finished",
+			"restream_process_state/"+id+"/finishing",
+			"restream_process_state/"+id+"/killed",
+			"restream_process_state/"+id+"/running",
+			"restream_process_state/"+id+"/starting",
+		)
+	}
+	expected = append(expected, "restream_processes", "restream_processes_running", "restream_processes_started")
+
+	require.Equal(t, expected, names)
+
+	require.Equal(t, float64(1), values["restream_process_state/process1/running"])
+	require.Equal(t, float64(0), values["restream_process_state/process1/finished"])
+	require.Equal(t, float64(1), values["restream_process_state/process2/finished"])
+	require.Equal(t, float64(0), values["restream_process_reconnects/process1"])
+	require.Equal(t, float64(2), values["restream_processes"])
+	require.Equal(t, float64(1), values["restream_processes_running"])
+	require.Equal(t, float64(1), values["restream_processes_started"])
+
+	rsi.StopProcess("process1")
+}
+
+func TestDryRunCleanup(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	rsi, err = New(Config{
+		FFmpeg:      rsi.(*restream).ffmpeg,
+		Filesystems: []fs.Filesystem{memfs},
+	})
+	require.NoError(t, err)
+
+	for _, name := range []string{"/live/segment_0.ts", "/live/segment_1.ts", "/live/segment_2.ts", "/live/index.m3u8", "/other/segment_0.ts"} {
+		memfs.WriteFileReader(name, strings.NewReader(name))
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	process := getDummyProcess()
+	process.Output[0].Cleanup = []app.ConfigIOCleanup{
+		{Pattern: "mem:/live/*.ts", MaxFiles: 1},
+	}
+
+	err = rsi.AddProcess(process)
+	require.NoError(t, err)
+
+	files, err := rsi.DryRunCleanup(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{
+		"mem": {"/live/segment_0.ts", "/live/segment_1.ts"},
+	}, files)
+
+	require.Equal(t, int64(5), memfs.Files(), "no files must be removed")
+
+	_, err = rsi.DryRunCleanup("unknown")
+	require.ErrorIs(t, err, ErrUnknownProcess)
+}
+
+func TestGetEffectiveCleanup(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	root := t.TempDir()
+
+	diskfs, err := fs.NewRootedDiskFilesystem(fs.RootedDiskConfig{
+		Root: root,
+	})
+	require.NoError(t, err)
+
+	diskfs.SetMetadata("base", root)
+
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	rsi, err = New(Config{
+		FFmpeg:      rsi.(*restream).ffmpeg,
+		Filesystems: []fs.Filesystem{diskfs, memfs},
+	})
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.Output[0].Address = root + "/{processid}/index.m3u8"
+	process.Output[0].Cleanup = []app.ConfigIOCleanup{
+		{Pattern: root + "/{processid}/*.ts", MaxFiles: 10},
+		{Pattern: "diskfs:/{processid}/*.m3u8", MaxFileAge: 60},
+		{Pattern: "memfs:/{processid}_{outputid}.ts", RetentionWindow: 30, PurgeOnDelete: true},
+		{Pattern: "unknown:/foobar.ts", MaxFiles: 1},
+	}
+
+	err = rsi.AddProcess(process)
+	require.NoError(t, err)
+
+	patterns, err := rsi.GetEffectiveCleanup(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]rfs.Pattern{
+		"disk": {
+			{Pattern: "/process/*.ts", MaxFiles: 10, OutputID: "out"},
+			{Pattern: "/process/*.m3u8", MaxFileAge: time.Minute, OutputID: "out"},
+		},
+		"mem": {
+			{Pattern: "/process_out.ts", RetentionWindow: 30 * time.Second, PurgeOnDelete: true, OutputID: "out"},
+		},
+	}, patterns)
+
+	err = rsi.DeleteProcess(process.ID)
+	require.NoError(t, err)
+
+	_, err = rsi.GetEffectiveCleanup(process.ID)
+	require.ErrorIs(t, err, ErrUnknownProcess)
+}
+
+func TestFindOrphanedFiles(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	root := t.TempDir()
+
+	diskfs, err := fs.NewRootedDiskFilesystem(fs.RootedDiskConfig{
+		Root: root,
+	})
+	require.NoError(t, err)
+
+	diskfs.SetMetadata("base", root)
+
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	rsi, err = New(Config{
+		FFmpeg:      rsi.(*restream).ffmpeg,
+		Filesystems: []fs.Filesystem{diskfs, memfs},
+	})
+	require.NoError(t, err)
+
+	for _, name := range []string{"/process/segment_0.ts", "/process/index.m3u8", "/renamed/segment_0.ts", "/deleted/segment_0.ts", "/other.txt"} {
+		_, _, err := diskfs.WriteFileReader(name, strings.NewReader(name))
+		require.NoError(t, err)
+	}
+
+	for _, name := range []string{"/deleted.ts", "/other.ts"} {
+		_, _, err := memfs.WriteFileReader(name, strings.NewReader(name))
+		require.NoError(t, err)
+	}
+
+	process := getDummyProcess()
+	process.Output[0].Address = root + "/{processid}/index.m3u8"
+	process.Output[0].Cleanup = []app.ConfigIOCleanup{
+		{Pattern: root + "/{processid}/*.ts", MaxFiles: 10},
+		{Pattern: "disk:/{processid}/*.m3u8", MaxFiles: 10},
+	}
+
+	err = rsi.AddProcess(process)
+	require.NoError(t, err)
+
+	deleted := getDummyProcess()
+	deleted.ID = "deleted"
+	deleted.Output[0].Address = root + "/{processid}/index.m3u8"
+	deleted.Output[0].Cleanup = []app.ConfigIOCleanup{
+		{Pattern: root + "/{processid}/*.ts", MaxFiles: 10},
+		{Pattern: "mem:/{processid}.ts", MaxFiles: 10},
+	}
+
+	err = rsi.AddProcess(deleted)
+	require.NoError(t, err)
+
+	files, err := rsi.FindOrphanedFiles("disk")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/other.txt", "/renamed/segment_0.ts"}, files)
+
+	files, err = rsi.FindOrphanedFiles("mem")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/other.ts"}, files)
+
+	err = rsi.DeleteProcess(deleted.ID)
+	require.NoError(t, err)
+
+	files, err = rsi.FindOrphanedFiles("disk")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/deleted/segment_0.ts", "/other.txt", "/renamed/segment_0.ts"}, files)
+
+	files, err = rsi.FindOrphanedFiles("mem")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/deleted.ts", "/other.ts"}, files)
+
+	require.Equal(t, int64(5), diskfs.Files(), "no files must be removed")
+
+	// The cleanup rules are not registered while the processes are stopped
+	rsi.Start()
+	rsi.Stop()
+
+	files, err = rsi.FindOrphanedFiles("disk")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/deleted/segment_0.ts", "/other.txt", "/renamed/segment_0.ts"}, files)
+
+	_, err = rsi.FindOrphanedFiles("unknown")
+	require.ErrorIs(t, err, ErrUnknownFilesystem)
+}
+
+func TestTeeCleanup(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	root := t.TempDir()
+
+	diskfs, err := fs.NewRootedDiskFilesystem(fs.RootedDiskConfig{
+		Root: root,
+	})
+	require.NoError(t, err)
+
+	diskfs.SetMetadata("base", root)
+
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	memfs.SetMetadata("base", "http://localhost/memfs")
+
+	rsi, err = New(Config{
+		FFmpeg:      rsi.(*restream).ffmpeg,
+		Filesystems: []fs.Filesystem{diskfs, memfs},
+	})
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.Output[0].Address = root + "/{processid}/index.m3u8|http://localhost/memfs/{processid}/index.m3u8"
+	process.Output[0].Cleanup = []app.ConfigIOCleanup{
+		{Filesystem: "disk", Pattern: root + "/{processid}/*.ts", MaxFiles: 10},
+		{Filesystem: "mem", Pattern: "http://localhost/memfs/{processid}/*.ts", MaxFiles: 3},
+	}
+
+	err = rsi.AddProcess(process)
+	require.NoError(t, err)
+
+	patterns, err := rsi.GetEffectiveCleanup(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]rfs.Pattern{
+		"disk": {
+			{Pattern: "/process/*.ts", MaxFiles: 10, OutputID: "out"},
+		},
+		"mem": {
+			{Pattern: "/process/*.ts", MaxFiles: 3, OutputID: "out"},
+		},
+	}, patterns)
+
+	process = getDummyProcess()
+	process.ID = "process2"
+	process.Output[0].Address = root + "/{processid}/index.m3u8"
+	process.Output[0].Cleanup = []app.ConfigIOCleanup{
+		{Filesystem: "mem", Pattern: "http://localhost/memfs/{processid}/*.ts", MaxFiles: 3},
+	}
+
+	err = rsi.AddProcess(process)
+	require.ErrorIs(t, err, ErrInvalidValue)
+}
+
+func TestFFmpegBinary(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	pinned := &dummyFFmpeg{FFmpeg: rs.ffmpeg}
+	rs.ffmpegs["pinned"] = pinned
+
+	process := getDummyProcess()
+	process.FFmpegBinary = "unknown"
+
+	err = rs.AddProcess(process)
+	require.Error(t, err, "unknown binaries must be rejected")
+
+	process.FFmpegBinary = "pinned"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	require.Len(t, pinned.configs, 1, "the selected binary should be used")
+	require.Equal(t, 1, pinned.parsers, "the parser of the selected binary should be used")
+
+	process = getDummyProcess()
+	process.ID = "default"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	require.Len(t, pinned.configs, 1, "the default binary should be used")
+}
+
+func TestPutProcess(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+	process.Autostart = true
+
+	created, err := rs.PutProcess(process)
+	require.NoError(t, err)
+	require.True(t, created)
+
+	task := rs.tasks[process.ID]
+	require.True(t, task.ffmpeg.IsRunning())
+
+	process = getDummyProcess()
+	process.Autostart = true
+
+	created, err = rs.PutProcess(process)
+	require.NoError(t, err)
+	require.False(t, created)
+	require.Same(t, task, rs.tasks[process.ID], "an unchanged process must not be replaced")
+	require.Equal(t, "start", task.process.Order)
+	require.True(t, task.ffmpeg.IsRunning())
+
+	process.StaleTimeout = 42
+
+	created, err = rs.PutProcess(process)
+	require.NoError(t, err)
+	require.False(t, created)
+	require.NotSame(t, task, rs.tasks[process.ID])
+	require.Equal(t, uint64(42), rs.tasks[process.ID].config.StaleTimeout)
+
+	rs.StopProcess(process.ID)
+}
+
+func TestProcessLogHistoryLimit(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	parser := rs.tasks[process.ID].parser
+	parser.ResetLog()
+
+	for i := 0; i < 4; i++ {
+		parser.Parse(fmt.Sprintf("run %d", i))
+		parser.ResetLog()
+	}
+
+	log, err := rs.GetProcessLogHistory(process.ID, 2)
+	require.NoError(t, err)
+	require.Len(t, log.History, 2)
+	require.Equal(t, []string{"run 2"}, log.History[0].Prelude)
+	require.Equal(t, []string{"run 3"}, log.History[1].Prelude)
+
+	log, err = rs.GetProcessLogHistory(process.ID, 0)
+	require.NoError(t, err)
+	require.Len(t, log.History, 3)
+
+	log, err = rs.GetProcessLogHistory(process.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, log.History, 3)
+
+	_, err = rs.GetProcessLogHistory("foobar", 1)
+	require.ErrorIs(t, err, ErrUnknownProcess)
+}
+
+func TestProcessGroups(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	for id, group := range map[string]string{"a1": "a", "a2": "a", "b1": "b", "c": ""} {
+		process := getDummyProcess()
+		process.ID = id
+		process.GroupID = group
+
+		err = rs.AddProcess(process)
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, []string{"a", "b"}, rs.GetGroupIDs())
+	require.Equal(t, []string{"a1", "a2"}, rs.GetProcessIDsByGroup("a"))
+	require.Empty(t, rs.GetProcessIDsByGroup(""))
+
+	// a1 is reading from a2
+	rs.tasks["a1"].references = []string{"a2"}
+	require.Equal(t, []string{"a2", "a1"}, rs.getGroupProcessIDs("a"), "referenced processes should come first")
+
+	err = rs.StartGroup("x")
+	require.ErrorIs(t, err, ErrUnknownGroup)
+
+	err = rs.StartGroup("a")
+	require.NoError(t, err)
+
+	for id, order := range map[string]string{"a1": "start", "a2": "start", "b1": "stop", "c": "stop"} {
+		require.Equal(t, order, rs.tasks[id].process.Order, id)
+	}
+
+	err = rs.DeleteGroup("a")
+	require.Error(t, err, "running processes must not be deleted")
+
+	err = rs.StopGroup("a")
+	require.NoError(t, err)
+
+	for _, id := range []string{"a1", "a2", "b1", "c"} {
+		require.Equal(t, "stop", rs.tasks[id].process.Order, id)
+	}
+
+	// c is reading from a2
+	rs.tasks["c"].references = []string{"a2"}
+
+	err = rs.DeleteGroup("a")
+	require.ErrorIs(t, err, ErrProcessReferenced)
+	require.Len(t, rs.tasks, 4, "no process must be deleted")
+
+	rs.tasks["c"].references = nil
+
+	err = rs.DeleteGroup("a")
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"b"}, rs.GetGroupIDs())
+	require.ElementsMatch(t, []string{"b1", "c"}, rs.GetProcessIDs("", ""))
+}
+
+func TestBandwidthLimit(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+	process.LimitBandwidthOut = 125000
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{
+		"-loglevel", "info",
+		"-f", "lavfi", "-re", "-i", "testsrc=size=1280x720:rate=25",
+		"-maxrate", "1000000", "-bufsize", "1000000", "-codec", "copy", "-f", "null", "-",
+	}, rs.tasks[process.ID].command)
+
+	process.ID = "readrate"
+	process.ReadAtNativeRate = true
+
+	err = rs.AddProcess(process)
+	require.Error(t, err, "reading the inputs at the native rate requires FFmpeg 5.0")
+}
+
+func TestReconcile(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	for _, id := range []string{"process1", "process2", "process3"} {
+		process := getDummyProcess()
+		process.ID = id
+
+		err = rs.AddProcess(process)
+		require.NoError(t, err)
+	}
+
+	err = rs.StartProcess("process1")
+	require.NoError(t, err)
+
+	changed, err := rs.Reconcile()
+	require.NoError(t, err)
+	require.Empty(t, changed)
+
+	task1 := rs.tasks["process1"]
+
+	// Change the store from the outside
+	data, err := rs.store.Load()
+	require.NoError(t, err)
+
+	delete(data.Process, "process3")
+
+	data.Process["process2"].Config.StaleTimeout = 42
+
+	process := getDummyProcess()
+	process.ID = "process4"
+	process.Input[0].Address = "#process2:output=out"
+
+	data.Process["process4"] = &app.Process{
+		ID:     "process4",
+		Config: process,
+		Order:  "start",
+	}
+
+	data.Metadata.Process["process1"] = map[string]interface{}{"foo": "bar"}
+
+	err = rs.store.Store(data)
+	require.NoError(t, err)
+
+	changed, err = rs.Reconcile()
+	require.NoError(t, err)
+	require.Equal(t, []string{"process2", "process3", "process4"}, changed)
+
+	require.Same(t, task1, rs.tasks["process1"], "unchanged processes must not be replaced")
+	require.True(t, task1.ffmpeg.IsRunning(), "unchanged processes must not be restarted")
+	require.Equal(t, "bar", task1.metadata["foo"])
+
+	require.NotContains(t, rs.tasks, "process3")
+	require.Equal(t, uint64(42), rs.tasks["process2"].config.StaleTimeout)
+	require.Equal(t, "start", rs.tasks["process4"].process.Order)
+	require.True(t, rs.tasks["process4"].ffmpeg.IsRunning())
+
+	changed, err = rs.Reconcile()
+	require.NoError(t, err)
+	require.Empty(t, changed)
+
+	rs.StopProcess("process1")
+	rs.StopProcess("process4")
+}
+
+func TestOptionalInputs(t *testing.T) {
+	valIn, err := ffmpeg.NewValidator([]string{"^https?://"}, nil)
+	require.NoError(t, err)
+
+	rsi, err := getDummyRestreamer(nil, valIn, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+	process.Input = []app.ConfigIO{
+		{ID: "cam1", Address: "rtsp://cam1.example.com/live", Optional: true},
+		{ID: "cam2", Address: "http://cam2.example.com/live.m3u8", Optional: true},
+		{ID: "cam3", Address: "rtsp://cam3.example.com/live"},
+	}
+
+	err = rs.AddProcess(process)
+	require.Error(t, err, "invalid inputs that are not optional must be rejected")
+
+	process.Input[2].Address = "http://cam3.example.com/live.m3u8"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	task := rs.tasks[process.ID]
+
+	require.Equal(t, []string{
+		"-loglevel", "info",
+		"-i", "http://cam2.example.com/live.m3u8",
+		"-i", "http://cam3.example.com/live.m3u8",
+		"-codec", "copy", "-f", "null", "-",
+	}, task.command)
+
+	require.Len(t, task.process.Config.Input, 3, "the stored config must keep all inputs")
+
+	task.parser.ResetLog()
+	task.parser.Parse(`ffmpeg.inputs:[{"url":"http://cam2.example.com/live.m3u8","format":"hls","index":0,"stream":0,"type":"video","codec":"h264"},{"url":"http://cam3.example.com/live.m3u8","format":"hls","index":1,"stream":0,"type":"video","codec":"h264"}]`)
+	task.parser.Parse(`ffmpeg.outputs:[{"url":"pipe:","format":"null","index":0,"stream":0,"type":"video","codec":"h264"}]`)
+	task.parser.Parse(`ffmpeg.progress:{"inputs":[{"index":0,"stream":0,"frame":1},{"index":1,"stream":0,"frame":1}],"outputs":[{"index":0,"stream":0,"frame":1}],"frame":1,"time":"0h0m0.04s"}`)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+
+	ids := []string{}
+	for _, p := range state.Progress.Input {
+		ids = append(ids, p.ID)
+	}
+
+	require.Equal(t, []string{"cam2", "cam3"}, ids, "the progress must refer to the remaining inputs")
+
+	process.ID = "none"
+	process.Input = process.Input[:1]
+
+	err = rs.AddProcess(process)
+	require.Error(t, err, "at least one input must remain")
+}
+
+// sizedFilesystem is a filesystem with a settable size and limit
+type sizedFilesystem struct {
+	fs.Filesystem
+
+	size  int64
+	limit int64
+}
+
+func (f *sizedFilesystem) Size() (int64, int64) {
+	return f.size, f.limit
+}
+
+func TestFilesystemFullCallbacks(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	events := []string{}
+
+	rs.onFilesystemFull = func(fsName string, size, limit int64) {
+		events = append(events, fmt.Sprintf("full %s %d/%d", fsName, size, limit))
+	}
+	rs.onFilesystemRecovered = func(fsName string, size, limit int64) {
+		events = append(events, fmt.Sprintf("recovered %s %d/%d", fsName, size, limit))
+	}
+
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	sizedfs := &sizedFilesystem{Filesystem: memfs, size: 50, limit: 100}
+
+	isFull := false
+	for _, size := range []int64{50, 100, 120, 90, 80, 100} {
+		sizedfs.size = size
+		isFull = rs.checkFilesystem(sizedfs, isFull)
+	}
+
+	require.Equal(t, []string{
+		"full mem 100/100",
+		"recovered mem 90/100",
+		"full mem 100/100",
+	}, events)
+	require.True(t, isFull)
+}
+
+func TestIgnoreFullDisk(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	dir := t.TempDir()
+
+	process := getDummyProcess()
+	process.Output[0].Address = filepath.Join(dir, "process.ts")
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	critical := getDummyProcess()
+	critical.ID = "critical"
+	critical.Output[0].Address = filepath.Join(dir, "critical.ts")
+	critical.IgnoreFullDisk = true
+
+	err = rs.AddProcess(critical)
+	require.NoError(t, err)
+
+	for _, id := range []string{process.ID, critical.ID} {
+		require.True(t, rs.tasks[id].usesDisk)
+
+		err = rs.StartProcess(id)
+		require.NoError(t, err)
+	}
+
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	sizedfs := &sizedFilesystem{Filesystem: memfs, size: 100, limit: 100}
+
+	isFull := rs.checkFilesystem(sizedfs, false)
+	require.True(t, isFull)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "stop", state.Order)
+
+	state, err = rs.GetProcessState(critical.ID)
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order)
+	require.True(t, rs.tasks[critical.ID].ffmpeg.IsRunning())
+
+	rs.StopProcess(critical.ID)
+}
+
+func TestTryAddProcess(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+
+	rs.lock.RLock()
+
+	start := time.Now()
+	err = rs.TryAddProcess(process, 100*time.Millisecond)
+	require.ErrorIs(t, err, ErrBusy)
+	require.Less(t, time.Since(start), time.Second)
+
+	rs.lock.RUnlock()
+
+	// The abandoned lock acquisition must release the lock again
+	require.Eventually(t, func() bool {
+		if !rs.lock.TryLock() {
+			return false
+		}
+
+		rs.lock.Unlock()
+
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	require.NotContains(t, rs.tasks, process.ID)
+
+	err = rs.TryAddProcess(process, time.Second)
+	require.NoError(t, err)
+	require.Contains(t, rs.tasks, process.ID)
+
+	err = rs.TryAddProcess(process, time.Second)
+	require.ErrorIs(t, err, ErrProcessExists)
+}
+
+func TestProcessIDsChangedSince(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	for _, id := range []string{"process1", "process2", "process3"} {
+		process := getDummyProcess()
+		process.ID = id
+
+		err = rs.AddProcess(process)
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, []string{"process1", "process2", "process3"}, rs.GetProcessIDsChangedSince(time.Time{}))
+
+	since := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	require.Empty(t, rs.GetProcessIDsChangedSince(since))
+
+	err = rs.StartProcess("process2")
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.ID = "process3"
+	process.StaleTimeout = 42
+
+	err = rs.UpdateProcess("process3", process)
+	require.NoError(t, err)
+
+	process.ID = "process4"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"process2", "process3", "process4"}, rs.GetProcessIDsChangedSince(since))
+
+	since = time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	err = rs.StopProcess("process2")
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"process2"}, rs.GetProcessIDsChangedSince(since))
+}
+
+func TestMoveIO(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+	process.Output = []app.ConfigIO{
+		{ID: "out1", Address: "http://example.com/1.m3u8"},
+		{ID: "out2", Address: "http://example.com/2.m3u8"},
+		{ID: "out3", Address: "http://example.com/3.m3u8"},
+	}
+	process.Input = append(process.Input, app.ConfigIO{ID: "in2", Address: "anullsrc"})
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.MoveOutput(process.ID, "out3", 0)
+	require.NoError(t, err)
+
+	err = rs.MoveInput(process.ID, "in2", 0)
+	require.NoError(t, err)
+
+	p, err := rs.GetProcess(process.ID)
+	require.NoError(t, err)
+
+	ids := []string{}
+	for _, io := range append(p.Config.Input, p.Config.Output...) {
+		ids = append(ids, io.ID)
+	}
+
+	require.Equal(t, []string{"in2", "in", "out3", "out1", "out2"}, ids)
+
+	require.Equal(t, []string{
+		"-loglevel", "info",
+		"-i", "anullsrc",
+		"-f", "lavfi", "-re", "-i", "testsrc=size=1280x720:rate=25",
+		"http://example.com/3.m3u8",
+		"http://example.com/1.m3u8",
+		"http://example.com/2.m3u8",
+	}, rs.tasks[process.ID].command)
+
+	data, err := rs.store.Load()
+	require.NoError(t, err)
+	require.Equal(t, "out3", data.Process[process.ID].Config.Output[0].ID, "the new order must be stored")
+
+	err = rs.MoveOutput(process.ID, "out1", 3)
+	require.Error(t, err)
+
+	err = rs.MoveOutput(process.ID, "out1", -1)
+	require.Error(t, err)
+
+	err = rs.MoveOutput(process.ID, "foobar", 0)
+	require.Error(t, err)
+
+	err = rs.MoveInput("foobar", "in", 0)
+	require.ErrorIs(t, err, ErrUnknownProcess)
+}
+
+func TestAddCanary(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+	process.Autostart = true
+	process.Output[0].Address = "rtmp://example.com/live/{processid}"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.AddCanary(process.ID, process.ID, nil)
+	require.Error(t, err, "the canary must have a different ID")
+
+	err = rs.AddCanary("foobar", "canary", nil)
+	require.Equal(t, ErrUnknownProcess, err)
+
+	err = rs.AddCanary(process.ID, "canary", func(config *app.Config) {
+		config.Output[0].Address = "rtmp://example.com/live/" + process.ID
+	})
+	require.ErrorIs(t, err, ErrOutputCollision)
+
+	_, err = rs.GetProcess("canary")
+	require.Equal(t, ErrUnknownProcess, err, "a colliding canary must not be added")
+
+	err = rs.AddCanary(process.ID, "canary", func(config *app.Config) {
+		config.ID = "foobar"
+		config.Options = append(config.Options, "-y")
+	})
+	require.NoError(t, err)
+
+	canary, err := rs.GetProcess("canary")
+	require.NoError(t, err)
+
+	require.Equal(t, "stop", canary.Order)
+	require.False(t, canary.Config.Autostart)
+	require.Equal(t, []string{"-loglevel", "info", "-y"}, canary.Config.Options)
+	require.Equal(t, "rtmp://example.com/live/canary", rs.tasks["canary"].config.Output[0].Address)
+
+	src, err := rs.GetProcess(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"-loglevel", "info"}, src.Config.Options, "the source must not be modified")
+}
+
+// countingParser counts the calls for the progress and the report
+type countingParser struct {
+	parse.Parser
+
+	progress int
+	report   int
+}
+
+func (p *countingParser) Progress() app.Progress {
+	p.progress++
+	return p.Parser.Progress()
+}
+
+func (p *countingParser) Report() parse.Report {
+	p.report++
+	return p.Parser.Report()
+}
+
+func TestProcessStateLite(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	parser := &countingParser{Parser: rs.tasks[process.ID].parser}
+	rs.tasks[process.ID].parser = parser
+
+	state, err := rs.GetProcessStateLite(process.ID)
+	require.NoError(t, err)
+
+	require.Equal(t, "start", state.Order)
+	require.Equal(t, "running", state.State)
+	require.NotZero(t, state.Time)
+	require.Empty(t, state.Progress.Input)
+	require.Empty(t, state.Progress.Output)
+	require.Empty(t, state.LastLog)
+	require.Empty(t, state.Command)
+	require.Equal(t, 0, parser.progress)
+	require.Equal(t, 0, parser.report)
+
+	full, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+
+	require.Equal(t, state.State, full.State)
+	require.NotEmpty(t, full.Command)
+	require.Equal(t, 1, parser.progress)
+	require.Equal(t, 1, parser.report)
+
+	_, err = rs.GetProcessStateLite("foobar")
+	require.Equal(t, ErrUnknownProcess, err)
+
+	rs.StopProcess(process.ID)
+}
+
+func TestReloadProcessUnchanged(t *testing.T) {
+	host := "localhost"
+
+	replacer := replace.New()
+	replacer.RegisterTemplateFunc("rtmp", func(config *app.Config, section string) string {
+		return "rtmp://" + host + "/app/live"
+	}, nil)
+
+	rsi, err := getDummyRestreamer(nil, nil, nil, replacer)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+	process.Output[0].Address = "{rtmp}"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	proc := rs.tasks[process.ID].ffmpeg
+
+	err = rs.ReloadProcess(process.ID)
+	require.NoError(t, err)
+
+	require.Same(t, proc, rs.tasks[process.ID].ffmpeg, "the process must not be restarted")
+	require.True(t, proc.IsRunning())
+
+	host = "example.com"
+
+	err = rs.ReloadProcess(process.ID)
+	require.NoError(t, err)
+
+	require.NotSame(t, proc, rs.tasks[process.ID].ffmpeg, "the process must be restarted")
+	require.Equal(t, "rtmp://example.com/app/live", rs.tasks[process.ID].command[len(rs.tasks[process.ID].command)-1])
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order)
+
+	rs.StopProcess(process.ID)
+}
+
+// usageProcess is a process that reports a fixed resource usage
+type usageProcess struct {
+	process.Process
+
+	running bool
+	cpu     float64
+	memory  uint64
+}
+
+func (p *usageProcess) IsRunning() bool {
+	return p.running
+}
+
+func (p *usageProcess) Status() process.Status {
+	status := process.Status{}
+	status.CPU.Current = p.cpu
+	status.Memory.Current = p.memory
+
+	return status
+}
+
+func TestTotalResourceUsage(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	cpu, memory := rs.TotalResourceUsage()
+	require.Equal(t, float64(0), cpu)
+	require.Equal(t, uint64(0), memory)
+
+	for _, id := range []string{"process1", "process2", "process3", "process4"} {
+		process := getDummyProcess()
+		process.ID = id
+
+		err = rs.AddProcess(process)
+		require.NoError(t, err)
+	}
+
+	rs.tasks["process1"].ffmpeg = &usageProcess{running: true, cpu: 12.5, memory: 1000}
+	rs.tasks["process2"].ffmpeg = &usageProcess{running: true, cpu: 30, memory: 500}
+	rs.tasks["process3"].ffmpeg = &usageProcess{running: false, cpu: 100, memory: 10000}
+	rs.tasks["process4"].ffmpeg = &usageProcess{running: true, cpu: 100, memory: 10000}
+	rs.tasks["process4"].valid = false
+
+	cpu, memory = rs.TotalResourceUsage()
+	require.Equal(t, 42.5, cpu)
+	require.Equal(t, uint64(1500), memory)
+}
+
+func TestImportExportConfigs(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process1 := getDummyProcess()
+	process1.ID = "process1"
+	process1.Output[0].Address = "{memfs}/foobar.m3u8"
+
+	process2 := getDummyProcess()
+	process2.ID = "process2"
+
+	require.NoError(t, rs.AddProcess(process1))
+	require.NoError(t, rs.AddProcess(process2))
+
+	_, err = rs.ExportConfigs("json", []string{"process1", "foobar"})
+	require.ErrorIs(t, err, ErrUnknownProcess)
+
+	for _, format := range []string{"json", "yaml", "toml"} {
+		data, err := rs.ExportConfigs(format, []string{"process1", "process2"})
+		require.NoError(t, err, format)
+
+		configs, err := rs.ImportConfigs(format, data)
+		require.NoError(t, err, format)
+		require.Equal(t, 2, len(configs), format)
+
+		require.True(t, process1.Diff(configs[0]).IsEmpty(), format)
+		require.True(t, process2.Diff(configs[1]).IsEmpty(), format)
+		require.Equal(t, "{memfs}/foobar.m3u8", configs[0].Output[0].Address, "placeholders must not be resolved")
+	}
+
+	_, err = rs.ExportConfigs("xml", []string{"process1"})
+	require.ErrorIs(t, err, app.ErrUnsupportedFormat)
+
+	_, err = rs.ImportConfigs("yaml", []byte("- id: process\n- id: process\n"))
+	require.Error(t, err, "duplicate IDs are not allowed")
+
+	_, err = rs.ImportConfigs("yaml", []byte("- id: process\n  output:\n    - id: out\n      address: \"-\"\n"))
+	require.Error(t, err, "an input is required")
+}
+
+func TestInitialConnectRetries(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+	process.Reconnect = true
+	process.ReconnectDelay = 1
+	process.InitialConnectRetries = 2
+	process.Output[0].Address = "rtmp://unreachable.example.com/live/stream"
+	process.Output[0].Options = []string{"-f", "flv"}
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		state, err := rs.GetProcessState(process.ID)
+		require.NoError(t, err)
+
+		return state.Order == "stop"
+	}, 10*time.Second, 100*time.Millisecond)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "failed", state.State)
+
+	task := rs.tasks[process.ID]
+	task.connect.lock.Lock()
+	require.Equal(t, uint64(2), task.connect.attempts)
+	task.connect.lock.Unlock()
+
+	data, err := rs.store.Load()
+	require.NoError(t, err)
+	require.Equal(t, "stop", data.Process[process.ID].Order)
+}
+
+func TestInitialConnectRetriesConnected(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+	process.InitialConnectRetries = 1
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	task := rs.tasks[process.ID]
+
+	// The fake ffmpeg reports its progress every second
+	require.Eventually(t, func() bool {
+		task.connect.lock.Lock()
+		defer task.connect.lock.Unlock()
+
+		return task.connect.connected
+	}, 5*time.Second, 100*time.Millisecond)
+
+	rs.onStateChange(task, task.config, "running", "failed")
+
+	time.Sleep(500 * time.Millisecond)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order, "a process that has been connected must not give up")
+
+	rs.StopProcess(process.ID)
+}
+
+func TestMetadataPlaceholder(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+	process.Output[0].Options = append(process.Output[0].Options, "-metadata", "title={metadata:title}")
+
+	err = rs.AddProcess(process)
+	require.Error(t, err, "the metadata key must exist")
+
+	process.Output[0].Options = append(process.Output[0].Options, "-metadata", "comment={metadata:title_1}")
+
+	err = rs.AddProcess(process)
+	require.ErrorIs(t, err, ErrInvalidValue, "the metadata key must be supported by the replacer")
+	require.Contains(t, err.Error(), "title_1")
+
+	process = getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.SetProcessMetadata(process.ID, "title", "Foobar")
+	require.NoError(t, err)
+
+	process.Output[0].Options = append(process.Output[0].Options, "-metadata", "title={metadata:title}")
+
+	err = rs.UpdateProcess(process.ID, process)
+	require.NoError(t, err)
+
+	require.Contains(t, rs.tasks[process.ID].command, "title=Foobar")
+
+	data, err := rs.GetProcessMetadata(process.ID, "title")
+	require.NoError(t, err)
+	require.Equal(t, "Foobar", data, "the metadata must be kept on update")
+
+	err = rs.SetProcessMetadata(process.ID, "title", "Barfoo")
+	require.NoError(t, err)
+
+	require.Contains(t, rs.tasks[process.ID].command, "title=Barfoo", "the process must be reloaded")
+
+	err = rs.SetProcessMetadata(process.ID, "title", map[string]string{"foo": "bar"})
+	require.NoError(t, err)
+
+	require.Contains(t, rs.tasks[process.ID].command, `title={"foo":"bar"}`)
+
+	err = rs.SetProcessMetadata(process.ID, "title", nil)
+	require.Error(t, err, "metadata that is used by the config must not be removed")
+
+	_, err = rs.SetProcessMetadataBulk([]string{process.ID}, "title", nil)
+	require.Error(t, err, "metadata that is used by the config must not be removed")
+
+	updated, err := rs.SetProcessMetadataBulk([]string{process.ID}, "title", "Bulk")
+	require.NoError(t, err)
+	require.Equal(t, []string{process.ID}, updated)
+
+	require.Contains(t, rs.tasks[process.ID].command, "title=Bulk")
+}
+
+func TestStopProcessesByFilesystem(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	root := t.TempDir()
+
+	diskfs, err := fs.NewRootedDiskFilesystem(fs.RootedDiskConfig{
+		Root: root,
+	})
+	require.NoError(t, err)
+
+	diskfs.SetMetadata("base", root)
+
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	memfs.SetMetadata("base", "http://localhost/memfs")
+
+	rs.fs.list = append(rs.fs.list, rfs.New(rfs.Config{FS: diskfs}), rfs.New(rfs.Config{FS: memfs}))
+	rs.fs.diskfs = append(rs.fs.diskfs, rs.fs.list[len(rs.fs.list)-2])
+
+	addresses := map[string]string{
+		"disk":    root + "/disk.ts",
+		"mem":     "http://localhost/memfs/mem.m3u8",
+		"tee":     "[f=mpegts]http://localhost/memfs/tee.ts|" + root + "/tee.ts",
+		"stopped": root + "/stopped.ts",
+	}
+
+	for id, address := range addresses {
+		process := getDummyProcess()
+		process.ID = id
+		process.Output[0].Address = address
+		process.Autostart = id != "stopped"
+
+		err = rs.AddProcess(process)
+		require.NoError(t, err, id)
+	}
+
+	_, err = rs.StopProcessesByFilesystem("foobar", time.Second)
+	require.ErrorIs(t, err, ErrUnknownFilesystem)
+
+	ids, err := rs.StopProcessesByFilesystem("disk", 5*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, []string{"disk", "tee"}, ids)
+
+	for _, id := range ids {
+		require.Equal(t, "stop", rs.tasks[id].process.Order)
+		require.False(t, rs.tasks[id].ffmpeg.IsRunning())
+	}
+
+	require.Equal(t, "start", rs.tasks["mem"].process.Order)
+	require.True(t, rs.tasks["mem"].ffmpeg.IsRunning())
+
+	ids, err = rs.StopProcessesByFilesystem("mem", 5*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, []string{"mem"}, ids)
+
+	// The lock is not held while waiting for the processes to exit
+	err = rs.StartProcess("mem")
+	require.NoError(t, err)
+
+	proc := rs.tasks["mem"].ffmpeg
+	defer proc.Stop(true)
+
+	rs.tasks["mem"].ffmpeg = &lingeringProcess{Process: proc}
+
+	done := make(chan error)
+
+	go func() {
+		_, err := rs.StopProcessesByFilesystem("mem", time.Second)
+		done <- err
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+
+	_, err = rs.GetProcess("mem")
+	require.NoError(t, err)
+
+	select {
+	case err = <-done:
+		t.Fatal("the processes should still be waited for")
+	default:
+	}
+
+	err = <-done
+	require.Error(t, err)
+}
+
+// lingeringProcess is a process that keeps running after it has been stopped
+type lingeringProcess struct {
+	process.Process
+}
+
+func (p *lingeringProcess) Stop(wait bool) error { return nil }
+func (p *lingeringProcess) IsRunning() bool      { return true }
+
+func TestOnOutputFileComplete(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	files := make(chan string, 10)
+
+	rsi, err = New(Config{
+		FFmpeg:      rsi.(*restream).ffmpeg,
+		Filesystems: []fs.Filesystem{memfs},
+		OnOutputFileComplete: func(processID string, output app.ConfigIO, path string) error {
+			files <- processID + "/" + output.ID + "/" + output.Address + "/" + path
+			return fmt.Errorf("failed to upload")
+		},
+	})
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.Output[0].Cleanup = []app.ConfigIOCleanup{
+		{Pattern: "mem:/live/*.ts"},
+	}
+
+	err = rsi.AddProcess(process)
+	require.NoError(t, err)
+
+	rsi.Start()
+	defer rsi.Stop()
+
+	memfs.WriteFileReader("/live/segment_0.ts", strings.NewReader("segment_0"))
+
+	select {
+	case file := <-files:
+		require.Equal(t, "process/out/-/mem:/live/segment_0.ts", file)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the hook hasn't been called")
+	}
+
+	time.Sleep(2 * time.Second)
+
+	require.Empty(t, files, "the hook must be called only once per file")
+}
+
+func TestMaxPlayoutPorts(t *testing.T) {
+	portrange, err := net.NewPortrange(3000, 3009)
+	require.NoError(t, err)
+
+	rsi, err := getDummyRestreamer(portrange, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+	rs.playoutPorts.max = 2
+
+	process1 := getDummyProcess()
+	process1.ID = "process1"
+	process1.Input[0].Address = "playout:" + process1.Input[0].Address
+	process1.Input = append(process1.Input, app.ConfigIO{
+		ID:      "in2",
+		Address: "playout:anullsrc",
+		Options: []string{"-f", "lavfi"},
+	})
+
+	err = rs.AddProcess(process1)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(rs.playoutPorts.ports))
+
+	process2 := getDummyProcess()
+	process2.ID = "process2"
+	process2.Input[0].Address = "playout:" + process2.Input[0].Address
+
+	err = rs.AddProcess(process2)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "max. number of playout ports")
+	require.Equal(t, 2, len(rs.playoutPorts.ports))
+
+	err = rs.DeleteProcess(process1.ID)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(rs.playoutPorts.ports))
+
+	err = rs.AddProcess(process2)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(rs.playoutPorts.ports))
+
+	addr, err := rs.GetPlayout(process2.ID, process2.Input[0].ID)
+	require.NoError(t, err)
+	require.NotEmpty(t, addr)
+}
+
+func TestReconcilePlayoutPorts(t *testing.T) {
+	portrange, err := net.NewPortrange(3000, 3001)
+	require.NoError(t, err)
+
+	rsi, err := getDummyRestreamer(portrange, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+	process.Input[0].Address = "playout:" + process.Input[0].Address
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	released, err := rs.ReconcilePlayoutPorts()
+	require.NoError(t, err)
+	require.Empty(t, released)
+
+	// Simulate a port that got lost during the setup of a process
+	leaked, err := rs.getPlayoutPort()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(rs.playoutPorts.ports))
+
+	_, err = portrange.Get()
+	require.Error(t, err)
+
+	released, err = rs.ReconcilePlayoutPorts()
+	require.NoError(t, err)
+	require.Equal(t, []int{leaked}, released)
+	require.Equal(t, 1, len(rs.playoutPorts.ports))
+
+	port, err := portrange.Get()
+	require.NoError(t, err)
+	require.Equal(t, leaked, port)
+
+	portrange.Put(port)
+
+	addr, err := rs.GetPlayout(process.ID, process.Input[0].ID)
+	require.NoError(t, err)
+	require.NotEmpty(t, addr)
+
+	// A port that is referenced without being assigned is reported
+	rs.lock.Lock()
+	rs.tasks[process.ID].playout["in2"] = 3005
+	rs.lock.Unlock()
+
+	released, err = rs.ReconcilePlayoutPorts()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "3005 (process)")
+	require.Empty(t, released)
+}
+
+func TestIsAddressAllowed(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	root := t.TempDir()
+
+	diskfs, err := fs.NewRootedDiskFilesystem(fs.RootedDiskConfig{
+		Root: root,
+	})
+	require.NoError(t, err)
+
+	diskfs.SetMetadata("base", root)
+
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	memfs.SetMetadata("base", "http://localhost/memfs")
+
+	rs.fs.list = append(rs.fs.list, rfs.New(rfs.Config{FS: diskfs}), rfs.New(rfs.Config{FS: memfs}))
+	rs.fs.diskfs = append(rs.fs.diskfs, rs.fs.list[len(rs.fs.list)-2])
+
+	type result struct {
+		allowed bool
+		fsName  string
+	}
+
+	outputs := map[string]result{
+		"rtmp://example.com/live/stream":   {true, ""},
+		"http://localhost/memfs/live.m3u8": {true, "mem"},
+		root + "/live/out.ts":              {true, "disk"},
+		"file:" + root + "/live/out.ts":    {true, "disk"},
+		"/etc/passwd":                      {false, ""},
+		root + "/../etc/passwd":            {false, ""},
+		"/dev/null":                        {true, ""},
+		"-":                                {true, ""},
+		"":                                 {false, ""},
+	}
+
+	for address, r := range outputs {
+		allowed, fsName, err := rs.IsAddressAllowed(address, true)
+		require.Equal(t, r.allowed, allowed, address)
+		require.Equal(t, r.fsName, fsName, address)
+
+		if r.allowed {
+			require.NoError(t, err, address)
+		} else {
+			require.Error(t, err, address)
+		}
+	}
+
+	allowed, fsName, err := rs.IsAddressAllowed(root+"/video.mp4", false)
+	require.NoError(t, err)
+	require.True(t, allowed)
+	require.Equal(t, "disk", fsName)
+
+	allowed, fsName, err = rs.IsAddressAllowed("rtmp://example.com/live/stream", false)
+	require.NoError(t, err)
+	require.True(t, allowed)
+	require.Equal(t, "", fsName)
+}
+
+func TestReferenceBehavior(t *testing.T) {
+	for _, behavior := range []string{"", "ignore", "block-start", "start-dependency"} {
+		rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+		require.NoError(t, err)
+
+		rs := rsi.(*restream)
+
+		producer := getDummyProcess()
+		producer.Output[0].Address = "rtmp://example.com/live/stream"
+
+		err = rs.AddProcess(producer)
+		require.NoError(t, err)
+
+		consumer := getDummyProcess()
+		consumer.ID = "consumer"
+		consumer.Input[0].Address = "#process:output=out"
+		consumer.Input[0].Options = []string{}
+		consumer.ReferenceBehavior = behavior
+
+		err = rs.AddProcess(consumer)
+		require.NoError(t, err)
+
+		err = rs.StartProcess(consumer.ID)
+
+		switch behavior {
+		case "block-start":
+			require.ErrorIs(t, err, ErrReferenceNotStarted)
+			require.Equal(t, "stop", rs.tasks[consumer.ID].process.Order)
+			require.Equal(t, "stop", rs.tasks[producer.ID].process.Order)
+		case "start-dependency":
+			require.NoError(t, err)
+			require.Equal(t, "start", rs.tasks[consumer.ID].process.Order)
+			require.Equal(t, "start", rs.tasks[producer.ID].process.Order)
+			require.True(t, rs.tasks[producer.ID].ffmpeg.IsRunning())
+		default:
+			require.NoError(t, err, behavior)
+			require.Equal(t, "start", rs.tasks[consumer.ID].process.Order)
+			require.Equal(t, "stop", rs.tasks[producer.ID].process.Order)
+		}
+
+		if behavior == "block-start" {
+			err = rs.StartProcess(producer.ID)
+			require.NoError(t, err)
+
+			err = rs.StartProcess(consumer.ID)
+			require.NoError(t, err, "the consumer can be started if the producer is started")
+		}
+
+		rs.StopProcess(consumer.ID)
+		rs.StopProcess(producer.ID)
+	}
+
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.ReferenceBehavior = "foobar"
+
+	err = rsi.AddProcess(process)
+	require.Error(t, err)
+}
+
+func TestAddProcessReturn(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.Autostart = true
+
+	p, err := rs.AddProcessReturn(process)
+	require.NoError(t, err)
+
+	require.Equal(t, process.ID, p.ID)
+	require.NotZero(t, p.CreatedAt)
+	require.Equal(t, p.CreatedAt, p.UpdatedAt)
+	require.Equal(t, "^4.0.2", p.Config.FFVersion)
+	require.Equal(t, "start", p.Order)
+
+	stored, err := rs.GetProcess(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, stored, p)
+
+	p.Config.Options = append(p.Config.Options, "-y")
+
+	stored, err = rs.GetProcess(process.ID)
+	require.NoError(t, err)
+	require.NotEqual(t, stored.Config.Options, p.Config.Options, "a copy of the process must be returned")
+
+	_, err = rs.AddProcessReturn(process)
+	require.ErrorIs(t, err, ErrProcessExists)
+
+	rs.StopProcess(process.ID)
+}
+
+func TestDemandProcess(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+	process.Autostart = true
+	process.OnDemand = true
+	process.IdleTimeout = 1
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	require.Equal(t, "stop", rs.tasks[process.ID].process.Order, "a process on demand must not be autostarted")
+
+	_, err = rs.DemandProcess("foobar")
+	require.Equal(t, ErrUnknownProcess, err)
+
+	release1, err := rs.DemandProcess(process.ID)
+	require.NoError(t, err)
+
+	require.Equal(t, "start", rs.tasks[process.ID].process.Order)
+	require.True(t, rs.tasks[process.ID].ffmpeg.IsRunning())
+
+	release2, err := rs.DemandProcess(process.ID)
+	require.NoError(t, err)
+
+	release1()
+	release1()
+
+	time.Sleep(1500 * time.Millisecond)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order, "the process must not be stopped as long as it is demanded")
+
+	release2()
+
+	// A new demand within the idle timeout keeps the process running
+	time.Sleep(500 * time.Millisecond)
+
+	release3, err := rs.DemandProcess(process.ID)
+	require.NoError(t, err)
+
+	time.Sleep(1000 * time.Millisecond)
+
+	state, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order)
+
+	release3()
+
+	require.Eventually(t, func() bool {
+		state, err := rs.GetProcessState(process.ID)
+		require.NoError(t, err)
+
+		return state.Order == "stop"
+	}, 5*time.Second, 100*time.Millisecond)
+
+	require.False(t, rs.tasks[process.ID].ffmpeg.IsRunning())
+
+	process = getDummyProcess()
+	process.ID = "other"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	_, err = rs.DemandProcess(process.ID)
+	require.Error(t, err, "only processes on demand can be demanded")
+}
+
+func TestDemandProcessReadLock(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+	process.OnDemand = true
+
+	require.NoError(t, rs.AddProcess(process))
+
+	release1, err := rs.DemandProcess(process.ID)
+	require.NoError(t, err)
+
+	// A further consumer of a started process only requires the read lock
+	rs.lock.RLock()
+
+	done := make(chan struct{})
+
+	var release2 func()
+
+	go func() {
+		defer close(done)
+		release2, err = rs.DemandProcess(process.ID)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		require.Fail(t, "demanding a started process must not wait for the write lock")
+	}
+
+	rs.lock.RUnlock()
+
+	require.NoError(t, err)
+	require.Equal(t, int64(2), atomic.LoadInt64(&rs.tasks[process.ID].demand.consumers))
+
+	release1()
+	release2()
+
+	// Without an idle timeout the process is stopped after the default idle timeout
+	time.Sleep(1500 * time.Millisecond)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order)
+
+	rs.lock.RLock()
+	require.NotNil(t, rs.tasks[process.ID].demand.idle)
+	rs.lock.RUnlock()
+}
+
+func TestDemandProcessRename(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.OnDemand = true
+	process.IdleTimeout = 1
+
+	require.NoError(t, rs.AddProcess(process))
+
+	release, err := rs.DemandProcess(process.ID)
+	require.NoError(t, err)
+
+	renamed := process.Clone()
+	renamed.ID = "renamed"
+
+	require.NoError(t, rs.UpdateProcess(process.ID, renamed))
+
+	state, err := rs.GetProcessState(renamed.ID)
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order, "the consumer must be kept on a rename")
+
+	release()
+
+	require.Eventually(t, func() bool {
+		state, err := rs.GetProcessState(renamed.ID)
+		require.NoError(t, err)
+
+		return state.Order == "stop"
+	}, 5*time.Second, 100*time.Millisecond, "the release must follow the renamed process")
+}
+
+func TestDemandPlayout(t *testing.T) {
+	portrange, err := net.NewPortrange(3000, 3001)
+	require.NoError(t, err)
+
+	rs, err := getDummyRestreamer(portrange, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.Input[0].Address = "playout:" + process.Input[0].Address
+	process.OnDemand = true
+	process.IdleTimeout = 1
+
+	require.NoError(t, rs.AddProcess(process))
+
+	_, _, err = rs.DemandPlayout("foobar", process.Input[0].ID)
+	require.Equal(t, ErrUnknownProcess, err)
+
+	_, _, err = rs.DemandPlayout(process.ID, "foobar")
+	require.Error(t, err, "playout of non-existing input should error")
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "stop", state.Order, "a failed demand must not start the process")
+
+	addr, release, err := rs.DemandPlayout(process.ID, process.Input[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1:3000", addr)
+
+	state, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order, "a consumer of the playout must start the process")
+
+	release()
+
+	require.Eventually(t, func() bool {
+		state, err := rs.GetProcessState(process.ID)
+		require.NoError(t, err)
+
+		return state.Order == "stop"
+	}, 5*time.Second, 100*time.Millisecond)
+
+	other := getDummyProcess()
+	other.ID = "other"
+	other.Input[0].Address = "playout:" + other.Input[0].Address
+
+	require.NoError(t, rs.AddProcess(other))
+
+	_, release, err = rs.DemandPlayout(other.ID, other.Input[0].ID)
+	require.NoError(t, err)
+
+	release()
+
+	state, err = rs.GetProcessState(other.ID)
+	require.NoError(t, err)
+	require.Equal(t, "stop", state.Order, "only processes on demand are started by a consumer")
+}
+
+func TestSupportedPlaceholders(t *testing.T) {
+	replacer := replace.New()
+
+	for _, name := range []string{"diskfs", "fs:disk", "memfs", "fs:mem", "rtmp", "srt"} {
+		replacer.RegisterTemplate(name, "", nil)
+	}
+
+	rs, err := getDummyRestreamer(nil, nil, nil, replacer)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{
+		"diskfs",
+		"fs:disk",
+		"fs:mem",
+		"inputid",
+		"memfs",
+		"metadata:*",
+		"outputid",
+		"processid",
+		"reference",
+		"rtmp",
+		"sequence",
+		"srt",
+		"vars:*",
+	}, rs.SupportedPlaceholders())
+
+	rs, err = getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"inputid", "metadata:*", "outputid", "processid", "reference", "sequence", "vars:*"}, rs.SupportedPlaceholders())
+}
+
+func TestConcurrentOperations(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	wg := sync.WaitGroup{}
+	lock := sync.Mutex{}
+	inProgress := 0
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+
+		go func(start bool) {
+			defer wg.Done()
+
+			var err error
+			if start {
+				err = rs.StartProcess(process.ID)
+			} else {
+				err = rs.StopProcess(process.ID)
+			}
+
+			if err == nil {
+				return
+			}
+
+			require.ErrorIs(t, err, ErrOperationInProgress)
+
+			lock.Lock()
+			inProgress++
+			lock.Unlock()
+		}(i%2 == 0)
+	}
+
+	wg.Wait()
+
+	require.Less(t, inProgress, 20)
+
+	task := rs.tasks[process.ID]
+
+	require.Equal(t, task.process.Order, task.ffmpeg.Status().Order)
+	if task.process.Order == "start" {
+		require.Equal(t, int64(1), rs.nProc)
+	} else {
+		require.Equal(t, int64(0), rs.nProc)
+	}
+
+	err = rs.beginOperation(process.ID)
+	require.NoError(t, err)
+
+	err = rs.StopProcess(process.ID)
+	require.ErrorIs(t, err, ErrOperationInProgress)
+
+	err = rs.RestartProcess(process.ID)
+	require.ErrorIs(t, err, ErrOperationInProgress)
+
+	rs.endOperation(process.ID)
+
+	err = rs.StopProcess(process.ID)
+	require.NoError(t, err)
+}
+
+func TestTemplateOnly(t *testing.T) {
+	portrange, err := net.NewPortrange(3000, 3009)
+	require.NoError(t, err)
+
+	rsi, err := getDummyRestreamer(portrange, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+	process.Input[0].Address = "playout:" + process.Input[0].Address
+	process.Autostart = true
+	process.TemplateOnly = true
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(rs.playoutPorts.ports))
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "stop", state.Order)
+
+	err = rs.StartProcess(process.ID)
+	require.ErrorIs(t, err, ErrTemplateOnly)
+
+	_, err = rs.GetPlayout(process.ID, process.Input[0].ID)
+	require.Error(t, err)
+
+	rs.tasks[process.ID].process.Order = "start"
+
+	rs.Start()
+	defer rs.Stop()
+
+	require.Equal(t, "stop", rs.tasks[process.ID].ffmpeg.Status().Order)
+	require.Equal(t, int64(0), rs.nProc)
+
+	invalid := getDummyProcess()
+	invalid.ID = "invalid"
+	invalid.TemplateOnly = true
+	invalid.Input = nil
+
+	err = rs.AddProcess(invalid)
+	require.Error(t, err)
+}
+
+// deltaStore is a store that applies the deltas to its data and counts the written bytes.
+type deltaStore struct {
+	data    store.StoreData
+	full    int // Number of complete writes
+	deltas  int // Number of writes of a delta
+	written int // Number of written bytes
+	lock    sync.Mutex
+}
+
+func newDeltaStore() *deltaStore {
+	return &deltaStore{
+		data: store.NewStoreData(),
+	}
+}
+
+func (s *deltaStore) Load() (store.StoreData, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.data, nil
+}
+
+func (s *deltaStore) Store(data store.StoreData) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	s.data = store.NewStoreData()
+	if err := json.Unmarshal(payload, &s.data); err != nil {
+		return err
+	}
+
+	s.full++
+	s.written += len(payload)
+
+	return nil
+}
+
+func (s *deltaStore) StoreDelta(delta store.StoreDelta) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	payload, err := json.Marshal(delta)
+	if err != nil {
+		return err
+	}
+
+	clone := store.NewStoreDelta()
+	if err := json.Unmarshal(payload, &clone); err != nil {
+		return err
+	}
+
+	s.data.Apply(clone)
+
+	s.deltas++
+	s.written += len(payload)
+
+	return nil
+}
+
+func (s *deltaStore) writes() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.full + s.deltas
+}
+
+func requireStored(t *testing.T, rs *restream, s *deltaStore) {
+	rs.lock.RLock()
+	want, err := json.Marshal(withoutEmptyMetadata(rs.storeData()))
+	rs.lock.RUnlock()
+	require.NoError(t, err)
+
+	s.lock.Lock()
+	have, err := json.Marshal(withoutEmptyMetadata(s.data))
+	s.lock.Unlock()
+	require.NoError(t, err)
+
+	require.JSONEq(t, string(want), string(have))
+}
+
+// withoutEmptyMetadata removes the processes without metadata from the metadata. They
+// are stored as nil by a complete write, but a delta doesn't store them at all.
+func withoutEmptyMetadata(data store.StoreData) store.StoreData {
+	metadata := map[string]map[string]interface{}{}
+
+	for id, m := range data.Metadata.Process {
+		if m != nil {
+			metadata[id] = m
+		}
+	}
+
+	data.Metadata.Process = metadata
+
+	return data
+}
+
+func TestSaveDelta(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	s := newDeltaStore()
+	rs.store = s
+
+	for i := 0; i < 3; i++ {
+		process := getDummyProcess()
+		process.ID = fmt.Sprintf("process%d", i)
+
+		err = rs.AddProcess(process)
+		require.NoError(t, err)
+		requireStored(t, rs, s)
+	}
+
+	require.Equal(t, 0, s.full)
+	require.Equal(t, 3, s.deltas)
+
+	err = rs.StartProcess("process0")
+	require.NoError(t, err)
+	requireStored(t, rs, s)
+
+	err = rs.SetProcessMetadata("process1", "foo", "bar")
+	require.NoError(t, err)
+	requireStored(t, rs, s)
+
+	err = rs.SetMetadata("foo", "bar")
+	require.NoError(t, err)
+	requireStored(t, rs, s)
+
+	process := getDummyProcess()
+	process.ID = "process3"
+	process.Autostart = true
+
+	err = rs.UpdateProcess("process1", process)
+	require.NoError(t, err)
+	requireStored(t, rs, s)
+
+	err = rs.DeleteProcess("process2")
+	require.NoError(t, err)
+	requireStored(t, rs, s)
+
+	err = rs.StopProcess("process0")
+	require.NoError(t, err)
+	requireStored(t, rs, s)
+
+	err = rs.StopProcess("process3")
+	require.NoError(t, err)
+	requireStored(t, rs, s)
+
+	require.Equal(t, 0, s.full)
+
+	// Nothing is written if nothing changed
+	writes := s.writes()
+
+	err = rs.StopProcess("process3")
+	require.NoError(t, err)
+
+	require.Equal(t, writes, s.writes())
+
+	// All data is written if everything is marked as changed, e.g. after a failed write
+	rs.lock.Lock()
+	rs.markAllChanged()
+	rs.save()
+	rs.lock.Unlock()
+	requireStored(t, rs, s)
+
+	require.Equal(t, 1, s.full)
+}
+
+func TestSaveDebounce(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	s := newDeltaStore()
+	rs.store = s
+	rs.saving.debounce = 200 * time.Millisecond
+
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		err = rs.SetProcessMetadata(process.ID, "foo", i)
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, 0, s.writes())
+
+	require.Eventually(t, func() bool {
+		return s.writes() == 1
+	}, 2*time.Second, 50*time.Millisecond)
+
+	requireStored(t, rs, s)
+
+	rs.Start()
+
+	err = rs.SetMetadata("foo", "bar")
+	require.NoError(t, err)
+
+	// Stopping writes the pending changes immediately
+	rs.Stop()
+
+	require.Equal(t, 2, s.writes())
+	requireStored(t, rs, s)
+
+	time.Sleep(500 * time.Millisecond)
+
+	require.Equal(t, 2, s.writes())
+}
+
+// fullStore hides the StoreDelta method of a store.
+type fullStore struct {
+	s store.Store
+}
+
+func (s fullStore) Load() (store.StoreData, error)   { return s.s.Load() }
+func (s fullStore) Store(data store.StoreData) error { return s.s.Store(data) }
+
+// countingFilesystem counts the bytes that are written with WriteFileSafe.
+type countingFilesystem struct {
+	fs.Filesystem
+
+	written int
+}
+
+func (f *countingFilesystem) WriteFileSafe(path string, data []byte) (int64, bool, error) {
+	f.written += len(data)
+
+	return f.Filesystem.WriteFileSafe(path, data)
+}
+
+func benchmarkSave(b *testing.B, delta bool) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(b, err)
+
+	rs := rsi.(*restream)
+
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(b, err)
+
+	counter := &countingFilesystem{Filesystem: memfs}
+
+	s, err := store.NewJSON(store.JSONConfig{
+		Filesystem: counter,
+	})
+	require.NoError(b, err)
+
+	rs.store = fullStore{s}
+	if delta {
+		rs.store = s
+	}
+
+	for i := 0; i < 500; i++ {
+		process := getDummyProcess()
+		process.ID = fmt.Sprintf("process%d", i)
+
+		err = rs.AddProcess(process)
+		require.NoError(b, err)
+	}
+
+	counter.written = 0
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		rs.SetProcessMetadata("process0", "foo", i)
+	}
+
+	b.ReportMetric(float64(counter.written)/float64(b.N), "written-B/op")
+}
+
+func BenchmarkSaveFull(b *testing.B) {
+	benchmarkSave(b, false)
+}
+
+func BenchmarkSaveDelta(b *testing.B) {
+	benchmarkSave(b, true)
+}
+
+func TestValidate(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	healthy := getDummyProcess()
+	healthy.ID = "healthy"
+
+	err = rs.AddProcess(healthy)
+	require.NoError(t, err)
+
+	producer := getDummyProcess()
+	producer.ID = "producer"
+
+	err = rs.AddProcess(producer)
+	require.NoError(t, err)
+
+	consumer := getDummyProcess()
+	consumer.ID = "consumer"
+	consumer.Input[0].Address = "#producer:output=out"
+
+	err = rs.AddProcess(consumer)
+	require.NoError(t, err)
+
+	version := getDummyProcess()
+	version.ID = "version"
+
+	err = rs.AddProcess(version)
+	require.NoError(t, err)
+
+	file := getDummyProcess()
+	file.ID = "file"
+	file.Output[0].Address = "/somewhere/else.mp4"
+
+	err = rs.AddProcess(file)
+	require.NoError(t, err)
+
+	require.Empty(t, rs.Validate())
+
+	err = rs.ForceDeleteProcess("producer")
+	require.NoError(t, err)
+
+	rs.tasks["version"].process.Config.FFVersion = "^5.0.0"
+
+	root := t.TempDir()
+
+	diskfs, err := fs.NewRootedDiskFilesystem(fs.RootedDiskConfig{
+		Root: root,
+	})
+	require.NoError(t, err)
+
+	diskfs.SetMetadata("base", root)
+
+	rs.fs.diskfs = append(rs.fs.diskfs, rfs.New(rfs.Config{FS: diskfs}))
+
+	problems := rs.Validate()
+
+	require.Equal(t, 3, len(problems), problems)
+
+	require.Equal(t, 1, len(problems["consumer"]))
+	require.Contains(t, problems["consumer"][0], "unknown process")
+
+	require.Equal(t, 1, len(problems["version"]))
+	require.Contains(t, problems["version"][0], "doesn't fit the constraint")
+
+	require.Equal(t, 1, len(problems["file"]))
+	require.Contains(t, problems["file"][0], "the address for output '#file:out' is invalid")
+
+	// The processes are not changed
+	for _, id := range []string{"healthy", "consumer", "version", "file"} {
+		require.True(t, rs.tasks[id].valid)
+	}
+
+	require.Equal(t, "^5.0.0", rs.tasks["version"].process.Config.FFVersion)
+	require.Equal(t, "/somewhere/else.mp4", rs.tasks["file"].config.Output[0].Address)
+}
+
+// progressParser always reports progress of the frames
+type progressParser struct {
+	process.Parser
+
+	progress app.Progress
+}
+
+func (p *progressParser) Parse(line string) uint64 {
+	return 1
+}
+
+func TestStaleParser(t *testing.T) {
+	fake := &progressParser{Parser: process.NewNullParser()}
+	progress := func() app.Progress { return fake.progress }
+
+	either := &staleParser{Parser: fake, metric: "either", progress: progress}
+	bytes := &staleParser{Parser: fake, metric: "bytes", progress: progress}
+
+	fake.progress.Output = []app.ProgressIO{{Size: 100}, {Size: 50}}
+
+	require.Equal(t, uint64(1), bytes.Parse(""))
+	require.Equal(t, uint64(1), either.Parse(""))
+
+	// The byte counter stalls while the frames are ticking
+	for i := 0; i < 3; i++ {
+		require.Equal(t, uint64(0), bytes.Parse(""))
+		require.Equal(t, uint64(1), either.Parse(""))
+	}
+
+	fake.progress.Output[1].Size = 80
+
+	require.Equal(t, uint64(1), bytes.Parse(""))
+	require.Equal(t, uint64(0), bytes.Parse(""))
+}
+
+func TestStaleMetric(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+	process.StaleMetric = "lines"
+
+	err = rs.AddProcess(process)
+	require.Error(t, err)
+
+	// The fake FFmpeg reports frames, but never any written bytes
+	process.StaleMetric = "frames"
+	process.StaleTimeout = 2
+	process.StaleAction = "stop"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	bytes := getDummyProcess()
+	bytes.ID = "bytes"
+	bytes.StaleMetric = "bytes"
+	bytes.StaleTimeout = 2
+	bytes.StaleAction = "stop"
+
+	err = rs.AddProcess(bytes)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(bytes.ID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		state, err := rs.GetProcessState(bytes.ID)
+		return err == nil && state.Order == "stop"
+	}, 10*time.Second, 100*time.Millisecond)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order)
+	require.Equal(t, "running", state.State)
+
+	rs.StopProcess(process.ID)
+}
+
+func TestLogAnnotations(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+
+	process.Options = append(process.Options, "-y")
+
+	err = rs.UpdateProcess(process.ID, process)
+	require.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+
+	log, err := rs.GetProcessLog(process.ID)
+	require.NoError(t, err)
+
+	// The log of the run before the update is in the history and the
+	// marker is at the boundary to the log of the current run
+	require.Equal(t, 1, len(log.History))
+	require.Empty(t, log.History[0].Annotations)
+	require.NotEmpty(t, log.History[0].Prelude)
+	require.Equal(t, 1, len(log.Annotations))
+	require.Equal(t, "update", log.Annotations[0].Reason)
+	require.True(t, log.History[0].CreatedAt.Before(log.Annotations[0].Timestamp))
+	require.False(t, log.CreatedAt.Before(log.Annotations[0].Timestamp))
+
+	rsi := rs.(*restream)
+
+	rsi.lock.Lock()
+	rsi.tasks[process.ID].process.Config.Options = append(rsi.tasks[process.ID].process.Config.Options, "-nostdin")
+	rsi.lock.Unlock()
+
+	err = rs.ReloadProcess(process.ID)
+	require.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+
+	log, err = rs.GetProcessLog(process.ID)
+	require.NoError(t, err)
+
+	require.Equal(t, 2, len(log.History))
+	require.Empty(t, log.History[0].Annotations)
+	require.Equal(t, 1, len(log.History[1].Annotations))
+	require.Equal(t, "update", log.History[1].Annotations[0].Reason)
+	require.Equal(t, 1, len(log.Annotations))
+	require.Equal(t, "reload", log.Annotations[0].Reason)
+
+	// The annotations are kept with the limited history
+	log, err = rs.GetProcessLogHistory(process.ID, 1)
+	require.NoError(t, err)
+
+	require.Equal(t, 1, len(log.History))
+	require.Equal(t, "update", log.History[0].Annotations[0].Reason)
+
+	rs.StopProcess(process.ID)
+}
+
+func TestSecrets(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	ffmpeg := &dummyFFmpeg{FFmpeg: rs.ffmpeg}
+	rs.ffmpeg = ffmpeg
+
+	process := getDummyProcess()
+	process.Input[0].Options = append(process.Input[0].Options, "-passphrase", "{secret:passphrase}")
+	process.Output[0].Address = "srt://example.com?streamid={secret:target}"
+
+	err = rs.AddProcess(process)
+	require.Error(t, err)
+
+	rs.secrets = func(name string) (string, error) {
+		switch name {
+		case "passphrase":
+			return "pass1234", nil
+		case "target":
+			return "unreachable42", nil
+		}
+
+		return "", fmt.Errorf("unknown secret")
+	}
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := rs.StreamProcessLog(ctx, process.ID)
+	require.NoError(t, err)
+
+	configs := ffmpeg.Configs()
+	pconfig := configs[len(configs)-1]
+
+	// The secrets are only used for starting FFmpeg
+	require.NotContains(t, strings.Join(pconfig.Command, " "), "pass1234")
+
+	args := strings.Join(pconfig.OnArgs(pconfig.Command), " ")
+	require.Contains(t, args, "-passphrase pass1234")
+	require.Contains(t, args, "srt://example.com?streamid=unreachable42")
+
+	// The resolved secrets are redacted in the log
+	pconfig.Parser.ResetLog()
+	pconfig.Parser.Parse("srt://example.com?streamid=unreachable42: Connection refused")
+
+	entry := <-ch
+	require.Equal(t, "srt://example.com?streamid={secret:target}: Connection refused", entry.Data)
+
+	config, err := rs.GetProcess(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "{secret:passphrase}", config.Config.Input[0].Options[4])
+	require.Equal(t, "srt://example.com?streamid={secret:target}", config.Config.Output[0].Address)
+
+	resolved, err := rs.GetResolvedConfig(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "{secret:passphrase}", resolved.Input[0].Options[4])
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Contains(t, state.Command, "{secret:passphrase}")
+	require.NotContains(t, strings.Join(state.Command, " "), "pass1234")
+	require.NotContains(t, strings.Join(state.Command, " "), "unreachable42")
+
+	data, err := json.Marshal(rs.storeData())
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "pass1234")
+	require.NotContains(t, string(data), "unreachable42")
+}
+
+func TestOutputSequence(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	ffmpeg := &dummyFFmpeg{FFmpeg: rs.ffmpeg}
+	rs.ffmpeg = ffmpeg
+
+	s := newDeltaStore()
+	rs.store = s
+
+	process := getDummyProcess()
+	process.WorkingDir = "/tmp/recordings"
+	process.Output[0].Address = "/tmp/rec-{sequence}.ts"
+
+	err = rs.AddProcess(process)
+	require.Error(t, err)
+
+	process.WorkingDir = ""
+	process.Output[0].Address = "/tmp/rec-{sequence,pad=3}-%03d.ts"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	configs := ffmpeg.Configs()
+	pconfig := configs[len(configs)-1]
+
+	require.Contains(t, pconfig.Command, "/tmp/rec-{sequence,pad=3}-%03d.ts")
+
+	// Every start of FFmpeg continues the sequence
+	require.Contains(t, pconfig.OnArgs(pconfig.Command), "/tmp/rec-001-%03d.ts")
+	require.Contains(t, pconfig.OnArgs(pconfig.Command), "/tmp/rec-002-%03d.ts")
+
+	require.Eventually(t, func() bool {
+		rs.lock.RLock()
+		defer rs.lock.RUnlock()
+
+		return rs.tasks[process.ID].metadata["sequence"] == uint64(2)
+	}, 5*time.Second, 50*time.Millisecond)
+
+	requireStored(t, rs, s)
+
+	// The sequence number is reserved
+	_, err = rs.GetProcessMetadata(process.ID, "sequence")
+	require.ErrorIs(t, err, ErrMetadataKeyNotFound)
+
+	err = rs.SetProcessMetadata(process.ID, "sequence", 0)
+	require.ErrorIs(t, err, ErrReservedMetadataKey)
+
+	// The sequence continues after loading the processes from the store
+	rsi, err = New(Config{
+		FFmpeg: ffmpeg,
+		Store:  s,
+	})
+	require.NoError(t, err)
+
+	rs = rsi.(*restream)
+
+	err = rs.ReloadProcess(process.ID)
+	require.NoError(t, err)
+
+	configs = ffmpeg.Configs()
+	pconfig = configs[len(configs)-1]
+
+	require.Contains(t, pconfig.OnArgs(pconfig.Command), "/tmp/rec-003-%03d.ts")
+
+	// An update continues the sequence, also if the sequence number hasn't been stored yet
+	require.Contains(t, pconfig.OnArgs(pconfig.Command), "/tmp/rec-004-%03d.ts")
+
+	update := process.Clone()
+	update.ID = "renamed"
+
+	err = rs.UpdateProcess(process.ID, update)
+	require.NoError(t, err)
+
+	configs = ffmpeg.Configs()
+	pconfig = configs[len(configs)-1]
+
+	require.Contains(t, pconfig.OnArgs(pconfig.Command), "/tmp/rec-005-%03d.ts")
+
+	require.Eventually(t, func() bool {
+		rs.lock.RLock()
+		defer rs.lock.RUnlock()
+
+		return rs.tasks["renamed"].metadata["sequence"] == uint64(5)
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestValidationErrors(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+	rs.strictVars = true
+
+	type result struct {
+		kind   error
+		ioType string
+		ioID   string
+		field  string
+	}
+
+	tests := map[string]struct {
+		modify func(config *app.Config)
+		result result
+	}{
+		"no inputs": {
+			func(config *app.Config) { config.Input = nil },
+			result{ErrMissingIO, "", "", "input"},
+		},
+		"no outputs": {
+			func(config *app.Config) { config.Output = nil },
+			result{ErrMissingIO, "", "", "output"},
+		},
+		"stale action": {
+			func(config *app.Config) { config.StaleAction = "foobar" },
+			result{ErrInvalidValue, "", "", "stale_action"},
+		},
+		"reference behavior": {
+			func(config *app.Config) { config.ReferenceBehavior = "foobar" },
+			result{ErrInvalidValue, "", "", "reference_behavior"},
+		},
+		"unknown variable": {
+			func(config *app.Config) { config.Output[0].Options[0] = "{vars:foobar}" },
+			result{ErrUnresolvedPlaceholder, "", "", "vars"},
+		},
+		"empty input ID": {
+			func(config *app.Config) { config.Input[0].ID = " " },
+			result{ErrEmptyIOID, "input", "", "id"},
+		},
+		"duplicate input ID": {
+			func(config *app.Config) { config.Input = append(config.Input, config.Input[0]) },
+			result{ErrDuplicateIOID, "input", "in", "id"},
+		},
+		"empty input address": {
+			func(config *app.Config) { config.Input[0].Address = " " },
+			result{ErrInputAddressEmpty, "input", "in", "address"},
+		},
+		"invalid input address": {
+			func(config *app.Config) { config.Input[0].Address = "rtmp://ex ample.com/live" },
+			result{ErrInvalidAddress, "input", "in", "address"},
+		},
+		"duplicate output ID": {
+			func(config *app.Config) { config.Output = append(config.Output, config.Output[0]) },
+			result{ErrDuplicateIOID, "output", "out", "id"},
+		},
+		"empty output address": {
+			func(config *app.Config) { config.Output[0].Address = " " },
+			result{ErrOutputAddressEmpty, "output", "out", "address"},
+		},
+		"output type": {
+			func(config *app.Config) { config.Output[0].OutputType = "foobar" },
+			result{ErrInvalidValue, "output", "out", "output_type"},
+		},
+		"output outside of base": {
+			func(config *app.Config) {
+				config.WorkingDir = "/tmp/recordings"
+				config.Output[0].Address = "/tmp/other/recording.ts"
+			},
+			result{ErrAddressOutsideBase, "output", "out", "address"},
+		},
+	}
+
+	for name, test := range tests {
+		process := getDummyProcess()
+		test.modify(process)
+
+		err := rs.AddProcess(process)
+		require.Error(t, err, name)
+		require.ErrorIs(t, err, test.result.kind, name)
+
+		verr := &ValidationError{}
+		require.True(t, errors.As(err, &verr), name)
+		require.Equal(t, process.ID, verr.ProcessID, name)
+		require.Equal(t, test.result.ioType, verr.IOType, name)
+		require.Equal(t, test.result.ioID, verr.IOID, name)
+		require.Equal(t, test.result.field, verr.Field, name)
+		require.Equal(t, verr.Message, err.Error(), name)
+	}
+
+	// The human-readable message and the cause are kept
+	process := getDummyProcess()
+	process.WorkingDir = "/tmp/recordings"
+	process.Output[0].Address = "/tmp/other/recording.ts"
+
+	err = rs.AddProcess(process)
+	require.EqualError(t, err, "the address for output '#process:out' is invalid: /tmp/other/recording.ts is not inside of /tmp/recordings/")
+	require.NotErrorIs(t, err, ErrInvalidAddress)
+
+	process = getDummyProcess()
+	process.Output[0].Address = "rtmp://ex ample.com/live|-"
+
+	err = rs.AddProcess(process)
+	require.ErrorIs(t, err, ErrInvalidAddress)
+
+	teeErr := &TeeError{}
+	require.True(t, errors.As(err, &teeErr))
+	require.Equal(t, 0, teeErr.Targets[0].Index)
+}
+
+func TestParseBitrate(t *testing.T) {
+	bitrates := map[string]int64{
+		"2500000": 2500000,
+		"128k":    128000,
+		"128K":    128000,
+		"2.5M":    2500000,
+		"1G":      1000000000,
+		"1Ki":     1024,
+		"2Mi":     2097152,
+		"":        -1,
+		"fast":    -1,
+		"-1k":     -1,
+		"12i":     -1,
+	}
+
+	for value, bitrate := range bitrates {
+		require.Equal(t, bitrate, parseBitrate(value), value)
+	}
+}
+
+func TestEstimateOutputBitrate(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+	process.Output = []app.ConfigIO{
+		{ID: "explicit", Address: "-", Options: []string{"-c:v", "libx264", "-b:v", "{vars:bitrate}", "-c:a", "aac", "-b:a", "128k", "-f", "null"}},
+		{ID: "maxrate", Address: "-", Options: []string{"-c:v", "libx264", "-maxrate", "1M", "-bufsize", "2M", "-an", "-f", "null"}},
+		{ID: "copy", Address: "-", Options: []string{"-codec", "copy", "-f", "null"}},
+		{ID: "partial", Address: "-", Options: []string{"-c:v", "copy", "-c:a", "aac", "-b:a", "128k", "-f", "null"}},
+		{ID: "unspecified", Address: "-", Options: []string{"-c:v", "libx264", "-f", "null"}},
+	}
+	process.Vars = map[string]string{"bitrate": "2M"}
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	bitrates, err := rs.EstimateOutputBitrate(process.ID)
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]int64{
+		"explicit":    2128000,
+		"maxrate":     1000000,
+		"copy":        -1,
+		"partial":     -1,
+		"unspecified": -1,
+	}, bitrates)
+
+	_, err = rs.EstimateOutputBitrate("foobar")
+	require.ErrorIs(t, err, ErrUnknownProcess)
+}
+
+func TestFilesystemSelectionPolicy(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	roots := []string{}
+	disks := []rfs.Filesystem{}
+
+	for i := 0; i < 2; i++ {
+		root := t.TempDir()
+
+		diskfs, err := fs.NewRootedDiskFilesystem(fs.RootedDiskConfig{
+			Root: root,
+		})
+		require.NoError(t, err)
+
+		diskfs.SetMetadata("base", root)
+
+		roots = append(roots, root)
+		disks = append(disks, rfs.New(rfs.Config{FS: diskfs}))
+	}
+
+	// The first disk is fuller than the second disk
+	_, _, err = disks[0].WriteFile("/data.bin", []byte("foobarfoobar"))
+	require.NoError(t, err)
+
+	rs.fs.list = append(rs.fs.list, disks...)
+	rs.fs.diskfs = append(rs.fs.diskfs, disks...)
+	rs.fsSelection.policy = "least-full"
+
+	process := getDummyProcess()
+	process.Output[0].Address = "live/stream.m3u8"
+	process.Output[0].Cleanup = []app.ConfigIOCleanup{
+		{Pattern: "live/*.ts", MaxFiles: 1},
+	}
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	config, err := rs.GetResolvedConfig(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, roots[1]+"/live/stream.m3u8", config.Output[0].Address)
+
+	// The selected filesystem is stored with the process
+	stored, err := rs.GetProcess(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, roots[1]+"/live/stream.m3u8", stored.Config.Output[0].Address)
+
+	// The cleanup is routed to the selected disk
+	for _, disk := range disks {
+		for _, name := range []string{"/live/a.ts", "/live/b.ts"} {
+			_, _, err = disk.WriteFile(name, []byte("segment"))
+			require.NoError(t, err)
+		}
+	}
+
+	require.Equal(t, 0, len(disks[0].DryRunCleanup(process.ID)))
+	require.Equal(t, 1, len(disks[1].DryRunCleanup(process.ID)))
+
+	// Absolute paths are not affected by the policy
+	absolute := getDummyProcess()
+	absolute.ID = "absolute"
+	absolute.Output[0].Address = roots[0] + "/abs/stream.m3u8"
+
+	err = rs.AddProcess(absolute)
+	require.NoError(t, err)
+
+	config, err = rs.GetResolvedConfig(absolute.ID)
+	require.NoError(t, err)
+	require.Equal(t, roots[0]+"/abs/stream.m3u8", config.Output[0].Address)
+
+	rs.fsSelection.policy = "round-robin"
+
+	bases := []string{}
+
+	for i := 0; i < 2; i++ {
+		process := getDummyProcess()
+		process.ID = fmt.Sprintf("rr%d", i)
+		process.Output[0].Address = fmt.Sprintf("rr%d/stream.m3u8", i)
+
+		err = rs.AddProcess(process)
+		require.NoError(t, err)
+
+		config, err := rs.GetResolvedConfig(process.ID)
+		require.NoError(t, err)
+
+		bases = append(bases, filepath.Dir(filepath.Dir(config.Output[0].Address)))
+	}
+
+	require.ElementsMatch(t, roots, bases)
+
+	// A validation or a reload doesn't select another filesystem
+	rs.Validate()
+
+	err = rs.ReloadProcess("rr0")
+	require.NoError(t, err)
+
+	config, err = rs.GetResolvedConfig("rr0")
+	require.NoError(t, err)
+	require.Equal(t, bases[0], filepath.Dir(filepath.Dir(config.Output[0].Address)))
+
+	process = getDummyProcess()
+	process.ID = "rr2"
+	process.Output[0].Address = "rr2/stream.m3u8"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	config, err = rs.GetResolvedConfig(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, bases[0], filepath.Dir(filepath.Dir(config.Output[0].Address)))
+}
+
+func TestNamedPipes(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	producer := getDummyProcess()
+	producer.ID = "producer"
+	producer.Output[0].Address = "pipe:feed1"
+
+	err = rs.AddProcess(producer)
+	require.Error(t, err, "named pipes should not be available without a directory")
+
+	dir := t.TempDir()
+	rs.pipeDir = dir
+
+	err = rs.AddProcess(producer)
+	require.NoError(t, err)
+
+	fifo := filepath.Join(dir, "feed1")
+
+	info, err := os.Stat(fifo)
+	require.NoError(t, err)
+	require.NotEqual(t, os.FileMode(0), info.Mode()&os.ModeNamedPipe, "not a FIFO")
+
+	require.Equal(t, "file:"+fifo, rs.tasks["producer"].config.Output[0].Address)
+
+	consumer := getDummyProcess()
+	consumer.ID = "consumer"
+	consumer.Input[0].Address = "pipe:feed1"
+	consumer.Input[0].Options = []string{"-f", "mpegts"}
+
+	err = rs.AddProcess(consumer)
+	require.NoError(t, err)
+
+	require.Equal(t, "file:"+fifo, rs.tasks["consumer"].config.Input[0].Address)
+	require.Contains(t, rs.tasks["consumer"].command, "file:"+fifo)
+
+	// The name of a named pipe has to be unique
+	other := getDummyProcess()
+	other.ID = "other"
+	other.Output[0].Address = "pipe:feed1"
+
+	err = rs.AddProcess(other)
+	require.Error(t, err)
+
+	twice := getDummyProcess()
+	twice.ID = "twice"
+	twice.Output[0].Address = "pipe:feed3"
+	twice.Output = append(twice.Output, app.ConfigIO{ID: "out2", Address: "pipe:feed3"})
+
+	err = rs.AddProcess(twice)
+	require.Error(t, err)
+
+	// Updating the process keeps the FIFOs that are still written
+	producer.Output = append(producer.Output, app.ConfigIO{ID: "out2", Address: "pipe:feed2"})
+
+	err = rs.UpdateProcess("producer", producer)
+	require.NoError(t, err)
+
+	require.FileExists(t, fifo)
+	require.FileExists(t, filepath.Join(dir, "feed2"))
+
+	producer.Output = producer.Output[1:]
+
+	err = rs.UpdateProcess("producer", producer)
+	require.NoError(t, err)
+
+	require.NoFileExists(t, fifo)
+	require.FileExists(t, filepath.Join(dir, "feed2"))
+
+	err = rs.DeleteProcess("producer")
+	require.NoError(t, err)
+
+	require.NoFileExists(t, filepath.Join(dir, "feed2"))
+
+	// pipe:0 and pipe:1 are not named pipes
+	stdout := getDummyProcess()
+	stdout.ID = "stdout"
+	stdout.Output[0].Address = "pipe:1"
+
+	err = rs.AddProcess(stdout)
+	require.NoError(t, err)
+
+	require.Empty(t, rs.tasks["stdout"].fifos)
+}
+
+// stuckProcess is a process that doesn't exit on Stop until it is killed
+type stuckProcess struct {
+	process.Process
+
+	killed chan struct{}
+	once   sync.Once
+}
+
+func (p *stuckProcess) Stop(wait bool) error {
+	<-p.killed
+	return nil
+}
+
+func (p *stuckProcess) ForceKill() error {
+	p.once.Do(func() { close(p.killed) })
+	return nil
+}
+
+func (p *stuckProcess) IsRunning() bool {
+	select {
+	case <-p.killed:
+		return false
+	default:
+		return true
+	}
+}
+
+func TestStopContext(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	stuck := getDummyProcess()
+	stuck.ID = "stuck"
+	err = rs.AddProcess(stuck)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	proc := &stuckProcess{killed: make(chan struct{})}
+	rs.tasks["stuck"].ffmpeg = proc
+
+	rs.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	rs.StopContext(ctx)
+
+	require.Less(t, time.Since(start), 5*time.Second)
+	require.False(t, proc.IsRunning(), "the stuck process should have been killed")
+	require.False(t, rs.tasks[process.ID].ffmpeg.IsRunning())
+
+	// The order is kept for the next start
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order)
+}
+
+// launchTracker records the starts of the launchProcess fakes
+type launchTracker struct {
+	running  int
+	max      int
+	finished map[string]bool
+	order    map[string]bool // Whether the producer finished starting before a process started
+	lock     sync.Mutex
+}
+
+// launchProcess is a process that takes a while to start
+type launchProcess struct {
+	process.Process
+
+	id      string
+	tracker *launchTracker
+	started bool
+	lock    sync.Mutex
+}
+
+func (p *launchProcess) Start() error {
+	p.tracker.lock.Lock()
+	p.tracker.running++
+	if p.tracker.running > p.tracker.max {
+		p.tracker.max = p.tracker.running
+	}
+	p.tracker.order[p.id] = p.tracker.finished["producer"]
+	p.tracker.lock.Unlock()
+
+	time.Sleep(100 * time.Millisecond)
+
+	p.lock.Lock()
+	p.started = true
+	p.lock.Unlock()
+
+	p.tracker.lock.Lock()
+	p.tracker.running--
+	p.tracker.finished[p.id] = true
+	p.tracker.lock.Unlock()
+
+	return nil
+}
+
+func (p *launchProcess) Stop(wait bool) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.started = false
+
+	return nil
+}
+
+func (p *launchProcess) IsRunning() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.started
+}
+
+func (p *launchProcess) Status() process.Status {
+	status := process.Status{Order: "stop"}
+	if p.IsRunning() {
+		status.Order = "start"
+	}
+
+	return status
+}
+
+func TestStartConcurrency(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+	rs.maxStartConcurrency = 3
+
+	producer := getDummyProcess()
+	producer.ID = "producer"
+	producer.Output[0].Address = "rtmp://example.com/live/stream"
+
+	err = rs.AddProcess(producer)
+	require.NoError(t, err)
+
+	consumer := getDummyProcess()
+	consumer.ID = "consumer"
+	consumer.Input[0].Address = "#producer:output=out"
+	consumer.Input[0].Options = []string{}
+	consumer.ReferenceBehavior = "start-dependency"
+
+	err = rs.AddProcess(consumer)
+	require.NoError(t, err)
+
+	for i := 0; i < 7; i++ {
+		process := getDummyProcess()
+		process.ID = fmt.Sprintf("process%d", i)
+
+		err = rs.AddProcess(process)
+		require.NoError(t, err)
+	}
+
+	tracker := &launchTracker{
+		finished: map[string]bool{},
+		order:    map[string]bool{},
+	}
+
+	for id, task := range rs.tasks {
+		task.process.Order = "start"
+		task.ffmpeg = &launchProcess{id: id, tracker: tracker}
+	}
+
+	// The producer is only started because of the consumer
+	rs.tasks["producer"].process.Order = "stop"
+
+	rs.Start()
+
+	require.Equal(t, int64(9), rs.nProc)
+
+	for id, task := range rs.tasks {
+		require.True(t, task.ffmpeg.IsRunning(), id)
+		require.Equal(t, "start", task.process.Order, id)
+	}
+
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	require.Equal(t, 9, len(tracker.finished))
+	require.Equal(t, 3, tracker.max)
+	require.True(t, tracker.order["consumer"], "the consumer must be started after the producer")
+}
+
+func TestStartConcurrencyAborted(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+	rs.maxStartConcurrency = 2
+
+	for i := 0; i < 4; i++ {
+		process := getDummyProcess()
+		process.ID = fmt.Sprintf("process%d", i)
+
+		err = rs.AddProcess(process)
+		require.NoError(t, err)
+	}
+
+	tracker := &launchTracker{
+		finished: map[string]bool{},
+		order:    map[string]bool{},
+	}
+
+	for id, task := range rs.tasks {
+		task.process.Order = "start"
+		task.ffmpeg = &launchProcess{id: id, tracker: tracker}
+	}
+
+	// The context gets cancelled after two of the processes have been launched
+	err = rs.StartContext(&countdownContext{Context: context.Background(), n: 4 + 2})
+	require.ErrorIs(t, err, context.Canceled)
+
+	running := 0
+	for _, task := range rs.tasks {
+		if task.ffmpeg.IsRunning() {
+			running++
+		}
+
+		require.Equal(t, "start", task.process.Order)
+	}
+
+	require.Equal(t, 2, running)
+	require.Equal(t, int64(2), rs.nProc)
+
+	err = rs.StartContext(context.Background())
+	require.NoError(t, err)
+
+	for id, task := range rs.tasks {
+		require.True(t, task.ffmpeg.IsRunning(), id)
+	}
+
+	require.Equal(t, int64(4), rs.nProc)
+}
+
+func TestStartConcurrencyAbortedStop(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+	rs.maxStartConcurrency = 2
+
+	for i := 0; i < 4; i++ {
+		process := getDummyProcess()
+		process.ID = fmt.Sprintf("process%d", i)
+
+		err = rs.AddProcess(process)
+		require.NoError(t, err)
+	}
+
+	tracker := &launchTracker{
+		finished: map[string]bool{},
+		order:    map[string]bool{},
+	}
+
+	for id, task := range rs.tasks {
+		task.process.Order = "start"
+		task.ffmpeg = &launchProcess{id: id, tracker: tracker}
+	}
+
+	err = rs.StartContext(&countdownContext{Context: context.Background(), n: 4 + 2})
+	require.ErrorIs(t, err, context.Canceled)
+
+	require.Equal(t, int64(2), rs.nProc)
+
+	// Stopping the processes that have not been launched doesn't count them again
+	for id, task := range rs.tasks {
+		if task.ffmpeg.IsRunning() {
+			continue
+		}
+
+		err = rs.StopProcess(id)
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, int64(2), rs.nProc)
+
+	for id := range rs.tasks {
+		err = rs.StopProcess(id)
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, int64(0), rs.nProc)
+}
+
+func TestStartConcurrencyUnlocked(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+	rs.maxStartConcurrency = 2
+
+	for i := 0; i < 4; i++ {
+		process := getDummyProcess()
+		process.ID = fmt.Sprintf("process%d", i)
+
+		err = rs.AddProcess(process)
+		require.NoError(t, err)
+	}
+
+	tracker := &launchTracker{
+		finished: map[string]bool{},
+		order:    map[string]bool{},
+	}
+
+	for id, task := range rs.tasks {
+		task.process.Order = "start"
+		task.ffmpeg = &launchProcess{id: id, tracker: tracker}
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		rs.Start()
+		close(done)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+
+	// The processes are launched without holding the lock
+	start := time.Now()
+	err = rs.StopProcess("process3")
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), 100*time.Millisecond)
+
+	<-done
+
+	for id, task := range rs.tasks {
+		if id == "process3" {
+			require.False(t, task.ffmpeg.IsRunning(), "a process that has been stopped while launching must not run")
+			require.Equal(t, "stop", task.process.Order)
+			continue
+		}
+
+		require.True(t, task.ffmpeg.IsRunning(), id)
+	}
+
+	require.Equal(t, int64(3), rs.nProc)
+}
+
+// exitedProcess is a process whose last run exited with a fixed code and signal
+type exitedProcess struct {
+	process.Process
+
+	code   int
+	signal string
+}
+
+func (p *exitedProcess) Status() process.Status {
+	status := process.Status{
+		State: "killed",
+	}
+
+	status.Exit.Code = p.code
+	status.Exit.Signal = p.signal
+
+	return status
+}
+
+func TestProcessExit(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, -1, state.ExitCode, "the process never ran")
+	require.Equal(t, "", state.ExitSignal)
+
+	rs.tasks[process.ID].ffmpeg = &exitedProcess{code: -1, signal: "killed"}
+
+	state, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "killed", state.State)
+	require.Equal(t, -1, state.ExitCode)
+	require.Equal(t, "killed", state.ExitSignal)
+
+	rs.tasks[process.ID].ffmpeg = &exitedProcess{code: 1}
+
+	state, err = rs.GetProcessStateLite(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, state.ExitCode)
+	require.Equal(t, "", state.ExitSignal)
+}
+
+func TestSetMaxProcesses(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	for _, id := range []string{"process1", "process2", "process3"} {
+		process := getDummyProcess()
+		process.ID = id
+		require.NoError(t, rs.AddProcess(process))
+	}
+
+	previous, err := rs.SetMaxProcesses(1)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), previous)
+
+	require.NoError(t, rs.StartProcess("process1"))
+	require.Error(t, rs.StartProcess("process2"))
+
+	// Raising the limit allows to start more processes immediately
+	previous, err = rs.SetMaxProcesses(3)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), previous)
+
+	require.NoError(t, rs.StartProcess("process2"))
+	require.NoError(t, rs.StartProcess("process3"))
+
+	// Lowering the limit keeps the running processes
+	previous, err = rs.SetMaxProcesses(1)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), previous)
+
+	for _, id := range []string{"process1", "process2", "process3"} {
+		require.Equal(t, "start", rs.tasks[id].ffmpeg.Status().Order)
+	}
+
+	require.NoError(t, rs.StopProcess("process3"))
+	require.Error(t, rs.StartProcess("process3"))
+
+	require.NoError(t, rs.StopProcess("process2"))
+	require.Error(t, rs.StartProcess("process3"))
+
+	require.NoError(t, rs.StopProcess("process1"))
+	require.NoError(t, rs.StartProcess("process3"))
+
+	_, err = rs.SetMaxProcesses(-1)
+	require.Error(t, err)
+
+	rs.StopProcess("process3")
+}
+
+// skillsFFmpeg wraps a FFmpeg and reports the given skills
+type skillsFFmpeg struct {
+	ffmpeg.FFmpeg
+
+	skills skills.Skills
+}
+
+func (f *skillsFFmpeg) Skills() skills.Skills {
+	return f.skills
+}
+
+func TestFilterNames(t *testing.T) {
+	require.Equal(t, []string{"scale", "fps"}, filterNames("scale=1280:720,fps=25"))
+	require.Equal(t, []string{"split", "scale", "overlay"}, filterNames("[0:v]split[a][b];[a]scale=640:-1[c];[b][c] overlay=10:10[out]"))
+	require.Equal(t, []string{}, filterNames(""))
+}
+
+func TestRequireSkills(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	s := rs.ffmpeg.Skills()
+	s.Codecs.Video = []skills.Codec{{Id: "h264", Encoders: []string{"libx264"}, Decoders: []string{"h264"}}}
+	s.Formats.Demuxers = []skills.Format{{Id: "lavfi"}}
+	s.Formats.Muxers = []skills.Format{{Id: "null"}, {Id: "flv"}}
+	s.Filters = []skills.Filter{{Id: "scale"}}
+
+	ff := &skillsFFmpeg{FFmpeg: rs.ffmpeg, skills: s}
+	rs.ffmpeg = ff
+
+	process := getDummyProcess()
+	process.Output[0].Options = []string{"-c:v", "h264_nvenc", "-vf", "scale=1280:720,unknownfilter", "-f", "flv"}
+
+	// The skills are not checked if not enabled
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+	require.NoError(t, rs.DeleteProcess(process.ID))
+
+	rs.requireSkills = true
+
+	err = rs.AddProcess(process)
+	require.ErrorIs(t, err, ErrMissingSkills)
+	require.Contains(t, err.Error(), "encoder 'h264_nvenc'")
+	require.Contains(t, err.Error(), "filter 'unknownfilter'")
+	require.NotContains(t, err.Error(), "muxer")
+
+	verr := &ValidationError{}
+	require.ErrorAs(t, err, &verr)
+	require.Equal(t, process.ID, verr.ProcessID)
+	require.Equal(t, "options", verr.Field)
+
+	// An encoder can be selected by its name or the name of the codec
+	process.Output[0].Options = []string{"-c:v", "libx264", "-vf", "scale=1280:720", "-c:a", "copy", "-f", "flv"}
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	process2 := getDummyProcess()
+	process2.ID = "process2"
+	process2.Output[0].Options = []string{"-codec:v", "h264", "-f", "null"}
+
+	err = rs.AddProcess(process2)
+	require.NoError(t, err)
+
+	// The skills are checked again on start
+	ff.skills.Codecs.Video = nil
+
+	err = rs.StartProcess(process.ID)
+	require.ErrorIs(t, err, ErrMissingSkills)
+	require.Contains(t, err.Error(), "encoder 'libx264'")
+}
+
+func TestRequireSkillsOutputMuxers(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	s := rs.ffmpeg.Skills()
+	s.Formats.Demuxers = []skills.Format{{Id: "lavfi"}}
+	s.Formats.Muxers = []skills.Format{{Id: "null"}, {Id: "flv"}, {Id: "tee"}, {Id: "mpegts"}}
+
+	ff := &skillsFFmpeg{FFmpeg: rs.ffmpeg, skills: s}
+	rs.ffmpeg = ff
+
+	process := getDummyProcess()
+	process.Output[0].Address = "http://localhost/memfs/{processid}.m3u8"
+	process.Output[0].Options = []string{"-codec", "copy"}
+
+	// The muxers are not checked if not enabled
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+	require.NoError(t, rs.DeleteProcess(process.ID))
+
+	rs.requireSkills = true
+
+	// The muxer is inferred from the extension of the address
+	err = rs.AddProcess(process)
+	require.ErrorIs(t, err, ErrMissingSkills)
+	require.Contains(t, err.Error(), "muxer 'hls' for the output 'out'")
+
+	// The muxer given by the options is preferred
+	process.Output[0].Options = []string{"-codec", "copy", "-f", "mpegts"}
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+	require.NoError(t, rs.DeleteProcess(process.ID))
+
+	// The muxers of all targets of a tee output are checked
+	process.Output[0].Address = "[f=flv]rtmp://localhost/live/stream|http://localhost/memfs/{processid}.mp4"
+	process.Output[0].Options = []string{"-codec", "copy", "-f", "tee"}
+
+	err = rs.AddProcess(process)
+	require.ErrorIs(t, err, ErrMissingSkills)
+	require.Contains(t, err.Error(), "muxer 'mp4' for the output 'out'")
+	require.NotContains(t, err.Error(), "'flv'")
+
+	// Addresses without a known extension are not checked
+	process.Output[0].Address = "rtmp://localhost/live/stream"
+	process.Output[0].Options = []string{"-codec", "copy"}
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	// The muxers are checked again on start
+	ff.skills.Formats.Muxers = []skills.Format{{Id: "null"}}
+	process.Output[0].Options = []string{"-codec", "copy", "-f", "flv"}
+
+	err = rs.UpdateProcess(process.ID, process)
+	require.ErrorIs(t, err, ErrMissingSkills)
+
+	ff.skills.Formats.Muxers = []skills.Format{{Id: "null"}, {Id: "flv"}}
+
+	err = rs.UpdateProcess(process.ID, process)
+	require.NoError(t, err)
+
+	ff.skills.Formats.Muxers = []skills.Format{{Id: "null"}}
+
+	err = rs.StartProcess(process.ID)
+	require.ErrorIs(t, err, ErrMissingSkills)
+	require.Contains(t, err.Error(), "muxer 'flv' for the output 'out'")
+}
+
+func TestApplyTemporaryConfig(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	s := newDeltaStore()
+	rs.store = s
+
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	temporary := process.Clone()
+	temporary.Options = []string{"-loglevel", "debug"}
+
+	err = rs.ApplyTemporaryConfig(process.ID, temporary, 0)
+	require.Error(t, err, "the duration must be positive")
+
+	err = rs.ApplyTemporaryConfig("unknown", temporary, time.Second)
+	require.Error(t, err)
+
+	err = rs.ApplyTemporaryConfig(process.ID, temporary, 500*time.Millisecond)
+	require.NoError(t, err)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order)
+	require.Equal(t, []string{"-loglevel", "debug"}, state.Command[:2])
+
+	// The original config is stored
+	s.lock.Lock()
+	require.Equal(t, []string{"-loglevel", "info"}, s.data.Process[process.ID].Config.Options)
+	s.lock.Unlock()
+
+	require.Eventually(t, func() bool {
+		state, err := rs.GetProcessState(process.ID)
+		if err != nil {
+			return false
+		}
+
+		return strings.Join(state.Command[:2], " ") == "-loglevel info"
+	}, 5*time.Second, 50*time.Millisecond)
+
+	state, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order)
+	require.Nil(t, rs.tasks[process.ID].temporary)
+
+	requireStored(t, rs, s)
+
+	// An update cancels the revert
+	err = rs.ApplyTemporaryConfig(process.ID, temporary, 300*time.Millisecond)
+	require.NoError(t, err)
+
+	updated := process.Clone()
+	updated.Options = []string{"-loglevel", "warning"}
+
+	err = rs.UpdateProcess(process.ID, updated)
+	require.NoError(t, err)
+
+	time.Sleep(600 * time.Millisecond)
+
+	config, err := rs.GetProcess(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"-loglevel", "warning"}, config.Config.Options)
+
+	requireStored(t, rs, s)
+
+	// A delete cancels the revert
+	err = rs.ApplyTemporaryConfig(process.ID, temporary, 300*time.Millisecond)
+	require.NoError(t, err)
+
+	err = rs.StopProcess(process.ID)
+	require.NoError(t, err)
+
+	err = rs.DeleteProcess(process.ID)
+	require.NoError(t, err)
+
+	time.Sleep(600 * time.Millisecond)
+
+	_, err = rs.GetProcess(process.ID)
+	require.ErrorIs(t, err, ErrUnknownProcess)
+}
+
+func TestApplyTemporaryConfigRevertFailed(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	s := newDeltaStore()
+	rs.store = s
+
+	root := t.TempDir()
+
+	diskfs, err := fs.NewRootedDiskFilesystem(fs.RootedDiskConfig{
+		Root: root,
+	})
+	require.NoError(t, err)
+
+	diskfs.SetMetadata("base", root)
+
+	rs.fs.list = append(rs.fs.list, rfs.New(rfs.Config{FS: diskfs}))
+	rs.fs.diskfs = append(rs.fs.diskfs, rs.fs.list[len(rs.fs.list)-1])
+
+	process := getDummyProcess()
+	process.Output[0].Address = root + "/out.ts"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	temporary := process.Clone()
+	temporary.Output[0].Address = root + "/temporary.ts"
+
+	err = rs.ApplyTemporaryConfig(process.ID, temporary, 100*time.Millisecond)
+	require.NoError(t, err)
+
+	// The other process prevents the revert because it writes to the original output
+	other := process.Clone()
+	other.ID = "other"
+
+	err = rs.AddProcess(other)
+	require.NoError(t, err)
+
+	time.Sleep(500 * time.Millisecond)
+
+	rs.lock.RLock()
+	require.NotNil(t, rs.tasks[process.ID].temporary)
+	require.Equal(t, root+"/temporary.ts", rs.tasks[process.ID].config.Output[0].Address)
+	rs.lock.RUnlock()
+
+	s.lock.Lock()
+	require.Equal(t, root+"/out.ts", s.data.Process[process.ID].Config.Output[0].Address)
+	s.lock.Unlock()
+
+	err = rs.DeleteProcess(other.ID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		rs.lock.RLock()
+		defer rs.lock.RUnlock()
+
+		task := rs.tasks[process.ID]
+
+		return task.temporary == nil && task.config.Output[0].Address == root+"/out.ts"
+	}, 5*time.Second, 50*time.Millisecond)
+
+	requireStored(t, rs, s)
+}
+
+func TestAuditTrail(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+
+	// Nothing is recorded if the audit trail is not enabled
+	require.NoError(t, rs.AddProcess(process))
+
+	history, err := rs.GetProcessHistory(process.ID)
+	require.NoError(t, err)
+	require.Empty(t, history)
+
+	require.NoError(t, rs.DeleteProcess(process.ID))
+
+	rs.auditTrail = true
+
+	alice := rs.WithActor("alice")
+
+	require.NoError(t, alice.AddProcess(process))
+	require.NoError(t, rs.UpdateProcess(process.ID, getDummyProcess()))
+	require.NoError(t, alice.StartProcess(process.ID))
+	require.NoError(t, rs.WithActor("bob").StopProcess(process.ID))
+	require.NoError(t, alice.ReloadProcess(process.ID))
+
+	// Failed operations are not recorded
+	require.Error(t, alice.UpdateProcess(process.ID, &app.Config{ID: process.ID}))
+
+	history, err = rs.GetProcessHistory(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, 5, len(history))
+
+	operations := []string{}
+	actors := []string{}
+	for _, e := range history {
+		operations = append(operations, e.Operation)
+		actors = append(actors, e.Actor)
+		require.False(t, e.Timestamp.IsZero())
+	}
+
+	require.Equal(t, []string{"add", "update", "start", "stop", "reload"}, operations)
+	require.Equal(t, []string{"alice", "", "alice", "bob", "alice"}, actors)
+
+	// The audit trail is persisted in the metadata of the process
+	data, err := json.Marshal(rs.storeData())
+	require.NoError(t, err)
+
+	stored := store.NewStoreData()
+	require.NoError(t, json.Unmarshal(data, &stored))
+
+	rs.tasks[process.ID].metadata = stored.Metadata.Process[process.ID]
+
+	restored, err := rs.GetProcessHistory(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, len(history), len(restored))
+	require.Equal(t, "bob", restored[3].Actor)
+	require.True(t, history[3].Timestamp.Equal(restored[3].Timestamp))
+
+	_, err = rs.GetProcessHistory("foobar")
+	require.ErrorIs(t, err, ErrUnknownProcess)
+
+	// The audit trail can't be changed or read as metadata
+	err = rs.SetProcessMetadata(process.ID, "audit", nil)
+	require.ErrorIs(t, err, ErrReservedMetadataKey)
+
+	_, err = rs.SetProcessMetadataBulk([]string{process.ID}, "audit", "foobar")
+	require.ErrorIs(t, err, ErrReservedMetadataKey)
+
+	err = rs.SetMetadata("audit", "foobar")
+	require.ErrorIs(t, err, ErrReservedMetadataKey)
+
+	_, err = rs.GetProcessMetadata(process.ID, "audit")
+	require.ErrorIs(t, err, ErrMetadataKeyNotFound)
+
+	metadata, err := rs.GetProcessMetadata(process.ID, "")
+	require.NoError(t, err)
+	require.Nil(t, metadata)
+
+	// The other operations record the actor as well
+	carol := rs.WithActor("carol")
+
+	config := getDummyProcess()
+	config.GroupID = "group"
+
+	created, err := carol.PutProcess(config)
+	require.NoError(t, err)
+	require.False(t, created)
+
+	require.NoError(t, carol.StartGroup("group"))
+	require.NoError(t, carol.RestartProcess(process.ID))
+	require.NoError(t, carol.StopGroup("group"))
+	require.NoError(t, carol.DeleteProcess(process.ID))
+
+	// The audit trail of a deleted process is kept
+	history, err = rs.GetProcessHistory(process.ID)
+	require.NoError(t, err)
+
+	operations = []string{}
+	for _, e := range history[5:] {
+		operations = append(operations, e.Operation)
+		require.Equal(t, "carol", e.Actor)
+	}
+
+	require.Equal(t, []string{"update", "start", "restart", "stop", "delete"}, operations)
+
+	_, err = rs.GetMetadata("audit")
+	require.ErrorIs(t, err, ErrMetadataKeyNotFound)
+
+	// A process with the same ID continues the audit trail
+	require.NoError(t, alice.AddProcess(getDummyProcess()))
+
+	history, err = rs.GetProcessHistory(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, 11, len(history))
+	require.Equal(t, "add", history[10].Operation)
+
+	// The deletion of a group is recorded for each process
+	config = getDummyProcess()
+	config.ID = "grouped"
+	config.GroupID = "group"
+
+	require.NoError(t, rs.AddProcess(config))
+	require.NoError(t, rs.WithActor("dave").DeleteGroup("group"))
+
+	history, err = rs.GetProcessHistory(config.ID)
+	require.NoError(t, err)
+	require.Equal(t, "delete", history[len(history)-1].Operation)
+	require.Equal(t, "dave", history[len(history)-1].Actor)
+}
+
+func TestSwitchInput(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+	process.Input[0].Address = "playout:" + process.Input[0].Address
+
+	require.NoError(t, rs.AddProcess(process))
+	require.NoError(t, rs.StartProcess(process.ID))
+
+	status := http.StatusNoContent
+	streams := []string{}
+	lock := sync.Mutex{}
+
+	// Fake playout that accepts or rejects the switch of the stream
+	playout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := io.ReadAll(req.Body)
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		if req.Method == http.MethodPut && req.URL.Path == "/v1/stream" {
+			streams = append(streams, string(data))
+		}
+
+		w.WriteHeader(status)
+	}))
+	defer playout.Close()
+
+	port, err := strconv.Atoi(playout.URL[strings.LastIndex(playout.URL, ":")+1:])
+	require.NoError(t, err)
+
+	rs.tasks[process.ID].playout[process.Input[0].ID] = port
+
+	err = rs.SwitchInput(process.ID, "foobar", "testsrc=size=640x360:rate=25")
+	require.Error(t, err)
+
+	err = rs.SwitchInput(process.ID, process.Input[0].ID, "")
+	require.Error(t, err)
+
+	// The stream is switched live without restarting the process
+	proc := rs.tasks[process.ID].ffmpeg
+
+	err = rs.SwitchInput(process.ID, process.Input[0].ID, "testsrc=size=640x360:rate=25")
+	require.NoError(t, err)
+
+	require.Same(t, proc, rs.tasks[process.ID].ffmpeg)
+	require.Equal(t, []string{"testsrc=size=640x360:rate=25"}, streams)
+	require.Equal(t, "playout:testsrc=size=640x360:rate=25", rs.tasks[process.ID].process.Config.Input[0].Address)
+	require.Equal(t, "playout:testsrc=size=640x360:rate=25", rs.tasks[process.ID].config.Input[0].Address)
+	require.Contains(t, rs.tasks[process.ID].command, "playout:testsrc=size=640x360:rate=25")
+
+	// A restart of the running FFmpeg uses the new address
+	require.Equal(t, rs.tasks[process.ID].command, rs.tasks[process.ID].liveArgs([]string{"-i", "old"}))
+
+	// The process is reloaded if the playout can't switch the stream
+	lock.Lock()
+	status = http.StatusBadRequest
+	lock.Unlock()
+
+	err = rs.SwitchInput(process.ID, process.Input[0].ID, "testsrc=size=320x180:rate=25")
+	require.NoError(t, err)
+
+	require.NotSame(t, proc, rs.tasks[process.ID].ffmpeg)
+	require.Equal(t, 2, len(streams))
+	require.Equal(t, "playout:testsrc=size=320x180:rate=25", rs.tasks[process.ID].process.Config.Input[0].Address)
+	require.Contains(t, rs.tasks[process.ID].command, "playout:testsrc=size=320x180:rate=25")
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order)
+
+	rs.StopProcess(process.ID)
+}
+
+func TestMigrateLegacyStore(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	legacy := `{
+		"version": 4,
+		"process": {
+			"process": {
+				"id": "process",
+				"reference": "",
+				"config": {
+					"id": "process",
+					"input": [{"address": "testsrc=size=1280x720:rate=25", "options": ["-f", "lavfi"]}],
+					"output": [{
+						"id": "out",
+						"address": "-",
+						"options": ["-codec", "copy", "-f", "null"],
+						"cleanup": [
+							{"pattern": "diskfs:/{processid}/*.ts", "max_files": 10},
+							{"pattern": "memfs:/{processid}.m3u8", "max_file_age_seconds": 60},
+							{"pattern": "/{processid}/*.jpg", "max_files": 5, "filesystem": "diskfs"}
+						]
+					}]
+				},
+				"created_at": 1700000000,
+				"updated_at": 1700000000
+			}
+		}
+	}`
+
+	s := newDeltaStore()
+	err = json.Unmarshal([]byte(legacy), &s.data)
+	require.NoError(t, err)
+
+	rsi, err = New(Config{
+		FFmpeg: rsi.(*restream).ffmpeg,
+		Store:  s,
+	})
+	require.NoError(t, err)
+
+	process, err := rsi.GetProcess("process")
+	require.NoError(t, err)
+	require.Equal(t, processSchema, process.Schema)
+	require.Equal(t, "stop", process.Order)
+	require.Equal(t, "input_0", process.Config.Input[0].ID)
+	require.Equal(t, []app.ConfigIOCleanup{
+		{Pattern: "disk:/{processid}/*.ts", MaxFiles: 10},
+		{Pattern: "mem:/{processid}.m3u8", MaxFileAge: 60},
+		{Pattern: "/{processid}/*.jpg", MaxFiles: 5, Filesystem: "disk"},
+	}, process.Config.Output[0].Cleanup)
+
+	// The migrated process has been written in the current format
+	require.Equal(t, 1, s.writes())
+	require.Equal(t, processSchema, s.data.Process["process"].Schema)
+	require.Equal(t, "disk:/{processid}/*.ts", s.data.Process["process"].Config.Output[0].Cleanup[0].Pattern)
+
+	// A process with the current schema isn't migrated again
+	s.data.Process["process"].Config.Output[0].Cleanup[0].Pattern = "diskfs:/{processid}/*.ts"
+
+	rsi, err = New(Config{
+		FFmpeg: rsi.(*restream).ffmpeg,
+		Store:  s,
+	})
+	require.NoError(t, err)
+
+	process, err = rsi.GetProcess("process")
+	require.NoError(t, err)
+	require.Equal(t, "diskfs:/{processid}/*.ts", process.Config.Output[0].Cleanup[0].Pattern)
+
+	// The legacy names in a new config are renamed as well
+	config := process.Config.Clone()
+	config.ID = "added"
+	config.Output[0].Cleanup = config.Output[0].Cleanup[:2]
+
+	err = rsi.AddProcess(config)
+	require.NoError(t, err)
+
+	process, err = rsi.GetProcess("added")
+	require.NoError(t, err)
+	require.Equal(t, "disk:/{processid}/*.ts", process.Config.Output[0].Cleanup[0].Pattern)
+}
+
+func TestClearProcessLog(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	parser := rs.tasks[process.ID].parser
+
+	parser.ResetLog()
+	parser.Parse("first run")
+	parser.ResetLog()
+	parser.Parse("second run")
+
+	log, err := rs.GetProcessLog(process.ID)
+	require.NoError(t, err)
+	require.NotEmpty(t, log.History)
+	require.NotEmpty(t, log.Log)
+
+	err = rs.ClearProcessLog(process.ID)
+	require.NoError(t, err)
+
+	log, err = rs.GetProcessLog(process.ID)
+	require.NoError(t, err)
+	require.Empty(t, log.History)
+	require.Empty(t, log.Log)
+
+	// FFmpeg is still running and new lines are collected
+	require.Equal(t, "running", rs.tasks[process.ID].ffmpeg.Status().State)
+
+	parser.Parse("after clear")
+
+	log, err = rs.GetProcessLog(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "after clear", log.Log[len(log.Log)-1].Data)
+
+	err = rs.ClearProcessLog("foobar")
+	require.ErrorIs(t, err, ErrUnknownProcess)
+
+	rs.StopProcess(process.ID)
+}
+
+func TestInputReconnect(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.Input[0].Address = "rtmp://localhost/live/stream"
+	process.Input[0].Options = []string{}
+	process.Input[0].Reconnect = true
+
+	err = rs.AddProcess(process)
+	require.ErrorIs(t, err, ErrInvalidValue)
+
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+	require.Equal(t, "reconnect", verr.Field)
+
+	process.Input[0].Address = "https://localhost/live/stream.m3u8"
+	process.Input[0].ReconnectDelay = 10
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	config, err := rs.GetResolvedConfig(process.ID)
+	require.NoError(t, err)
+	require.Contains(t, strings.Join(config.CreateCommand(), " "), "-reconnect 1 -reconnect_streamed 1 -reconnect_delay_max 10 -i https://localhost/live/stream.m3u8")
+}
+
+func TestFilesystems(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	diskfs, err := fs.NewRootedDiskFilesystem(fs.RootedDiskConfig{
+		Root: t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	_, _, err = diskfs.WriteFile("/file.txt", []byte("hello"))
+	require.NoError(t, err)
+
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	sizedfs, err := fs.NewSizedFilesystem(memfs, 1024, false)
+	require.NoError(t, err)
+
+	_, _, err = sizedfs.WriteFile("/file.txt", []byte("hello world"))
+	require.NoError(t, err)
+
+	rsi, err = New(Config{
+		FFmpeg:      rsi.(*restream).ffmpeg,
+		Filesystems: []fs.Filesystem{diskfs, sizedfs},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, []app.FilesystemInfo{
+		{Name: "disk", Type: "disk", Size: 5, Limit: -1},
+		{Name: "mem", Type: "mem", Size: 11, Limit: 1024},
+	}, rsi.Filesystems())
+}
+
+func TestGetStatesByReference(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	for _, p := range []struct{ id, reference string }{
+		{"a", "camera1"},
+		{"b", "camera1"},
+		{"c", "camera2"},
+		{"d", "other"},
+	} {
+		process := getDummyProcess()
+		process.ID = p.id
+		process.Reference = p.reference
+
+		err = rs.AddProcess(process)
+		require.NoError(t, err)
+	}
+
+	err = rs.StartProcess("a")
+	require.NoError(t, err)
+
+	states, err := rs.GetStatesByReference("camera*")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(states))
+	require.Equal(t, 2, len(states["camera1"]))
+	require.Equal(t, 1, len(states["camera2"]))
+	require.Contains(t, states["camera2"], "c")
+
+	// The states are keyed by the ID of the process
+	require.Equal(t, "start", states["camera1"]["a"].Order)
+	require.Equal(t, "stop", states["camera1"]["b"].Order)
+
+	states, err = rs.GetStatesByReference("")
+	require.NoError(t, err)
+	require.Equal(t, 3, len(states))
+
+	_, err = rs.GetStatesByReference("[")
+	require.Error(t, err)
+
+	rs.StopProcess("a")
+}
+
+// failingStore is a store that fails writing the data as long as fail is set
+type failingStore struct {
+	backend store.Store
+	fail    int32
+}
+
+func (s *failingStore) setFail(fail bool) {
+	if fail {
+		atomic.StoreInt32(&s.fail, 1)
+	} else {
+		atomic.StoreInt32(&s.fail, 0)
+	}
+}
+
+func (s *failingStore) Load() (store.StoreData, error) {
+	return s.backend.Load()
+}
+
+func (s *failingStore) Store(data store.StoreData) error {
+	if atomic.LoadInt32(&s.fail) != 0 {
+		return errors.New("failed to write")
+	}
+
+	return s.backend.Store(data)
+}
+
+func TestFailureMode(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	s := &failingStore{backend: newDeltaStore()}
+	s.setFail(true)
+	rs.store = s
+
+	// The failure is only logged if not strict
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	_, err = rs.GetProcess(process.ID)
+	require.NoError(t, err)
+
+	err = rs.DeleteProcess(process.ID)
+	require.NoError(t, err)
+
+	rs.failureMode = "strict"
+
+	// A process that can't be stored isn't added
+	err = rs.AddProcess(process)
+	require.Error(t, err)
+
+	_, err = rs.GetProcess(process.ID)
+	require.ErrorIs(t, err, ErrUnknownProcess)
+
+	s.setFail(false)
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	stored, err := rs.GetProcess(process.ID)
+	require.NoError(t, err)
+
+	s.setFail(true)
+
+	// A process that can't be stored isn't updated
+	update := getDummyProcess()
+	update.Reference = "updated"
+
+	err = rs.UpdateProcess(process.ID, update)
+	require.Error(t, err)
+
+	current, err := rs.GetProcess(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, stored, current)
+
+	// A process that can't be removed from the store isn't deleted
+	err = rs.DeleteProcess(process.ID)
+	require.Error(t, err)
+
+	current, err = rs.GetProcess(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, stored, current)
+
+	s.setFail(false)
+
+	err = rs.DeleteProcess(process.ID)
+	require.NoError(t, err)
+
+	data, err := s.Load()
+	require.NoError(t, err)
+	require.Empty(t, data.Process)
+
+	_, err = New(Config{
+		FFmpeg:      rs.ffmpeg,
+		FailureMode: "foobar",
+	})
+	require.Error(t, err)
+}
+
+func TestFailureModeStrict(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	s := &failingStore{backend: newDeltaStore()}
+	rs.store = s
+	rs.failureMode = "strict"
+
+	for _, id := range []string{"a", "b"} {
+		process := getDummyProcess()
+		process.ID = id
+		process.GroupID = "group"
+		process.Output = append(process.Output, app.ConfigIO{
+			ID:      "out2",
+			Address: "-",
+			Options: []string{"-f", "null"},
+		})
+
+		err = rs.AddProcess(process)
+		require.NoError(t, err)
+	}
+
+	s.setFail(true)
+
+	process := getDummyProcess()
+	process.ID = "try"
+
+	err = rs.TryAddProcess(process, time.Second)
+	require.Error(t, err)
+
+	_, err = rs.GetProcess("try")
+	require.ErrorIs(t, err, ErrUnknownProcess)
+
+	err = rs.AddCanary("a", "canary", nil)
+	require.Error(t, err)
+
+	_, err = rs.GetProcess("canary")
+	require.ErrorIs(t, err, ErrUnknownProcess)
+
+	err = rs.MoveOutput("a", "out2", 0)
+	require.Error(t, err)
+
+	config, err := rs.GetProcess("a")
+	require.NoError(t, err)
+	require.Equal(t, "out", config.Config.Output[0].ID)
+
+	err = rs.StartGroup("group")
+	require.Error(t, err)
+
+	for _, id := range []string{"a", "b"} {
+		process, err := rs.GetProcess(id)
+		require.NoError(t, err)
+		require.Equal(t, "stop", process.Order)
+	}
+
+	err = rs.DeleteGroup("group")
+	require.Error(t, err)
+
+	require.ElementsMatch(t, []string{"a", "b"}, rs.GetProcessIDsByGroup("group"))
+
+	temporary := getDummyProcess()
+	temporary.ID = "a"
+	temporary.Options = []string{"-loglevel", "debug"}
+
+	err = rs.ApplyTemporaryConfig("a", temporary, time.Minute)
+	require.Error(t, err)
+
+	resolved, err := rs.GetResolvedConfig("a")
+	require.NoError(t, err)
+	require.Equal(t, []string{"-loglevel", "info"}, resolved.Options)
+
+	rs.lock.RLock()
+	require.Nil(t, rs.tasks["a"].temporary)
+	rs.lock.RUnlock()
+
+	// A revert that can't be stored is tried again
+	s.setFail(false)
+
+	err = rs.ApplyTemporaryConfig("a", temporary, 100*time.Millisecond)
+	require.NoError(t, err)
+
+	s.setFail(true)
+
+	time.Sleep(500 * time.Millisecond)
+
+	rs.lock.RLock()
+	require.NotNil(t, rs.tasks["a"].temporary)
+	require.Equal(t, []string{"-loglevel", "debug"}, rs.tasks["a"].config.Options)
+	rs.lock.RUnlock()
+
+	s.setFail(false)
+
+	require.Eventually(t, func() bool {
+		rs.lock.RLock()
+		defer rs.lock.RUnlock()
+
+		task := rs.tasks["a"]
+
+		return task.temporary == nil && task.config.Options[1] == "info"
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestLogRateLimit(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	ffmpeg := &dummyFFmpeg{FFmpeg: rs.ffmpeg}
+	rs.ffmpeg = ffmpeg
+
+	process := getDummyProcess()
+	process.LogRateLimit = 10
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := rs.StreamProcessLog(ctx, process.ID)
+	require.NoError(t, err)
+
+	configs := ffmpeg.Configs()
+	parser := configs[len(configs)-1].Parser
+
+	parser.ResetLog()
+
+	received := func() []string {
+		lines := []string{}
+
+		for {
+			select {
+			case entry := <-ch:
+				lines = append(lines, entry.Data)
+			default:
+				return lines
+			}
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		parser.Parse(fmt.Sprintf("line %d", i))
+	}
+
+	// Progress lines are never dropped
+	parser.Parse("frame=  100 fps= 25 q=-1.0 size=     100kB time=00:00:04.00 bitrate= 204.8kbits/s speed=1x")
+
+	lines := received()
+	require.Equal(t, 10, len(lines))
+	require.Equal(t, "line 9", lines[9])
+
+	time.Sleep(1100 * time.Millisecond)
+
+	parser.Parse("next line")
+
+	require.Equal(t, []string{"990 lines suppressed", "next line"}, received())
+
+	// The remaining suppressed lines are reported when the process stops
+	for i := 0; i < 20; i++ {
+		parser.Parse(fmt.Sprintf("line %d", i))
+	}
+
+	parser.ResetStats()
+
+	lines = received()
+	require.Equal(t, 10, len(lines))
+	require.Equal(t, "11 lines suppressed", lines[len(lines)-1])
+
+	log, err := rs.GetProcessLog(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "11 lines suppressed", log.Log[len(log.Log)-1].Data)
+}
+
+func TestGlobalOptions(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rsi, err = New(Config{
+		FFmpeg:        rsi.(*restream).ffmpeg,
+		GlobalOptions: []string{"-hide_banner", "-init_hw_device", "vaapi=va:/dev/dri/renderD128"},
+	})
+	require.NoError(t, err)
+
+	process1 := getDummyProcess()
+	process1.ID = "process1"
+	process1.Options = []string{"-loglevel", "info"}
+
+	process2 := getDummyProcess()
+	process2.ID = "process2"
+	process2.Options = []string{"-hide_banner", "-loglevel", "info"}
+
+	for _, process := range []*app.Config{process1, process2} {
+		err = rsi.AddProcess(process)
+		require.NoError(t, err)
+	}
+
+	config, err := rsi.GetResolvedConfig(process1.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"-hide_banner", "-init_hw_device", "vaapi=va:/dev/dri/renderD128", "-loglevel", "info"}, config.Options)
+	require.Equal(t, config.Options, config.CreateCommand()[:5])
+
+	// Global options are not added twice
+	config, err = rsi.GetResolvedConfig(process2.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"-init_hw_device", "vaapi=va:/dev/dri/renderD128", "-hide_banner", "-loglevel", "info"}, config.Options)
+
+	err = rsi.ReloadProcess(process1.ID)
+	require.NoError(t, err)
+
+	config, err = rsi.GetResolvedConfig(process1.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"-hide_banner", "-init_hw_device", "vaapi=va:/dev/dri/renderD128", "-loglevel", "info"}, config.Options)
+
+	// The stored config doesn't contain the global options
+	process, err := rsi.GetProcess(process1.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"-loglevel", "info"}, process.Config.Options)
+}
+
+func TestPortConflicts(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process1 := getDummyProcess()
+	process1.ID = "process1"
+	process1.Input[0].Address = "rtmp://0.0.0.0/live/stream1"
+	process1.Input[0].Options = []string{"-listen", "1"}
+
+	process2 := getDummyProcess()
+	process2.ID = "process2"
+	process2.Input[0].Address = "rtmp://0.0.0.0:1935/live/stream2"
+	process2.Input[0].Options = []string{"-listen", "1"}
+
+	process3 := getDummyProcess()
+	process3.ID = "process3"
+	process3.Input[0].Address = "srt://0.0.0.0:6000?mode=listener"
+	process3.Input[0].Options = []string{}
+
+	// A TCP port doesn't conflict with the same UDP port
+	process4 := getDummyProcess()
+	process4.ID = "process4"
+	process4.Input[0].Address = "tcp://0.0.0.0:6000?listen"
+	process4.Input[0].Options = []string{}
+
+	// A caller doesn't listen on the port
+	process5 := getDummyProcess()
+	process5.ID = "process5"
+	process5.Input[0].Address = "srt://example.com:6000?mode=caller"
+	process5.Input[0].Options = []string{}
+
+	for _, process := range []*app.Config{process1, process2, process3, process4, process5} {
+		err = rs.AddProcess(process)
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, map[int][]string{
+		1935: {"process1", "process2"},
+	}, rs.GetPortConflicts())
+
+	// A process that conflicts via TCP and via UDP on the same port is listed once
+	both := getDummyProcess()
+	both.ID = "both"
+	both.Input[0].Address = "tcp://0.0.0.0:6000?listen"
+	both.Input[0].Options = []string{}
+	both.Input = append(both.Input, app.ConfigIO{
+		ID:      "udp",
+		Address: "udp://0.0.0.0:6000",
+	})
+
+	err = rs.AddProcess(both)
+	require.NoError(t, err)
+
+	require.Equal(t, map[int][]string{
+		1935: {"process1", "process2"},
+		6000: {"both", "process3", "process4"},
+	}, rs.GetPortConflicts())
+
+	err = rs.DeleteProcess(both.ID)
+	require.NoError(t, err)
+
+	rs.rejectPortConflicts = true
+
+	process6 := getDummyProcess()
+	process6.ID = "process6"
+	process6.Input[0].Address = "udp://@:6000"
+	process6.Input[0].Options = []string{}
+
+	err = rs.AddProcess(process6)
+	require.ErrorIs(t, err, ErrPortConflict)
+	require.Contains(t, err.Error(), "process3")
+
+	// A process doesn't conflict with the process it replaces
+	process3.Input[0].Address = "srt://0.0.0.0:6000?mode=listener&latency=200"
+
+	err = rs.UpdateProcess(process3.ID, process3)
+	require.NoError(t, err)
+}
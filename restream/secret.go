@@ -0,0 +1,107 @@
+package restream
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/datarhei/core/v16/process"
+)
+
+// reSecret matches the placeholders of the form {secret:name}
+var reSecret = regexp.MustCompile(`\{secret:([^}]+)\}`)
+
+// secrets resolves the secret placeholders in the arguments of a process and
+// redacts the resolved secrets in the log lines of the process.
+type secrets struct {
+	provider func(name string) (string, error)
+	values   map[string]string // Resolved secrets by their name
+	lock     sync.RWMutex
+}
+
+func newSecrets(provider func(name string) (string, error)) *secrets {
+	return &secrets{
+		provider: provider,
+		values:   make(map[string]string),
+	}
+}
+
+// Resolve replaces the secret placeholders in the arguments with the secrets from
+// the provider. Placeholders of secrets that can't be resolved are left untouched.
+func (s *secrets) Resolve(args []string) ([]string, error) {
+	resolved := make([]string, len(args))
+	errs := []string{}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i, arg := range args {
+		resolved[i] = reSecret.ReplaceAllStringFunc(arg, func(placeholder string) string {
+			name := reSecret.FindStringSubmatch(placeholder)[1]
+
+			value, err := s.provider(name)
+			if err != nil {
+				errs = append(errs, name+" ("+err.Error()+")")
+				return placeholder
+			}
+
+			s.values[name] = value
+
+			return value
+		})
+	}
+
+	if len(errs) != 0 {
+		return resolved, fmt.Errorf("failed to resolve secrets: %s", strings.Join(errs, ", "))
+	}
+
+	return resolved, nil
+}
+
+// Redact replaces all resolved secrets in the line with their placeholder.
+func (s *secrets) Redact(line string) string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	// Replace longer secrets first in case one secret contains another
+	names := make([]string, 0, len(s.values))
+	for name, value := range s.values {
+		if len(value) != 0 {
+			names = append(names, name)
+		}
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return len(s.values[names[i]]) > len(s.values[names[j]])
+	})
+
+	for _, name := range names {
+		line = strings.ReplaceAll(line, s.values[name], "{secret:"+name+"}")
+	}
+
+	return line
+}
+
+// usesSecrets returns whether any of the arguments contains a secret placeholder.
+func usesSecrets(args []string) bool {
+	for _, arg := range args {
+		if reSecret.MatchString(arg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// secretParser redacts the resolved secrets in every line before it's parsed.
+type secretParser struct {
+	process.Parser
+
+	secrets *secrets
+}
+
+func (p *secretParser) Parse(line string) uint64 {
+	return p.Parser.Parse(p.secrets.Redact(line))
+}
@@ -0,0 +1,123 @@
+package restream
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// reSequence matches the placeholders of the form {sequence} or {sequence,pad=3}
+var reSequence = regexp.MustCompile(`\{sequence(?:,pad=([0-9]+))?\}`)
+
+// sequenceMetadataKey is the key of the process metadata for the last used sequence number.
+// The key is reserved and can't be set or read with the metadata functions.
+const sequenceMetadataKey = "sequence"
+
+// usesSequence returns whether any of the arguments contains a sequence placeholder.
+func usesSequence(args []string) bool {
+	for _, arg := range args {
+		if reSequence.MatchString(arg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// replaceSequence replaces all sequence placeholders in str with the number. The
+// number is padded with leading zeros to the width given by the pad parameter.
+func replaceSequence(str string, n uint64) string {
+	return reSequence.ReplaceAllStringFunc(str, func(placeholder string) string {
+		pad, _ := strconv.Atoi(reSequence.FindStringSubmatch(placeholder)[1])
+
+		return fmt.Sprintf("%0*d", pad, n)
+	})
+}
+
+// seedSequence initializes the sequence number of the task from its metadata. The
+// sequence number never goes backwards. The caller has to hold the lock.
+func (t *task) seedSequence() {
+	n := uint64(0)
+
+	switch v := t.metadata[sequenceMetadataKey].(type) {
+	case uint64:
+		n = v
+	case float64:
+		n = uint64(v)
+	case json.Number:
+		n, _ = strconv.ParseUint(v.String(), 10, 64)
+	}
+
+	t.sequence.lock.Lock()
+	defer t.sequence.lock.Unlock()
+
+	if n > t.sequence.value {
+		t.sequence.value = n
+	}
+}
+
+// keepSequence continues the sequence number of the replaced task. The caller has to
+// hold the lock.
+func (t *task) keepSequence(replaced *task) {
+	replaced.sequence.lock.Lock()
+	n := replaced.sequence.value
+	replaced.sequence.lock.Unlock()
+
+	t.sequence.lock.Lock()
+	defer t.sequence.lock.Unlock()
+
+	if n > t.sequence.value {
+		t.sequence.value = n
+	}
+}
+
+// resolveSequence advances the sequence number of the task and replaces the sequence
+// placeholders in the arguments with it. It is called for every start of FFmpeg, such
+// that each run writes to new files.
+func (r *restream) resolveSequence(t *task, args []string) []string {
+	t.sequence.lock.Lock()
+	t.sequence.value++
+	n := t.sequence.value
+	t.sequence.lock.Unlock()
+
+	// FFmpeg is started while the lock might be held
+	go r.storeSequence(t)
+
+	resolved := make([]string, len(args))
+	for i, arg := range args {
+		resolved[i] = replaceSequence(arg, n)
+	}
+
+	return resolved
+}
+
+// storeSequence writes the current sequence number of the task to its metadata
+// such that it will be persisted. If the task has been replaced in the meantime, the
+// sequence number is written to the task that replaced it.
+func (r *restream) storeSequence(t *task) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// Follow the task through its updates, it might have been renamed
+	for t.demand.successor != nil {
+		t.demand.successor.keepSequence(t)
+		t = t.demand.successor
+	}
+
+	if task, ok := r.tasks[t.id]; !ok || task != t {
+		return
+	}
+
+	t.sequence.lock.Lock()
+	n := t.sequence.value
+	t.sequence.lock.Unlock()
+
+	if current, ok := t.metadata[sequenceMetadataKey].(uint64); ok && current >= n {
+		return
+	}
+
+	t.setMetadata(sequenceMetadataKey, n)
+	r.markChanged(t.id)
+	r.save()
+}
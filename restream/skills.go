@@ -0,0 +1,277 @@
+package restream
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/datarhei/core/v16/ffmpeg/skills"
+	"github.com/datarhei/core/v16/restream/app"
+)
+
+// capability is a part of FFmpeg that is required by a process, e.g. the encoder "libx264".
+type capability struct {
+	kind string // "encoder", "decoder", "muxer", "demuxer", or "filter"
+	name string
+}
+
+func (c capability) String() string {
+	return c.kind + " '" + c.name + "'"
+}
+
+// reFilterName matches the name of a filter at the beginning of a filter description
+// after the input labels have been removed.
+var reFilterName = regexp.MustCompile(`^([A-Za-z0-9_]+)`)
+
+// requiredCapabilities returns the encoders, decoders, muxers, demuxers, and filters that
+// are selected by the options of the config.
+func requiredCapabilities(config *app.Config) []capability {
+	caps := []capability{}
+
+	caps = append(caps, optionCapabilities(config.Options, false)...)
+
+	for _, input := range config.Input {
+		caps = append(caps, optionCapabilities(input.Options, false)...)
+	}
+
+	for _, output := range config.Output {
+		caps = append(caps, optionCapabilities(output.Options, true)...)
+	}
+
+	return caps
+}
+
+// optionCapabilities returns the capabilities that are selected by the options. For the
+// options of an output, codecs and formats refer to encoders and muxers, otherwise to
+// decoders and demuxers.
+func optionCapabilities(options []string, isOutput bool) []capability {
+	caps := []capability{}
+
+	codec, format := "decoder", "demuxer"
+	if isOutput {
+		codec, format = "encoder", "muxer"
+	}
+
+	for i := 0; i < len(options)-1; i++ {
+		option, value := options[i], options[i+1]
+
+		switch {
+		case option == "-c" || option == "-codec" || strings.HasPrefix(option, "-c:") || strings.HasPrefix(option, "-codec:") ||
+			option == "-vcodec" || option == "-acodec" || option == "-scodec":
+			if value != "copy" {
+				caps = append(caps, capability{kind: codec, name: value})
+			}
+		case option == "-f":
+			caps = append(caps, capability{kind: format, name: value})
+		case option == "-vf" || option == "-af" || option == "-filter" || strings.HasPrefix(option, "-filter:") ||
+			option == "-filter_complex" || option == "-lavfi":
+			for _, name := range filterNames(value) {
+				caps = append(caps, capability{kind: "filter", name: name})
+			}
+		default:
+			continue
+		}
+
+		i++
+	}
+
+	return caps
+}
+
+// filterNames returns the names of all filters in the filtergraph.
+func filterNames(graph string) []string {
+	names := []string{}
+
+	for _, chain := range strings.Split(graph, ";") {
+		for _, filter := range strings.Split(chain, ",") {
+			filter = strings.TrimSpace(filter)
+
+			// Remove the input labels
+			for strings.HasPrefix(filter, "[") {
+				end := strings.Index(filter, "]")
+				if end == -1 {
+					break
+				}
+
+				filter = strings.TrimSpace(filter[end+1:])
+			}
+
+			if name := reFilterName.FindString(filter); len(name) != 0 {
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names
+}
+
+// hasCapability returns whether the skills provide the capability. A codec can be
+// given by the name of the encoder or decoder, or by the name of the codec.
+func hasCapability(s skills.Skills, c capability) bool {
+	switch c.kind {
+	case "encoder", "decoder":
+		for _, codecs := range [][]skills.Codec{s.Codecs.Video, s.Codecs.Audio, s.Codecs.Subtitle} {
+			for _, codec := range codecs {
+				coders := codec.Decoders
+				if c.kind == "encoder" {
+					coders = codec.Encoders
+				}
+
+				if codec.Id == c.name && len(coders) != 0 {
+					return true
+				}
+
+				for _, coder := range coders {
+					if coder == c.name {
+						return true
+					}
+				}
+			}
+		}
+	case "muxer", "demuxer":
+		formats, devices := s.Formats.Demuxers, s.Devices.Demuxers
+		if c.kind == "muxer" {
+			formats, devices = s.Formats.Muxers, s.Devices.Muxers
+		}
+
+		for _, f := range formats {
+			if f.Id == c.name {
+				return true
+			}
+		}
+
+		for _, d := range devices {
+			if d.Id == c.name {
+				return true
+			}
+		}
+	case "filter":
+		for _, f := range s.Filters {
+			if f.Id == c.name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// checkSkills returns a ValidationError naming all capabilities the config requires that
+// are not available in the FFmpeg binary of the config, including the muxers of the outputs.
+func (r *restream) checkSkills(config *app.Config) error {
+	ff, err := r.ffmpegFor(config)
+	if err != nil {
+		return err
+	}
+
+	s := ff.Skills()
+
+	missing := []string{}
+	seen := map[capability]struct{}{}
+
+	// The muxers of the outputs are checked first in order to name the output
+	for _, output := range config.Output {
+		for _, muxer := range outputMuxers(output) {
+			c := capability{kind: "muxer", name: muxer}
+
+			if _, ok := seen[c]; ok {
+				continue
+			}
+
+			seen[c] = struct{}{}
+
+			if !hasCapability(s, c) {
+				missing = append(missing, c.String()+" for the output '"+output.ID+"'")
+			}
+		}
+	}
+
+	for _, c := range requiredCapabilities(config) {
+		if _, ok := seen[c]; ok {
+			continue
+		}
+
+		seen[c] = struct{}{}
+
+		if !hasCapability(s, c) {
+			missing = append(missing, c.String())
+		}
+	}
+
+	if len(missing) != 0 {
+		return newValidationError(ErrMissingSkills, config.ID, "", "", "options", "%w for the process '%s': %s", ErrMissingSkills, config.ID, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// extensionMuxers are the muxers FFmpeg selects for the extension of an output address
+var extensionMuxers = map[string]string{
+	".m3u8": "hls",
+	".mpd":  "dash",
+	".ts":   "mpegts",
+	".flv":  "flv",
+	".mp4":  "mp4",
+	".mov":  "mov",
+	".mkv":  "matroska",
+	".webm": "webm",
+	".ogg":  "ogg",
+	".mp3":  "mp3",
+	".aac":  "adts",
+	".wav":  "wav",
+	".jpg":  "image2",
+	".jpeg": "image2",
+	".png":  "image2",
+}
+
+// reTeeFormat matches the format option of a tee target, e.g. "[f=flv]"
+var reTeeFormat = regexp.MustCompile(`^\[(?:[^\]]*:)?f=([^:\]]+)[^\]]*\]`)
+
+// outputMuxers returns the muxers that are used by the output. The muxer is given by the
+// "-f" option or inferred from the extension of the address. For a tee output the muxers
+// of the targets are returned as well. Addresses without a known extension are ignored.
+func outputMuxers(output app.ConfigIO) []string {
+	muxers := []string{}
+
+	for i := 0; i < len(output.Options)-1; i++ {
+		if output.Options[i] == "-f" {
+			muxers = append(muxers, output.Options[i+1])
+			break
+		}
+	}
+
+	if len(muxers) == 0 {
+		if muxer := addressMuxer(output.Address); len(muxer) != 0 {
+			muxers = append(muxers, muxer)
+		}
+	}
+
+	if len(muxers) == 0 || muxers[0] != "tee" || !isTeeAddress(output.Address, output.OutputType) {
+		return muxers
+	}
+
+	for _, target := range strings.Split(output.Address, "|") {
+		if matches := reTeeFormat.FindStringSubmatch(target); matches != nil {
+			muxers = append(muxers, matches[1])
+			continue
+		}
+
+		target = reTeeOptions.ReplaceAllString(target, "")
+
+		if muxer := addressMuxer(target); len(muxer) != 0 {
+			muxers = append(muxers, muxer)
+		}
+	}
+
+	return muxers
+}
+
+// addressMuxer returns the muxer FFmpeg selects for the extension of the address, or an
+// empty string if the extension is not known.
+func addressMuxer(address string) string {
+	if i := strings.IndexAny(address, "?#"); i != -1 {
+		address = address[:i]
+	}
+
+	return extensionMuxers[strings.ToLower(path.Ext(address))]
+}
@@ -0,0 +1,177 @@
+package restream
+
+import (
+	"container/ring"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/datarhei/core/v16/restream/app"
+)
+
+// stateHistory keeps the last state transitions of a process.
+type stateHistory struct {
+	transitions *ring.Ring
+	lock        sync.Mutex
+}
+
+func newStateHistory(length int) *stateHistory {
+	if length <= 0 {
+		length = 1
+	}
+
+	return &stateHistory{
+		transitions: ring.New(length),
+	}
+}
+
+// Add records a state transition. If the history is full, the oldest transition
+// will be overwritten.
+func (h *stateHistory) Add(from, to string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.transitions.Value = app.StateTransition{
+		From: from,
+		To:   to,
+		Time: time.Now(),
+	}
+	h.transitions = h.transitions.Next()
+}
+
+// Since returns all recorded transitions that happened at or after the given time,
+// the oldest first.
+func (h *stateHistory) Since(since time.Time) []app.StateTransition {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	transitions := []app.StateTransition{}
+
+	h.transitions.Do(func(v interface{}) {
+		if v == nil {
+			return
+		}
+
+		t := v.(app.StateTransition)
+		if t.Time.Before(since) {
+			return
+		}
+
+		transitions = append(transitions, t)
+	})
+
+	return transitions
+}
+
+// stateBroadcaster distributes the states of a process to all subscribers.
+type stateBroadcaster struct {
+	subscribers map[chan *app.State]chan struct{} // The value is closed together with the channel of the subscriber
+	next        *stateBroadcaster                 // Broadcaster that took over the subscribers
+	lock        sync.RWMutex
+}
+
+func newStateBroadcaster() *stateBroadcaster {
+	return &stateBroadcaster{
+		subscribers: make(map[chan *app.State]chan struct{}),
+	}
+}
+
+// Subscribe returns a channel that receives the state of the process after each state
+// change until the context is cancelled or the broadcaster is closed. If the receiver
+// is too slow, states will be dropped.
+func (b *stateBroadcaster) Subscribe(ctx context.Context) <-chan *app.State {
+	ch := make(chan *app.State, 16)
+
+	done := make(chan struct{})
+
+	b.lock.Lock()
+	b.subscribers[ch] = done
+	b.lock.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			b.unsubscribe(ch)
+		case <-done:
+		}
+	}()
+
+	return ch
+}
+
+// unsubscribe removes the subscriber and closes its channel. A subscriber that has been
+// transferred is removed from the broadcaster it has been transferred to.
+func (b *stateBroadcaster) unsubscribe(ch chan *app.State) {
+	b.lock.Lock()
+
+	done, ok := b.subscribers[ch]
+	if !ok {
+		next := b.next
+		b.lock.Unlock()
+
+		if next != nil {
+			next.unsubscribe(ch)
+		}
+
+		return
+	}
+
+	delete(b.subscribers, ch)
+	close(ch)
+	close(done)
+
+	b.lock.Unlock()
+}
+
+// HasSubscribers returns whether there's at least one subscriber.
+func (b *stateBroadcaster) HasSubscribers() bool {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	return len(b.subscribers) != 0
+}
+
+// Publish sends the state to all subscribers without blocking.
+func (b *stateBroadcaster) Publish(state *app.State) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- state:
+		default:
+		}
+	}
+}
+
+// TransferTo moves all subscribers to another broadcaster.
+func (b *stateBroadcaster) TransferTo(dst *stateBroadcaster) {
+	if b == dst {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	dst.lock.Lock()
+	defer dst.lock.Unlock()
+
+	for ch, done := range b.subscribers {
+		dst.subscribers[ch] = done
+		delete(b.subscribers, ch)
+	}
+
+	b.next = dst
+}
+
+// Close closes the channels of all subscribers.
+func (b *stateBroadcaster) Close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for ch, done := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+		close(done)
+	}
+}
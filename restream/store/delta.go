@@ -0,0 +1,63 @@
+package store
+
+import (
+	"github.com/datarhei/core/v16/restream/app"
+)
+
+// StoreDelta contains the changes of the data since it has been stored the last time.
+type StoreDelta struct {
+	Process       map[string]*app.Process           // Added or changed processes
+	Metadata      map[string]map[string]interface{} // Metadata of the added or changed processes
+	Removed       []string                          // IDs of the removed processes
+	SystemChanged bool                              // Whether the system metadata changed
+	System        map[string]interface{}            // System metadata, only valid if SystemChanged is true
+}
+
+func NewStoreDelta() StoreDelta {
+	return StoreDelta{
+		Process:  make(map[string]*app.Process),
+		Metadata: make(map[string]map[string]interface{}),
+	}
+}
+
+// IsEmpty returns whether the delta doesn't contain any changes.
+func (d *StoreDelta) IsEmpty() bool {
+	return len(d.Process) == 0 && len(d.Removed) == 0 && !d.SystemChanged
+}
+
+// DeltaStore is a store that is able to write only the changes of the data.
+type DeltaStore interface {
+	Store
+
+	// StoreDelta stores the changes since the last call of Store or StoreDelta,
+	// or since the data has been loaded.
+	StoreDelta(delta StoreDelta) error
+}
+
+// Apply applies the changes of the delta to the data.
+func (c *StoreData) Apply(delta StoreDelta) {
+	c.sanitize()
+
+	if c.Metadata.Process == nil {
+		c.Metadata.Process = make(map[string]map[string]interface{})
+	}
+
+	for _, id := range delta.Removed {
+		delete(c.Process, id)
+		delete(c.Metadata.Process, id)
+	}
+
+	for id, p := range delta.Process {
+		c.Process[id] = p
+
+		if metadata := delta.Metadata[id]; metadata != nil {
+			c.Metadata.Process[id] = metadata
+		} else {
+			delete(c.Metadata.Process, id)
+		}
+	}
+
+	if delta.SystemChanged {
+		c.Metadata.System = delta.System
+	}
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/datarhei/core/v16/restream/app"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyDelta(t *testing.T) {
+	data := NewStoreData()
+	data.Process["foo"] = &app.Process{ID: "foo"}
+	data.Process["bar"] = &app.Process{ID: "bar"}
+	data.Metadata.Process["foo"] = map[string]interface{}{"key": "value"}
+	data.Metadata.System = map[string]interface{}{"system": "value"}
+
+	delta := NewStoreDelta()
+	require.True(t, delta.IsEmpty())
+
+	delta.Process["bar"] = &app.Process{ID: "bar", Order: "start"}
+	delta.Metadata["bar"] = map[string]interface{}{"key": "data"}
+	delta.Process["baz"] = &app.Process{ID: "baz"}
+	delta.Removed = append(delta.Removed, "foo")
+	require.False(t, delta.IsEmpty())
+
+	data.Apply(delta)
+
+	require.Equal(t, map[string]*app.Process{
+		"bar": {ID: "bar", Order: "start"},
+		"baz": {ID: "baz"},
+	}, data.Process)
+	require.Equal(t, map[string]map[string]interface{}{
+		"bar": {"key": "data"},
+	}, data.Metadata.Process)
+	require.Equal(t, map[string]interface{}{"system": "value"}, data.Metadata.System)
+
+	delta = NewStoreDelta()
+	delta.SystemChanged = true
+
+	data.Apply(delta)
+
+	require.Nil(t, data.Metadata.System)
+}
@@ -1,6 +1,7 @@
 package store
 
 import (
+	"bytes"
 	gojson "encoding/json"
 	"fmt"
 	"os"
@@ -9,6 +10,7 @@ import (
 	"github.com/datarhei/core/v16/encoding/json"
 	"github.com/datarhei/core/v16/io/fs"
 	"github.com/datarhei/core/v16/log"
+	"github.com/datarhei/core/v16/restream/app"
 )
 
 type JSONConfig struct {
@@ -18,9 +20,10 @@ type JSONConfig struct {
 }
 
 type jsonStore struct {
-	fs       fs.Filesystem
-	filepath string
-	logger   log.Logger
+	fs          fs.Filesystem
+	filepath    string
+	journalpath string // Path to the journal with the changes that are not yet in the database file
+	logger      log.Logger
 
 	// Mutex to serialize access to the backend
 	lock sync.RWMutex
@@ -28,6 +31,13 @@ type jsonStore struct {
 
 var version uint64 = 4
 
+// maxJournalEntries is the number of changes in the journal after which the
+// journal is merged into the database file.
+const maxJournalEntries = 100
+
+// NewJSON returns a store that writes the data to a JSON file. The store implements the
+// DeltaStore interface. The changes are written to a journal next to the file and they
+// are merged into the file if the journal grows too long or all data is stored.
 func NewJSON(config JSONConfig) (Store, error) {
 	s := &jsonStore{
 		fs:       config.Filesystem,
@@ -39,6 +49,8 @@ func NewJSON(config JSONConfig) (Store, error) {
 		s.filepath = "/db.json"
 	}
 
+	s.journalpath = s.filepath + ".journal"
+
 	if s.fs == nil {
 		return nil, fmt.Errorf("no valid filesystem provided")
 	}
@@ -59,6 +71,15 @@ func (s *jsonStore) Load() (StoreData, error) {
 		return NewStoreData(), err
 	}
 
+	deltas, _, err := s.loadJournal(s.journalpath)
+	if err != nil {
+		return NewStoreData(), err
+	}
+
+	for _, delta := range deltas {
+		data.Apply(delta)
+	}
+
 	data.sanitize()
 
 	return data, nil
@@ -69,14 +90,90 @@ func (s *jsonStore) Store(data StoreData) error {
 		return fmt.Errorf("invalid version (have: %d, want: %d)", data.Version, version)
 	}
 
-	s.lock.RLock()
-	defer s.lock.RUnlock()
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
-	err := s.store(s.filepath, data)
+	// The journal has to be merged first, otherwise it would be applied to the
+	// new data if removing it fails
+	deltas, _, err := s.loadJournal(s.journalpath)
 	if err != nil {
 		return fmt.Errorf("failed to store data: %w", err)
 	}
 
+	if len(deltas) != 0 {
+		if err := s.compact(deltas); err != nil {
+			return fmt.Errorf("failed to store data: %w", err)
+		}
+	}
+
+	err = s.store(s.filepath, data)
+	if err != nil {
+		return fmt.Errorf("failed to store data: %w", err)
+	}
+
+	return nil
+}
+
+// StoreDelta appends the changes to the journal.
+func (s *jsonStore) StoreDelta(delta StoreDelta) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	deltas, journal, err := s.loadJournal(s.journalpath)
+	if err != nil {
+		return fmt.Errorf("failed to store delta: %w", err)
+	}
+
+	if len(deltas) >= maxJournalEntries {
+		if err := s.compact(deltas); err != nil {
+			return fmt.Errorf("failed to store delta: %w", err)
+		}
+
+		journal = nil
+	}
+
+	entry, err := gojson.Marshal(jsonDelta(delta))
+	if err != nil {
+		return fmt.Errorf("failed to store delta: %w", err)
+	}
+
+	// The content of the journal might be shared with the filesystem
+	buf := make([]byte, 0, len(journal)+len(entry)+1)
+	buf = append(buf, journal...)
+	buf = append(buf, entry...)
+	buf = append(buf, '\n')
+
+	_, _, err = s.fs.WriteFileSafe(s.journalpath, buf)
+	if err != nil {
+		return fmt.Errorf("failed to store delta: %w", err)
+	}
+
+	s.logger.WithField("file", s.journalpath).Debug().Log("Stored delta")
+
+	return nil
+}
+
+// compact merges the changes of the journal into the database file and removes the journal.
+// If removing the journal fails, applying the changes again on the next load doesn't alter
+// the merged data.
+func (s *jsonStore) compact(deltas []StoreDelta) error {
+	data, err := s.load(s.filepath, version)
+	if err != nil {
+		return err
+	}
+
+	for _, delta := range deltas {
+		data.Apply(delta)
+	}
+
+	if err := s.store(s.filepath, data); err != nil {
+		return err
+	}
+
+	s.fs.Remove(s.journalpath)
+
+	s.logger.WithField("file", s.filepath).Debug().Log("Merged journal")
+
 	return nil
 }
 
@@ -96,6 +193,51 @@ func (s *jsonStore) store(filepath string, data StoreData) error {
 	return nil
 }
 
+// jsonDelta is an entry of the journal.
+type jsonDelta struct {
+	Process       map[string]*app.Process           `json:"process,omitempty"`
+	Metadata      map[string]map[string]interface{} `json:"metadata,omitempty"`
+	Removed       []string                          `json:"removed,omitempty"`
+	SystemChanged bool                              `json:"system_changed,omitempty"`
+	System        map[string]interface{}            `json:"system,omitempty"`
+}
+
+// loadJournal returns the changes in the journal and its raw content. A missing
+// journal contains no changes.
+func (s *jsonStore) loadJournal(filepath string) ([]StoreDelta, []byte, error) {
+	_, err := s.fs.Stat(filepath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+
+		return nil, nil, err
+	}
+
+	journal, err := s.fs.ReadFile(filepath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	deltas := []StoreDelta{}
+
+	for _, line := range bytes.Split(journal, []byte{'\n'}) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		entry := jsonDelta{}
+
+		if err := gojson.Unmarshal(line, &entry); err != nil {
+			return nil, nil, json.FormatError(line, err)
+		}
+
+		deltas = append(deltas, StoreDelta(entry))
+	}
+
+	return deltas, journal, nil
+}
+
 type storeVersion struct {
 	Version uint64 `json:"version"`
 }
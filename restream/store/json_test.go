@@ -1,9 +1,12 @@
 package store
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/datarhei/core/v16/io/fs"
+	"github.com/datarhei/core/v16/restream/app"
+
 	"github.com/stretchr/testify/require"
 )
 
@@ -110,3 +113,176 @@ func TestInvalidVersion(t *testing.T) {
 	require.Error(t, err)
 	require.Equal(t, true, data.IsEmpty())
 }
+
+// countingFilesystem counts the bytes that are written with WriteFileSafe.
+type countingFilesystem struct {
+	fs.Filesystem
+
+	written int
+}
+
+func (f *countingFilesystem) WriteFileSafe(path string, data []byte) (int64, bool, error) {
+	f.written += len(data)
+
+	return f.Filesystem.WriteFileSafe(path, data)
+}
+
+func getMemFS(t testing.TB) fs.Filesystem {
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	return memfs
+}
+
+func TestStoreDelta(t *testing.T) {
+	memfs := getMemFS(t)
+
+	s, err := NewJSON(JSONConfig{
+		Filesystem: memfs,
+	})
+	require.NoError(t, err)
+
+	store := s.(DeltaStore)
+
+	data := NewStoreData()
+	data.Process["foo"] = &app.Process{ID: "foo"}
+	data.Process["bar"] = &app.Process{ID: "bar"}
+	data.Metadata.Process["foo"] = map[string]interface{}{"key": "value"}
+
+	err = store.Store(data)
+	require.NoError(t, err)
+
+	delta := NewStoreDelta()
+	delta.Process["bar"] = &app.Process{ID: "bar", Order: "start"}
+	delta.Metadata["bar"] = map[string]interface{}{"key": "data"}
+	delta.Removed = []string{"foo"}
+
+	err = store.StoreDelta(delta)
+	require.NoError(t, err)
+
+	delta = NewStoreDelta()
+	delta.Process["foo"] = &app.Process{ID: "foo", Order: "stop"}
+	delta.SystemChanged = true
+	delta.System = map[string]interface{}{"system": "value"}
+
+	err = store.StoreDelta(delta)
+	require.NoError(t, err)
+
+	_, err = memfs.Stat("/db.json.journal")
+	require.NoError(t, err, "the changes must be written to the journal")
+
+	want := NewStoreData()
+	want.Process["foo"] = &app.Process{ID: "foo", Order: "stop"}
+	want.Process["bar"] = &app.Process{ID: "bar", Order: "start"}
+	want.Metadata.Process["bar"] = map[string]interface{}{"key": "data"}
+	want.Metadata.System = map[string]interface{}{"system": "value"}
+
+	loaded, err := store.Load()
+	require.NoError(t, err)
+	require.Equal(t, want, loaded)
+
+	// Storing all data merges the journal
+	err = store.Store(loaded)
+	require.NoError(t, err)
+
+	_, err = memfs.Stat("/db.json.journal")
+	require.Error(t, err, "the journal must be merged")
+
+	loaded, err = store.Load()
+	require.NoError(t, err)
+	require.Equal(t, want, loaded)
+}
+
+func TestStoreDeltaCompact(t *testing.T) {
+	memfs := getMemFS(t)
+
+	s, err := NewJSON(JSONConfig{
+		Filesystem: memfs,
+	})
+	require.NoError(t, err)
+
+	store := s.(DeltaStore)
+
+	for i := 0; i <= maxJournalEntries; i++ {
+		delta := NewStoreDelta()
+		delta.Process[fmt.Sprintf("process%d", i)] = &app.Process{ID: fmt.Sprintf("process%d", i)}
+		if i != 0 {
+			delta.Removed = []string{fmt.Sprintf("process%d", i-1)}
+		}
+
+		journal, _ := memfs.ReadFile("/db.json.journal")
+		journal = append([]byte{}, journal...)
+
+		err = store.StoreDelta(delta)
+		require.NoError(t, err)
+
+		if i == maxJournalEntries {
+			// Simulate a crash before the merged journal has been removed
+			_, _, err = memfs.WriteFileSafe("/db.json.journal", journal)
+			require.NoError(t, err)
+		}
+	}
+
+	_, err = memfs.Stat("/db.json")
+	require.NoError(t, err, "the journal must be merged after too many changes")
+
+	loaded, err := store.Load()
+	require.NoError(t, err)
+
+	want := map[string]*app.Process{
+		fmt.Sprintf("process%d", maxJournalEntries-1): {ID: fmt.Sprintf("process%d", maxJournalEntries-1)},
+	}
+
+	require.Equal(t, want, loaded.Process, "applying a merged journal again must not alter the data")
+}
+
+func benchmarkStore(b *testing.B, delta bool) {
+	memfs := &countingFilesystem{Filesystem: getMemFS(b)}
+
+	s, err := NewJSON(JSONConfig{
+		Filesystem: memfs,
+	})
+	require.NoError(b, err)
+
+	store := s.(DeltaStore)
+
+	data := NewStoreData()
+	for i := 0; i < 500; i++ {
+		id := fmt.Sprintf("process%d", i)
+		data.Process[id] = &app.Process{ID: id, Config: &app.Config{ID: id}}
+	}
+
+	err = store.Store(data)
+	require.NoError(b, err)
+
+	memfs.written = 0
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		data.Metadata.Process["process0"] = map[string]interface{}{"foo": i}
+
+		if delta {
+			d := NewStoreDelta()
+			d.Process["process0"] = data.Process["process0"]
+			d.Metadata["process0"] = data.Metadata.Process["process0"]
+
+			err = store.StoreDelta(d)
+		} else {
+			err = store.Store(data)
+		}
+
+		require.NoError(b, err)
+	}
+
+	b.ReportMetric(float64(memfs.written)/float64(b.N), "written-B/op")
+}
+
+func BenchmarkStore(b *testing.B) {
+	benchmarkStore(b, false)
+}
+
+func BenchmarkStoreDelta(b *testing.B) {
+	benchmarkStore(b, true)
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/datarhei/core/v16/log"
+)
+
+type MultiConfig struct {
+	Primary  Store   // Store for loading and storing the data
+	Replicas []Store // Stores the data is mirrored to
+	Logger   log.Logger
+}
+
+type multiStore struct {
+	primary  Store
+	replicas []Store
+	logger   log.Logger
+
+	pending *StoreData    // Latest data that still has to be written to the replicas
+	signal  chan struct{} // Signals the replicator that there is pending data
+	lock    sync.Mutex
+}
+
+// NewMulti returns a store that writes synchronously to the primary store and mirrors
+// the data asynchronously to the replica stores. The data is only loaded from the
+// primary store. Errors writing to a replica are only logged.
+func NewMulti(config MultiConfig) (Store, error) {
+	s := &multiStore{
+		primary:  config.Primary,
+		replicas: config.Replicas,
+		logger:   config.Logger,
+		signal:   make(chan struct{}, 1),
+	}
+
+	if s.primary == nil {
+		return nil, fmt.Errorf("no primary store provided")
+	}
+
+	for i, r := range s.replicas {
+		if r == nil {
+			return nil, fmt.Errorf("the replica store %d must be provided", i)
+		}
+	}
+
+	if s.logger == nil {
+		s.logger = log.New("")
+	}
+
+	go s.replicate()
+
+	return s, nil
+}
+
+func (s *multiStore) Load() (StoreData, error) {
+	return s.primary.Load()
+}
+
+func (s *multiStore) Store(data StoreData) error {
+	if err := s.primary.Store(data); err != nil {
+		return err
+	}
+
+	if len(s.replicas) == 0 {
+		return nil
+	}
+
+	// The data has to be copied because it will be written after this call returned
+	snapshot, err := clone(data)
+	if err != nil {
+		s.logger.Error().WithError(err).Log("Failed to copy the data for the replicas")
+		return nil
+	}
+
+	// Only the latest data has to be written to the replicas
+	s.lock.Lock()
+	s.pending = &snapshot
+	s.lock.Unlock()
+
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// clone returns a deep copy of the data.
+func clone(data StoreData) (StoreData, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return StoreData{}, err
+	}
+
+	snapshot := NewStoreData()
+
+	if err := json.Unmarshal(payload, &snapshot); err != nil {
+		return StoreData{}, err
+	}
+
+	return snapshot, nil
+}
+
+// replicate writes the pending data to all replicas.
+func (s *multiStore) replicate() {
+	for range s.signal {
+		s.lock.Lock()
+		data := s.pending
+		s.pending = nil
+		s.lock.Unlock()
+
+		if data == nil {
+			continue
+		}
+
+		for i, r := range s.replicas {
+			if err := r.Store(*data); err != nil {
+				s.logger.Error().WithField("replica", i).WithError(err).Log("Failed to write to replica")
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/datarhei/core/v16/io/fs"
+	"github.com/datarhei/core/v16/restream/app"
+	"github.com/stretchr/testify/require"
+)
+
+func getMemStore(t *testing.T) Store {
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	store, err := NewJSON(JSONConfig{
+		Filesystem: memfs,
+	})
+	require.NoError(t, err)
+
+	return store
+}
+
+// failingStore is a store that can't be written to
+type failingStore struct {
+	store Store
+}
+
+func (s *failingStore) Load() (StoreData, error) {
+	return s.store.Load()
+}
+
+func (s *failingStore) Store(data StoreData) error {
+	return fmt.Errorf("failed")
+}
+
+func TestMulti(t *testing.T) {
+	primary := getMemStore(t)
+	replica := getMemStore(t)
+
+	store, err := NewMulti(MultiConfig{
+		Primary:  primary,
+		Replicas: []Store{&failingStore{getMemStore(t)}, replica},
+	})
+	require.NoError(t, err)
+
+	data := NewStoreData()
+	data.Process["foobar"] = &app.Process{
+		ID:     "foobar",
+		Config: &app.Config{ID: "foobar"},
+		Order:  "stop",
+	}
+
+	err = store.Store(data)
+	require.NoError(t, err)
+
+	// Changes after storing must not end up in the replica
+	data.Process["foobar"].Order = "start"
+
+	loaded, err := primary.Load()
+	require.NoError(t, err)
+	require.Contains(t, loaded.Process, "foobar")
+
+	require.Eventually(t, func() bool {
+		loaded, err := replica.Load()
+		require.NoError(t, err)
+
+		process, ok := loaded.Process["foobar"]
+		if !ok {
+			return false
+		}
+
+		require.Equal(t, "stop", process.Order)
+
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	loaded, err = store.Load()
+	require.NoError(t, err)
+	require.Contains(t, loaded.Process, "foobar", "data should be loaded from the primary")
+
+	_, err = NewMulti(MultiConfig{})
+	require.Error(t, err)
+
+	store, err = NewMulti(MultiConfig{
+		Primary:  &failingStore{primary},
+		Replicas: []Store{replica},
+	})
+	require.NoError(t, err)
+
+	err = store.Store(data)
+	require.Error(t, err, "errors of the primary store must be returned")
+}
@@ -0,0 +1,99 @@
+package restream
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/datarhei/core/v16/restream/app"
+)
+
+var ErrUnknownTemplate = errors.New("unknown template")
+
+// reTemplateVar matches the placeholders for template variables, e.g. {tpl:source}
+var reTemplateVar = regexp.MustCompile(`{tpl:([a-z:]+)}`)
+
+// AddProcessFromTemplate adds a new process based on the template with the given name. All
+// placeholders of the form {tpl:name} in the template will be replaced by the value of the
+// corresponding variable. All variables used in the template are required.
+func (r *restream) AddProcessFromTemplate(templateName, id string, vars map[string]string) error {
+	tmpl, ok := r.templates[templateName]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownTemplate, templateName)
+	}
+
+	config := tmpl.Clone()
+	config.ID = id
+
+	missing := []string{}
+
+	for _, name := range templateVars(config) {
+		if _, ok := vars[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) != 0 {
+		return fmt.Errorf("missing variables for template '%s': %s", templateName, strings.Join(missing, ", "))
+	}
+
+	expandTemplate(config, func(s string) string {
+		for name, value := range vars {
+			s = r.replace.Replace(s, "tpl:"+name, value, nil, nil, "template")
+		}
+
+		return s
+	})
+
+	return r.AddProcess(config)
+}
+
+// templateVars returns the sorted list of the names of all template variables in the config.
+func templateVars(config *app.Config) []string {
+	names := map[string]struct{}{}
+
+	expandTemplate(config, func(s string) string {
+		for _, matches := range reTemplateVar.FindAllStringSubmatch(s, -1) {
+			names[matches[1]] = struct{}{}
+		}
+
+		return s
+	})
+
+	list := []string{}
+	for name := range names {
+		list = append(list, name)
+	}
+
+	sort.Strings(list)
+
+	return list
+}
+
+// expandTemplate applies fn to all strings of the config that may contain template variables.
+func expandTemplate(config *app.Config, fn func(s string) string) {
+	config.Reference = fn(config.Reference)
+
+	for i, option := range config.Options {
+		config.Options[i] = fn(option)
+	}
+
+	for _, ios := range [][]app.ConfigIO{config.Input, config.Output} {
+		for i, io := range ios {
+			io.ID = fn(io.ID)
+			io.Address = fn(io.Address)
+
+			for j, option := range io.Options {
+				io.Options[j] = fn(option)
+			}
+
+			for j, cleanup := range io.Cleanup {
+				io.Cleanup[j].Pattern = fn(cleanup.Pattern)
+			}
+
+			ios[i] = io
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package restream
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/datarhei/core/v16/restream/app"
+)
+
+// temporaryConfig is the original config of a process that runs with a temporary config.
+type temporaryConfig struct {
+	original *app.Config
+	deadline time.Time // Time of the revert
+	timer    *time.Timer
+}
+
+// ApplyTemporaryConfig updates the process with the config and reverts it to the current
+// config after the duration. Until then, the current config is written to the store, such
+// that the temporary config doesn't survive a restart. An update or a delete of the process
+// ends the temporary config early: the update replaces it and the delete removes it, both
+// without restoring the original config. Applying another temporary config extends the
+// duration and reverts to the config from before the first temporary config.
+func (r *restream) ApplyTemporaryConfig(id string, config *app.Config, duration time.Duration) error {
+	if duration <= 0 {
+		return fmt.Errorf("the duration for the temporary config of the process '%s' must be positive", id)
+	}
+
+	if config.ID != id {
+		return fmt.Errorf("the temporary config must have the ID of the process '%s'", id)
+	}
+
+	if err := r.beginOperation(id); err != nil {
+		return err
+	}
+	defer r.endOperation(id)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	task, ok := r.tasks[id]
+	if !ok {
+		return ErrUnknownProcess
+	}
+
+	original := task.process.Config.Clone()
+	if task.temporary != nil {
+		original = task.temporary.original
+	}
+
+	if err := r.updateProcess(id, config); err != nil {
+		return err
+	}
+
+	t := r.tasks[id]
+	r.scheduleRevert(t, original, duration)
+
+	r.audit(t, "update", "")
+
+	if err := r.commit(); err != nil {
+		t.stopTemporary()
+		r.revertUpdate(id, task)
+
+		// Keep the revert of an earlier temporary config
+		if task.temporary != nil {
+			r.scheduleRevert(r.tasks[id], task.temporary.original, time.Until(task.temporary.deadline))
+		}
+
+		return err
+	}
+
+	t.logger.Info().WithField("duration", duration.Seconds()).Log("Applied temporary config")
+
+	return nil
+}
+
+// scheduleRevert reverts the task to the original config after the duration. The lock
+// must be held.
+func (r *restream) scheduleRevert(t *task, original *app.Config, duration time.Duration) {
+	t.temporary = &temporaryConfig{
+		original: original,
+		deadline: time.Now().Add(duration),
+		timer: time.AfterFunc(duration, func() {
+			r.revertTemporaryConfig(t)
+		}),
+	}
+}
+
+// revertTemporaryConfig updates the process with its original config, if the task is
+// still the current one. If the update fails, the revert is tried again later and the
+// original config remains the one in the store.
+func (r *restream) revertTemporaryConfig(t *task) {
+	if err := r.beginOperation(t.id); err != nil {
+		// Try again as soon as the other operation might be finished
+		r.lock.Lock()
+		if t.temporary != nil {
+			t.temporary.timer.Reset(time.Second)
+		}
+		r.lock.Unlock()
+		return
+	}
+	defer r.endOperation(t.id)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// Check whether the task is still the current one
+	if task, ok := r.tasks[t.id]; !ok || task != t || t.temporary == nil {
+		return
+	}
+
+	original := t.temporary.original
+
+	if err := r.updateProcess(t.id, original); err != nil {
+		t.logger.Warn().WithError(err).Log("Failed to revert the temporary config, trying again")
+		t.temporary.timer.Reset(time.Second)
+		return
+	}
+
+	r.audit(r.tasks[t.id], "update", "")
+
+	if err := r.commit(); err != nil {
+		t.logger.Warn().WithError(err).Log("Failed to store the reverted config, trying again")
+
+		r.revertUpdate(t.id, t)
+
+		if restored, ok := r.tasks[t.id]; ok {
+			r.scheduleRevert(restored, original, time.Second)
+		}
+
+		return
+	}
+
+	t.logger.Info().Log("Reverted temporary config")
+}
+
+// stopTemporary cancels the revert of the temporary config of the task.
+func (t *task) stopTemporary() {
+	if t.temporary == nil {
+		return
+	}
+
+	t.temporary.timer.Stop()
+	t.temporary = nil
+}
+
+// storedProcess returns the process of the task as it will be written to the store. For
+// a temporary config, the original config will be stored.
+func (t *task) storedProcess() *app.Process {
+	if t.temporary == nil {
+		return t.process
+	}
+
+	process := t.process.Clone()
+	process.Config = t.temporary.original.Clone()
+
+	return process
+}